@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// events fans the parsed bpftrace events out to running captures.
+var events = &eventHub{subscribers: map[chan *Event]struct{}{}}
+
+// aggregates holds the latest value of every map entry printed by bpftrace.
+var aggregates = &aggregateStore{values: map[string]int64{}}
+
+// eventHub broadcasts events to subscribers without ever blocking the
+// bpftrace reader; slow subscribers lose events instead.
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[chan *Event]struct{}
+}
+
+func (h *eventHub) Subscribe() chan *Event {
+	ch := make(chan *Event, CAPTURE_BUFFER_SIZE)
+	h.mu.Lock()
+	h.subscribers[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *eventHub) Unsubscribe(ch chan *Event) {
+	h.mu.Lock()
+	delete(h.subscribers, ch)
+	h.mu.Unlock()
+}
+
+func (h *eventHub) Publish(ev *Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers {
+		select {
+		case ch <- ev:
+		default:
+			metrics.Inc("gpu_bpf_capture_dropped_events_total")
+		}
+	}
+}
+
+// aggregateLine matches single value map entries such as
+// @function_calls[cudaMalloc]: 42 or @opens[python]: 3
+var aggregateLine = regexp.MustCompile(`^(@\w+(?:\[.*\])?): (-?\d+)$`)
+
+type aggregateStore struct {
+	mu     sync.Mutex
+	values map[string]int64
+}
+
+// Record stores a printed map entry and reports whether the line was one.
+func (a *aggregateStore) Record(line string) bool {
+	m := aggregateLine.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil {
+		return false
+	}
+	value, err := strconv.ParseInt(m[2], 10, 64)
+	if err != nil {
+		return false
+	}
+	a.mu.Lock()
+	a.values[m[1]] = value
+	a.mu.Unlock()
+	return true
+}
+
+func (a *aggregateStore) Snapshot() map[string]int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	snapshot := make(map[string]int64, len(a.values))
+	for k, v := range a.values {
+		snapshot[k] = v
+	}
+	return snapshot
+}
+
+// capturedEvent is an event as streamed to capture collectors.
+type capturedEvent struct {
+	*Event
+	Time   time.Time `json:"time"`
+	PodUID string    `json:"podUid,omitempty"`
+}
+
+// handleCapture streams the events of the next ?seconds= (default 60) as
+// NDJSON, optionally restricted to the processes of the pods listed in
+// ?pods=<uid>,<uid>.
+func handleCapture(w http.ResponseWriter, r *http.Request) {
+	duration := time.Minute
+	if v := r.URL.Query().Get("seconds"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds <= 0 {
+			http.Error(w, "invalid seconds "+strconv.Quote(v), http.StatusBadRequest)
+			return
+		}
+		duration = time.Duration(seconds) * time.Second
+	}
+	pods := map[string]bool{}
+	if v := r.URL.Query().Get("pods"); v != "" {
+		for _, uid := range strings.Split(v, ",") {
+			pods[uid] = true
+		}
+	}
+
+	ch := events.Subscribe()
+	defer events.Unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	log.Info().Dur("duration", duration).Int("pods", len(pods)).Msg("Capture started")
+	for {
+		select {
+		case <-r.Context().Done():
+			log.Info().Msg("Capture aborted by the collector")
+			return
+		case <-timer.C:
+			log.Info().Msg("Capture finished")
+			return
+		case ev := <-ch:
+			out := capturedEvent{Event: ev, Time: time.Now().UTC(), PodUID: podUIDForPid(ev.Pid)}
+			if len(pods) > 0 && !pods[out.PodUID] {
+				continue
+			}
+			if err := enc.Encode(out); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// handleAggregates serves the latest bpftrace map values as JSON.
+func handleAggregates(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(aggregates.Snapshot()); err != nil {
+		log.Error().Err(err).Msg("Failed to write aggregates")
+	}
+}
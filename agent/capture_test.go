@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestAggregateStoreRecord(t *testing.T) {
+	tests := []struct {
+		line string
+		key  string
+		want int64
+	}{
+		{line: "@function_calls[cudaMalloc]: 42", key: "@function_calls[cudaMalloc]", want: 42},
+		{line: "  @opens[python]: -3  ", key: "@opens[python]", want: -3},
+		{line: "@total: 7", key: "@total", want: 7},
+		{line: "@hist[cuInit]:"},
+		{line: "1523 CUDA_MALLOC python 4242 0"},
+	}
+	for _, tt := range tests {
+		store := &aggregateStore{values: map[string]int64{}}
+		if ok := store.Record(tt.line); ok != (tt.key != "") {
+			t.Errorf("Record(%q) = %v, want %v", tt.line, ok, tt.key != "")
+			continue
+		}
+		if tt.key == "" {
+			continue
+		}
+		if got := store.Snapshot()[tt.key]; got != tt.want {
+			t.Errorf("Record(%q) stored %d, want %d", tt.line, got, tt.want)
+		}
+	}
+}
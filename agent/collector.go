@@ -0,0 +1,368 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// captureTarget is an agent a capture collects events from.
+type captureTarget struct {
+	Node    string
+	Address string
+}
+
+// nodeSummary describes what was collected from one agent.
+type nodeSummary struct {
+	Node         string           `json:"node"`
+	Address      string           `json:"address"`
+	Events       int              `json:"events"`
+	EventsByType map[string]int   `json:"eventsByType,omitempty"`
+	Aggregates   map[string]int64 `json:"aggregates,omitempty"`
+	Error        string           `json:"error,omitempty"`
+}
+
+// captureSummary is written next to the events in the capture archive.
+type captureSummary struct {
+	Capture      string         `json:"capture"`
+	StartTime    time.Time      `json:"startTime"`
+	EndTime      time.Time      `json:"endTime"`
+	Events       int            `json:"events"`
+	EventsByType map[string]int `json:"eventsByType"`
+	Nodes        []nodeSummary  `json:"nodes"`
+}
+
+// runCollector implements "agent collect": it streams the events of every
+// agent listed in CAPTURE_TARGETS for CAPTURE_SECONDS, then stores an
+// archive with events.ndjson and summary.json in CAPTURE_OUTPUT or in the
+// S3 bucket configured through the S3_* variables.
+func runCollector() error {
+	name := os.Getenv("CAPTURE_NAME")
+	seconds, err := strconv.Atoi(os.Getenv("CAPTURE_SECONDS"))
+	if err != nil || seconds <= 0 {
+		return fmt.Errorf("invalid CAPTURE_SECONDS %q", os.Getenv("CAPTURE_SECONDS"))
+	}
+	targets, err := parseCaptureTargets(os.Getenv("CAPTURE_TARGETS"))
+	if err != nil {
+		return err
+	}
+
+	eventsFile, err := os.CreateTemp("", "events-*.ndjson")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(eventsFile.Name())
+	defer eventsFile.Close()
+
+	summary := captureSummary{
+		Capture:      name,
+		StartTime:    time.Now().UTC(),
+		EventsByType: map[string]int{},
+		Nodes:        make([]nodeSummary, len(targets)),
+	}
+	log.Info().Str("capture", name).Int("seconds", seconds).Int("nodes", len(targets)).Msg("Collecting trace capture")
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			summary.Nodes[i] = collectNode(target, seconds, os.Getenv("CAPTURE_PODS"), eventsFile, &mu)
+		}()
+	}
+	wg.Wait()
+	summary.EndTime = time.Now().UTC()
+
+	failed := 0
+	for _, node := range summary.Nodes {
+		summary.Events += node.Events
+		for typ, n := range node.EventsByType {
+			summary.EventsByType[typ] += n
+		}
+		if node.Error != "" {
+			failed++
+			log.Warn().Str("node", node.Node).Str("error", node.Error).Msg("Capture incomplete on node")
+		}
+	}
+	if failed == len(targets) {
+		return errors.New("no agent could be captured")
+	}
+
+	archive, err := os.CreateTemp("", "capture-*.tar.gz")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+	if err := writeCaptureArchive(archive, eventsFile, summary); err != nil {
+		return fmt.Errorf("writing capture archive: %w", err)
+	}
+
+	if output := os.Getenv("CAPTURE_OUTPUT"); output != "" {
+		return storeCaptureFile(archive, output)
+	}
+	return uploadCaptureS3(archive)
+}
+
+// parseCaptureTargets parses "node=host:port,node=host:port".
+func parseCaptureTargets(value string) ([]captureTarget, error) {
+	var targets []captureTarget
+	for _, entry := range strings.Split(value, ",") {
+		if entry == "" {
+			continue
+		}
+		node, addr, ok := strings.Cut(entry, "=")
+		if !ok || node == "" || addr == "" {
+			return nil, fmt.Errorf("invalid capture target %q", entry)
+		}
+		targets = append(targets, captureTarget{Node: node, Address: addr})
+	}
+	if len(targets) == 0 {
+		return nil, errors.New("missing environment variable CAPTURE_TARGETS")
+	}
+	return targets, nil
+}
+
+// collectNode streams one agent's events into out, tagging every event with
+// the node it was captured on, and fetches the agent's aggregates at the end.
+func collectNode(target captureTarget, seconds int, pods string, out io.Writer, mu *sync.Mutex) nodeSummary {
+	summary := nodeSummary{Node: target.Node, Address: target.Address, EventsByType: map[string]int{}}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(seconds)*time.Second+time.Minute)
+	defer cancel()
+
+	query := url.Values{"seconds": {strconv.Itoa(seconds)}}
+	if pods != "" {
+		query.Set("pods", pods)
+	}
+	body, err := agentGet(ctx, target.Address, "/debug/capture?"+query.Encode())
+	if err != nil {
+		summary.Error = err.Error()
+		return summary
+	}
+	defer body.Close()
+
+	nodeField, _ := json.Marshal(target.Node)
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		var ev struct {
+			Type string `json:"event"`
+		}
+		if len(line) < 2 || line[0] != '{' || json.Unmarshal(line, &ev) != nil {
+			continue
+		}
+		summary.Events++
+		summary.EventsByType[ev.Type]++
+
+		mu.Lock()
+		fmt.Fprintf(out, "{\"node\":%s,%s\n", nodeField, line[1:])
+		mu.Unlock()
+	}
+	if err := scanner.Err(); err != nil {
+		summary.Error = err.Error()
+	}
+
+	aggBody, err := agentGet(ctx, target.Address, "/debug/aggregates")
+	if err != nil {
+		if summary.Error == "" {
+			summary.Error = err.Error()
+		}
+		return summary
+	}
+	defer aggBody.Close()
+	if err := json.NewDecoder(aggBody).Decode(&summary.Aggregates); err != nil && summary.Error == "" {
+		summary.Error = err.Error()
+	}
+	return summary
+}
+
+func agentGet(ctx context.Context, addr, path string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// writeCaptureArchive writes events.ndjson and summary.json as a tar.gz.
+func writeCaptureArchive(w io.Writer, events *os.File, summary captureSummary) error {
+	sort.Slice(summary.Nodes, func(i, j int) bool { return summary.Nodes[i].Node < summary.Nodes[j].Node })
+	summaryJSON, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	info, err := events.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := events.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	if err := tw.WriteHeader(&tar.Header{Name: "events.ndjson", Mode: 0o644, Size: info.Size(), ModTime: summary.EndTime}); err != nil {
+		return err
+	}
+	if _, err := io.Copy(tw, events); err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: "summary.json", Mode: 0o644, Size: int64(len(summaryJSON)), ModTime: summary.EndTime}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(summaryJSON); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// storeCaptureFile copies the archive to a path on a mounted volume.
+func storeCaptureFile(archive *os.File, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, archive); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	log.Info().Str("path", path).Msg("Capture archive stored")
+	return nil
+}
+
+// uploadCaptureS3 uploads the archive with a path-style PUT signed with AWS
+// signature version 4, which S3 compatible stores such as MinIO accept.
+func uploadCaptureS3(archive *os.File) error {
+	endpoint, bucket, key := os.Getenv("S3_ENDPOINT"), os.Getenv("S3_BUCKET"), os.Getenv("S3_KEY")
+	if endpoint == "" || bucket == "" || key == "" {
+		return errors.New("either CAPTURE_OUTPUT or S3_ENDPOINT, S3_BUCKET and S3_KEY must be set")
+	}
+	region := os.Getenv("S3_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+	scheme := "https"
+	if insecure, _ := strconv.ParseBool(os.Getenv("S3_INSECURE")); insecure {
+		scheme = "http"
+	}
+
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	payload, err := io.ReadAll(archive)
+	if err != nil {
+		return err
+	}
+
+	u := &url.URL{Scheme: scheme, Host: endpoint, Path: "/" + bucket + "/" + key}
+	req, err := http.NewRequest(http.MethodPut, u.String(), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+	signS3Request(req, payload, os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"), region, time.Now().UTC())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("uploading capture archive: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	log.Info().Str("bucket", bucket).Str("key", key).Msg("Capture archive uploaded")
+	return nil
+}
+
+// signS3Request adds the AWS signature version 4 headers to an S3 request.
+func signS3Request(req *http.Request, payload []byte, accessKey, secretKey, region string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(payload)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
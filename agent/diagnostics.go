@@ -0,0 +1,234 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// failures records the most recent unexpected bpftrace exit.
+var failures = &failureStore{}
+
+// lineRing keeps the last lines written to it.
+type lineRing struct {
+	mu    sync.Mutex
+	lines []string
+	next  int
+	full  bool
+}
+
+func newLineRing(size int) *lineRing {
+	return &lineRing{lines: make([]string, size)}
+}
+
+func (r *lineRing) Add(line string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lines[r.next] = line
+	r.next = (r.next + 1) % len(r.lines)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// Lines returns the kept lines, oldest first.
+func (r *lineRing) Lines() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([]string(nil), r.lines[:r.next]...)
+	}
+	return append(append([]string(nil), r.lines[r.next:]...), r.lines[:r.next]...)
+}
+
+// bpftraceFailure describes an unexpected bpftrace exit, as served on /debug/failure.
+type bpftraceFailure struct {
+	Node       string    `json:"node,omitempty"`
+	Time       time.Time `json:"time"`
+	Reason     string    `json:"reason"`
+	ExitCode   int       `json:"exitCode"`
+	Restarts   int       `json:"restarts"`
+	StderrTail []string  `json:"stderrTail,omitempty"`
+	Bundle     string    `json:"bundle,omitempty"`
+}
+
+type failureStore struct {
+	mu   sync.Mutex
+	last *bpftraceFailure
+}
+
+func (s *failureStore) Set(f *bpftraceFailure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.last = f
+}
+
+func (s *failureStore) Last() *bpftraceFailure {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last
+}
+
+// recordFailure summarises an unexpected bpftrace exit and writes a
+// diagnostic bundle for it.
+func recordFailure(runErr error, stderrTail []string, restarts int) *bpftraceFailure {
+	f := &bpftraceFailure{
+		Node:       os.Getenv("NODE_NAME"),
+		Time:       time.Now().UTC(),
+		Reason:     failureReason(runErr, stderrTail),
+		ExitCode:   -1,
+		Restarts:   restarts,
+		StderrTail: stderrTail,
+	}
+	if exitErr, ok := runErr.(*exec.ExitError); ok {
+		f.ExitCode = exitErr.ExitCode()
+	}
+
+	bundle, err := writeDiagnosticBundle(f)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to write diagnostic bundle")
+	} else {
+		f.Bundle = filepath.Base(bundle)
+		log.Info().Str("bundle", bundle).Msg("Diagnostic bundle written")
+	}
+	failures.Set(f)
+	return f
+}
+
+// failureReason prefers the last error bpftrace printed over the exit status.
+func failureReason(runErr error, stderrTail []string) string {
+	for i := len(stderrTail) - 1; i >= 0; i-- {
+		if strings.HasPrefix(stderrTail[i], "ERROR:") {
+			return stderrTail[i]
+		}
+	}
+	if runErr != nil {
+		return "bpftrace exited: " + runErr.Error()
+	}
+	return "bpftrace exited"
+}
+
+// writeDiagnosticBundle stores the failure, the stderr tail, the rendered
+// script and the kernel and driver versions as a tar.gz in DIAGNOSTICS_DIR,
+// keeping the newest DIAGNOSTICS_MAX_BUNDLES bundles.
+func writeDiagnosticBundle(f *bpftraceFailure) (string, error) {
+	dir := diagnosticsDir()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("bpftrace-%s.tar.gz", f.Time.Format("20060102T150405.000")))
+	out, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	summary, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"failure.json", summary},
+		{"stderr.log", []byte(strings.Join(f.StderrTail, "\n") + "\n")},
+		{"script.bt", readOrNote(scriptPath)},
+		{"kernel.txt", append(readOrNote("/proc/sys/kernel/osrelease"), readOrNote("/proc/version")...)},
+		{"driver.txt", readOrNote("/proc/driver/nvidia/version")},
+	}
+
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	for _, file := range files {
+		hdr := &tar.Header{Name: file.name, Mode: 0o644, Size: int64(len(file.data)), ModTime: f.Time}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return "", err
+		}
+		if _, err := tw.Write(file.data); err != nil {
+			return "", err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return "", err
+	}
+	if err := gz.Close(); err != nil {
+		return "", err
+	}
+	pruneDiagnosticBundles(dir)
+	return path, nil
+}
+
+func readOrNote(path string) []byte {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return []byte(fmt.Sprintf("unavailable: %v\n", err))
+	}
+	return data
+}
+
+func diagnosticsDir() string {
+	if dir := os.Getenv("DIAGNOSTICS_DIR"); dir != "" {
+		return dir
+	}
+	return DIAGNOSTICS_DIR
+}
+
+func pruneDiagnosticBundles(dir string) {
+	matches, err := filepath.Glob(filepath.Join(dir, "bpftrace-*.tar.gz"))
+	if err != nil {
+		return
+	}
+	sort.Strings(matches)
+	for len(matches) > DIAGNOSTICS_MAX_BUNDLES {
+		if err := os.Remove(matches[0]); err != nil && !os.IsNotExist(err) {
+			log.Warn().Err(err).Str("file", matches[0]).Msg("Failed to remove diagnostic bundle")
+		}
+		matches = matches[1:]
+	}
+}
+
+// handleFailure serves the last bpftrace failure as JSON, or 204 if
+// bpftrace has not failed since the agent started.
+func handleFailure(w http.ResponseWriter, _ *http.Request) {
+	f := failures.Last()
+	if f == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(f)
+}
+
+// handleDiagnostics serves a diagnostic bundle by name, the newest one by default.
+func handleDiagnostics(w http.ResponseWriter, r *http.Request) {
+	dir := diagnosticsDir()
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		matches, _ := filepath.Glob(filepath.Join(dir, "bpftrace-*.tar.gz"))
+		if len(matches) == 0 {
+			http.Error(w, "no diagnostic bundle", http.StatusNotFound)
+			return
+		}
+		sort.Strings(matches)
+		name = filepath.Base(matches[len(matches)-1])
+	}
+	if name != filepath.Base(name) || !strings.HasPrefix(name, "bpftrace-") {
+		http.Error(w, "invalid bundle name", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	http.ServeFile(w, r, filepath.Join(dir, name))
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withDiagnosticsDir points the bundles at a fresh directory and the
+// rendered script at a file in it.
+func withDiagnosticsDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("DIAGNOSTICS_DIR", dir)
+	savedScript, savedFailures := scriptPath, failures
+	scriptPath, failures = filepath.Join(dir, "nvidia_events.bt"), &failureStore{}
+	t.Cleanup(func() { scriptPath, failures = savedScript, savedFailures })
+	if err := os.WriteFile(scriptPath, []byte("uprobe:/usr/lib/libcudart.so:cudaMalloc {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// bundleFiles reads the files of a diagnostic bundle by name.
+func bundleFiles(t *testing.T, r io.Reader) map[string]string {
+	t.Helper()
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	files := map[string]string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[hdr.Name] = string(data)
+	}
+}
+
+func testFailure(at time.Time) *bpftraceFailure {
+	return &bpftraceFailure{
+		Node:       "gpu-node-1",
+		Time:       at,
+		Reason:     "ERROR: Could not resolve symbol: /usr/lib/libcudart.so:cudaMalloc",
+		ExitCode:   1,
+		Restarts:   2,
+		StderrTail: []string{"Attaching 3 probes...", "ERROR: Could not resolve symbol: /usr/lib/libcudart.so:cudaMalloc"},
+	}
+}
+
+func TestWriteDiagnosticBundle(t *testing.T) {
+	dir := withDiagnosticsDir(t)
+	f := testFailure(time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC))
+
+	path, err := writeDiagnosticBundle(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "bpftrace-20250301T120000.000.tar.gz"); path != want {
+		t.Fatalf("bundle written to %s, want %s", path, want)
+	}
+	bundle, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bundle.Close()
+	files := bundleFiles(t, bundle)
+
+	var summary bpftraceFailure
+	if err := json.Unmarshal([]byte(files["failure.json"]), &summary); err != nil {
+		t.Fatalf("failure.json: %v", err)
+	}
+	if summary.Reason != f.Reason || summary.ExitCode != 1 || summary.Restarts != 2 || summary.Node != "gpu-node-1" {
+		t.Errorf("failure.json = %+v, want %+v", summary, *f)
+	}
+	if want := strings.Join(f.StderrTail, "\n") + "\n"; files["stderr.log"] != want {
+		t.Errorf("stderr.log = %q, want %q", files["stderr.log"], want)
+	}
+	if !strings.Contains(files["script.bt"], "cudaMalloc") {
+		t.Errorf("script.bt = %q, want the rendered script", files["script.bt"])
+	}
+	// Kernel and driver versions are noted as unavailable rather than missing
+	for _, name := range []string{"kernel.txt", "driver.txt"} {
+		if files[name] == "" {
+			t.Errorf("%s is empty", name)
+		}
+	}
+	if len(files) != 5 {
+		t.Errorf("bundle has %d files, want 5", len(files))
+	}
+}
+
+func TestPruneDiagnosticBundles(t *testing.T) {
+	dir := withDiagnosticsDir(t)
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	var paths []string
+	for i := 0; i < DIAGNOSTICS_MAX_BUNDLES+2; i++ {
+		path, err := writeDiagnosticBundle(testFailure(start.Add(time.Duration(i) * time.Minute)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pruneDiagnosticBundles(dir)
+
+	matches, _ := filepath.Glob(filepath.Join(dir, "bpftrace-*.tar.gz"))
+	if len(matches) != DIAGNOSTICS_MAX_BUNDLES {
+		t.Fatalf("kept %d bundles, want %d", len(matches), DIAGNOSTICS_MAX_BUNDLES)
+	}
+	for i, path := range paths {
+		_, err := os.Stat(path)
+		if kept := err == nil; kept != (i >= 2) {
+			t.Errorf("bundle %d kept = %v, want only the newest %d", i, kept, DIAGNOSTICS_MAX_BUNDLES)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes.txt")); err != nil {
+		t.Errorf("pruning removed a file that is not a bundle: %v", err)
+	}
+}
+
+func TestHandleDiagnostics(t *testing.T) {
+	withDiagnosticsDir(t)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handleDiagnostics(rec, httptest.NewRequest(http.MethodGet, "/debug/diagnostics"+query, nil))
+		return rec
+	}
+	if rec := get(""); rec.Code != http.StatusNotFound {
+		t.Fatalf("without bundles status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	older, err := writeDiagnosticBundle(testFailure(start))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newer := testFailure(start.Add(time.Minute))
+	newer.Reason = "bpftrace exited: signal: killed"
+	if _, err := writeDiagnosticBundle(newer); err != nil {
+		t.Fatal(err)
+	}
+
+	reason := func(rec *httptest.ResponseRecorder) string {
+		t.Helper()
+		var summary bpftraceFailure
+		if err := json.Unmarshal([]byte(bundleFiles(t, rec.Body)["failure.json"]), &summary); err != nil {
+			t.Fatal(err)
+		}
+		return summary.Reason
+	}
+
+	rec := get("")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := rec.Header().Get("Content-Disposition"); !strings.Contains(got, "bpftrace-20250301T120100.000.tar.gz") {
+		t.Errorf("Content-Disposition = %q, want the newest bundle", got)
+	}
+	if got := reason(rec); got != newer.Reason {
+		t.Errorf("newest bundle reason = %q, want %q", got, newer.Reason)
+	}
+
+	rec = get("?name=" + filepath.Base(older))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("by name status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := reason(rec); !strings.HasPrefix(got, "ERROR:") {
+		t.Errorf("named bundle reason = %q, want the older failure", got)
+	}
+
+	if rec := get("?name=bpftrace-20990101T000000.000.tar.gz"); rec.Code != http.StatusNotFound {
+		t.Errorf("missing bundle status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	for _, name := range []string{"../bpftrace-x.tar.gz", "nvidia_events.bt"} {
+		if rec := get("?name=" + name); rec.Code != http.StatusBadRequest {
+			t.Errorf("name %q status = %d, want %d", name, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestHandleFailure(t *testing.T) {
+	withDiagnosticsDir(t)
+
+	if code := probe(handleFailure); code != http.StatusNoContent {
+		t.Fatalf("before a failure status = %d, want %d", code, http.StatusNoContent)
+	}
+
+	f := recordFailure(nil, []string{"ERROR: Could not resolve symbol: /usr/lib/libcudart.so:cudaMalloc"}, 1)
+	if f.Bundle == "" {
+		t.Fatal("recordFailure did not write a bundle")
+	}
+	rec := httptest.NewRecorder()
+	handleFailure(rec, httptest.NewRequest(http.MethodGet, "/debug/failure", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var got bpftraceFailure
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Reason != f.Reason || got.Bundle != f.Bundle || got.ExitCode != -1 {
+		t.Errorf("failure = %+v, want %+v", got, *f)
+	}
+}
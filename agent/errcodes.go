@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// cudaErrorNames maps cudaError_t values returned by the CUDA runtime API to
+// their enumerator names.
+var cudaErrorNames = map[int64]string{
+	0:   "cudaSuccess",
+	1:   "cudaErrorInvalidValue",
+	2:   "cudaErrorMemoryAllocation",
+	3:   "cudaErrorInitializationError",
+	4:   "cudaErrorCudartUnloading",
+	5:   "cudaErrorProfilerDisabled",
+	9:   "cudaErrorInvalidConfiguration",
+	12:  "cudaErrorInvalidPitchValue",
+	13:  "cudaErrorInvalidSymbol",
+	16:  "cudaErrorInvalidHostPointer",
+	17:  "cudaErrorInvalidDevicePointer",
+	18:  "cudaErrorInvalidTexture",
+	21:  "cudaErrorInvalidMemcpyDirection",
+	34:  "cudaErrorStubLibrary",
+	35:  "cudaErrorInsufficientDriver",
+	36:  "cudaErrorCallRequiresNewerDriver",
+	46:  "cudaErrorDevicesUnavailable",
+	49:  "cudaErrorIncompatibleDriverContext",
+	52:  "cudaErrorMissingConfiguration",
+	98:  "cudaErrorInvalidDeviceFunction",
+	100: "cudaErrorNoDevice",
+	101: "cudaErrorInvalidDevice",
+	102: "cudaErrorDeviceNotLicensed",
+	127: "cudaErrorStartupFailure",
+	200: "cudaErrorInvalidKernelImage",
+	201: "cudaErrorDeviceUninitialized",
+	209: "cudaErrorNoKernelImageForDevice",
+	214: "cudaErrorECCUncorrectable",
+	215: "cudaErrorUnsupportedLimit",
+	216: "cudaErrorDeviceAlreadyInUse",
+	217: "cudaErrorPeerAccessUnsupported",
+	218: "cudaErrorInvalidPtx",
+	220: "cudaErrorNvlinkUncorrectable",
+	222: "cudaErrorUnsupportedPtxVersion",
+	300: "cudaErrorInvalidSource",
+	301: "cudaErrorFileNotFound",
+	302: "cudaErrorSharedObjectSymbolNotFound",
+	303: "cudaErrorSharedObjectInitFailed",
+	304: "cudaErrorOperatingSystem",
+	400: "cudaErrorInvalidResourceHandle",
+	401: "cudaErrorIllegalState",
+	500: "cudaErrorSymbolNotFound",
+	600: "cudaErrorNotReady",
+	700: "cudaErrorIllegalAddress",
+	701: "cudaErrorLaunchOutOfResources",
+	702: "cudaErrorLaunchTimeout",
+	704: "cudaErrorPeerAccessAlreadyEnabled",
+	705: "cudaErrorPeerAccessNotEnabled",
+	708: "cudaErrorSetOnActiveProcess",
+	709: "cudaErrorContextIsDestroyed",
+	710: "cudaErrorAssert",
+	712: "cudaErrorHostMemoryAlreadyRegistered",
+	713: "cudaErrorHostMemoryNotRegistered",
+	714: "cudaErrorHardwareStackError",
+	715: "cudaErrorIllegalInstruction",
+	716: "cudaErrorMisalignedAddress",
+	719: "cudaErrorLaunchFailure",
+	720: "cudaErrorCooperativeLaunchTooLarge",
+	800: "cudaErrorNotPermitted",
+	801: "cudaErrorNotSupported",
+	802: "cudaErrorSystemNotReady",
+	803: "cudaErrorSystemDriverMismatch",
+	900: "cudaErrorStreamCaptureUnsupported",
+	909: "cudaErrorTimeout",
+	999: "cudaErrorUnknown",
+}
+
+// errnoNames maps the errno values the NVIDIA driver entry points commonly
+// return to their symbolic names.
+var errnoNames = map[int64]string{
+	1:   "EPERM",
+	2:   "ENOENT",
+	3:   "ESRCH",
+	4:   "EINTR",
+	5:   "EIO",
+	6:   "ENXIO",
+	7:   "E2BIG",
+	9:   "EBADF",
+	11:  "EAGAIN",
+	12:  "ENOMEM",
+	13:  "EACCES",
+	14:  "EFAULT",
+	16:  "EBUSY",
+	17:  "EEXIST",
+	19:  "ENODEV",
+	22:  "EINVAL",
+	23:  "ENFILE",
+	24:  "EMFILE",
+	25:  "ENOTTY",
+	28:  "ENOSPC",
+	32:  "EPIPE",
+	34:  "ERANGE",
+	38:  "ENOSYS",
+	95:  "EOPNOTSUPP",
+	110: "ETIMEDOUT",
+}
+
+// errorName resolves the symbolic name of an error code for the given
+// return value classification ("errno", "cudaError" or "pointer").
+func errorName(class, code string) string {
+	if class == "pointer" {
+		return "NULL"
+	}
+	n, err := strconv.ParseInt(code, 10, 64)
+	if err != nil {
+		return fmt.Sprintf("%s(%s)", class, code)
+	}
+
+	var names map[int64]string
+	switch class {
+	case "errno":
+		names = errnoNames
+	case "cudaError":
+		names = cudaErrorNames
+	}
+	if name, ok := names[n]; ok {
+		return name
+	}
+	return fmt.Sprintf("%s(%d)", class, n)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Event is a single line printed by the bpftrace script. The template prints
+// every event with the same leading columns:
+//
+//	TIME(ms) EVENT COMM PID GPU_ID DETAILS...
+//
+// DETAILS is free text, but key=value tokens in it are exposed as Fields.
+type Event struct {
+	ElapsedMs uint64            `json:"elapsedMs"`
+	Type      string            `json:"event"`
+	Comm      string            `json:"comm"`
+	Pid       int               `json:"pid"`
+	GpuID     string            `json:"gpuId,omitempty"`
+	Details   string            `json:"details,omitempty"`
+	Fields    map[string]string `json:"fields,omitempty"`
+	ErrorName string            `json:"errorName,omitempty"`
+}
+
+// parseEvent parses a bpftrace output line into an Event. Lines that do not
+// follow the event column layout (banners, END summaries) are rejected.
+func parseEvent(line string) (*Event, bool) {
+	cols := strings.Fields(line)
+	if len(cols) == 0 {
+		return nil, false
+	}
+	elapsed, err := strconv.ParseUint(cols[0], 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	// Lines starting with a timestamp are events, anything else is malformed
+	if len(cols) < 5 {
+		metrics.Inc("gpu_bpf_parse_errors_total")
+		return nil, false
+	}
+	pid, err := strconv.Atoi(cols[3])
+	if err != nil {
+		metrics.Inc("gpu_bpf_parse_errors_total")
+		return nil, false
+	}
+
+	ev := &Event{
+		ElapsedMs: elapsed,
+		Type:      cols[1],
+		Comm:      cols[2],
+		Pid:       pid,
+	}
+	if cols[4] != "-" {
+		ev.GpuID = cols[4]
+	}
+	if len(cols) > 5 {
+		ev.Details = strings.Join(cols[5:], " ")
+		for _, tok := range cols[5:] {
+			key, value, ok := strings.Cut(tok, "=")
+			if !ok || key == "" {
+				continue
+			}
+			if ev.Fields == nil {
+				ev.Fields = map[string]string{}
+			}
+			ev.Fields[key] = value
+		}
+	}
+	return ev, true
+}
+
+// handleEvent classifies an event, updates the agent counters and logs it.
+func handleEvent(ev *Event, line string) {
+	metrics.Inc("gpu_bpf_events_total", "event", ev.Type)
+	eventCount.Add(1)
+
+	if ev.Type == "STACK_DUMP" {
+		reportTopStacks()
+		return
+	}
+
+	if ev.Type == "RET_ERROR" {
+		fn, class, code := ev.Fields["fn"], ev.Fields["class"], ev.Fields["code"]
+		ev.ErrorName = errorName(class, code)
+		metrics.Inc("gpu_bpf_function_errors_total",
+			"function", fn, "class", class, "code", code, "error", ev.ErrorName)
+	}
+	events.Publish(ev)
+	if otel != nil {
+		otel.Enqueue(ev)
+	}
+	publishToSinks(ev)
+
+	entry := log.Info().Str("source", "stdout").Str("event", ev.Type).Str("comm", ev.Comm).Int("pid", ev.Pid)
+	if ev.ErrorName != "" {
+		entry = entry.Str("error", ev.ErrorName)
+	}
+	entry.Msg(line)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// counterValue returns the current value of a series in the agent registry.
+func counterValue(name string, labels ...string) float64 {
+	key := labelString(labels)
+	for _, p := range metrics.Snapshot() {
+		if p.Name == name && labelString(p.Labels) == key {
+			return p.Value
+		}
+	}
+	return 0
+}
+
+func TestParseEvent(t *testing.T) {
+	tests := []struct {
+		name       string
+		line       string
+		want       *Event
+		parseError bool
+	}{
+		{
+			name: "event with fields",
+			line: "1523 CUDA_MALLOC python 4242 0 size=1048576 ret=0 note",
+			want: &Event{
+				ElapsedMs: 1523,
+				Type:      "CUDA_MALLOC",
+				Comm:      "python",
+				Pid:       4242,
+				GpuID:     "0",
+				Details:   "size=1048576 ret=0 note",
+				Fields:    map[string]string{"size": "1048576", "ret": "0"},
+			},
+		},
+		{
+			name: "no gpu and no details",
+			line: "7 NVIDIA_OPEN nvidia-smi 12 -",
+			want: &Event{ElapsedMs: 7, Type: "NVIDIA_OPEN", Comm: "nvidia-smi", Pid: 12},
+		},
+		{
+			name: "tokens without a key are not fields",
+			line: "7 SPAN python 12 - =x fn=cuInit",
+			want: &Event{ElapsedMs: 7, Type: "SPAN", Comm: "python", Pid: 12, Details: "=x fn=cuInit", Fields: map[string]string{"fn": "cuInit"}},
+		},
+		{name: "empty line", line: "   "},
+		{name: "banner", line: "Tracing NVIDIA GPU driver activity... Hit Ctrl-C to end."},
+		{name: "map entry", line: "@function_calls[cudaMalloc]: 42"},
+		{name: "too few columns", line: "12 CUDA_MALLOC python 4242", parseError: true},
+		{name: "invalid pid", line: "12 CUDA_MALLOC python pid 0", parseError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := counterValue("gpu_bpf_parse_errors_total")
+			got, ok := parseEvent(tt.line)
+			if ok != (tt.want != nil) {
+				t.Fatalf("parseEvent(%q) ok = %v, want %v", tt.line, ok, tt.want != nil)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseEvent(%q) = %+v, want %+v", tt.line, got, tt.want)
+			}
+			counted := counterValue("gpu_bpf_parse_errors_total") > before
+			if counted != tt.parseError {
+				t.Errorf("parseEvent(%q) counted a parse error = %v, want %v", tt.line, counted, tt.parseError)
+			}
+		})
+	}
+}
+
+func TestErrorName(t *testing.T) {
+	tests := []struct {
+		class, code, want string
+	}{
+		{"errno", "2", "ENOENT"},
+		{"errno", "110", "ETIMEDOUT"},
+		{"errno", "4095", "errno(4095)"},
+		{"cudaError", "2", "cudaErrorMemoryAllocation"},
+		{"cudaError", "100000", "cudaError(100000)"},
+		{"cudaError", "bogus", "cudaError(bogus)"},
+		{"pointer", "0", "NULL"},
+		{"unknown", "1", "unknown(1)"},
+	}
+	for _, tt := range tests {
+		if got := errorName(tt.class, tt.code); got != tt.want {
+			t.Errorf("errorName(%q, %q) = %q, want %q", tt.class, tt.code, got, tt.want)
+		}
+	}
+}
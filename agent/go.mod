@@ -2,7 +2,10 @@ module gpu-bpf-operator/agent
 
 go 1.25.2
 
-require github.com/rs/zerolog v1.34.0
+require (
+	github.com/google/pprof v0.0.0-20241029153458-d1b30febd7db
+	github.com/rs/zerolog v1.34.0
+)
 
 require (
 	github.com/mattn/go-colorable v0.1.13 // indirect
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// kafkaProducer is a minimal Kafka producer speaking the wire protocol
+// directly: Metadata v1 to find partition leaders and Produce v3 with v2
+// record batches, acks=1 and no compression. Batches go to the partitions of
+// the topic in turn.
+type kafkaProducer struct {
+	brokers []string
+	topic   string
+
+	mu            sync.Mutex
+	correlationID int32
+	conns         map[string]net.Conn
+	leaders       map[int32]string // partition -> broker address
+	partitions    []int32
+	next          int
+}
+
+func newKafkaProducer(cfg KafkaSink) (*kafkaProducer, error) {
+	if len(cfg.Brokers) == 0 || cfg.Topic == "" {
+		return nil, errors.New("kafka sink requires brokers and a topic")
+	}
+	return &kafkaProducer{brokers: cfg.Brokers, topic: cfg.Topic, conns: map[string]net.Conn{}}, nil
+}
+
+func (p *kafkaProducer) Write(batch [][]byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.partitions) == 0 {
+		if err := p.refreshMetadata(); err != nil {
+			return err
+		}
+	}
+	partition := p.partitions[p.next%len(p.partitions)]
+	p.next++
+
+	err := p.produce(p.leaders[partition], partition, batch)
+	if err != nil {
+		// Leadership may have moved, look it up again on the next attempt
+		p.partitions = nil
+	}
+	return err
+}
+
+func (p *kafkaProducer) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for addr, conn := range p.conns {
+		conn.Close()
+		delete(p.conns, addr)
+	}
+	return nil
+}
+
+// refreshMetadata asks the bootstrap brokers for the leaders of the topic's partitions.
+func (p *kafkaProducer) refreshMetadata() error {
+	var req kafkaEncoder
+	req.int32(1)
+	req.string(p.topic)
+
+	var lastErr error
+	for _, addr := range p.brokers {
+		resp, err := p.roundTrip(addr, 3, 1, req.Bytes())
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return p.parseMetadata(resp)
+	}
+	return fmt.Errorf("kafka metadata: %w", lastErr)
+}
+
+func (p *kafkaProducer) parseMetadata(resp []byte) error {
+	d := kafkaDecoder{buf: resp}
+	brokers := map[int32]string{}
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		id := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.string() // rack
+		brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.int32() // controller id
+
+	p.leaders = map[int32]string{}
+	p.partitions = nil
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		topicErr := d.int16()
+		topic := d.string()
+		d.int8() // is_internal
+		for m := d.int32(); m > 0 && d.err == nil; m-- {
+			partErr := d.int16()
+			partition := d.int32()
+			leader := d.int32()
+			for r := d.int32(); r > 0 && d.err == nil; r-- {
+				d.int32() // replicas
+			}
+			for r := d.int32(); r > 0 && d.err == nil; r-- {
+				d.int32() // isr
+			}
+			if topic != p.topic || topicErr != 0 || partErr != 0 {
+				continue
+			}
+			if addr, ok := brokers[leader]; ok {
+				p.leaders[partition] = addr
+				p.partitions = append(p.partitions, partition)
+			}
+		}
+		if topic == p.topic && topicErr != 0 {
+			return fmt.Errorf("kafka metadata: topic %s: error code %d", topic, topicErr)
+		}
+	}
+	if d.err != nil {
+		// Partitions of a truncated response may have made it into the list
+		p.partitions = nil
+		return fmt.Errorf("kafka metadata: %w", d.err)
+	}
+	if len(p.partitions) == 0 {
+		return fmt.Errorf("kafka metadata: no partition of topic %s has a leader", p.topic)
+	}
+	return nil
+}
+
+// produce sends one record batch to a partition leader and checks the ack.
+func (p *kafkaProducer) produce(addr string, partition int32, batch [][]byte) error {
+	records := encodeRecordBatch(batch, time.Now())
+
+	var req kafkaEncoder
+	req.int16(-1)    // transactional_id: null
+	req.int16(1)     // acks
+	req.int32(10000) // timeout ms
+	req.int32(1)
+	req.string(p.topic)
+	req.int32(1)
+	req.int32(partition)
+	req.bytes(records)
+
+	resp, err := p.roundTrip(addr, 0, 3, req.Bytes())
+	if err != nil {
+		return err
+	}
+	d := kafkaDecoder{buf: resp}
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		d.string()
+		for m := d.int32(); m > 0 && d.err == nil; m-- {
+			d.int32()
+			code := d.int16()
+			d.int64() // base offset
+			d.int64() // log append time
+			if code != 0 {
+				return fmt.Errorf("kafka produce to %s/%d: error code %d", p.topic, partition, code)
+			}
+		}
+	}
+	return d.err
+}
+
+// roundTrip sends a request and returns the response body after the correlation id.
+func (p *kafkaProducer) roundTrip(addr string, apiKey, apiVersion int16, body []byte) ([]byte, error) {
+	conn, ok := p.conns[addr]
+	if !ok {
+		var err error
+		conn, err = net.DialTimeout("tcp", addr, 10*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		p.conns[addr] = conn
+	}
+	fail := func(err error) ([]byte, error) {
+		conn.Close()
+		delete(p.conns, addr)
+		return nil, err
+	}
+
+	p.correlationID++
+	var msg kafkaEncoder
+	msg.int16(apiKey)
+	msg.int16(apiVersion)
+	msg.int32(p.correlationID)
+	msg.string(OTEL_SERVICE_NAME) // client id
+	msg.Write(body)
+
+	frame := make([]byte, 4, 4+msg.Len())
+	binary.BigEndian.PutUint32(frame, uint32(msg.Len()))
+	frame = append(frame, msg.Bytes()...)
+
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	if _, err := conn.Write(frame); err != nil {
+		return fail(err)
+	}
+	r := bufio.NewReader(conn)
+	var size int32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return fail(err)
+	}
+	// The size comes from the broker, bound it before allocating
+	if size < 4 || size > KAFKA_MAX_RESPONSE_SIZE {
+		return fail(fmt.Errorf("kafka: invalid response size %d", size))
+	}
+	resp := make([]byte, size)
+	if _, err := io.ReadFull(r, resp); err != nil {
+		return fail(err)
+	}
+	if int32(binary.BigEndian.Uint32(resp)) != p.correlationID {
+		return fail(errors.New("kafka: unexpected correlation id"))
+	}
+	return resp[4:], nil
+}
+
+// encodeRecordBatch encodes values as an uncompressed v2 record batch.
+func encodeRecordBatch(values [][]byte, now time.Time) []byte {
+	ts := now.UnixMilli()
+
+	var records kafkaEncoder
+	for i, value := range values {
+		var rec kafkaEncoder
+		rec.int8(0)          // attributes
+		rec.varint(0)        // timestamp delta
+		rec.varint(int64(i)) // offset delta
+		rec.varint(-1)       // key: null
+		rec.varint(int64(len(value)))
+		rec.Write(value)
+		rec.varint(0) // headers
+		records.varint(int64(rec.Len()))
+		records.Write(rec.Bytes())
+	}
+
+	// Everything from attributes onwards is covered by the CRC
+	var tail kafkaEncoder
+	tail.int16(0) // attributes
+	tail.int32(int32(len(values) - 1))
+	tail.int64(ts)
+	tail.int64(ts)
+	tail.int64(-1) // producer id
+	tail.int16(-1) // producer epoch
+	tail.int32(-1) // base sequence
+	tail.int32(int32(len(values)))
+	tail.Write(records.Bytes())
+
+	var batch kafkaEncoder
+	batch.int64(0)                             // base offset
+	batch.int32(int32(4 + 1 + 4 + tail.Len())) // batch length
+	batch.int32(-1)                            // partition leader epoch
+	batch.int8(2)                              // magic
+	batch.int32(int32(crc32.Checksum(tail.Bytes(), crc32.MakeTable(crc32.Castagnoli))))
+	batch.Write(tail.Bytes())
+	return batch.Bytes()
+}
+
+// kafkaEncoder writes Kafka protocol primitives.
+type kafkaEncoder struct {
+	bytes.Buffer
+}
+
+func (e *kafkaEncoder) int8(v int8) { e.WriteByte(byte(v)) }
+func (e *kafkaEncoder) int16(v int16) {
+	e.Write(binary.BigEndian.AppendUint16(nil, uint16(v)))
+}
+func (e *kafkaEncoder) int32(v int32) {
+	e.Write(binary.BigEndian.AppendUint32(nil, uint32(v)))
+}
+func (e *kafkaEncoder) int64(v int64) {
+	e.Write(binary.BigEndian.AppendUint64(nil, uint64(v)))
+}
+func (e *kafkaEncoder) varint(v int64) { e.Write(binary.AppendVarint(nil, v)) }
+func (e *kafkaEncoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.WriteString(s)
+}
+func (e *kafkaEncoder) bytes(b []byte) {
+	e.int32(int32(len(b)))
+	e.Write(b)
+}
+
+// kafkaDecoder reads Kafka protocol primitives, remembering the first error.
+type kafkaDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *kafkaDecoder) take(n int) []byte {
+	if d.err != nil || n < 0 || len(d.buf) < n {
+		if d.err == nil {
+			d.err = io.ErrUnexpectedEOF
+		}
+		return make([]byte, max(n, 0))
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *kafkaDecoder) int8() int8   { return int8(d.take(1)[0]) }
+func (d *kafkaDecoder) int16() int16 { return int16(binary.BigEndian.Uint16(d.take(2))) }
+func (d *kafkaDecoder) int32() int32 { return int32(binary.BigEndian.Uint32(d.take(4))) }
+func (d *kafkaDecoder) int64() int64 { return int64(binary.BigEndian.Uint64(d.take(8))) }
+func (d *kafkaDecoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.take(int(n)))
+}
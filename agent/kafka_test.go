@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// kafkaRequest is a request received by the fake broker.
+type kafkaRequest struct {
+	apiKey, apiVersion int16
+	clientID           string
+	body               []byte
+}
+
+// fakeBroker accepts one connection at a time and answers every request
+// with the frame returned by respond.
+type fakeBroker struct {
+	net.Listener
+	mu       sync.Mutex
+	requests []kafkaRequest
+}
+
+func newFakeBroker(t *testing.T, respond func(req kafkaRequest, correlationID int32) []byte) *fakeBroker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBroker{Listener: l}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			b.serve(conn, respond)
+		}
+	}()
+	return b
+}
+
+func (b *fakeBroker) serve(conn net.Conn, respond func(kafkaRequest, int32) []byte) {
+	defer conn.Close()
+	for {
+		var size int32
+		if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+			return
+		}
+		frame := make([]byte, size)
+		if _, err := io.ReadFull(conn, frame); err != nil {
+			return
+		}
+		d := kafkaDecoder{buf: frame}
+		req := kafkaRequest{apiKey: d.int16(), apiVersion: d.int16()}
+		correlationID := d.int32()
+		req.clientID = d.string()
+		req.body = d.buf
+		b.mu.Lock()
+		b.requests = append(b.requests, req)
+		b.mu.Unlock()
+		if _, err := conn.Write(respond(req, correlationID)); err != nil {
+			return
+		}
+	}
+}
+
+func (b *fakeBroker) received() []kafkaRequest {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]kafkaRequest(nil), b.requests...)
+}
+
+// kafkaFrame prefixes a response body with its size and the correlation id.
+func kafkaFrame(correlationID int32, body []byte) []byte {
+	var e kafkaEncoder
+	e.int32(int32(4 + len(body)))
+	e.int32(correlationID)
+	e.Write(body)
+	return e.Bytes()
+}
+
+// metadataResponse encodes a Metadata v1 response listing the broker at addr
+// as the leader of the given partitions of topic.
+func metadataResponse(addr, topic string, topicErr int16, partitions ...int32) []byte {
+	host, port, _ := net.SplitHostPort(addr)
+	portNum, _ := strconv.Atoi(port)
+
+	var e kafkaEncoder
+	e.int32(1) // brokers
+	e.int32(1)
+	e.string(host)
+	e.int32(int32(portNum))
+	e.int16(-1) // rack: null
+	e.int32(1)  // controller id
+	e.int32(2)  // topics
+	e.int16(0)
+	e.string("other")
+	e.int8(0)
+	e.int32(1)
+	e.int16(0)
+	e.int32(0)
+	e.int32(1)
+	e.int32(0)
+	e.int32(0)
+	e.int16(topicErr)
+	e.string(topic)
+	e.int8(0)
+	e.int32(int32(len(partitions)))
+	for _, p := range partitions {
+		e.int16(0)
+		e.int32(p)
+		e.int32(1) // leader
+		e.int32(1) // replicas
+		e.int32(1)
+		e.int32(1) // isr
+		e.int32(1)
+	}
+	return e.Bytes()
+}
+
+// produceResponse encodes a Produce v3 response for one partition.
+func produceResponse(topic string, partition int32, code int16) []byte {
+	var e kafkaEncoder
+	e.int32(1)
+	e.string(topic)
+	e.int32(1)
+	e.int32(partition)
+	e.int16(code)
+	e.int64(0)  // base offset
+	e.int64(-1) // log append time
+	e.int32(0)  // throttle time
+	return e.Bytes()
+}
+
+// decodeRecordBatch returns the values of a v2 record batch and checks its
+// length and CRC.
+func decodeRecordBatch(t *testing.T, batch []byte) [][]byte {
+	d := kafkaDecoder{buf: batch}
+	d.int64() // base offset
+	if length := d.int32(); int(length) != len(d.buf) {
+		t.Fatalf("batch length = %d, want %d", length, len(d.buf))
+	}
+	d.int32() // partition leader epoch
+	if magic := d.int8(); magic != 2 {
+		t.Fatalf("magic = %d, want 2", magic)
+	}
+	if crc := uint32(d.int32()); crc != crc32.Checksum(d.buf, crc32.MakeTable(crc32.Castagnoli)) {
+		t.Fatal("batch CRC does not match")
+	}
+	d.int16() // attributes
+	lastOffsetDelta := d.int32()
+	d.take(8 + 8 + 8 + 2 + 4)
+	count := d.int32()
+	if lastOffsetDelta != count-1 {
+		t.Fatalf("last offset delta = %d for %d records", lastOffsetDelta, count)
+	}
+
+	r := bytes.NewReader(d.buf)
+	var values [][]byte
+	for i := int32(0); i < count; i++ {
+		length, _ := binary.ReadVarint(r)
+		rec := make([]byte, length)
+		io.ReadFull(r, rec)
+		rr := bytes.NewReader(rec)
+		rr.ReadByte() // attributes
+		binary.ReadVarint(rr)
+		if offsetDelta, _ := binary.ReadVarint(rr); offsetDelta != int64(i) {
+			t.Fatalf("record %d has offset delta %d", i, offsetDelta)
+		}
+		if keyLength, _ := binary.ReadVarint(rr); keyLength != -1 {
+			t.Fatalf("record %d has a key", i)
+		}
+		valueLength, _ := binary.ReadVarint(rr)
+		value := make([]byte, valueLength)
+		io.ReadFull(rr, value)
+		values = append(values, value)
+	}
+	if d.err != nil {
+		t.Fatal(d.err)
+	}
+	return values
+}
+
+func TestKafkaEncoder(t *testing.T) {
+	var e kafkaEncoder
+	e.int8(-1)
+	e.int16(-2)
+	e.int32(0x01020304)
+	e.int64(-1)
+	e.varint(-1)
+	e.varint(300)
+	e.string("ab")
+	e.bytes([]byte{9})
+	want := []byte{
+		0xff,
+		0xff, 0xfe,
+		0x01, 0x02, 0x03, 0x04,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0x01,
+		0xd8, 0x04,
+		0x00, 0x02, 'a', 'b',
+		0x00, 0x00, 0x00, 0x01, 9,
+	}
+	if !bytes.Equal(e.Bytes(), want) {
+		t.Errorf("encoded % x, want % x", e.Bytes(), want)
+	}
+}
+
+func TestKafkaDecoder(t *testing.T) {
+	d := kafkaDecoder{buf: []byte{0xff, 0xff, 0xfe, 0x01, 0x02, 0x03, 0x04, 0x00, 0x02, 'a', 'b', 0xff, 0xff}}
+	if v := d.int8(); v != -1 {
+		t.Errorf("int8() = %d", v)
+	}
+	if v := d.int16(); v != -2 {
+		t.Errorf("int16() = %d", v)
+	}
+	if v := d.int32(); v != 0x01020304 {
+		t.Errorf("int32() = %d", v)
+	}
+	if v := d.string(); v != "ab" {
+		t.Errorf("string() = %q", v)
+	}
+	if v := d.string(); v != "" || d.err != nil {
+		t.Errorf("null string() = %q, %v", v, d.err)
+	}
+
+	// Reading past the end zero-fills and keeps the first error
+	if v := d.int64(); v != 0 || d.err != io.ErrUnexpectedEOF {
+		t.Errorf("int64() past the end = %d, %v", v, d.err)
+	}
+	short := kafkaDecoder{buf: []byte{0x00, 0x05, 'a'}}
+	if short.string(); short.err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated string() error = %v", short.err)
+	}
+}
+
+func TestEncodeRecordBatch(t *testing.T) {
+	values := [][]byte{[]byte(`{"event":"A"}`), []byte(`{"event":"B"}`), {}}
+	got := decodeRecordBatch(t, encodeRecordBatch(values, time.UnixMilli(1700000000000)))
+	if !reflect.DeepEqual(got, values) {
+		t.Errorf("decoded %q, want %q", got, values)
+	}
+}
+
+func TestKafkaProducerWrite(t *testing.T) {
+	var broker *fakeBroker
+	broker = newFakeBroker(t, func(req kafkaRequest, correlationID int32) []byte {
+		if req.apiKey == 3 {
+			return kafkaFrame(correlationID, metadataResponse(broker.Addr().String(), "events", 0, 0, 1))
+		}
+		d := kafkaDecoder{buf: req.body}
+		d.int16()
+		d.int16()
+		d.int32()
+		d.int32()
+		d.string()
+		d.int32()
+		partition := d.int32()
+		return kafkaFrame(correlationID, produceResponse("events", partition, 0))
+	})
+
+	p, err := newKafkaProducer(KafkaSink{Brokers: []string{broker.Addr().String()}, Topic: "events"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	for _, value := range []string{"1", "2"} {
+		if err := p.Write([][]byte{[]byte(value)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	requests := broker.received()
+	if len(requests) != 3 {
+		t.Fatalf("broker received %d requests, want a metadata and two produce requests", len(requests))
+	}
+	if r := requests[0]; r.apiKey != 3 || r.apiVersion != 1 || r.clientID != OTEL_SERVICE_NAME {
+		t.Errorf("metadata request = %+v", r)
+	}
+	var partitions []int32
+	for i, r := range requests[1:] {
+		if r.apiKey != 0 || r.apiVersion != 3 {
+			t.Fatalf("produce request = %+v", r)
+		}
+		d := kafkaDecoder{buf: r.body}
+		if transactionalID := d.int16(); transactionalID != -1 {
+			t.Errorf("transactional id length = %d, want -1", transactionalID)
+		}
+		if acks := d.int16(); acks != 1 {
+			t.Errorf("acks = %d, want 1", acks)
+		}
+		d.int32() // timeout
+		d.int32()
+		if topic := d.string(); topic != "events" {
+			t.Errorf("topic = %q", topic)
+		}
+		d.int32()
+		partitions = append(partitions, d.int32())
+		records := d.take(int(d.int32()))
+		if values := decodeRecordBatch(t, records); len(values) != 1 || string(values[0]) != []string{"1", "2"}[i] {
+			t.Errorf("produced %q", values)
+		}
+	}
+	if !reflect.DeepEqual(partitions, []int32{0, 1}) {
+		t.Errorf("produced to partitions %v, want [0 1]", partitions)
+	}
+}
+
+func TestKafkaProducerErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		respond func(addr string, req kafkaRequest, correlationID int32) []byte
+		wantErr string
+	}{
+		{
+			name: "topic error",
+			respond: func(addr string, req kafkaRequest, correlationID int32) []byte {
+				return kafkaFrame(correlationID, metadataResponse(addr, "events", 3))
+			},
+			wantErr: "error code 3",
+		},
+		{
+			name: "no partitions",
+			respond: func(addr string, req kafkaRequest, correlationID int32) []byte {
+				return kafkaFrame(correlationID, metadataResponse(addr, "events", 0))
+			},
+			wantErr: "no partition",
+		},
+		{
+			name: "truncated metadata",
+			respond: func(addr string, req kafkaRequest, correlationID int32) []byte {
+				body := metadataResponse(addr, "events", 0, 0)
+				return kafkaFrame(correlationID, body[:len(body)-3])
+			},
+			wantErr: "unexpected EOF",
+		},
+		{
+			name: "produce error",
+			respond: func(addr string, req kafkaRequest, correlationID int32) []byte {
+				if req.apiKey == 3 {
+					return kafkaFrame(correlationID, metadataResponse(addr, "events", 0, 0))
+				}
+				return kafkaFrame(correlationID, produceResponse("events", 0, 6))
+			},
+			wantErr: "error code 6",
+		},
+		{
+			name: "wrong correlation id",
+			respond: func(addr string, req kafkaRequest, correlationID int32) []byte {
+				return kafkaFrame(correlationID+1, nil)
+			},
+			wantErr: "correlation id",
+		},
+		{
+			name: "oversized response",
+			respond: func(addr string, req kafkaRequest, correlationID int32) []byte {
+				return binary.BigEndian.AppendUint32(nil, KAFKA_MAX_RESPONSE_SIZE+1)
+			},
+			wantErr: "invalid response size",
+		},
+		{
+			name: "negative response size",
+			respond: func(addr string, req kafkaRequest, correlationID int32) []byte {
+				return binary.BigEndian.AppendUint32(nil, 0xffffffff)
+			},
+			wantErr: "invalid response size -1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var broker *fakeBroker
+			broker = newFakeBroker(t, func(req kafkaRequest, correlationID int32) []byte {
+				return tt.respond(broker.Addr().String(), req, correlationID)
+			})
+			p, err := newKafkaProducer(KafkaSink{Brokers: []string{broker.Addr().String()}, Topic: "events"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+
+			err = p.Write([][]byte{[]byte("1")})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Write() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+			if len(p.partitions) != 0 {
+				t.Error("the partitions were not looked up again after the failure")
+			}
+		})
+	}
+}
+
+func TestNewKafkaProducerRequiresBrokersAndTopic(t *testing.T) {
+	for _, cfg := range []KafkaSink{{Topic: "events"}, {Brokers: []string{"localhost:9092"}}} {
+		if _, err := newKafkaProducer(cfg); err == nil {
+			t.Errorf("newKafkaProducer(%+v) succeeded", cfg)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	b64 "encoding/base64"
 	"encoding/json"
@@ -9,88 +8,120 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"os/signal"
 	"strings"
 	"syscall"
-	"text/template"
 
 	"github.com/rs/zerolog/log"
 )
 
 func main() {
-	if err := generateBpftraceScript(); err != nil {
-		log.Fatal().Err(err).Msg("Failed to generate bpftrace script")
+	if len(os.Args) > 1 && os.Args[1] == "collect" {
+		if err := runCollector(); err != nil {
+			log.Fatal().Err(err).Msg("Trace capture failed")
+		}
+		return
+	}
+
+	if err := loadBpftraceScript(); err != nil {
+		log.Fatal().Err(err).Msg("Failed to load bpftrace script")
 	}
 
+	startServer(AGENT_LISTEN_ADDR, agentHandler())
+	startServer(AGENT_DEBUG_ADDR, debugHandler())
+
 	// Step 2: Set up context and signal handling
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	sigChan := setupSignalHandler()
 
+	// Export over OTLP when a collector endpoint is configured
+	otelDone := make(chan struct{})
+	if otel = newOTLPExporterFromEnv(); otel != nil {
+		go func() {
+			otel.Run(ctx)
+			close(otelDone)
+		}()
+	} else {
+		close(otelDone)
+	}
+
+	// Forward events to the output sinks of the policy
+	sinkConfigs, err := loadOutputSinks()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load output sinks")
+	}
+	sinksDone := startSinks(ctx, sinkConfigs)
+
+	// Namespace-scoped policies only report processes of their own pods
+	if tenantPods, err = newPodFilterFromEnv(); err != nil {
+		log.Fatal().Err(err).Msg("Failed to load tenant pods")
+	}
+	if tenantPods != nil {
+		go tenantPods.Watch(ctx)
+	}
+
+	// Buffer bpftrace output between the pipe and event processing
+	if eventQueue, err = newLineQueueFromEnv(); err != nil {
+		log.Fatal().Err(err).Msg("Failed to set up the event queue")
+	}
+
 	// Step 3: Execute the bpftrace script
 	if err := executeBpftraceScript(ctx, sigChan, cancel); err != nil {
 		log.Fatal().Err(err).Msg("Failed to execute bpftrace script")
 	}
+	<-otelDone
+	sinksDone.Wait()
 
 	log.Info().Msg("Application shutdown complete")
 }
 
-// generateBpftraceScript generates a bpftrace script from a template
-func generateBpftraceScript() error {
-	var probes []string
-	templateData := TemplateProbeLib{
-		ProbeLib: []string{},
-	}
-	log.Info().Msg("Generating bpftrace script from template...")
-	probeEnv := os.Getenv("PROBE_CALLS")
-	if len(probeEnv) == 0 {
-		err := errors.New("missing environment variable PROBE_CALLS")
-		log.Err(err).Msg("Please set PROBE_CALLS environment variable")
-		return err
+// loadOutputSinks decodes the optional OUTPUT_SINKS environment variable
+func loadOutputSinks() ([]OutputSink, error) {
+	var configs []OutputSink
+	sinkEnv := os.Getenv("OUTPUT_SINKS")
+	if len(sinkEnv) == 0 {
+		return nil, nil
 	}
-	sDec, _ := b64.StdEncoding.DecodeString(probeEnv)
-	if err := json.Unmarshal([]byte(sDec), &probes); err != nil {
-		log.Err(err).Msg("Error while running json.Unmarshal() ")
+	sDec, err := b64.StdEncoding.DecodeString(sinkEnv)
+	if err != nil {
+		return nil, fmt.Errorf("decoding OUTPUT_SINKS: %w", err)
 	}
-	// Access the parsed data
-	for _, p := range probes {
-		templateData.ProbeLib = append(templateData.ProbeLib, p)
+	if err := json.Unmarshal(sDec, &configs); err != nil {
+		return nil, fmt.Errorf("parsing OUTPUT_SINKS: %w", err)
 	}
+	return configs, nil
+}
 
-	// Create template function map
-	funcMap := template.FuncMap{
-		"contains": func(needle string, haystack []string) bool {
-			for _, item := range haystack {
-				if strings.EqualFold(item, needle) {
-					return true
-				}
-			}
-			return false
-		},
-	}
+// scriptPath is the bpftrace script rendered by the operator.
+var scriptPath string
 
-	// Parse template
-	tmpl, err := template.New("nvidia_events.bt.tmpl").Funcs(funcMap).ParseFiles(TEMPLATE_FILE_PATH)
-	if err != nil {
+// loadBpftraceScript locates the bpftrace script rendered by the operator
+// and the functions it traces
+func loadBpftraceScript() error {
+	scriptPath = os.Getenv("SCRIPT_PATH")
+	if len(scriptPath) == 0 {
+		err := errors.New("missing environment variable SCRIPT_PATH")
+		log.Err(err).Msg("Please set SCRIPT_PATH environment variable")
 		return err
 	}
-	fmt.Println(tmpl)
-
-	// Create output file
-	f, err := os.Create(BT_FILE_PATH)
-	if err != nil {
-		return err
+	if _, err := os.Stat(scriptPath); err != nil {
+		return fmt.Errorf("reading bpftrace script: %w", err)
 	}
-	defer f.Close()
 
-	// Execute template and write to file
-	if err := tmpl.Execute(f, templateData); err != nil {
-		return err
+	// FUNCTION_CALLS is optional; policies without spec.functions leave it empty
+	if functionEnv := os.Getenv("FUNCTION_CALLS"); len(functionEnv) > 0 {
+		fDec, err := b64.StdEncoding.DecodeString(functionEnv)
+		if err != nil {
+			return fmt.Errorf("decoding FUNCTION_CALLS: %w", err)
+		}
+		if err := json.Unmarshal(fDec, &tracedFunctions); err != nil {
+			return fmt.Errorf("parsing FUNCTION_CALLS: %w", err)
+		}
 	}
 
-	log.Info().Str("path", BT_FILE_PATH).Msg("CUDA Event tracer successfully generated")
+	log.Info().Str("path", scriptPath).Msg("CUDA Event tracer loaded")
 	return nil
 }
 
@@ -102,56 +133,87 @@ func setupSignalHandler() chan os.Signal {
 	return sigChan
 }
 
-// executeBpftraceScript executes the bpftrace script and streams output
+// executeBpftraceScript runs the bpftrace script under supervision until an
+// interrupt signal is received
 func executeBpftraceScript(ctx context.Context, sigChan chan os.Signal, cancel context.CancelFunc) error {
 	log.Info().Msg("Starting bpftrace script execution...")
-	cmd := exec.CommandContext(ctx, "/usr/bin/bpftrace", BT_FILE_PATH)
-	stdoutPipe, err := cmd.StdoutPipe()
-	if err != nil {
-		return err
-	}
+	go func() {
+		select {
+		case <-sigChan:
+			log.Info().Msg("Received interrupt signal, shutting down bpftrace...")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
 
-	stderrPipe, err := cmd.StderrPipe()
-	if err != nil {
+	if err := superviseBpftrace(ctx); err != nil {
 		return err
 	}
+	log.Info().Msg("bpftrace script stopped successfully")
+	return nil
+}
 
-	// Start the command
-	if err := cmd.Start(); err != nil {
-		return err
+// streamEvents reads bpftrace stdout line-by-line into the event queue and
+// processes it on a separate goroutine, so that slow consumers do not block
+// the pipe
+func streamEvents(pipe io.Reader) {
+	err := readLines(pipe, OUTPUT_MAX_LINE, func(line string) {
+		// The script prints its banner from BEGIN, after all probes are attached
+		if strings.HasPrefix(line, READY_BANNER) {
+			health.setReady()
+		}
+		eventQueue.Push(line)
+	})
+	if err != nil {
+		log.Error().Err(err).Str("source", "stdout").Msg("Error reading output")
 	}
+}
 
-	log.Info().Msg("bpftrace script started successfully")
-
-	// Stream outputs concurrently
-	go streamOutput(stdoutPipe, "stdout")
-	go streamOutput(stderrPipe, "stderr")
-
-	// Wait for interrupt signal
-	<-sigChan
-	log.Info().Msg("Received interrupt signal, shutting down bpftrace...")
-	cancel()
-
-	// Wait for the command to finish
-	if err := cmd.Wait(); err != nil {
-		// Ignore "signal: killed" error as it's expected
-		if err.Error() != "signal: killed" {
-			log.Error().Err(err).Msg("Error while waiting for bpftrace to exit")
-			return err
+// processEvents handles event lines and logs everything else as plain output
+func processEvents(queue *lineQueue) {
+	parser := &stackParser{}
+	for {
+		line, ok := queue.Pop()
+		if !ok {
+			return
+		}
+		if sample, consumed := parser.Feed(line); consumed {
+			if sample != nil && tenantPods.Allows(sample.Pid) {
+				stacks.Add(*sample)
+			}
+			continue
 		}
+		if ev, ok := parseEvent(line); ok {
+			if !tenantPods.Allows(ev.Pid) {
+				metrics.Inc("gpu_bpf_events_filtered_total")
+				continue
+			}
+			handleEvent(ev, line)
+			continue
+		}
+		// Map dumps aggregate over all processes of the node and cannot be
+		// attributed to pods
+		if tenantPods != nil {
+			continue
+		}
+		aggregates.Record(line)
+		log.Info().Str("source", "stdout").Msg(line)
 	}
-
-	log.Info().Msg("bpftrace script stopped successfully")
-	return nil
 }
 
-// streamOutput reads from a pipe line-by-line and logs with source tag
-func streamOutput(pipe io.Reader, source string) {
-	scanner := bufio.NewScanner(pipe)
-	for scanner.Scan() {
-		log.Info().Str("source", source).Msg(scanner.Text())
-	}
-	if err := scanner.Err(); err != nil {
+// streamOutput reads from a pipe line-by-line and logs with source tag,
+// keeping the last lines in tail if it is not nil
+func streamOutput(pipe io.Reader, source string, tail *lineRing) {
+	err := readLines(pipe, OUTPUT_MAX_LINE, func(line string) {
+		if tail != nil {
+			tail.Add(line)
+		}
+		if attachFailure.MatchString(line) {
+			metrics.Inc("gpu_bpf_probe_attach_failures_total")
+		}
+		log.Info().Str("source", source).Msg(line)
+	})
+	if err != nil {
 		log.Error().Err(err).Str("source", source).Msg("Error reading output")
 	}
 }
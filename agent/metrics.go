@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// metrics is the agent-wide registry served on /metrics.
+var metrics = newMetricsRegistry()
+
+// metricsRegistry is a minimal Prometheus text-format registry. Series are
+// identified by metric name plus an ordered list of label key/value pairs.
+type metricsRegistry struct {
+	mu     sync.Mutex
+	help   map[string]string
+	kind   map[string]string
+	series map[string]map[string]float64
+	labels map[string]map[string][]string
+}
+
+func newMetricsRegistry() *metricsRegistry {
+	m := &metricsRegistry{
+		help:   map[string]string{},
+		kind:   map[string]string{},
+		series: map[string]map[string]float64{},
+		labels: map[string]map[string][]string{},
+	}
+	m.Describe("gpu_bpf_events_total", "counter", "Events emitted by the bpftrace script, by event type.")
+	m.Describe("gpu_bpf_function_errors_total", "counter", "Error returns of traced functions, by function and error code.")
+	m.Describe("gpu_bpf_capture_dropped_events_total", "counter", "Events dropped because a trace capture could not keep up.")
+	m.Describe("gpu_bpf_otlp_dropped_events_total", "counter", "Events dropped because the OTLP export queue was full.")
+	m.Describe("gpu_bpf_otlp_export_failures_total", "counter", "Failed OTLP export requests, by signal.")
+	m.Describe("gpu_bpf_sink_events_total", "counter", "Events handled by output sinks, by sink and result.")
+	m.Describe("gpu_bpf_sink_batches_total", "counter", "Batches written by output sinks, by sink and result.")
+	m.Describe("gpu_bpf_sink_retries_total", "counter", "Retried batch deliveries, by sink.")
+	m.Describe("gpu_bpf_sink_write_seconds_total", "counter", "Time spent writing batches, by sink.")
+	m.Describe("gpu_bpf_sink_queue_length", "gauge", "Events waiting in the queue of an output sink.")
+	m.Describe("gpu_bpf_events_dropped_total", "counter", "Output lines dropped because the event queue was full, by drop policy.")
+	m.Describe("gpu_bpf_events_spilled_total", "counter", "Output lines written to the spill file.")
+	m.Describe("gpu_bpf_event_queue_length", "gauge", "Output lines waiting in the in-memory event queue.")
+	m.Describe("gpu_bpf_event_spill_bytes", "gauge", "Size of the spill file.")
+	m.Describe("gpu_bpf_bpftrace_up", "gauge", "Whether bpftrace is running.")
+	m.Describe("gpu_bpf_bpftrace_restarts_total", "counter", "Restarts of bpftrace after it exited unexpectedly.")
+	m.Describe("gpu_bpf_events_per_second", "gauge", "Events handled per second over the last interval.")
+	m.Describe("gpu_bpf_parse_errors_total", "counter", "Event lines that could not be parsed.")
+	m.Describe("gpu_bpf_probe_attach_failures_total", "counter", "Probes bpftrace reported it could not attach.")
+	m.Describe("gpu_bpf_output_lines_too_long_total", "counter", "Output lines skipped because they exceeded the maximum line length.")
+	m.Describe("gpu_bpf_events_filtered_total", "counter", "Events dropped because they came from processes outside of the policy's pods.")
+	m.Describe("gpu_bpf_tenant_pods", "gauge", "Pods whose processes a namespace-scoped policy reports.")
+	return m
+}
+
+// Describe registers the type and help text of a metric.
+func (m *metricsRegistry) Describe(name, kind, help string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.kind[name] = kind
+	m.help[name] = help
+}
+
+// Inc increments a counter series by one.
+func (m *metricsRegistry) Inc(name string, labels ...string) {
+	m.Add(name, 1, labels...)
+}
+
+// Add increments a counter series by delta.
+func (m *metricsRegistry) Add(name string, delta float64, labels ...string) {
+	key := labelString(labels)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ensure(name, key, labels)
+	m.series[name][key] += delta
+}
+
+// Set sets a gauge series to value.
+func (m *metricsRegistry) Set(name string, value float64, labels ...string) {
+	key := labelString(labels)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ensure(name, key, labels)
+	m.series[name][key] = value
+}
+
+// ensure creates a series on first use. The caller must hold m.mu.
+func (m *metricsRegistry) ensure(name, key string, labels []string) {
+	if m.series[name] == nil {
+		m.series[name] = map[string]float64{}
+		m.labels[name] = map[string][]string{}
+	}
+	if _, ok := m.labels[name][key]; !ok {
+		m.labels[name][key] = append([]string(nil), labels...)
+	}
+}
+
+// metricPoint is the current value of one series.
+type metricPoint struct {
+	Name   string
+	Kind   string
+	Help   string
+	Labels []string
+	Value  float64
+}
+
+// Snapshot returns the current value of every series, sorted by name.
+func (m *metricsRegistry) Snapshot() []metricPoint {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var points []metricPoint
+	for name, series := range m.series {
+		for key, value := range series {
+			points = append(points, metricPoint{
+				Name:   name,
+				Kind:   m.kind[name],
+				Help:   m.help[name],
+				Labels: m.labels[name][key],
+				Value:  value,
+			})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].Name != points[j].Name {
+			return points[i].Name < points[j].Name
+		}
+		return labelString(points[i].Labels) < labelString(points[j].Labels)
+	})
+	return points
+}
+
+// Write writes all series in the Prometheus text exposition format.
+func (m *metricsRegistry) Write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.series))
+	for name := range m.series {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if help, ok := m.help[name]; ok {
+			fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+			fmt.Fprintf(w, "# TYPE %s %s\n", name, m.kind[name])
+		}
+		keys := make([]string, 0, len(m.series[name]))
+		for key := range m.series[name] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(w, "%s%s %g\n", name, key, m.series[name][key])
+		}
+	}
+}
+
+// labelString renders key/value pairs as a Prometheus label set.
+func labelString(labels []string) string {
+	if len(labels) < 2 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		fmt.Fprintf(&b, `%s="%s"`, labels[i], value)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
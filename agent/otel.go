@@ -0,0 +1,481 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// otel exports events and metrics over OTLP/HTTP. It is nil unless
+// OTEL_EXPORTER_OTLP_ENDPOINT is set.
+var otel *otlpExporter
+
+var (
+	bpftraceStartMu sync.Mutex
+	bpftraceStart   = time.Now()
+)
+
+// setBpftraceStart records when bpftrace was started; event timestamps are
+// relative to it.
+func setBpftraceStart(t time.Time) {
+	bpftraceStartMu.Lock()
+	bpftraceStart = t
+	bpftraceStartMu.Unlock()
+}
+
+// eventTime converts a duration since the bpftrace start into wall clock time.
+func eventTime(sinceStart time.Duration) time.Time {
+	bpftraceStartMu.Lock()
+	defer bpftraceStartMu.Unlock()
+	return bpftraceStart.Add(sinceStart)
+}
+
+// otlpExporter batches events into OTLP logs and spans and periodically
+// exports the agent metrics, using the OTLP/HTTP JSON encoding so that no
+// protobuf or gRPC dependencies are needed.
+type otlpExporter struct {
+	endpoint       string
+	headers        map[string]string
+	resource       otlpResource
+	metricInterval time.Duration
+	client         *http.Client
+	queue          chan *Event
+	startTime      time.Time
+}
+
+// newOTLPExporterFromEnv configures the exporter from the standard
+// OTEL_EXPORTER_OTLP_ENDPOINT, OTEL_EXPORTER_OTLP_HEADERS, OTEL_SERVICE_NAME,
+// OTEL_RESOURCE_ATTRIBUTES and OTEL_METRIC_EXPORT_INTERVAL variables, and the
+// NODE_NAME, POD_NAME, POD_NAMESPACE and POLICY_NAME variables set by the
+// operator.
+func newOTLPExporterFromEnv() *otlpExporter {
+	endpoint := strings.TrimSuffix(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "/")
+	if endpoint == "" {
+		return nil
+	}
+
+	serviceName := os.Getenv("OTEL_SERVICE_NAME")
+	if serviceName == "" {
+		serviceName = OTEL_SERVICE_NAME
+	}
+	attrs := []otlpKeyValue{stringAttr("service.name", serviceName)}
+	for _, kv := range []struct{ key, env string }{
+		{"k8s.node.name", "NODE_NAME"},
+		{"k8s.pod.name", "POD_NAME"},
+		{"k8s.namespace.name", "POD_NAMESPACE"},
+		{"gpu_bpf.policy.name", "POLICY_NAME"},
+	} {
+		if v := os.Getenv(kv.env); v != "" {
+			attrs = append(attrs, stringAttr(kv.key, v))
+		}
+	}
+	for key, value := range parseKeyValueList(os.Getenv("OTEL_RESOURCE_ATTRIBUTES")) {
+		attrs = append(attrs, stringAttr(key, value))
+	}
+
+	interval := OTEL_METRIC_INTERVAL
+	if v := os.Getenv("OTEL_METRIC_EXPORT_INTERVAL"); v != "" {
+		if ms, err := strconv.Atoi(v); err == nil && ms > 0 {
+			interval = time.Duration(ms) * time.Millisecond
+		}
+	}
+
+	return &otlpExporter{
+		endpoint:       endpoint,
+		headers:        parseKeyValueList(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS")),
+		resource:       otlpResource{Attributes: attrs},
+		metricInterval: interval,
+		client:         &http.Client{Timeout: 10 * time.Second},
+		queue:          make(chan *Event, OTEL_QUEUE_SIZE),
+		startTime:      time.Now(),
+	}
+}
+
+// parseKeyValueList parses the "key=value,key=value" lists used by the
+// OTEL_* variables.
+func parseKeyValueList(value string) map[string]string {
+	out := map[string]string{}
+	for _, entry := range strings.Split(value, ",") {
+		key, val, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			continue
+		}
+		out[strings.TrimSpace(key)] = strings.TrimSpace(val)
+	}
+	return out
+}
+
+// Enqueue hands an event over to the exporter without blocking the
+// bpftrace reader.
+func (e *otlpExporter) Enqueue(ev *Event) {
+	select {
+	case e.queue <- ev:
+	default:
+		metrics.Inc("gpu_bpf_otlp_dropped_events_total")
+	}
+}
+
+// Run exports queued events in batches and the metrics on every interval
+// until ctx is cancelled.
+func (e *otlpExporter) Run(ctx context.Context) {
+	log.Info().Str("endpoint", e.endpoint).Msg("Exporting events and metrics over OTLP")
+	flush := time.NewTicker(OTEL_FLUSH_INTERVAL)
+	defer flush.Stop()
+	metricTicker := time.NewTicker(e.metricInterval)
+	defer metricTicker.Stop()
+
+	var batch []*Event
+	for {
+		select {
+		case <-ctx.Done():
+			e.exportEvents(batch)
+			e.exportMetrics()
+			return
+		case ev := <-e.queue:
+			batch = append(batch, ev)
+			if len(batch) >= OTEL_BATCH_SIZE {
+				e.exportEvents(batch)
+				batch = nil
+			}
+		case <-flush.C:
+			e.exportEvents(batch)
+			batch = nil
+		case <-metricTicker.C:
+			e.exportMetrics()
+		}
+	}
+}
+
+// exportEvents sends SPAN events as spans and every other event as a log record.
+func (e *otlpExporter) exportEvents(batch []*Event) {
+	if len(batch) == 0 {
+		return
+	}
+	var records []otlpLogRecord
+	var spans []otlpSpan
+	for _, ev := range batch {
+		if ev.Type == "SPAN" {
+			if span, ok := e.span(ev); ok {
+				spans = append(spans, span)
+			}
+			continue
+		}
+		records = append(records, e.logRecord(ev))
+	}
+
+	if len(records) > 0 {
+		e.post("/v1/logs", map[string]any{
+			"resourceLogs": []any{map[string]any{
+				"resource":  e.resource,
+				"scopeLogs": []any{map[string]any{"scope": otlpScope, "logRecords": records}},
+			}},
+		})
+	}
+	if len(spans) > 0 {
+		e.post("/v1/traces", map[string]any{
+			"resourceSpans": []any{map[string]any{
+				"resource":   e.resource,
+				"scopeSpans": []any{map[string]any{"scope": otlpScope, "spans": spans}},
+			}},
+		})
+	}
+}
+
+// eventAttributes describes the process and GPU an event belongs to.
+func eventAttributes(ev *Event) []otlpKeyValue {
+	attrs := []otlpKeyValue{
+		stringAttr("event.name", ev.Type),
+		stringAttr("process.command", ev.Comm),
+		intAttr("process.pid", int64(ev.Pid)),
+	}
+	if uid := podUIDForPid(ev.Pid); uid != "" {
+		attrs = append(attrs, stringAttr("k8s.pod.uid", uid))
+	}
+	if ev.GpuID != "" {
+		attrs = append(attrs, stringAttr("gpu.id", ev.GpuID))
+	}
+	return attrs
+}
+
+func (e *otlpExporter) logRecord(ev *Event) otlpLogRecord {
+	attrs := eventAttributes(ev)
+	for key, value := range ev.Fields {
+		attrs = append(attrs, stringAttr("gpu_bpf."+key, value))
+	}
+	severity, severityText := 9, "INFO"
+	if ev.ErrorName != "" || strings.HasSuffix(ev.Type, "_FAILED") || strings.HasSuffix(ev.Type, "_ERROR") {
+		severity, severityText = 13, "WARN"
+	}
+	if ev.ErrorName != "" {
+		attrs = append(attrs, stringAttr("error.type", ev.ErrorName))
+	}
+	body := ev.Type
+	if ev.Details != "" {
+		body += " " + ev.Details
+	}
+	return otlpLogRecord{
+		TimeUnixNano:         unixNano(eventTime(time.Duration(ev.ElapsedMs) * time.Millisecond)),
+		ObservedTimeUnixNano: unixNano(time.Now()),
+		SeverityNumber:       severity,
+		SeverityText:         severityText,
+		Body:                 otlpAnyValue{StringValue: &body},
+		Attributes:           attrs,
+	}
+}
+
+// span turns a SPAN event (fn, tid, start_ns, dur_ns, ret) into a span.
+func (e *otlpExporter) span(ev *Event) (otlpSpan, bool) {
+	startNs, err1 := strconv.ParseUint(ev.Fields["start_ns"], 10, 64)
+	durNs, err2 := strconv.ParseUint(ev.Fields["dur_ns"], 10, 64)
+	if err1 != nil || err2 != nil {
+		return otlpSpan{}, false
+	}
+	start := eventTime(time.Duration(startNs))
+	fn := ev.Fields["fn"]
+
+	attrs := eventAttributes(ev)
+	if tid, err := strconv.ParseInt(ev.Fields["tid"], 10, 64); err == nil {
+		attrs = append(attrs, intAttr("thread.id", tid))
+	}
+	span := otlpSpan{
+		TraceID:           randomHex(16),
+		SpanID:            randomHex(8),
+		Name:              fn,
+		Kind:              1, // SPAN_KIND_INTERNAL
+		StartTimeUnixNano: unixNano(start),
+		EndTimeUnixNano:   unixNano(start.Add(time.Duration(durNs))),
+	}
+
+	ret := ev.Fields["ret"]
+	attrs = append(attrs, stringAttr("code.function", fn), stringAttr("gpu_bpf.return", ret))
+	if class := returnClass(fn); class != "" {
+		if code, failed := classifyReturn(class, ret); failed {
+			name := errorName(class, code)
+			attrs = append(attrs, stringAttr("error.type", name))
+			span.Status = &otlpStatus{Code: 2, Message: name} // STATUS_CODE_ERROR
+		}
+	}
+	span.Attributes = attrs
+	return span, true
+}
+
+// returnClass returns how the return value of a traced function is classified.
+func returnClass(fn string) string {
+	for _, f := range tracedFunctions {
+		if f.Name == fn {
+			return f.Returns
+		}
+	}
+	return ""
+}
+
+// classifyReturn applies the bpftrace template's error rules to a raw return
+// value and returns the error code of failed calls.
+func classifyReturn(class, ret string) (string, bool) {
+	v, err := strconv.ParseInt(ret, 10, 64)
+	if err != nil {
+		return "", false
+	}
+	switch class {
+	case "errno":
+		// errno and cudaError functions return a 32-bit int, whose upper
+		// half bpftrace does not sign-extend into retval
+		code := int64(int32(v))
+		return strconv.FormatInt(-code, 10), code < 0
+	case "cudaError":
+		code := int64(int32(v))
+		return strconv.FormatInt(code, 10), code != 0
+	case "pointer":
+		return "0", v == 0
+	}
+	return "", false
+}
+
+// exportMetrics sends the agent counters and the latest bpftrace map values.
+func (e *otlpExporter) exportMetrics() {
+	now := unixNano(time.Now())
+	start := unixNano(e.startTime)
+
+	byName := map[string]*otlpMetric{}
+	var order []string
+	for _, p := range metrics.Snapshot() {
+		m, ok := byName[p.Name]
+		if !ok {
+			m = &otlpMetric{Name: p.Name, Description: p.Help}
+			if p.Kind == "counter" {
+				m.Sum = &otlpSum{AggregationTemporality: 2, IsMonotonic: true} // CUMULATIVE
+			} else {
+				m.Gauge = &otlpGauge{}
+			}
+			byName[p.Name] = m
+			order = append(order, p.Name)
+		}
+		var attrs []otlpKeyValue
+		for i := 0; i+1 < len(p.Labels); i += 2 {
+			attrs = append(attrs, stringAttr(p.Labels[i], p.Labels[i+1]))
+		}
+		point := otlpDataPoint{Attributes: attrs, StartTimeUnixNano: start, TimeUnixNano: now, AsDouble: p.Value}
+		if m.Sum != nil {
+			m.Sum.DataPoints = append(m.Sum.DataPoints, point)
+		} else {
+			m.Gauge.DataPoints = append(m.Gauge.DataPoints, point)
+		}
+	}
+
+	out := make([]*otlpMetric, 0, len(order)+1)
+	for _, name := range order {
+		out = append(out, byName[name])
+	}
+	if snapshot := aggregates.Snapshot(); len(snapshot) > 0 {
+		gauge := &otlpMetric{
+			Name:        "gpu_bpf_map_value",
+			Description: "Latest value of the bpftrace map entries printed by the script.",
+			Gauge:       &otlpGauge{},
+		}
+		for entry, value := range snapshot {
+			name, key, _ := strings.Cut(strings.TrimSuffix(entry, "]"), "[")
+			gauge.Gauge.DataPoints = append(gauge.Gauge.DataPoints, otlpDataPoint{
+				Attributes:   []otlpKeyValue{stringAttr("map", name), stringAttr("key", key)},
+				TimeUnixNano: now,
+				AsDouble:     float64(value),
+			})
+		}
+		out = append(out, gauge)
+	}
+	if len(out) == 0 {
+		return
+	}
+
+	e.post("/v1/metrics", map[string]any{
+		"resourceMetrics": []any{map[string]any{
+			"resource":     e.resource,
+			"scopeMetrics": []any{map[string]any{"scope": otlpScope, "metrics": out}},
+		}},
+	})
+}
+
+func (e *otlpExporter) post(path string, payload any) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Error().Err(err).Str("path", path).Msg("Failed to encode OTLP payload")
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, e.endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		log.Error().Err(err).Str("path", path).Msg("Failed to create OTLP request")
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		metrics.Inc("gpu_bpf_otlp_export_failures_total", "signal", strings.TrimPrefix(path, "/v1/"))
+		log.Warn().Err(err).Str("path", path).Msg("OTLP export failed")
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		metrics.Inc("gpu_bpf_otlp_export_failures_total", "signal", strings.TrimPrefix(path, "/v1/"))
+		log.Warn().Str("path", path).Str("status", resp.Status).Msg("OTLP export rejected")
+	}
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// OTLP JSON encoding, see opentelemetry-proto/docs/specification.md. 64-bit
+// integers are encoded as decimal strings and IDs as hex strings.
+
+var otlpScope = map[string]string{"name": OTEL_SERVICE_NAME}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+func stringAttr(key, value string) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{StringValue: &value}}
+}
+
+func intAttr(key string, value int64) otlpKeyValue {
+	s := fmt.Sprint(value)
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{IntValue: &s}}
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano         string         `json:"timeUnixNano"`
+	ObservedTimeUnixNano string         `json:"observedTimeUnixNano"`
+	SeverityNumber       int            `json:"severityNumber"`
+	SeverityText         string         `json:"severityText"`
+	Body                 otlpAnyValue   `json:"body"`
+	Attributes           []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            *otlpStatus    `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpMetric struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Sum         *otlpSum   `json:"sum,omitempty"`
+	Gauge       *otlpGauge `json:"gauge,omitempty"`
+}
+
+type otlpSum struct {
+	AggregationTemporality int             `json:"aggregationTemporality"`
+	IsMonotonic            bool            `json:"isMonotonic"`
+	DataPoints             []otlpDataPoint `json:"dataPoints"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpDataPoint `json:"dataPoints"`
+}
+
+type otlpDataPoint struct {
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string         `json:"timeUnixNano"`
+	AsDouble          float64        `json:"asDouble"`
+}
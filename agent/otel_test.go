@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// otlpCollector is a stand-in for an OpenTelemetry collector that records
+// the requests it receives per path.
+type otlpCollector struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests map[string][]map[string]any
+	headers  http.Header
+}
+
+func newOTLPCollector(t *testing.T, status int) *otlpCollector {
+	c := &otlpCollector{requests: map[string][]map[string]any{}}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var payload map[string]any
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Errorf("collector received invalid JSON on %s: %v", r.URL.Path, err)
+		}
+		c.mu.Lock()
+		c.requests[r.URL.Path] = append(c.requests[r.URL.Path], payload)
+		c.headers = r.Header.Clone()
+		c.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(c.Close)
+	return c
+}
+
+func (c *otlpCollector) header(key string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.headers.Get(key)
+}
+
+func (c *otlpCollector) received(path string) []map[string]any {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.requests[path]
+}
+
+// first walks a decoded OTLP payload through the first element of each list.
+func first(payload map[string]any, keys ...string) map[string]any {
+	cur := payload
+	for _, key := range keys {
+		cur = cur[key].([]any)[0].(map[string]any)
+	}
+	return cur
+}
+
+// attributes flattens OTLP key/value attributes.
+func attributes(obj map[string]any) map[string]string {
+	out := map[string]string{}
+	attrs, _ := obj["attributes"].([]any)
+	for _, a := range attrs {
+		kv := a.(map[string]any)
+		value := kv["value"].(map[string]any)
+		if s, ok := value["stringValue"].(string); ok {
+			out[kv["key"].(string)] = s
+		} else {
+			out[kv["key"].(string)] = value["intValue"].(string)
+		}
+	}
+	return out
+}
+
+func TestClassifyReturn(t *testing.T) {
+	tests := []struct {
+		class, ret string
+		code       string
+		failed     bool
+	}{
+		{"errno", "0", "0", false},
+		{"errno", "3", "-3", false},
+		{"errno", "-22", "22", true},
+		// -ENOENT as a 32-bit int that bpftrace did not sign-extend
+		{"errno", "4294967294", "2", true},
+		{"cudaError", "0", "0", false},
+		{"cudaError", "2", "2", true},
+		{"cudaError", "4294967295", "-1", true},
+		{"pointer", "0", "0", true},
+		{"pointer", "140737488355328", "0", false},
+		{"errno", "bogus", "", false},
+		{"unknown", "-1", "", false},
+	}
+	for _, tt := range tests {
+		code, failed := classifyReturn(tt.class, tt.ret)
+		if code != tt.code || failed != tt.failed {
+			t.Errorf("classifyReturn(%q, %q) = %q, %v, want %q, %v", tt.class, tt.ret, code, failed, tt.code, tt.failed)
+		}
+	}
+}
+
+func TestParseKeyValueList(t *testing.T) {
+	got := parseKeyValueList(" a = 1 ,b=2,,=3,c,d=x=y")
+	want := map[string]string{"a": "1", "b": "2", "d": "x=y"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseKeyValueList() = %v, want %v", got, want)
+	}
+}
+
+func TestNewOTLPExporterFromEnv(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	if e := newOTLPExporterFromEnv(); e != nil {
+		t.Fatal("exporter configured without an endpoint")
+	}
+
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4318/")
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "authorization=Bearer token")
+	t.Setenv("OTEL_SERVICE_NAME", "")
+	t.Setenv("OTEL_RESOURCE_ATTRIBUTES", "deployment.environment=test")
+	t.Setenv("OTEL_METRIC_EXPORT_INTERVAL", "1500")
+	t.Setenv("NODE_NAME", "node-a")
+	t.Setenv("POD_NAME", "")
+	t.Setenv("POD_NAMESPACE", "")
+	t.Setenv("POLICY_NAME", "nvidia")
+
+	e := newOTLPExporterFromEnv()
+	if e.endpoint != "http://collector:4318" {
+		t.Errorf("endpoint = %q", e.endpoint)
+	}
+	if e.headers["authorization"] != "Bearer token" {
+		t.Errorf("headers = %v", e.headers)
+	}
+	if e.metricInterval != 1500*time.Millisecond {
+		t.Errorf("metric interval = %v", e.metricInterval)
+	}
+	got := map[string]string{}
+	for _, kv := range e.resource.Attributes {
+		got[kv.Key] = *kv.Value.StringValue
+	}
+	want := map[string]string{
+		"service.name":           OTEL_SERVICE_NAME,
+		"k8s.node.name":          "node-a",
+		"gpu_bpf.policy.name":    "nvidia",
+		"deployment.environment": "test",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("resource attributes = %v, want %v", got, want)
+	}
+}
+
+func TestOTLPExporterExportsEvents(t *testing.T) {
+	collector := newOTLPCollector(t, http.StatusOK)
+	saved := tracedFunctions
+	tracedFunctions = []Function{{Name: "cuMemAlloc", Kind: "uprobe", Returns: "cudaError"}}
+	t.Cleanup(func() { tracedFunctions = saved })
+
+	e := &otlpExporter{
+		endpoint: collector.URL,
+		headers:  map[string]string{"X-Tenant": "gpu"},
+		resource: otlpResource{Attributes: []otlpKeyValue{stringAttr("service.name", "test")}},
+		client:   collector.Client(),
+	}
+	e.exportEvents([]*Event{
+		{ElapsedMs: 10, Type: "NVIDIA_OPEN", Comm: "python", Pid: 1, GpuID: "0", Details: "flags=2", Fields: map[string]string{"flags": "2"}},
+		{ElapsedMs: 20, Type: "NVIDIA_OPEN_FAILED", Comm: "python", Pid: 1, ErrorName: "ENOENT"},
+		{ElapsedMs: 30, Type: "SPAN", Comm: "python", Pid: 1, Fields: map[string]string{"fn": "cuMemAlloc", "tid": "7", "start_ns": "1000", "dur_ns": "500", "ret": "2"}},
+		{ElapsedMs: 40, Type: "SPAN", Comm: "python", Pid: 1, Fields: map[string]string{"fn": "cuMemAlloc", "start_ns": "bogus"}},
+	})
+
+	if got := collector.header("X-Tenant"); got != "gpu" {
+		t.Errorf("X-Tenant header = %q, want gpu", got)
+	}
+	if got := collector.header("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type header = %q, want application/json", got)
+	}
+
+	logs := collector.received("/v1/logs")
+	if len(logs) != 1 {
+		t.Fatalf("received %d log requests, want 1", len(logs))
+	}
+	if attrs := attributes(first(logs[0], "resourceLogs")["resource"].(map[string]any)); attrs["service.name"] != "test" {
+		t.Errorf("resource attributes = %v", attrs)
+	}
+	records := first(logs[0], "resourceLogs", "scopeLogs")["logRecords"].([]any)
+	if len(records) != 2 {
+		t.Fatalf("received %d log records, want 2", len(records))
+	}
+	info, warn := records[0].(map[string]any), records[1].(map[string]any)
+	if info["severityText"] != "INFO" || info["body"].(map[string]any)["stringValue"] != "NVIDIA_OPEN flags=2" {
+		t.Errorf("first log record = %v", info)
+	}
+	if attrs := attributes(info); attrs["gpu_bpf.flags"] != "2" || attrs["gpu.id"] != "0" || attrs["process.pid"] != "1" {
+		t.Errorf("first log record attributes = %v", attrs)
+	}
+	if warn["severityText"] != "WARN" || attributes(warn)["error.type"] != "ENOENT" {
+		t.Errorf("second log record = %v", warn)
+	}
+
+	traces := collector.received("/v1/traces")
+	if len(traces) != 1 {
+		t.Fatalf("received %d trace requests, want 1", len(traces))
+	}
+	spans := first(traces[0], "resourceSpans", "scopeSpans")["spans"].([]any)
+	if len(spans) != 1 {
+		t.Fatalf("received %d spans, want 1 as the malformed span is skipped", len(spans))
+	}
+	span := spans[0].(map[string]any)
+	if span["name"] != "cuMemAlloc" || len(span["traceId"].(string)) != 32 || len(span["spanId"].(string)) != 16 {
+		t.Errorf("span = %v", span)
+	}
+	if status := span["status"].(map[string]any); status["code"] != float64(2) || status["message"] != "cudaErrorMemoryAllocation" {
+		t.Errorf("span status = %v", status)
+	}
+	if attrs := attributes(span); attrs["thread.id"] != "7" || attrs["gpu_bpf.return"] != "2" {
+		t.Errorf("span attributes = %v", attrs)
+	}
+}
+
+func TestOTLPExporterExportsMetrics(t *testing.T) {
+	collector := newOTLPCollector(t, http.StatusOK)
+	e := &otlpExporter{endpoint: collector.URL, client: collector.Client(), startTime: time.Now()}
+
+	metrics.Inc("gpu_bpf_events_total", "event", "OTLP_TEST")
+	aggregates.Record("@otlp_test[cuInit]: 3")
+	e.exportMetrics()
+
+	requests := collector.received("/v1/metrics")
+	if len(requests) != 1 {
+		t.Fatalf("received %d metric requests, want 1", len(requests))
+	}
+	byName := map[string]map[string]any{}
+	for _, m := range first(requests[0], "resourceMetrics", "scopeMetrics")["metrics"].([]any) {
+		byName[m.(map[string]any)["name"].(string)] = m.(map[string]any)
+	}
+	events, ok := byName["gpu_bpf_events_total"]
+	if !ok || events["sum"].(map[string]any)["isMonotonic"] != true {
+		t.Fatalf("gpu_bpf_events_total is not exported as a monotonic sum: %v", events)
+	}
+	found := false
+	for _, p := range byName["gpu_bpf_map_value"]["gauge"].(map[string]any)["dataPoints"].([]any) {
+		point := p.(map[string]any)
+		if attrs := attributes(point); attrs["map"] == "@otlp_test" && attrs["key"] == "cuInit" && point["asDouble"] == float64(3) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("gpu_bpf_map_value has no data point for @otlp_test[cuInit]: %v", byName["gpu_bpf_map_value"])
+	}
+}
+
+func TestOTLPExporterCountsFailures(t *testing.T) {
+	collector := newOTLPCollector(t, http.StatusServiceUnavailable)
+	e := &otlpExporter{endpoint: collector.URL, client: collector.Client()}
+
+	before := counterValue("gpu_bpf_otlp_export_failures_total", "signal", "logs")
+	e.exportEvents([]*Event{{Type: "NVIDIA_OPEN", Comm: "python", Pid: 1}})
+	if failures := counterValue("gpu_bpf_otlp_export_failures_total", "signal", "logs") - before; failures != 1 {
+		t.Errorf("counted %v export failures, want 1", failures)
+	}
+}
+
+func TestOTLPExporterRunFlushesOnShutdown(t *testing.T) {
+	collector := newOTLPCollector(t, http.StatusOK)
+	e := &otlpExporter{
+		endpoint:       collector.URL,
+		client:         collector.Client(),
+		metricInterval: time.Hour,
+		queue:          make(chan *Event, 1),
+		startTime:      time.Now(),
+	}
+	before := counterValue("gpu_bpf_otlp_dropped_events_total")
+	e.Enqueue(&Event{Type: "NVIDIA_OPEN", Comm: "python", Pid: 1})
+	e.Enqueue(&Event{Type: "NVIDIA_OPEN", Comm: "python", Pid: 1})
+	if dropped := counterValue("gpu_bpf_otlp_dropped_events_total") - before; dropped != 1 {
+		t.Errorf("dropped %v events, want 1", dropped)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.Run(ctx)
+		close(done)
+	}()
+	// Wait for the queued event to be picked up before shutting down
+	for len(e.queue) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if n := len(collector.received("/v1/logs")); n != 1 {
+		t.Errorf("received %d log requests, want 1", n)
+	}
+	if n := len(collector.received("/v1/metrics")); n != 1 {
+		t.Errorf("received %d metric requests, want 1", n)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// podUIDPattern matches the pod UID in kubelet cgroup paths for both the
+// cgroupfs ("pod<uid>") and systemd ("pod<uid_with_underscores>.slice") drivers.
+var podUIDPattern = regexp.MustCompile(`pod([0-9a-f]{8}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{12})`)
+
+// procRoot is where the processes of the host are read from. The agent runs
+// with hostPID, so it is the host's /proc.
+var procRoot = "/proc"
+
+// podUIDEntry is the pod of a process, valid as long as the PID belongs to
+// the process that started at startTime.
+type podUIDEntry struct {
+	startTime uint64
+	uid       string
+}
+
+var (
+	podUIDCacheMu sync.Mutex
+	podUIDCache   = map[int]podUIDEntry{}
+	// podUIDCacheLimit is the size at which exited processes are evicted.
+	// It grows with the live processes so that a busy node doesn't sweep
+	// the cache on every lookup.
+	podUIDCacheLimit = POD_UID_CACHE_SIZE
+)
+
+// podUIDForPid resolves the UID of the pod a host process runs in, or "" for
+// processes outside of Kubernetes pods. Lookups are cached per process: the
+// kernel reuses PIDs, so an entry only holds while the start time of the PID
+// is the one it was cached with.
+func podUIDForPid(pid int) string {
+	startTime, err := processStartTime(pid)
+	if err != nil {
+		// The process is gone, and so is whatever was cached for its PID
+		podUIDCacheMu.Lock()
+		delete(podUIDCache, pid)
+		podUIDCacheMu.Unlock()
+		return ""
+	}
+
+	podUIDCacheMu.Lock()
+	entry, ok := podUIDCache[pid]
+	podUIDCacheMu.Unlock()
+	if ok && entry.startTime == startTime {
+		return entry.uid
+	}
+
+	data, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return ""
+	}
+	entry = podUIDEntry{startTime: startTime}
+	if m := podUIDPattern.FindStringSubmatch(string(data)); m != nil {
+		entry.uid = strings.ReplaceAll(m[1], "_", "-")
+	}
+
+	podUIDCacheMu.Lock()
+	defer podUIDCacheMu.Unlock()
+	if len(podUIDCache) >= podUIDCacheLimit {
+		evictExitedProcesses()
+		podUIDCacheLimit = max(POD_UID_CACHE_SIZE, 2*len(podUIDCache))
+	}
+	podUIDCache[pid] = entry
+	return entry.uid
+}
+
+// evictExitedProcesses drops the cache entries of PIDs that exited or were
+// reused since they were cached. The caller holds podUIDCacheMu.
+func evictExitedProcesses() {
+	for pid, entry := range podUIDCache {
+		if startTime, err := processStartTime(pid); err != nil || startTime != entry.startTime {
+			delete(podUIDCache, pid)
+		}
+	}
+}
+
+// processStartTime reads the start time of a process, in clock ticks since
+// boot, from field 22 of /proc/<pid>/stat.
+func processStartTime(pid int) (uint64, error) {
+	data, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0, err
+	}
+	// The command name in field 2 may contain spaces and parentheses, the
+	// fields after it start with the state in field 3
+	stat := string(data)
+	end := strings.LastIndexByte(stat, ')')
+	if end < 0 {
+		return 0, fmt.Errorf("malformed stat of pid %d", pid)
+	}
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("malformed stat of pid %d", pid)
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
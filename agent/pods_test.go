@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const (
+	trainerUID = "6f1d3c2a-1b2c-4d5e-8f90-a1b2c3d4e5f6"
+	otherUID   = "0a9b8c7d-6e5f-4a3b-9c2d-1e0f9a8b7c6d"
+)
+
+// fakeProc points the agent at an empty /proc and an empty pod UID cache.
+func fakeProc(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	oldRoot, oldLimit := procRoot, podUIDCacheLimit
+	procRoot = root
+	podUIDCacheMu.Lock()
+	podUIDCache = map[int]podUIDEntry{}
+	podUIDCacheMu.Unlock()
+	t.Cleanup(func() {
+		procRoot, podUIDCacheLimit = oldRoot, oldLimit
+		podUIDCacheMu.Lock()
+		podUIDCache = map[int]podUIDEntry{}
+		podUIDCacheMu.Unlock()
+	})
+	return root
+}
+
+// startProcess writes the stat and cgroup files of a process started at
+// startTime in the pod with the given UID, or on the host for "".
+func startProcess(t *testing.T, root string, pid int, startTime uint64, podUID string) {
+	t.Helper()
+	dir := filepath.Join(root, strconv.Itoa(pid))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	stat := fmt.Sprintf("%d (python3 (worker) x) S 1 %d %d 0 -1 4194560 100 0 0 0 5 3 0 0 20 0 4 0 %d 1000000 200 0\n",
+		pid, pid, pid, startTime)
+	cgroup := "0::/system.slice/sshd.service\n"
+	if podUID != "" {
+		cgroup = "0::/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod" +
+			strings.ReplaceAll(podUID, "-", "_") + ".slice/cri-containerd-abc.scope\n"
+	}
+	for name, content := range map[string]string{"stat": stat, "cgroup": cgroup} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func exitProcess(t *testing.T, root string, pid int) {
+	t.Helper()
+	if err := os.RemoveAll(filepath.Join(root, strconv.Itoa(pid))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPodUIDForPid(t *testing.T) {
+	root := fakeProc(t)
+	startProcess(t, root, 100, 5000, trainerUID)
+	startProcess(t, root, 200, 5100, "")
+
+	if got := podUIDForPid(100); got != trainerUID {
+		t.Errorf("podUIDForPid(100) = %q, want %q", got, trainerUID)
+	}
+	if got := podUIDForPid(200); got != "" {
+		t.Errorf("podUIDForPid(200) = %q, want a host process", got)
+	}
+	if got := podUIDForPid(300); got != "" {
+		t.Errorf("podUIDForPid(300) = %q, want a missing process", got)
+	}
+
+	// Cached lookups don't read the cgroup again
+	if err := os.Remove(filepath.Join(root, "100", "cgroup")); err != nil {
+		t.Fatal(err)
+	}
+	if got := podUIDForPid(100); got != trainerUID {
+		t.Errorf("cached podUIDForPid(100) = %q, want %q", got, trainerUID)
+	}
+}
+
+func TestPodUIDForPidReusedPid(t *testing.T) {
+	root := fakeProc(t)
+	startProcess(t, root, 100, 5000, trainerUID)
+	if got := podUIDForPid(100); got != trainerUID {
+		t.Fatalf("podUIDForPid(100) = %q, want %q", got, trainerUID)
+	}
+
+	// The kernel hands the PID to a process of another pod
+	exitProcess(t, root, 100)
+	startProcess(t, root, 100, 9000, otherUID)
+	if got := podUIDForPid(100); got != otherUID {
+		t.Errorf("podUIDForPid of a reused PID = %q, want %q", got, otherUID)
+	}
+
+	// An exited process is evicted instead of answered from the cache
+	exitProcess(t, root, 100)
+	if got := podUIDForPid(100); got != "" {
+		t.Errorf("podUIDForPid of an exited process = %q, want none", got)
+	}
+	podUIDCacheMu.Lock()
+	_, cached := podUIDCache[100]
+	podUIDCacheMu.Unlock()
+	if cached {
+		t.Error("exited process is still cached")
+	}
+}
+
+func TestPodUIDCacheEvictsExitedProcesses(t *testing.T) {
+	root := fakeProc(t)
+	podUIDCacheLimit = 4
+	for pid := 1; pid <= 4; pid++ {
+		startProcess(t, root, pid, uint64(pid), trainerUID)
+		podUIDForPid(pid)
+	}
+	exitProcess(t, root, 1)
+	exitProcess(t, root, 2)
+	exitProcess(t, root, 3)
+	startProcess(t, root, 3, 42, otherUID)
+
+	startProcess(t, root, 5, 5, trainerUID)
+	podUIDForPid(5)
+
+	podUIDCacheMu.Lock()
+	defer podUIDCacheMu.Unlock()
+	if len(podUIDCache) != 2 {
+		t.Errorf("cache holds %d processes, want the 2 live ones: %v", len(podUIDCache), podUIDCache)
+	}
+	if _, ok := podUIDCache[4]; !ok {
+		t.Error("live process 4 was evicted")
+	}
+	if podUIDCacheLimit != POD_UID_CACHE_SIZE {
+		t.Errorf("cache limit = %d, want %d", podUIDCacheLimit, POD_UID_CACHE_SIZE)
+	}
+}
+
+func TestProcessStartTime(t *testing.T) {
+	root := fakeProc(t)
+	startProcess(t, root, 100, 123456789, "")
+	if got, err := processStartTime(100); err != nil || got != 123456789 {
+		t.Errorf("processStartTime = %d, %v, want 123456789", got, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "100", "stat"), []byte("100 (python3 S 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := processStartTime(100); err == nil {
+		t.Error("processStartTime accepted a malformed stat")
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// writePprofProfile encodes folded stacks as a gzipped pprof profile
+// (github.com/google/pprof/proto/profile.proto) with a single "samples"
+// value per stack.
+func writePprofProfile(w io.Writer, folded map[string]uint64, start time.Time, duration time.Duration) error {
+	p := newPprofBuilder()
+
+	stacks := make([]string, 0, len(folded))
+	for stack := range folded {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+
+	var body protoBuffer
+	body.messageField(1, p.valueType("samples", "count"))
+	for _, stack := range stacks {
+		frames := strings.Split(stack, ";")
+		// pprof lists locations leaf first
+		locations := make([]uint64, 0, len(frames))
+		for i := len(frames) - 1; i >= 0; i-- {
+			locations = append(locations, p.location(frames[i]))
+		}
+		var sample protoBuffer
+		sample.packedField(1, locations)
+		sample.packedField(2, []uint64{folded[stack]})
+		body.messageField(2, sample.Bytes())
+	}
+	for _, loc := range p.locations {
+		body.messageField(4, loc)
+	}
+	for _, fn := range p.functions {
+		body.messageField(5, fn)
+	}
+	for _, s := range p.strings {
+		body.stringField(6, s)
+	}
+	body.uint64Field(9, uint64(start.UnixNano()))
+	body.uint64Field(10, uint64(duration.Nanoseconds()))
+	body.messageField(11, p.valueType("samples", "count"))
+	body.uint64Field(12, 1)
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(body.Bytes()); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// pprofBuilder interns strings and frames into the profile tables.
+type pprofBuilder struct {
+	strings     []string
+	stringIndex map[string]uint64
+	locationIDs map[string]uint64
+	locations   [][]byte
+	functions   [][]byte
+}
+
+func newPprofBuilder() *pprofBuilder {
+	return &pprofBuilder{
+		strings:     []string{""},
+		stringIndex: map[string]uint64{"": 0},
+		locationIDs: map[string]uint64{},
+	}
+}
+
+func (p *pprofBuilder) str(s string) uint64 {
+	if i, ok := p.stringIndex[s]; ok {
+		return i
+	}
+	i := uint64(len(p.strings))
+	p.strings = append(p.strings, s)
+	p.stringIndex[s] = i
+	return i
+}
+
+func (p *pprofBuilder) valueType(typ, unit string) []byte {
+	var vt protoBuffer
+	vt.uint64Field(1, p.str(typ))
+	vt.uint64Field(2, p.str(unit))
+	return vt.Bytes()
+}
+
+// location returns the location ID of a frame, creating a function and a
+// location with a single line for it on first use.
+func (p *pprofBuilder) location(frame string) uint64 {
+	if id, ok := p.locationIDs[frame]; ok {
+		return id
+	}
+	id := uint64(len(p.locationIDs) + 1)
+	p.locationIDs[frame] = id
+
+	var fn protoBuffer
+	fn.uint64Field(1, id)
+	fn.uint64Field(2, p.str(frame))
+	fn.uint64Field(3, p.str(frame))
+	p.functions = append(p.functions, fn.Bytes())
+
+	var line protoBuffer
+	line.uint64Field(1, id)
+	var loc protoBuffer
+	loc.uint64Field(1, id)
+	loc.messageField(4, line.Bytes())
+	p.locations = append(p.locations, loc.Bytes())
+	return id
+}
+
+// protoBuffer is a minimal protobuf wire format encoder.
+type protoBuffer struct {
+	bytes.Buffer
+}
+
+func (b *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		b.WriteByte(byte(x) | 0x80)
+		x >>= 7
+	}
+	b.WriteByte(byte(x))
+}
+
+func (b *protoBuffer) key(tag int, wireType uint64) {
+	b.varint(uint64(tag)<<3 | wireType)
+}
+
+func (b *protoBuffer) uint64Field(tag int, x uint64) {
+	if x == 0 {
+		return
+	}
+	b.key(tag, 0)
+	b.varint(x)
+}
+
+func (b *protoBuffer) bytesField(tag int, data []byte) {
+	b.key(tag, 2)
+	b.varint(uint64(len(data)))
+	b.Write(data)
+}
+
+func (b *protoBuffer) stringField(tag int, s string) {
+	b.bytesField(tag, []byte(s))
+}
+
+func (b *protoBuffer) messageField(tag int, msg []byte) {
+	b.bytesField(tag, msg)
+}
+
+func (b *protoBuffer) packedField(tag int, xs []uint64) {
+	var packed protoBuffer
+	for _, x := range xs {
+		packed.varint(x)
+	}
+	b.bytesField(tag, packed.Bytes())
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+// profileStacks returns the folded stacks and values of a decoded profile.
+func profileStacks(p *profile.Profile) map[string]int64 {
+	folded := map[string]int64{}
+	for _, sample := range p.Sample {
+		var frames []string
+		for i := len(sample.Location) - 1; i >= 0; i-- {
+			loc := sample.Location[i]
+			if len(loc.Line) != 1 {
+				return nil
+			}
+			frames = append(frames, loc.Line[0].Function.Name)
+		}
+		folded[strings.Join(frames, ";")] += sample.Value[0]
+	}
+	return folded
+}
+
+func TestWritePprofProfile(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	folded := map[string]uint64{
+		"python;main;cudaMalloc;__x64_sys_ioctl;nvidia_unlocked_ioctl": 12,
+		"python;main;cudaFree":  3,
+		"trainer;cudaMalloc":    200,
+		"python;;cudaHostAlloc": 1,
+	}
+	var buf bytes.Buffer
+	if err := writePprofProfile(&buf, folded, start, 5*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := profile.Parse(&buf)
+	if err != nil {
+		t.Fatalf("profile.Parse: %v", err)
+	}
+	if err := p.CheckValid(); err != nil {
+		t.Fatalf("CheckValid: %v", err)
+	}
+	if len(p.SampleType) != 1 || p.SampleType[0].Type != "samples" || p.SampleType[0].Unit != "count" {
+		t.Errorf("sample types = %v", p.SampleType)
+	}
+	if p.PeriodType == nil || p.PeriodType.Type != "samples" || p.Period != 1 {
+		t.Errorf("period = %v %d", p.PeriodType, p.Period)
+	}
+	if p.TimeNanos != start.UnixNano() || p.DurationNanos != (5*time.Minute).Nanoseconds() {
+		t.Errorf("time = %d, duration = %d", p.TimeNanos, p.DurationNanos)
+	}
+
+	want := map[string]int64{}
+	for stack, count := range folded {
+		want[stack] = int64(count)
+	}
+	if got := profileStacks(p); !reflect.DeepEqual(got, want) {
+		t.Errorf("stacks = %v, want %v", got, want)
+	}
+	// Frames shared between stacks are interned once, the empty frame
+	// included
+	if len(p.Function) != 9 || len(p.Location) != 9 {
+		t.Errorf("%d functions and %d locations, want 9 each", len(p.Function), len(p.Location))
+	}
+	for _, loc := range p.Location {
+		if loc.Line[0].Function.ID != loc.ID {
+			t.Errorf("location %d points at function %d", loc.ID, loc.Line[0].Function.ID)
+		}
+	}
+}
+
+func TestWritePprofProfileEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writePprofProfile(&buf, nil, time.Now(), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	p, err := profile.Parse(&buf)
+	if err != nil {
+		t.Fatalf("profile.Parse: %v", err)
+	}
+	if len(p.Sample) != 0 || len(p.SampleType) != 1 {
+		t.Errorf("profile = %v", p)
+	}
+}
+
+func TestProtoBuffer(t *testing.T) {
+	var b protoBuffer
+	b.uint64Field(1, 0)
+	b.uint64Field(1, 300)
+	b.stringField(6, "")
+	b.packedField(2, []uint64{1, 128})
+	want := []byte{0x08, 0xac, 0x02, 0x32, 0x00, 0x12, 0x03, 0x01, 0x80, 0x01}
+	if got := b.Bytes(); !bytes.Equal(got, want) {
+		t.Errorf("encoded % x, want % x", got, want)
+	}
+}
+
+func TestParseStackQuery(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    stackQuery
+		wantErr string
+	}{
+		{query: "", want: stackQuery{Window: time.Minute}},
+		{query: "seconds=300&pid=4242&comm=python&pod=uid-1&function=cudaMalloc",
+			want: stackQuery{Window: 5 * time.Minute, Pid: 4242, Comm: "python", PodUID: "uid-1", Function: "cudaMalloc"}},
+		{query: "seconds=86400", want: stackQuery{Window: STACK_RETENTION}},
+		{query: "seconds=0", wantErr: `invalid seconds "0"`},
+		{query: "seconds=soon", wantErr: `invalid seconds "soon"`},
+		{query: "pid=python", wantErr: `invalid pid "python"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			values, _ := url.ParseQuery(tt.query)
+			before := time.Now()
+			q, err := parseStackQuery(values)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("err = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if q.Since.Before(before.Add(-q.Window)) || q.Since.After(time.Now().Add(-q.Window)) {
+				t.Errorf("since = %v, want %v before now", q.Since, q.Window)
+			}
+			q.Since = time.Time{}
+			if q != tt.want {
+				t.Errorf("query = %+v, want %+v", q, tt.want)
+			}
+		})
+	}
+}
+
+func TestStackQueryMatches(t *testing.T) {
+	sample := stackSample{Function: "cudaMalloc", Comm: "python", Pid: 4242, PodUID: "uid-1"}
+	tests := []struct {
+		query stackQuery
+		want  bool
+	}{
+		{stackQuery{}, true},
+		{stackQuery{Pid: 4242, Comm: "python", PodUID: "uid-1", Function: "cudaMalloc"}, true},
+		{stackQuery{Pid: 1}, false},
+		{stackQuery{Comm: "trainer"}, false},
+		{stackQuery{PodUID: "uid-2"}, false},
+		{stackQuery{Function: "cudaFree"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.query.Matches(sample); got != tt.want {
+			t.Errorf("%+v.Matches() = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+// withStacks replaces the stack store of the agent for a test.
+func withStacks(t *testing.T, samples ...stackSample) {
+	t.Helper()
+	saved := stacks
+	stacks = newStackStore(STACK_RETENTION)
+	for _, sample := range samples {
+		stacks.Add(sample)
+	}
+	t.Cleanup(func() { stacks = saved })
+}
+
+func TestHandleFoldedStacks(t *testing.T) {
+	now := time.Now()
+	withStacks(t,
+		stackSample{Function: "cudaMalloc", Comm: "python", Pid: 1, User: []string{"cudaMalloc", "main"}, Count: 2, At: now.Add(-10 * time.Minute)},
+		stackSample{Function: "cudaMalloc", Comm: "python", Pid: 1, User: []string{"cudaMalloc", "main"}, Count: 5, At: now},
+		stackSample{Function: "cudaFree", Comm: "trainer", Pid: 2, User: []string{"cudaFree"}, Count: 7, At: now},
+	)
+
+	tests := []struct {
+		query string
+		code  int
+		want  string
+	}{
+		{"", http.StatusOK, "trainer;cudaFree 7\npython;main;cudaMalloc 5\n"},
+		{"seconds=900", http.StatusOK, "python;main;cudaMalloc 7\ntrainer;cudaFree 7\n"},
+		{"function=cudaMalloc&seconds=900", http.StatusOK, "python;main;cudaMalloc 7\n"},
+		{"pid=2", http.StatusOK, "trainer;cudaFree 7\n"},
+		{"comm=nobody", http.StatusOK, ""},
+		{"seconds=-1", http.StatusBadRequest, "invalid seconds \"-1\"\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handleFoldedStacks(rec, httptest.NewRequest(http.MethodGet, "/debug/stacks/folded?"+tt.query, nil))
+			if rec.Code != tt.code || rec.Body.String() != tt.want {
+				t.Errorf("got %d %q, want %d %q", rec.Code, rec.Body.String(), tt.code, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlePprofProfile(t *testing.T) {
+	withStacks(t,
+		stackSample{Function: "cudaMalloc", Comm: "python", Pid: 1, User: []string{"cudaMalloc", "main"}, Count: 5, At: time.Now()},
+		stackSample{Function: "cudaFree", Comm: "trainer", Pid: 2, User: []string{"cudaFree"}, Count: 7, At: time.Now()},
+	)
+
+	rec := httptest.NewRecorder()
+	handlePprofProfile(rec, httptest.NewRequest(http.MethodGet, "/debug/pprof/profile?seconds=120&comm=python", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Content-Disposition"); !strings.Contains(got, "profile.pb.gz") {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+	p, err := profile.Parse(rec.Body)
+	if err != nil {
+		t.Fatalf("profile.Parse: %v", err)
+	}
+	if got := profileStacks(p); !reflect.DeepEqual(got, map[string]int64{"python;main;cudaMalloc": 5}) {
+		t.Errorf("stacks = %v", got)
+	}
+	if p.DurationNanos != (2 * time.Minute).Nanoseconds() {
+		t.Errorf("duration = %d", p.DurationNanos)
+	}
+
+	rec = httptest.NewRecorder()
+	handlePprofProfile(rec, httptest.NewRequest(http.MethodGet, "/debug/pprof/profile?pid=x", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Drop policies of the event queue, mirroring spec.buffering.policy
+const (
+	DROP_OLDEST = "dropOldest"
+	DROP_NEWEST = "dropNewest"
+	DROP_BLOCK  = "block"
+)
+
+// eventQueue buffers bpftrace stdout, configured through EVENT_* variables.
+var eventQueue *lineQueue
+
+// lineQueue is the bounded queue between the bpftrace stdout reader and event
+// processing, so that slow consumers do not stall the pipe. When it is full,
+// lines overflow to the spill file if one is configured and are otherwise
+// handled according to the drop policy.
+type lineQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    []string
+	head   int
+	n      int
+	policy string
+	spill  *spillFile
+	closed bool
+}
+
+func newLineQueue(size int, policy string, spill *spillFile) *lineQueue {
+	q := &lineQueue{buf: make([]string, size), policy: policy, spill: spill}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// newLineQueueFromEnv builds the event queue from EVENT_QUEUE_SIZE,
+// EVENT_DROP_POLICY, EVENT_SPILL_DIR and EVENT_SPILL_MAX_MB.
+func newLineQueueFromEnv() (*lineQueue, error) {
+	size := EVENT_QUEUE_SIZE
+	if v := os.Getenv("EVENT_QUEUE_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid EVENT_QUEUE_SIZE %q", v)
+		}
+		size = n
+	}
+
+	policy := EVENT_DROP_POLICY
+	if v := os.Getenv("EVENT_DROP_POLICY"); v != "" {
+		switch v {
+		case DROP_OLDEST, DROP_NEWEST, DROP_BLOCK:
+			policy = v
+		default:
+			return nil, fmt.Errorf("invalid EVENT_DROP_POLICY %q", v)
+		}
+	}
+
+	var spill *spillFile
+	if dir := os.Getenv("EVENT_SPILL_DIR"); dir != "" {
+		maxMB := EVENT_SPILL_MAX_MB
+		if v := os.Getenv("EVENT_SPILL_MAX_MB"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid EVENT_SPILL_MAX_MB %q", v)
+			}
+			maxMB = n
+		}
+		var err error
+		if spill, err = newSpillFile(dir, int64(maxMB)<<20); err != nil {
+			return nil, err
+		}
+	}
+	return newLineQueue(size, policy, spill), nil
+}
+
+// Push queues a line. It only blocks with the "block" policy.
+func (q *lineQueue) Push(line string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		if q.closed {
+			return
+		}
+		// Once lines are spilled, newer lines follow them to keep the order
+		if q.spill != nil && (q.n == len(q.buf) || q.spill.Pending() > 0) {
+			if err := q.spill.Write(line); err == nil {
+				metrics.Inc("gpu_bpf_events_spilled_total")
+				q.cond.Broadcast()
+				return
+			} else if !errors.Is(err, errSpillFull) {
+				log.Warn().Err(err).Msg("Failed to spill event")
+			}
+		}
+		if q.n < len(q.buf) && (q.spill == nil || q.spill.Pending() == 0) {
+			q.buf[(q.head+q.n)%len(q.buf)] = line
+			q.n++
+			q.cond.Broadcast()
+			return
+		}
+
+		switch q.policy {
+		case DROP_NEWEST:
+			metrics.Inc("gpu_bpf_events_dropped_total", "policy", q.policy)
+			return
+		case DROP_OLDEST:
+			if q.n == 0 || (q.spill != nil && q.spill.Pending() > 0) {
+				// The oldest lines are spilled and cannot be removed from
+				// the file, so the new line is dropped instead
+				metrics.Inc("gpu_bpf_events_dropped_total", "policy", q.policy)
+				return
+			}
+			q.head = (q.head + 1) % len(q.buf)
+			q.n--
+			metrics.Inc("gpu_bpf_events_dropped_total", "policy", q.policy)
+		default:
+			q.cond.Wait()
+		}
+	}
+}
+
+// Pop returns the oldest line, waiting for one. It returns false once the
+// queue is closed and drained.
+func (q *lineQueue) Pop() (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		if q.n > 0 {
+			line := q.buf[q.head]
+			q.buf[q.head] = ""
+			q.head = (q.head + 1) % len(q.buf)
+			q.n--
+			metrics.Set("gpu_bpf_event_queue_length", float64(q.n))
+			q.cond.Broadcast()
+			return line, true
+		}
+		if q.spill != nil && q.spill.Pending() > 0 {
+			line, err := q.spill.Read()
+			if err == nil {
+				q.cond.Broadcast()
+				return line, true
+			}
+			log.Error().Err(err).Msg("Failed to read spilled events, discarding the spill file")
+			metrics.Add("gpu_bpf_events_dropped_total", float64(q.spill.Pending()), "policy", "spill")
+			q.spill.Reset()
+			continue
+		}
+		if q.closed {
+			return "", false
+		}
+		q.cond.Wait()
+	}
+}
+
+// Close wakes up waiting readers and writers; queued lines are still returned.
+func (q *lineQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+var errSpillFull = errors.New("spill file is full")
+
+// spillFile holds lines that did not fit into the in-memory queue. It is
+// truncated whenever it has been read completely.
+type spillFile struct {
+	path     string
+	w        *os.File
+	r        *os.File
+	reader   *bufio.Reader
+	size     int64
+	maxBytes int64
+	pending  int
+}
+
+func newSpillFile(dir string, maxBytes int64) (*spillFile, error) {
+	host := os.Getenv("NODE_NAME")
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("spill directory: %w", err)
+	}
+	path := filepath.Join(dir, "events-"+host+".spill")
+	// Lines left over by a previous agent belong to a different bpftrace run
+	w, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	r, err := os.Open(path)
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	return &spillFile{path: path, w: w, r: r, reader: bufio.NewReader(r), maxBytes: maxBytes}, nil
+}
+
+func (s *spillFile) Pending() int {
+	return s.pending
+}
+
+func (s *spillFile) Write(line string) error {
+	if s.size+int64(len(line))+1 > s.maxBytes {
+		return errSpillFull
+	}
+	n, err := io.WriteString(s.w, line+"\n")
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+	s.pending++
+	metrics.Set("gpu_bpf_event_spill_bytes", float64(s.size))
+	return nil
+}
+
+func (s *spillFile) Read() (string, error) {
+	line, err := s.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	s.pending--
+	if s.pending == 0 {
+		s.Reset()
+	}
+	return line[:len(line)-1], nil
+}
+
+// Reset empties the spill file.
+func (s *spillFile) Reset() {
+	if err := s.w.Truncate(0); err != nil {
+		log.Warn().Err(err).Str("path", s.path).Msg("Failed to truncate spill file")
+	}
+	s.r.Seek(0, io.SeekStart)
+	s.reader.Reset(s.r)
+	s.size, s.pending = 0, 0
+	metrics.Set("gpu_bpf_event_spill_bytes", 0)
+}
+
+// readLines calls fn for every line of r. Lines longer than maxLine are
+// skipped and counted instead of ending the stream like bufio.Scanner does.
+func readLines(r io.Reader, maxLine int, fn func(string)) error {
+	br := bufio.NewReaderSize(r, 64*1024)
+	var line []byte
+	tooLong := false
+	for {
+		chunk, isPrefix, err := br.ReadLine()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if !tooLong {
+			if len(line)+len(chunk) > maxLine {
+				tooLong = true
+				line = line[:0]
+			} else {
+				line = append(line, chunk...)
+			}
+		}
+		if isPrefix {
+			continue
+		}
+		if tooLong {
+			metrics.Inc("gpu_bpf_output_lines_too_long_total")
+		} else {
+			fn(string(line))
+		}
+		line, tooLong = line[:0], false
+	}
+}
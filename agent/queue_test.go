@@ -0,0 +1,177 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// drain pops every queued line of a closed queue.
+func drain(q *lineQueue) []string {
+	q.Close()
+	var lines []string
+	for {
+		line, ok := q.Pop()
+		if !ok {
+			return lines
+		}
+		lines = append(lines, line)
+	}
+}
+
+func TestLineQueueDropPolicies(t *testing.T) {
+	tests := []struct {
+		policy string
+		want   []string
+	}{
+		{DROP_NEWEST, []string{"1", "2", "3"}},
+		{DROP_OLDEST, []string{"3", "4", "5"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			before := counterValue("gpu_bpf_events_dropped_total", "policy", tt.policy)
+			q := newLineQueue(3, tt.policy, nil)
+			for _, line := range []string{"1", "2", "3", "4", "5"} {
+				q.Push(line)
+			}
+			if got := drain(q); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("queued lines = %v, want %v", got, tt.want)
+			}
+			if dropped := counterValue("gpu_bpf_events_dropped_total", "policy", tt.policy) - before; dropped != 2 {
+				t.Errorf("dropped %v lines, want 2", dropped)
+			}
+		})
+	}
+}
+
+func TestLineQueueBlocks(t *testing.T) {
+	q := newLineQueue(1, DROP_BLOCK, nil)
+	q.Push("1")
+
+	pushed := make(chan struct{})
+	go func() {
+		q.Push("2")
+		close(pushed)
+	}()
+	select {
+	case <-pushed:
+		t.Fatal("Push did not block on a full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if line, _ := q.Pop(); line != "1" {
+		t.Fatalf("Pop() = %q, want 1", line)
+	}
+	select {
+	case <-pushed:
+	case <-time.After(time.Second):
+		t.Fatal("Push did not resume after Pop")
+	}
+	if got := drain(q); !reflect.DeepEqual(got, []string{"2"}) {
+		t.Errorf("queued lines = %v, want [2]", got)
+	}
+}
+
+func TestLineQueueSpill(t *testing.T) {
+	t.Setenv("NODE_NAME", "node-a")
+	dir := t.TempDir()
+	spill, err := newSpillFile(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := newLineQueue(2, DROP_NEWEST, spill)
+	for _, line := range []string{"1", "2", "3", "4"} {
+		q.Push(line)
+	}
+	if spill.Pending() != 2 {
+		t.Fatalf("spilled %d lines, want 2", spill.Pending())
+	}
+
+	// Lines pushed while the spill file has pending lines follow them
+	if line, _ := q.Pop(); line != "1" {
+		t.Fatalf("Pop() = %q, want 1", line)
+	}
+	q.Push("5")
+	if got := drain(q); !reflect.DeepEqual(got, []string{"2", "3", "4", "5"}) {
+		t.Errorf("queued lines = %v, want [2 3 4 5]", got)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "events-node-a.spill"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 0 {
+		t.Errorf("spill file has %d bytes after it was read, want 0", info.Size())
+	}
+}
+
+func TestLineQueueSpillFull(t *testing.T) {
+	t.Setenv("NODE_NAME", "node-a")
+	spill, err := newSpillFile(t.TempDir(), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := newLineQueue(1, DROP_NEWEST, spill)
+	for _, line := range []string{"1", "2", "3", "4"} {
+		q.Push(line)
+	}
+	if got := drain(q); !reflect.DeepEqual(got, []string{"1", "2", "3"}) {
+		t.Errorf("queued lines = %v, want [1 2 3]", got)
+	}
+}
+
+func TestNewLineQueueFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{name: "defaults"},
+		{name: "spill", env: map[string]string{"EVENT_QUEUE_SIZE": "10", "EVENT_DROP_POLICY": DROP_BLOCK, "EVENT_SPILL_DIR": "spill", "EVENT_SPILL_MAX_MB": "1"}},
+		{name: "invalid size", env: map[string]string{"EVENT_QUEUE_SIZE": "0"}, wantErr: "EVENT_QUEUE_SIZE"},
+		{name: "invalid policy", env: map[string]string{"EVENT_DROP_POLICY": "sometimes"}, wantErr: "EVENT_DROP_POLICY"},
+		{name: "invalid spill size", env: map[string]string{"EVENT_SPILL_DIR": "spill", "EVENT_SPILL_MAX_MB": "-1"}, wantErr: "EVENT_SPILL_MAX_MB"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"EVENT_QUEUE_SIZE", "EVENT_DROP_POLICY", "EVENT_SPILL_DIR", "EVENT_SPILL_MAX_MB"} {
+				value := tt.env[key]
+				if key == "EVENT_SPILL_DIR" && value != "" {
+					value = filepath.Join(t.TempDir(), value)
+				}
+				t.Setenv(key, value)
+			}
+			q, err := newLineQueueFromEnv()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("newLineQueueFromEnv() error = %v, want it to mention %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (q.spill != nil) != (tt.env["EVENT_SPILL_DIR"] != "") {
+				t.Errorf("spill file configured = %v, want %v", q.spill != nil, tt.env["EVENT_SPILL_DIR"] != "")
+			}
+		})
+	}
+}
+
+func TestReadLines(t *testing.T) {
+	before := counterValue("gpu_bpf_output_lines_too_long_total")
+	input := "short\n" + strings.Repeat("x", 100) + "\nlast"
+	var lines []string
+	if err := readLines(strings.NewReader(input), 10, func(line string) { lines = append(lines, line) }); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(lines, []string{"short", "last"}) {
+		t.Errorf("lines = %v, want [short last]", lines)
+	}
+	if skipped := counterValue("gpu_bpf_output_lines_too_long_total") - before; skipped != 1 {
+		t.Errorf("skipped %v lines, want 1", skipped)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// agentHandler serves the endpoints read from other pods: metrics and health
+// for Prometheus and the kubelet, /debug/failure for the operator and the
+// capture endpoints for the collectors of trace captures. The events of
+// cluster policies cover every process on the node, so the operator limits
+// who may reach this port with a NetworkPolicy.
+func agentHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		metrics.Write(w)
+	})
+	mux.HandleFunc("/healthz", health.handleHealthz)
+	mux.HandleFunc("/readyz", health.handleReadyz)
+	mux.HandleFunc("/debug/failure", handleFailure)
+	mux.HandleFunc("/debug/capture", handleCapture)
+	mux.HandleFunc("/debug/aggregates", handleAggregates)
+	return mux
+}
+
+// debugHandler serves the endpoints meant for people debugging a node:
+// diagnostic bundles, stacks and profiles. They are only served on
+// localhost, reach them with kubectl port-forward.
+func debugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/diagnostics", handleDiagnostics)
+	mux.HandleFunc("/debug/stacks/folded", handleFoldedStacks)
+	mux.HandleFunc("/debug/pprof/profile", handlePprofProfile)
+	return mux
+}
+
+// startServer serves handler on addr in the background.
+func startServer(addr string, handler http.Handler) *http.Server {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Str("addr", addr).Msg("Agent HTTP server stopped")
+		}
+	}()
+	log.Info().Str("addr", addr).Msg("Agent HTTP server listening")
+	return srv
+}
+
+// handleFoldedStacks serves the stacks of a time window in the folded format
+// used by flamegraph.pl and speedscope, one "frame;frame;frame count" per line.
+func handleFoldedStacks(w http.ResponseWriter, r *http.Request) {
+	q, err := parseStackQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, top := range topStacks(stacks.Aggregate(q.Since, q.Matches), 0) {
+		fmt.Fprintf(w, "%s %d\n", top.Folded, top.Count)
+	}
+}
+
+// handlePprofProfile serves the stacks of a time window as a pprof profile,
+// e.g. go tool pprof http://localhost:9091/debug/pprof/profile?seconds=300
+// with kubectl port-forward <agent pod> 9091
+func handlePprofProfile(w http.ResponseWriter, r *http.Request) {
+	q, err := parseStackQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="profile.pb.gz"`)
+	if err := writePprofProfile(w, stacks.Aggregate(q.Since, q.Matches), q.Since, q.Window); err != nil {
+		log.Error().Err(err).Msg("Failed to write pprof profile")
+	}
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// sinks are the output sinks configured through OUTPUT_SINKS.
+var sinks []*sinkRunner
+
+// sinkWriter delivers a batch of NDJSON encoded events.
+type sinkWriter interface {
+	Write(batch [][]byte) error
+	Close() error
+}
+
+// outputEvent is an event as delivered to output sinks.
+type outputEvent struct {
+	*Event
+	Time   time.Time `json:"time"`
+	Node   string    `json:"node,omitempty"`
+	Policy string    `json:"policy,omitempty"`
+	PodUID string    `json:"podUid,omitempty"`
+}
+
+// sinkRunner queues events for one sink and delivers them in batches with
+// retries, applying the sink's backpressure policy when the queue is full.
+type sinkRunner struct {
+	name        string
+	writer      sinkWriter
+	queue       chan []byte
+	block       bool
+	maxEvents   int
+	maxWait     time.Duration
+	maxAttempts int
+	backoff     time.Duration
+}
+
+// newSinkRunner applies the defaults for settings left empty in the policy.
+func newSinkRunner(cfg OutputSink) (*sinkRunner, error) {
+	var writer sinkWriter
+	var err error
+	switch {
+	case cfg.File != nil:
+		writer, err = newFileSink(cfg.Name, *cfg.File)
+	case cfg.HTTP != nil:
+		writer = newHTTPSink(*cfg.HTTP)
+	case cfg.Kafka != nil:
+		writer, err = newKafkaProducer(*cfg.Kafka)
+	default:
+		err = fmt.Errorf("sink %s has no file, http or kafka configuration", cfg.Name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r := &sinkRunner{
+		name:        cfg.Name,
+		writer:      writer,
+		block:       cfg.Backpressure == "block",
+		maxEvents:   SINK_MAX_EVENTS,
+		maxWait:     SINK_MAX_WAIT,
+		maxAttempts: SINK_MAX_ATTEMPTS,
+		backoff:     SINK_RETRY_BACKOFF,
+	}
+	queueSize := SINK_QUEUE_SIZE
+	if cfg.QueueSize > 0 {
+		queueSize = cfg.QueueSize
+	}
+	r.queue = make(chan []byte, queueSize)
+	if b := cfg.Batching; b != nil {
+		if b.MaxEvents > 0 {
+			r.maxEvents = b.MaxEvents
+		}
+		if d, err := time.ParseDuration(b.MaxWait); err == nil && d > 0 {
+			r.maxWait = d
+		}
+	}
+	if rt := cfg.Retry; rt != nil {
+		if rt.MaxAttempts > 0 {
+			r.maxAttempts = rt.MaxAttempts
+		}
+		if d, err := time.ParseDuration(rt.Backoff); err == nil && d > 0 {
+			r.backoff = d
+		}
+	}
+	return r, nil
+}
+
+// startSinks starts a runner for every configured sink. Sinks that cannot be
+// set up are logged and skipped so that the others keep working.
+func startSinks(ctx context.Context, configs []OutputSink) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	for _, cfg := range configs {
+		runner, err := newSinkRunner(cfg)
+		if err != nil {
+			log.Error().Err(err).Str("sink", cfg.Name).Msg("Failed to set up output sink")
+			continue
+		}
+		sinks = append(sinks, runner)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runner.Run(ctx)
+		}()
+		log.Info().Str("sink", cfg.Name).Msg("Output sink started")
+	}
+	return &wg
+}
+
+// publishToSinks encodes an event once and queues it on every sink.
+func publishToSinks(ev *Event) {
+	if len(sinks) == 0 {
+		return
+	}
+	line, err := json.Marshal(outputEvent{
+		Event:  ev,
+		Time:   eventTime(time.Duration(ev.ElapsedMs) * time.Millisecond).UTC(),
+		Node:   os.Getenv("NODE_NAME"),
+		Policy: os.Getenv("POLICY_NAME"),
+		PodUID: podUIDForPid(ev.Pid),
+	})
+	if err != nil {
+		return
+	}
+	for _, s := range sinks {
+		s.Enqueue(line)
+	}
+}
+
+func (r *sinkRunner) Enqueue(line []byte) {
+	if r.block {
+		r.queue <- line
+		return
+	}
+	select {
+	case r.queue <- line:
+	default:
+		metrics.Inc("gpu_bpf_sink_events_total", "sink", r.name, "result", "dropped")
+	}
+}
+
+// Run delivers batches until ctx is cancelled, then flushes what is queued.
+func (r *sinkRunner) Run(ctx context.Context) {
+	defer r.writer.Close()
+	timer := time.NewTimer(r.maxWait)
+	defer timer.Stop()
+
+	var batch [][]byte
+	flush := func() {
+		if len(batch) > 0 {
+			r.deliver(batch)
+			batch = nil
+		}
+		metrics.Set("gpu_bpf_sink_queue_length", float64(len(r.queue)), "sink", r.name)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				select {
+				case line := <-r.queue:
+					batch = append(batch, line)
+					if len(batch) >= r.maxEvents {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		case line := <-r.queue:
+			batch = append(batch, line)
+			if len(batch) >= r.maxEvents {
+				flush()
+			}
+		case <-timer.C:
+			flush()
+			timer.Reset(r.maxWait)
+		}
+	}
+}
+
+// deliver writes a batch, retrying with exponential backoff.
+func (r *sinkRunner) deliver(batch [][]byte) {
+	backoff := r.backoff
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		err := r.writer.Write(batch)
+		metrics.Add("gpu_bpf_sink_write_seconds_total", time.Since(start).Seconds(), "sink", r.name)
+		if err == nil {
+			metrics.Inc("gpu_bpf_sink_batches_total", "sink", r.name, "result", "delivered")
+			metrics.Add("gpu_bpf_sink_events_total", float64(len(batch)), "sink", r.name, "result", "delivered")
+			return
+		}
+		if attempt >= r.maxAttempts {
+			log.Error().Err(err).Str("sink", r.name).Int("events", len(batch)).Msg("Giving up on batch")
+			metrics.Inc("gpu_bpf_sink_batches_total", "sink", r.name, "result", "failed")
+			metrics.Add("gpu_bpf_sink_events_total", float64(len(batch)), "sink", r.name, "result", "failed")
+			return
+		}
+		log.Warn().Err(err).Str("sink", r.name).Int("attempt", attempt).Dur("backoff", backoff).Msg("Batch delivery failed, retrying")
+		metrics.Inc("gpu_bpf_sink_retries_total", "sink", r.name)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// fileSink appends events to <path>/events-<node>.ndjson and rotates the
+// file once it exceeds the maximum size, keeping the newest rotated files.
+type fileSink struct {
+	dir      string
+	base     string
+	maxBytes int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+func newFileSink(name string, cfg FileSink) (*fileSink, error) {
+	host := os.Getenv("NODE_NAME")
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+	s := &fileSink{
+		dir:      cfg.Path,
+		base:     "events-" + host,
+		maxBytes: int64(SINK_FILE_MAX_MB) << 20,
+		maxFiles: SINK_FILE_MAX_KEEP,
+	}
+	if cfg.MaxSizeMB > 0 {
+		s.maxBytes = int64(cfg.MaxSizeMB) << 20
+	}
+	if cfg.MaxFiles > 0 {
+		s.maxFiles = cfg.MaxFiles
+	}
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return nil, fmt.Errorf("file sink %s: %w", name, err)
+	}
+	return s, s.open()
+}
+
+func (s *fileSink) current() string {
+	return filepath.Join(s.dir, s.base+".ndjson")
+}
+
+func (s *fileSink) open() error {
+	f, err := os.OpenFile(s.current(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.file, s.size = f, info.Size()
+	return nil
+}
+
+func (s *fileSink) Write(batch [][]byte) error {
+	if s.file == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	var buf bytes.Buffer
+	for _, line := range batch {
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	n, err := s.file.Write(buf.Bytes())
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+	// The batch is written, a failed rotation must not cause a redelivery
+	if s.size >= s.maxBytes {
+		if err := s.rotate(); err != nil {
+			log.Warn().Err(err).Str("dir", s.dir).Msg("Failed to rotate output file")
+		}
+	}
+	return nil
+}
+
+// rotate renames the current file with a timestamp and prunes old files.
+func (s *fileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	s.file = nil
+	rotated := filepath.Join(s.dir, fmt.Sprintf("%s-%s.ndjson", s.base, time.Now().UTC().Format("20060102T150405.000")))
+	if err := os.Rename(s.current(), rotated); err != nil {
+		return err
+	}
+
+	matches, err := filepath.Glob(filepath.Join(s.dir, s.base+"-*.ndjson"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(matches)
+	for len(matches) > s.maxFiles {
+		if err := os.Remove(matches[0]); err != nil && !os.IsNotExist(err) {
+			log.Warn().Err(err).Str("file", matches[0]).Msg("Failed to remove rotated file")
+		}
+		matches = matches[1:]
+	}
+	return s.open()
+}
+
+func (s *fileSink) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
+
+// httpSink POSTs each batch as an application/x-ndjson body.
+type httpSink struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func newHTTPSink(cfg HTTPSink) *httpSink {
+	return &httpSink{url: cfg.URL, headers: cfg.Headers, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (s *httpSink) Write(batch [][]byte) error {
+	body := append(bytes.Join(batch, []byte("\n")), '\n')
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("X-Event-Count", strconv.Itoa(len(batch)))
+	for key, value := range s.headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("POST %s: %s", s.url, resp.Status)
+	}
+	return nil
+}
+
+func (s *httpSink) Close() error {
+	return nil
+}
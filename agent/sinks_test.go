@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSink records delivered batches and fails the first failures writes.
+type fakeSink struct {
+	mu       sync.Mutex
+	batches  [][]string
+	failures int
+	closed   bool
+}
+
+func (s *fakeSink) Write(batch [][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("unavailable")
+	}
+	var lines []string
+	for _, line := range batch {
+		lines = append(lines, string(line))
+	}
+	s.batches = append(s.batches, lines)
+	return nil
+}
+
+func (s *fakeSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func TestNewSinkRunner(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     OutputSink
+		want    sinkRunner
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			cfg:  OutputSink{Name: "http", HTTP: &HTTPSink{URL: "http://example.com"}},
+			want: sinkRunner{maxEvents: SINK_MAX_EVENTS, maxWait: SINK_MAX_WAIT, maxAttempts: SINK_MAX_ATTEMPTS, backoff: SINK_RETRY_BACKOFF},
+		},
+		{
+			name: "overrides",
+			cfg: OutputSink{
+				Name:         "http",
+				HTTP:         &HTTPSink{URL: "http://example.com"},
+				Batching:     &SinkBatching{MaxEvents: 10, MaxWait: "250ms"},
+				Retry:        &SinkRetry{MaxAttempts: 7, Backoff: "2s"},
+				Backpressure: "block",
+				QueueSize:    5,
+			},
+			want: sinkRunner{block: true, maxEvents: 10, maxWait: 250 * time.Millisecond, maxAttempts: 7, backoff: 2 * time.Second},
+		},
+		{
+			name: "invalid durations keep the defaults",
+			cfg: OutputSink{
+				Name:     "http",
+				HTTP:     &HTTPSink{URL: "http://example.com"},
+				Batching: &SinkBatching{MaxWait: "soon"},
+				Retry:    &SinkRetry{Backoff: "-1s"},
+			},
+			want: sinkRunner{maxEvents: SINK_MAX_EVENTS, maxWait: SINK_MAX_WAIT, maxAttempts: SINK_MAX_ATTEMPTS, backoff: SINK_RETRY_BACKOFF},
+		},
+		{name: "no destination", cfg: OutputSink{Name: "empty"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := newSinkRunner(tt.cfg)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("newSinkRunner() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := sinkRunner{block: r.block, maxEvents: r.maxEvents, maxWait: r.maxWait, maxAttempts: r.maxAttempts, backoff: r.backoff}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newSinkRunner() = %+v, want %+v", got, tt.want)
+			}
+			wantQueue := SINK_QUEUE_SIZE
+			if tt.cfg.QueueSize > 0 {
+				wantQueue = tt.cfg.QueueSize
+			}
+			if cap(r.queue) != wantQueue {
+				t.Errorf("queue size = %d, want %d", cap(r.queue), wantQueue)
+			}
+		})
+	}
+}
+
+func TestSinkRunnerDeliver(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		result   string
+		batches  int
+	}{
+		{name: "delivered after retries", failures: 2, result: "delivered", batches: 1},
+		{name: "failed after the last attempt", failures: 3, result: "failed", batches: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sinkName := "deliver-" + tt.result
+			writer := &fakeSink{failures: tt.failures}
+			r := &sinkRunner{name: sinkName, writer: writer, maxAttempts: 3, backoff: time.Millisecond}
+
+			before := counterValue("gpu_bpf_sink_events_total", "sink", sinkName, "result", tt.result)
+			r.deliver([][]byte{[]byte("a"), []byte("b")})
+			if len(writer.batches) != tt.batches {
+				t.Errorf("delivered %d batches, want %d", len(writer.batches), tt.batches)
+			}
+			if n := counterValue("gpu_bpf_sink_events_total", "sink", sinkName, "result", tt.result) - before; n != 2 {
+				t.Errorf("counted %v %s events, want 2", n, tt.result)
+			}
+		})
+	}
+}
+
+func TestSinkRunnerRun(t *testing.T) {
+	writer := &fakeSink{}
+	r := &sinkRunner{name: "run", writer: writer, queue: make(chan []byte, 10), maxEvents: 2, maxWait: time.Hour, maxAttempts: 1}
+	for _, line := range []string{"1", "2", "3"} {
+		r.Enqueue([]byte(line))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.Run(ctx)
+		close(done)
+	}()
+	for len(r.queue) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	if want := [][]string{{"1", "2"}, {"3"}}; !reflect.DeepEqual(writer.batches, want) {
+		t.Errorf("batches = %v, want %v", writer.batches, want)
+	}
+	if !writer.closed {
+		t.Error("the writer was not closed")
+	}
+}
+
+func TestSinkRunnerDropsWhenFull(t *testing.T) {
+	r := &sinkRunner{name: "full", queue: make(chan []byte, 1)}
+	before := counterValue("gpu_bpf_sink_events_total", "sink", "full", "result", "dropped")
+	r.Enqueue([]byte("1"))
+	r.Enqueue([]byte("2"))
+	if n := counterValue("gpu_bpf_sink_events_total", "sink", "full", "result", "dropped") - before; n != 1 {
+		t.Errorf("dropped %v events, want 1", n)
+	}
+}
+
+func TestHTTPSink(t *testing.T) {
+	var gotBody string
+	var gotHeader http.Header
+	status := http.StatusAccepted
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotBody, gotHeader = string(body), r.Header.Clone()
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	s := newHTTPSink(HTTPSink{URL: server.URL, Headers: map[string]string{"Authorization": "Bearer token"}})
+	if err := s.Write([][]byte{[]byte(`{"event":"A"}`), []byte(`{"event":"B"}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if gotBody != "{\"event\":\"A\"}\n{\"event\":\"B\"}\n" {
+		t.Errorf("body = %q", gotBody)
+	}
+	for key, want := range map[string]string{
+		"Content-Type":  "application/x-ndjson",
+		"X-Event-Count": "2",
+		"Authorization": "Bearer token",
+	} {
+		if got := gotHeader.Get(key); got != want {
+			t.Errorf("%s header = %q, want %q", key, got, want)
+		}
+	}
+
+	status = http.StatusInternalServerError
+	if err := s.Write([][]byte{[]byte(`{}`)}); err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("Write() error = %v, want the 500 status", err)
+	}
+}
+
+func TestFileSinkRotates(t *testing.T) {
+	t.Setenv("NODE_NAME", "node-a")
+	dir := t.TempDir()
+	s, err := newFileSink("file", FileSink{Path: dir, MaxFiles: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	// Rotate after every batch
+	s.maxBytes = 1
+
+	for _, line := range []string{"1", "2", "3", "4"} {
+		if err := s.Write([][]byte{[]byte(line)}); err != nil {
+			t.Fatal(err)
+		}
+		// Rotated files are named with millisecond timestamps
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	rotated, err := filepath.Glob(filepath.Join(dir, "events-node-a-*.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated) != 2 {
+		t.Fatalf("kept %d rotated files, want 2", len(rotated))
+	}
+	var kept []string
+	for _, path := range rotated {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kept = append(kept, string(data))
+	}
+	if want := []string{"3\n", "4\n"}; !reflect.DeepEqual(kept, want) {
+		t.Errorf("rotated files hold %q, want %q", kept, want)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "events-node-a.ndjson")); err != nil || info.Size() != 0 {
+		t.Errorf("current file = %v, %v, want an empty file", info, err)
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// stacks holds the stacks flushed by the bpftrace script.
+var stacks = newStackStore(STACK_RETENTION)
+
+// tracedFunctions are the spec.functions the running script was rendered with.
+var tracedFunctions []Function
+
+// stackMapName returns the bpftrace map a function's stacks are aggregated in.
+func stackMapName(stack *StackCapture) string {
+	switch {
+	case stack.Kernel && stack.User:
+		return "@kustacks"
+	case stack.Kernel:
+		return "@kstacks"
+	default:
+		return "@ustacks"
+	}
+}
+
+// stackSample is one aggregated stack printed from a bpftrace stack map.
+// Frames are ordered innermost first, as bpftrace prints them.
+type stackSample struct {
+	Function string
+	Comm     string
+	Pid      int
+	PodUID   string
+	Kernel   []string
+	User     []string
+	Count    uint64
+	At       time.Time
+}
+
+var (
+	frameOffset = regexp.MustCompile(`\+(0x)?[0-9a-fA-F]+$`)
+	entryEnd    = regexp.MustCompile(`^\]: (\d+)$`)
+)
+
+// Folded renders the sample as a folded stack (comm;outer;...;inner), with
+// user frames above the syscall boundary and kernel frames below it.
+func (s stackSample) Folded() string {
+	frames := []string{s.Comm}
+	for i := len(s.User) - 1; i >= 0; i-- {
+		frames = append(frames, s.User[i])
+	}
+	for i := len(s.Kernel) - 1; i >= 0; i-- {
+		frames = append(frames, s.Kernel[i])
+	}
+	return strings.Join(frames, ";")
+}
+
+// stackParser reassembles the multi-line entries bpftrace prints for maps
+// keyed by stacks, e.g.
+//
+//	@kustacks[cudaMalloc, python, 4242,
+//	        nvidia_unlocked_ioctl+0
+//	        __x64_sys_ioctl+141
+//	,
+//	        cudaMalloc+0
+//	]: 12
+type stackParser struct {
+	mapName string
+	lines   []string
+}
+
+// Feed consumes a line of bpftrace output. It reports whether the line was
+// part of a stack map entry and returns the sample once an entry completes.
+func (p *stackParser) Feed(line string) (*stackSample, bool) {
+	if p.mapName == "" {
+		for _, name := range []string{"@kstacks[", "@ustacks[", "@kustacks["} {
+			if strings.HasPrefix(line, name) {
+				p.mapName = strings.TrimSuffix(name, "[")
+				p.lines = []string{strings.TrimPrefix(line, name)}
+				return nil, true
+			}
+		}
+		return nil, false
+	}
+
+	m := entryEnd.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil {
+		// Frames are indented, anything else at the start of a line means
+		// the entry was cut off, e.g. by a restart of bpftrace
+		if line != "" && line != "," && !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "\t") {
+			log.Warn().Str("map", p.mapName).Str("key", p.lines[0]).Msg("Dropping an unterminated stack map entry")
+			p.mapName, p.lines = "", nil
+			return p.Feed(line)
+		}
+		p.lines = append(p.lines, line)
+		return nil, true
+	}
+
+	sample := p.assemble()
+	p.mapName, p.lines = "", nil
+	if sample == nil {
+		return nil, true
+	}
+	sample.Count, _ = strconv.ParseUint(m[1], 10, 64)
+	sample.At = time.Now()
+	sample.PodUID = podUIDForPid(sample.Pid)
+	return sample, true
+}
+
+// assemble builds a sample from the buffered lines of one map entry.
+func (p *stackParser) assemble() *stackSample {
+	key := strings.Split(p.lines[0], ",")
+	if len(key) < 3 {
+		log.Warn().Str("map", p.mapName).Str("key", p.lines[0]).Msg("Unexpected stack map key")
+		return nil
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(key[2]))
+	sample := &stackSample{
+		Function: strings.TrimSpace(key[0]),
+		Comm:     strings.TrimSpace(key[1]),
+		Pid:      pid,
+	}
+
+	var blocks [][]string
+	var current []string
+	for _, line := range p.lines[1:] {
+		frame := strings.TrimSpace(line)
+		switch frame {
+		case "":
+		case ",":
+			blocks = append(blocks, current)
+			current = nil
+		default:
+			current = append(current, cleanFrame(frame))
+		}
+	}
+	blocks = append(blocks, current)
+
+	switch p.mapName {
+	case "@kstacks":
+		sample.Kernel = blocks[0]
+	case "@ustacks":
+		sample.User = blocks[0]
+	case "@kustacks":
+		sample.Kernel = blocks[0]
+		if len(blocks) > 1 {
+			sample.User = blocks[1]
+		}
+	}
+	return sample
+}
+
+// cleanFrame strips the offset and module suffixes from a symbolized frame.
+func cleanFrame(frame string) string {
+	if i := strings.Index(frame, " ("); i > 0 {
+		frame = frame[:i]
+	}
+	if strings.HasPrefix(frame, "0x") {
+		return frame
+	}
+	return frameOffset.ReplaceAllString(frame, "")
+}
+
+// stackStore keeps stack samples for a bounded retention window.
+type stackStore struct {
+	mu        sync.Mutex
+	retention time.Duration
+	samples   []stackSample
+}
+
+func newStackStore(retention time.Duration) *stackStore {
+	return &stackStore{retention: retention}
+}
+
+// Add records a sample and evicts samples older than the retention window.
+func (s *stackStore) Add(sample stackSample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.samples = append(s.samples, sample)
+
+	cutoff := time.Now().Add(-s.retention)
+	i := 0
+	for i < len(s.samples) && s.samples[i].At.Before(cutoff) {
+		i++
+	}
+	s.samples = s.samples[i:]
+}
+
+// Aggregate sums the samples recorded since the given time by folded stack.
+func (s *stackStore) Aggregate(since time.Time, keep func(stackSample) bool) map[string]uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	folded := map[string]uint64{}
+	for _, sample := range s.samples {
+		if sample.At.Before(since) || (keep != nil && !keep(sample)) {
+			continue
+		}
+		folded[sample.Folded()] += sample.Count
+	}
+	return folded
+}
+
+// stackQuery selects the stacks of a time window, optionally narrowed down
+// to a pod, a process or a traced function.
+type stackQuery struct {
+	Since    time.Time
+	Window   time.Duration
+	Pid      int
+	Comm     string
+	PodUID   string
+	Function string
+}
+
+// parseStackQuery reads a stackQuery from the URL parameters seconds, pid,
+// comm, pod and function. The window defaults to 60s and is capped at the
+// retention period.
+func parseStackQuery(values url.Values) (stackQuery, error) {
+	q := stackQuery{
+		Window:   time.Minute,
+		Comm:     values.Get("comm"),
+		PodUID:   values.Get("pod"),
+		Function: values.Get("function"),
+	}
+	if v := values.Get("seconds"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds <= 0 {
+			return q, fmt.Errorf("invalid seconds %q", v)
+		}
+		q.Window = time.Duration(seconds) * time.Second
+	}
+	if q.Window > STACK_RETENTION {
+		q.Window = STACK_RETENTION
+	}
+	if v := values.Get("pid"); v != "" {
+		pid, err := strconv.Atoi(v)
+		if err != nil {
+			return q, fmt.Errorf("invalid pid %q", v)
+		}
+		q.Pid = pid
+	}
+	q.Since = time.Now().Add(-q.Window)
+	return q, nil
+}
+
+// Matches reports whether a sample satisfies the query filters.
+func (q stackQuery) Matches(s stackSample) bool {
+	return (q.Pid == 0 || s.Pid == q.Pid) &&
+		(q.Comm == "" || s.Comm == q.Comm) &&
+		(q.PodUID == "" || s.PodUID == q.PodUID) &&
+		(q.Function == "" || s.Function == q.Function)
+}
+
+// stackCount is a folded stack and the number of times it was hit.
+type stackCount struct {
+	Folded string `json:"folded"`
+	Count  uint64 `json:"count"`
+}
+
+// topStacks returns the n most frequent folded stacks.
+func topStacks(folded map[string]uint64, n int) []stackCount {
+	top := make([]stackCount, 0, len(folded))
+	for stack, count := range folded {
+		top = append(top, stackCount{Folded: stack, Count: count})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Folded < top[j].Folded
+	})
+	if n > 0 && len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// reportTopStacks logs the hottest stacks of every function capturing stacks.
+func reportTopStacks() {
+	since := time.Now().Add(-STACK_RETENTION)
+	for _, fn := range tracedFunctions {
+		if fn.Stack == nil {
+			continue
+		}
+		n := fn.Stack.TopN
+		if n == 0 {
+			n = STACK_DEFAULT_TOPN
+		}
+		name := fn.Name
+		folded := stacks.Aggregate(since, func(s stackSample) bool { return s.Function == name })
+		for rank, top := range topStacks(folded, n) {
+			log.Info().Str("source", "stacks").Str("function", name).Int("rank", rank+1).
+				Uint64("count", top.Count).Msg(top.Folded)
+		}
+	}
+}
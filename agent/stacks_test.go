@@ -0,0 +1,162 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// feedLines feeds bpftrace output to a parser and returns the samples it
+// completed and the lines it did not consume.
+func feedLines(p *stackParser, output string) ([]*stackSample, []string) {
+	var samples []*stackSample
+	var rest []string
+	for _, line := range strings.Split(output, "\n") {
+		sample, consumed := p.Feed(line)
+		if !consumed {
+			rest = append(rest, line)
+		}
+		if sample != nil {
+			samples = append(samples, sample)
+		}
+	}
+	return samples, rest
+}
+
+func TestStackParserKernelStacks(t *testing.T) {
+	fakeProc(t)
+	samples, rest := feedLines(&stackParser{}, `@kstacks[nvidia_ioctl, python, 4242,
+        nvidia_unlocked_ioctl+0
+        __x64_sys_ioctl+141
+        do_syscall_64+93 (vmlinux)
+]: 12`)
+	if len(rest) != 0 {
+		t.Errorf("unconsumed lines %q", rest)
+	}
+	if len(samples) != 1 {
+		t.Fatalf("parsed %d samples, want 1", len(samples))
+	}
+	s := samples[0]
+	if s.Function != "nvidia_ioctl" || s.Comm != "python" || s.Pid != 4242 || s.Count != 12 {
+		t.Errorf("sample = %+v", s)
+	}
+	if want := []string{"nvidia_unlocked_ioctl", "__x64_sys_ioctl", "do_syscall_64"}; !reflect.DeepEqual(s.Kernel, want) {
+		t.Errorf("kernel frames = %q, want %q", s.Kernel, want)
+	}
+	if s.User != nil {
+		t.Errorf("user frames = %q, want none", s.User)
+	}
+	if s.At.IsZero() {
+		t.Error("sample has no time")
+	}
+}
+
+func TestStackParserKernelAndUserStacks(t *testing.T) {
+	fakeProc(t)
+	samples, _ := feedLines(&stackParser{}, `@kustacks[cudaMalloc, python, 4242,
+        nvidia_unlocked_ioctl+0
+        __x64_sys_ioctl+141
+,
+        cudaMalloc+0
+        0x7f3a2b1c0d00
+        main+0x2a
+]: 3
+@ustacks[cudaFree, trainer, 7,
+        cudaFree+16
+]: 1`)
+	if len(samples) != 2 {
+		t.Fatalf("parsed %d samples, want 2", len(samples))
+	}
+	s := samples[0]
+	if want := []string{"nvidia_unlocked_ioctl", "__x64_sys_ioctl"}; !reflect.DeepEqual(s.Kernel, want) {
+		t.Errorf("kernel frames = %q, want %q", s.Kernel, want)
+	}
+	if want := []string{"cudaMalloc", "0x7f3a2b1c0d00", "main"}; !reflect.DeepEqual(s.User, want) {
+		t.Errorf("user frames = %q, want %q", s.User, want)
+	}
+	if got, want := s.Folded(), "python;main;0x7f3a2b1c0d00;cudaMalloc;__x64_sys_ioctl;nvidia_unlocked_ioctl"; got != want {
+		t.Errorf("Folded() = %q, want %q", got, want)
+	}
+	if got, want := samples[1].Folded(), "trainer;cudaFree"; got != want {
+		t.Errorf("Folded() = %q, want %q", got, want)
+	}
+}
+
+func TestStackParserStackDump(t *testing.T) {
+	fakeProc(t)
+	// The output of the interval probe that hands a window over to the agent
+	samples, rest := feedLines(&stackParser{}, `@kstacks[nvidia_ioctl, python, 4242,
+        nvidia_unlocked_ioctl+0
+]: 2
+@kstacks[nvidia_ioctl, python, 4243,
+        nvidia_unlocked_ioctl+0
+]: 5
+
+10000        STACK_DUMP         -                0        -       `)
+	if len(samples) != 2 {
+		t.Fatalf("parsed %d samples, want 2", len(samples))
+	}
+	if len(rest) != 2 || rest[0] != "" {
+		t.Fatalf("unconsumed lines %q, want the blank line and STACK_DUMP", rest)
+	}
+	ev, ok := parseEvent(rest[1])
+	if !ok || ev.Type != "STACK_DUMP" {
+		t.Errorf("parseEvent(%q) = %+v, %v, want a STACK_DUMP event", rest[1], ev, ok)
+	}
+}
+
+func TestStackParserMalformedInput(t *testing.T) {
+	fakeProc(t)
+	p := &stackParser{}
+	samples, rest := feedLines(p, `@kstacks[python,
+        nvidia_unlocked_ioctl+0
+]: 4
+@ustacks[cudaMalloc, python, 4242,
+        cudaMalloc+0
+10000        CALL               python           4242     -        fn=cudaFree
+@ustacks[cudaFree, python, 4242,
+        cudaFree+0
+]: 1
+Attaching 12 probes...`)
+	if len(samples) != 1 || samples[0].Function != "cudaFree" || samples[0].Count != 1 {
+		t.Errorf("samples = %+v, want only the complete cudaFree entry", samples)
+	}
+	want := []string{"10000        CALL               python           4242     -        fn=cudaFree", "Attaching 12 probes..."}
+	if !reflect.DeepEqual(rest, want) {
+		t.Errorf("unconsumed lines %q, want %q", rest, want)
+	}
+	if p.mapName != "" || p.lines != nil {
+		t.Errorf("parser left in entry %s %q", p.mapName, p.lines)
+	}
+}
+
+func TestStackStoreRetention(t *testing.T) {
+	store := newStackStore(time.Minute)
+	now := time.Now()
+	store.Add(stackSample{Function: "cudaMalloc", Comm: "old", Count: 1, At: now.Add(-2 * time.Minute)})
+	store.Add(stackSample{Function: "cudaMalloc", Comm: "python", Kernel: []string{"f"}, Count: 2, At: now.Add(-30 * time.Second)})
+	store.Add(stackSample{Function: "cudaFree", Comm: "python", Kernel: []string{"f"}, Count: 3, At: now})
+
+	if got := store.Aggregate(time.Time{}, nil); !reflect.DeepEqual(got, map[string]uint64{"python;f": 5}) {
+		t.Errorf("Aggregate() = %v, want the samples within the retention", got)
+	}
+	if got := store.Aggregate(now.Add(-10*time.Second), nil); !reflect.DeepEqual(got, map[string]uint64{"python;f": 3}) {
+		t.Errorf("Aggregate(since) = %v, want the last window", got)
+	}
+	keep := func(s stackSample) bool { return s.Function == "cudaMalloc" }
+	if got := store.Aggregate(time.Time{}, keep); !reflect.DeepEqual(got, map[string]uint64{"python;f": 2}) {
+		t.Errorf("Aggregate(keep) = %v", got)
+	}
+}
+
+func TestTopStacks(t *testing.T) {
+	folded := map[string]uint64{"a;x": 5, "a;y": 9, "a;z": 5, "b": 1}
+	want := []stackCount{{"a;y", 9}, {"a;x", 5}, {"a;z", 5}}
+	if got := topStacks(folded, 3); !reflect.DeepEqual(got, want) {
+		t.Errorf("topStacks() = %v, want %v", got, want)
+	}
+	if got := topStacks(folded, 0); len(got) != 4 {
+		t.Errorf("topStacks(0) returned %d stacks, want all", len(got))
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// health is the agent state reported by /healthz and /readyz.
+var health = &agentHealth{}
+
+// attachFailure matches the bpftrace diagnostics of probes that could not be attached
+var attachFailure = regexp.MustCompile(`(?i)(could not attach|failed to attach|could not resolve symbol|unable to attach)`)
+
+// eventCount counts handled events for the events-per-second gauge.
+var eventCount atomic.Uint64
+
+// bpftraceCommand is the bpftrace binary the script is run with.
+var bpftraceCommand = "/usr/bin/bpftrace"
+
+// Restart timing of bpftrace, see the BPFTRACE_* defaults
+var (
+	restartBackoff    = BPFTRACE_RESTART_BACKOFF
+	maxRestartBackoff = BPFTRACE_MAX_BACKOFF
+	stableAfter       = BPFTRACE_STABLE_AFTER
+)
+
+type agentHealth struct {
+	mu      sync.Mutex
+	running bool
+	ready   bool
+	failure string
+}
+
+func (h *agentHealth) setRunning(running bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.running = running
+	if !running {
+		h.ready = false
+	}
+	metrics.Set("gpu_bpf_bpftrace_up", boolToFloat(running))
+}
+
+// setReady is called once bpftrace has attached its probes and runs BEGIN.
+func (h *agentHealth) setReady() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.running && !h.ready {
+		h.ready = true
+		log.Info().Msg("bpftrace probes attached, agent is ready")
+	}
+}
+
+// setFailed marks the agent as unhealthy after bpftrace could not be kept running.
+func (h *agentHealth) setFailed(reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.failure = reason
+}
+
+func (h *agentHealth) handleHealthz(w http.ResponseWriter, _ *http.Request) {
+	h.mu.Lock()
+	failure := h.failure
+	h.mu.Unlock()
+	if failure != "" {
+		http.Error(w, failure, http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func (h *agentHealth) handleReadyz(w http.ResponseWriter, _ *http.Request) {
+	h.mu.Lock()
+	ready := h.ready
+	h.mu.Unlock()
+	if !ready {
+		http.Error(w, "bpftrace probes are not attached", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// superviseBpftrace runs bpftrace until ctx is cancelled, restarting it with
+// exponential backoff when it exits on its own. It gives up after
+// BPFTRACE_MAX_RESTARTS consecutive failures.
+func superviseBpftrace(ctx context.Context) error {
+	maxRestarts := BPFTRACE_MAX_RESTARTS
+	if v := os.Getenv("BPFTRACE_MAX_RESTARTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid BPFTRACE_MAX_RESTARTS %q", v)
+		}
+		maxRestarts = n
+	}
+
+	go processEvents(eventQueue)
+	defer eventQueue.Close()
+	go trackEventRate(ctx)
+
+	backoff := restartBackoff
+	consecutiveFailures, restarts := 0, 0
+	for {
+		started := time.Now()
+		stderrTail := newLineRing(DIAGNOSTICS_STDERR_LINES)
+		err := runBpftrace(ctx, stderrTail)
+		if ctx.Err() != nil {
+			return nil
+		}
+		failure := recordFailure(err, stderrTail.Lines(), restarts)
+		if err == nil {
+			err = fmt.Errorf("bpftrace exited")
+		}
+
+		// A run that stayed up for a while starts a new series of failures
+		if time.Since(started) > stableAfter {
+			consecutiveFailures, backoff = 0, restartBackoff
+		}
+		consecutiveFailures++
+		if consecutiveFailures > maxRestarts {
+			health.setFailed(failure.Reason)
+			return fmt.Errorf("giving up after %d restarts: %s", maxRestarts, failure.Reason)
+		}
+
+		log.Error().Err(err).Str("reason", failure.Reason).Dur("backoff", backoff).Int("failures", consecutiveFailures).Msg("bpftrace exited unexpectedly, restarting")
+		metrics.Inc("gpu_bpf_bpftrace_restarts_total")
+		restarts++
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxRestartBackoff)
+	}
+}
+
+// runBpftrace runs bpftrace once and returns when it exits, keeping the last
+// lines of its stderr in stderrTail.
+func runBpftrace(ctx context.Context, stderrTail *lineRing) error {
+	cmd := exec.CommandContext(ctx, bpftraceCommand, scriptPath)
+	// Interrupt rather than kill bpftrace on shutdown so that it detaches its
+	// probes and runs END before the agent exits. BPFTRACE_DETACH_TIMEOUT
+	// stays below the termination grace period of the agent pods.
+	cmd.Cancel = func() error {
+		log.Info().Msg("Detaching bpftrace probes...")
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	cmd.WaitDelay = BPFTRACE_DETACH_TIMEOUT
+	stdoutPipe, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderrPipe, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	setBpftraceStart(time.Now())
+	health.setRunning(true)
+	defer health.setRunning(false)
+	log.Info().Int("pid", cmd.Process.Pid).Msg("bpftrace script started successfully")
+
+	// Both pipes must be read to the end before waiting for the process
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		streamEvents(stdoutPipe)
+	}()
+	go func() {
+		defer wg.Done()
+		streamOutput(stderrPipe, "stderr", stderrTail)
+	}()
+	wg.Wait()
+	return cmd.Wait()
+}
+
+// trackEventRate updates the events-per-second gauge.
+func trackEventRate(ctx context.Context) {
+	ticker := time.NewTicker(EVENT_RATE_INTERVAL)
+	defer ticker.Stop()
+	last := eventCount.Load()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			current := eventCount.Load()
+			metrics.Set("gpu_bpf_events_per_second", float64(current-last)/EVENT_RATE_INTERVAL.Seconds())
+			last = current
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeBpftrace is a stand-in for bpftrace that fails its first
+// FAKE_FAILURES runs and then attaches until it is interrupted.
+const fakeBpftrace = `#!/bin/sh
+n=$(( $(cat "$FAKE_RUNS" 2>/dev/null || echo 0) + 1 ))
+echo $n > "$FAKE_RUNS"
+if [ "$n" -le "$FAKE_FAILURES" ]; then
+    echo "Attaching 3 probes..."
+    echo "ERROR: Could not resolve symbol: /usr/lib/libcudart.so:cudaMalloc" >&2
+    exit 1
+fi
+trap 'exit 0' INT
+echo "Tracing NVIDIA GPU driver activity... Hit Ctrl-C to end."
+while :; do sleep 0.02; done
+`
+
+// supervisorTest runs the supervisor against fakeBpftrace with fresh agent
+// state and short restart backoffs.
+type supervisorTest struct {
+	runs string
+}
+
+func newSupervisorTest(t *testing.T, failedRuns int) *supervisorTest {
+	t.Helper()
+	dir := t.TempDir()
+	command := filepath.Join(dir, "bpftrace")
+	if err := os.WriteFile(command, []byte(fakeBpftrace), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	st := &supervisorTest{runs: filepath.Join(dir, "runs")}
+	t.Setenv("FAKE_RUNS", st.runs)
+	t.Setenv("FAKE_FAILURES", strconv.Itoa(failedRuns))
+	t.Setenv("DIAGNOSTICS_DIR", filepath.Join(dir, "diagnostics"))
+	t.Setenv("BPFTRACE_MAX_RESTARTS", "")
+
+	savedCommand, savedScript, savedQueue, savedHealth, savedFailures := bpftraceCommand, scriptPath, eventQueue, health, failures
+	savedBackoff, savedMaxBackoff, savedStable := restartBackoff, maxRestartBackoff, stableAfter
+	bpftraceCommand, scriptPath = command, filepath.Join(dir, "nvidia_events.bt")
+	eventQueue = newLineQueue(64, DROP_OLDEST, nil)
+	health, failures = &agentHealth{}, &failureStore{}
+	restartBackoff, maxRestartBackoff, stableAfter = 10*time.Millisecond, 15*time.Millisecond, time.Minute
+	t.Cleanup(func() {
+		bpftraceCommand, scriptPath, eventQueue, health, failures = savedCommand, savedScript, savedQueue, savedHealth, savedFailures
+		restartBackoff, maxRestartBackoff, stableAfter = savedBackoff, savedMaxBackoff, savedStable
+	})
+	return st
+}
+
+func (st *supervisorTest) Runs() int {
+	data, _ := os.ReadFile(st.runs)
+	n, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return n
+}
+
+func probe(handler http.HandlerFunc) int {
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	return rec.Code
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSuperviseBpftraceGivesUp(t *testing.T) {
+	st := newSupervisorTest(t, 100)
+	t.Setenv("BPFTRACE_MAX_RESTARTS", "2")
+	restartsBefore := counterValue("gpu_bpf_bpftrace_restarts_total")
+
+	started := time.Now()
+	err := superviseBpftrace(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "giving up after 2 restarts: ERROR: Could not resolve symbol") {
+		t.Fatalf("superviseBpftrace() = %v, want to give up", err)
+	}
+	// The backoff doubles from 10ms and is capped at 15ms
+	if elapsed := time.Since(started); elapsed < 25*time.Millisecond {
+		t.Errorf("gave up after %v, want the backoffs to be waited for", elapsed)
+	}
+	if got := st.Runs(); got != 3 {
+		t.Errorf("bpftrace ran %d times, want 3", got)
+	}
+	if got := counterValue("gpu_bpf_bpftrace_restarts_total") - restartsBefore; got != 2 {
+		t.Errorf("restarts counted %v times, want 2", got)
+	}
+
+	if code := probe(health.handleHealthz); code != http.StatusServiceUnavailable {
+		t.Errorf("/healthz = %d after giving up, want 503", code)
+	}
+	if code := probe(health.handleReadyz); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz = %d after giving up, want 503", code)
+	}
+	last := failures.Last()
+	if last == nil || last.Restarts != 2 || last.ExitCode != 1 || last.Bundle == "" {
+		t.Errorf("last failure = %+v", last)
+	}
+}
+
+func TestSuperviseBpftraceRestartsUntilReady(t *testing.T) {
+	st := newSupervisorTest(t, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- superviseBpftrace(ctx) }()
+
+	waitFor(t, "the agent to become ready", func() bool { return probe(health.handleReadyz) == http.StatusOK })
+	if got := st.Runs(); got != 3 {
+		t.Errorf("bpftrace ran %d times, want 3", got)
+	}
+	if code := probe(health.handleHealthz); code != http.StatusOK {
+		t.Errorf("/healthz = %d, want 200", code)
+	}
+	if got := counterValue("gpu_bpf_bpftrace_up"); got != 1 {
+		t.Errorf("gpu_bpf_bpftrace_up = %v, want 1", got)
+	}
+
+	// Shutting down interrupts bpftrace, which is not a failure
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("superviseBpftrace() = %v after shutdown", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("bpftrace was not interrupted")
+	}
+	if code := probe(health.handleReadyz); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz = %d after shutdown, want 503", code)
+	}
+	if code := probe(health.handleHealthz); code != http.StatusOK {
+		t.Errorf("/healthz = %d after shutdown, want 200", code)
+	}
+}
+
+func TestSuperviseBpftraceStableRunsResetFailures(t *testing.T) {
+	st := newSupervisorTest(t, 4)
+	t.Setenv("BPFTRACE_MAX_RESTARTS", "1")
+	// Every run counts as stable, so no series of failures gets long enough
+	stableAfter = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- superviseBpftrace(ctx) }()
+
+	waitFor(t, "the agent to become ready", func() bool { return probe(health.handleReadyz) == http.StatusOK })
+	if got := st.Runs(); got != 5 {
+		t.Errorf("bpftrace ran %d times, want 5", got)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("superviseBpftrace() = %v", err)
+	}
+}
+
+func TestSuperviseBpftraceInvalidMaxRestarts(t *testing.T) {
+	t.Setenv("BPFTRACE_MAX_RESTARTS", "-1")
+	if err := superviseBpftrace(context.Background()); err == nil {
+		t.Error("superviseBpftrace accepted a negative BPFTRACE_MAX_RESTARTS")
+	}
+}
+
+func TestAgentHealth(t *testing.T) {
+	h := &agentHealth{}
+	h.setReady()
+	if code := probe(h.handleReadyz); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz = %d before bpftrace runs, want 503", code)
+	}
+	h.setRunning(true)
+	if code := probe(h.handleReadyz); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz = %d before the probes are attached, want 503", code)
+	}
+	h.setReady()
+	if code := probe(h.handleReadyz); code != http.StatusOK {
+		t.Errorf("/readyz = %d once attached, want 200", code)
+	}
+	h.setRunning(false)
+	if code := probe(h.handleReadyz); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz = %d after bpftrace exited, want 503", code)
+	}
+	if code := probe(h.handleHealthz); code != http.StatusOK {
+		t.Errorf("/healthz = %d, want 200", code)
+	}
+	h.setFailed("ERROR: out of memory")
+	rec := httptest.NewRecorder()
+	h.handleHealthz(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "out of memory") {
+		t.Errorf("/healthz = %d %q after a failure", rec.Code, rec.Body.String())
+	}
+}
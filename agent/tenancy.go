@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// tenantPods restricts events to the pods of a namespace-scoped policy. It
+// is nil for systemwide policies, which see every process on the node.
+var tenantPods *podFilter
+
+// podFilter allows the processes of the pods listed in POD_UIDS_FILE, which
+// the operator keeps up to date as pods of the namespace come and go.
+type podFilter struct {
+	path string
+	mu   sync.RWMutex
+	uids map[string]bool
+}
+
+// newPodFilterFromEnv loads the pod filter from POD_UIDS_FILE, if set.
+func newPodFilterFromEnv() (*podFilter, error) {
+	path := os.Getenv("POD_UIDS_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	f := &podFilter{path: path}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *podFilter) reload() error {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	uids := map[string]bool{}
+	for _, uid := range strings.Fields(string(data)) {
+		uids[uid] = true
+	}
+	f.mu.Lock()
+	f.uids = uids
+	f.mu.Unlock()
+	metrics.Set("gpu_bpf_tenant_pods", float64(len(uids)))
+	return nil
+}
+
+// Watch reloads the pod list until ctx is cancelled. The kubelet updates
+// mounted ConfigMaps with a delay, so events of new pods are dropped until
+// their UID shows up.
+func (f *podFilter) Watch(ctx context.Context) {
+	ticker := time.NewTicker(POD_UIDS_RELOAD_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := f.reload(); err != nil {
+				log.Warn().Err(err).Str("path", f.path).Msg("Failed to reload tenant pods, keeping the previous list")
+			}
+		}
+	}
+}
+
+// Allows reports whether events of a process may be reported. Processes
+// outside of the listed pods, including host processes, are filtered.
+func (f *podFilter) Allows(pid int) bool {
+	if f == nil {
+		return true
+	}
+	uid := podUIDForPid(pid)
+	if uid == "" {
+		return false
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.uids[uid]
+}
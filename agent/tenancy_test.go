@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPodFilter(t *testing.T) {
+	root := fakeProc(t)
+	startProcess(t, root, 100, 5000, trainerUID)
+	startProcess(t, root, 200, 5100, otherUID)
+	startProcess(t, root, 300, 5200, "")
+
+	path := filepath.Join(t.TempDir(), "pod-uids")
+	if err := os.WriteFile(path, []byte(trainerUID+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("POD_UIDS_FILE", path)
+	f, err := newPodFilterFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for pid, want := range map[int]bool{100: true, 200: false, 300: false, 400: false} {
+		if got := f.Allows(pid); got != want {
+			t.Errorf("Allows(%d) = %v, want %v", pid, got, want)
+		}
+	}
+
+	// A PID reused by another tenant's process is not let through
+	exitProcess(t, root, 100)
+	startProcess(t, root, 100, 9000, otherUID)
+	if f.Allows(100) {
+		t.Error("Allows let a reused PID of another pod through")
+	}
+
+	// Pods show up once the operator lists them
+	if err := os.WriteFile(path, []byte(trainerUID+"\n"+otherUID+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if !f.Allows(200) {
+		t.Error("Allows dropped a pod after it was listed")
+	}
+	if got := counterValue("gpu_bpf_tenant_pods"); got != 2 {
+		t.Errorf("gpu_bpf_tenant_pods = %v, want 2", got)
+	}
+}
+
+func TestPodFilterFromEnv(t *testing.T) {
+	t.Setenv("POD_UIDS_FILE", "")
+	if f, err := newPodFilterFromEnv(); f != nil || err != nil {
+		t.Errorf("newPodFilterFromEnv() = %v, %v, want no filter", f, err)
+	}
+	var systemwide *podFilter
+	if !systemwide.Allows(1) {
+		t.Error("a nil filter must allow every process")
+	}
+
+	t.Setenv("POD_UIDS_FILE", filepath.Join(t.TempDir(), "missing"))
+	if _, err := newPodFilterFromEnv(); err == nil {
+		t.Error("newPodFilterFromEnv accepted a missing file")
+	}
+}
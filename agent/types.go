@@ -1,16 +1,114 @@
 package main
 
+import "time"
+
 const (
-	TEMPLATE_FILE_PATH = "templates/nvidia_events.bt.tmpl"
-	BT_FILE_PATH       = "/tmp/nvidia_events.bt"
+	AGENT_LISTEN_ADDR = ":9090"
+	// AGENT_DEBUG_ADDR serves stacks, profiles and diagnostic bundles to
+	// kubectl port-forward only
+	AGENT_DEBUG_ADDR   = "127.0.0.1:9091"
+	STACK_RETENTION    = 30 * time.Minute
+	STACK_DEFAULT_TOPN = 10
+	// CAPTURE_BUFFER_SIZE is how many events a capture may lag behind
+	// before events are dropped for it
+	CAPTURE_BUFFER_SIZE = 4096
+	// OTLP export defaults, overridable through the standard OTEL_* variables
+	OTEL_SERVICE_NAME    = "gpu-bpf-agent"
+	OTEL_METRIC_INTERVAL = 60 * time.Second
+	OTEL_FLUSH_INTERVAL  = 5 * time.Second
+	OTEL_BATCH_SIZE      = 512
+	OTEL_QUEUE_SIZE      = 8192
+	// Output sink defaults for settings left empty in spec.sinks
+	SINK_MAX_EVENTS    = 500
+	SINK_MAX_WAIT      = time.Second
+	SINK_MAX_ATTEMPTS  = 3
+	SINK_RETRY_BACKOFF = time.Second
+	SINK_QUEUE_SIZE    = 10000
+	SINK_FILE_MAX_MB   = 100
+	SINK_FILE_MAX_KEEP = 5
+	// KAFKA_MAX_RESPONSE_SIZE bounds the Kafka responses the producer reads,
+	// the default socket.request.max.bytes of the brokers
+	KAFKA_MAX_RESPONSE_SIZE = 100 << 20
+	// Event queue defaults for settings left empty in spec.buffering
+	EVENT_QUEUE_SIZE   = 16384
+	EVENT_DROP_POLICY  = DROP_OLDEST
+	EVENT_SPILL_MAX_MB = 256
+	// bpftrace supervision
+	BPFTRACE_MAX_RESTARTS    = 5
+	BPFTRACE_RESTART_BACKOFF = time.Second
+	BPFTRACE_MAX_BACKOFF     = time.Minute
+	BPFTRACE_STABLE_AFTER    = 5 * time.Minute
+	BPFTRACE_DETACH_TIMEOUT  = 20 * time.Second
+	EVENT_RATE_INTERVAL      = 10 * time.Second
+	READY_BANNER             = "Tracing NVIDIA GPU driver activity"
+	// Crash diagnostics of bpftrace
+	DIAGNOSTICS_DIR          = "/tmp/gpu-bpf-diagnostics"
+	DIAGNOSTICS_MAX_BUNDLES  = 5
+	DIAGNOSTICS_STDERR_LINES = 200
+	// OUTPUT_MAX_LINE is the longest bpftrace output line that is processed
+	OUTPUT_MAX_LINE = 1 << 20
+
+	POD_UIDS_RELOAD_INTERVAL = 10 * time.Second
+	// POD_UID_CACHE_SIZE is how many PIDs are cached before the entries of
+	// exited processes are evicted
+	POD_UID_CACHE_SIZE = 4096
 )
 
-type TemplateProbeLib struct {
-	ProbeLib []string
-	LibPath  string
+// Function mirrors an entry of the policy's spec.functions as encoded in FUNCTION_CALLS.
+type Function struct {
+	Name    string        `json:"name"`
+	Kind    string        `json:"kind"`
+	Args    []Arg         `json:"args,omitempty"`
+	Returns string        `json:"returns,omitempty"`
+	Stack   *StackCapture `json:"stack,omitempty"`
+	Trace   bool          `json:"trace,omitempty"`
+}
+
+type StackCapture struct {
+	Kernel bool `json:"kernel,omitempty"`
+	User   bool `json:"user,omitempty"`
+	TopN   int  `json:"topN,omitempty"`
+}
+
+type Arg struct {
+	Index int    `json:"index"`
+	Name  string `json:"name"`
+}
+
+// OutputSink mirrors an entry of the policy's spec.sinks as encoded in OUTPUT_SINKS.
+type OutputSink struct {
+	Name         string        `json:"name"`
+	File         *FileSink     `json:"file,omitempty"`
+	HTTP         *HTTPSink     `json:"http,omitempty"`
+	Kafka        *KafkaSink    `json:"kafka,omitempty"`
+	Batching     *SinkBatching `json:"batching,omitempty"`
+	Retry        *SinkRetry    `json:"retry,omitempty"`
+	Backpressure string        `json:"backpressure,omitempty"`
+	QueueSize    int           `json:"queueSize,omitempty"`
+}
+
+type FileSink struct {
+	Path      string `json:"path"`
+	MaxSizeMB int    `json:"maxSizeMB,omitempty"`
+	MaxFiles  int    `json:"maxFiles,omitempty"`
+}
+
+type HTTPSink struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+type KafkaSink struct {
+	Brokers []string `json:"brokers"`
+	Topic   string   `json:"topic"`
+}
+
+type SinkBatching struct {
+	MaxEvents int    `json:"maxEvents,omitempty"`
+	MaxWait   string `json:"maxWait,omitempty"`
 }
 
-type Probe struct {
-	Kind string `json:"Kind"`
-	Name string `json:"Name"`
+type SinkRetry struct {
+	MaxAttempts int    `json:"maxAttempts,omitempty"`
+	Backoff     string `json:"backoff,omitempty"`
 }
@@ -0,0 +1,41 @@
+/*
+Copyright 2025 WoodProgrammer.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"github.com/WoodProgrammer/gpu-bpf-operator/api/v1beta1"
+)
+
+// ConvertTo converts this ClusterCudaEBPFPolicy to the Hub version (v1beta1).
+func (src *ClusterCudaEBPFPolicy) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1beta1.ClusterCudaEBPFPolicy)
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+	convertSpecToHub(src.Spec.DeepCopy(), &dst.Spec)
+	convertStatusToHub(src.Status.DeepCopy(), &dst.Status)
+	return nil
+}
+
+// ConvertFrom converts from the Hub version (v1beta1) to this version.
+func (dst *ClusterCudaEBPFPolicy) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1beta1.ClusterCudaEBPFPolicy)
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+	convertSpecFromHub(src.Spec.DeepCopy(), &dst.Spec)
+	convertStatusFromHub(src.Status.DeepCopy(), &dst.Status)
+	return nil
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2025 WoodProgrammer.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/diff"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+	"sigs.k8s.io/randfill"
+
+	"github.com/WoodProgrammer/gpu-bpf-operator/api/v1beta1"
+)
+
+// fuzzIterations is how many random objects each round trip is tested with.
+const fuzzIterations = 200
+
+// newFiller returns a filler for random API objects. TypeMeta is left empty
+// because conversions don't set it, the conversion webhook does.
+func newFiller() *randfill.Filler {
+	return randfill.NewWithSeed(GinkgoRandomSeed()).NilChance(0.2).NumElements(0, 3).MaxDepth(10).Funcs(
+		func(*metav1.TypeMeta, randfill.Continue) {},
+	)
+}
+
+// expectSemanticallyEqual fails with a side by side dump of both objects
+// unless they are semantically equal.
+func expectSemanticallyEqual(actual, expected any) {
+	GinkgoHelper()
+	Expect(equality.Semantic.DeepEqual(actual, expected)).To(BeTrue(), func() string {
+		return "objects differ (expected | actual):\n" + diff.ObjectGoPrintSideBySide(expected, actual)
+	})
+}
+
+// describeRoundTrip converts random objects of a kind from v1alpha1 to the
+// v1beta1 hub and back, and from the hub to v1alpha1 and back. Neither may
+// lose or alter anything.
+func describeRoundTrip(newSpoke func() conversion.Convertible, newHub func() conversion.Hub) {
+	It("should round-trip random v1alpha1 objects through v1beta1", func() {
+		filler := newFiller()
+		for range fuzzIterations {
+			original := newSpoke()
+			filler.Fill(original)
+			want := original.DeepCopyObject()
+
+			hub := newHub()
+			Expect(original.ConvertTo(hub)).To(Succeed())
+			restored := newSpoke()
+			Expect(restored.ConvertFrom(hub)).To(Succeed())
+
+			expectSemanticallyEqual(restored, want)
+			expectSemanticallyEqual(original, want)
+		}
+	})
+
+	It("should round-trip random v1beta1 objects through v1alpha1", func() {
+		filler := newFiller()
+		for range fuzzIterations {
+			original := newHub()
+			filler.Fill(original)
+			want := original.DeepCopyObject()
+
+			spoke := newSpoke()
+			Expect(spoke.ConvertFrom(original)).To(Succeed())
+			restored := newHub()
+			Expect(spoke.ConvertTo(restored)).To(Succeed())
+
+			expectSemanticallyEqual(restored, want)
+			expectSemanticallyEqual(original, want)
+		}
+	})
+}
+
+var _ = Describe("Conversion", func() {
+	Context("of CudaEBPFPolicy", func() {
+		describeRoundTrip(
+			func() conversion.Convertible { return &CudaEBPFPolicy{} },
+			func() conversion.Hub { return &v1beta1.CudaEBPFPolicy{} },
+		)
+
+		It("should move the output settings into spec.output", func() {
+			policy := &CudaEBPFPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-policy", Namespace: "default"},
+				Spec: CudaEBPFPolicySpec{
+					Mode:         "systemwide",
+					Probes:       []string{"nvidia_open"},
+					OutputFormat: "prometheus",
+					OTLP:         &OTLPExport{Endpoint: "http://otel-collector:4318"},
+					Sinks: []OutputSink{{
+						Name:         "archive",
+						File:         &FileSink{Path: "/var/log/gpu"},
+						Backpressure: "block",
+					}},
+					Buffering: &EventBuffering{QueueSize: 128, Policy: "dropNewest"},
+				},
+			}
+
+			hub := &v1beta1.CudaEBPFPolicy{}
+			Expect(policy.ConvertTo(hub)).To(Succeed())
+			Expect(hub.Spec.Mode).To(Equal(v1beta1.TracingModeSystemWide))
+			Expect(hub.Spec.Probes).To(Equal([]v1beta1.DriverProbe{v1beta1.DriverProbeOpen}))
+			Expect(hub.Spec.Output).To(Equal(v1beta1.OutputSpec{
+				Format: v1beta1.OutputFormatPrometheus,
+				OTLP:   &v1beta1.OTLPExport{Endpoint: "http://otel-collector:4318"},
+				Sinks: []v1beta1.OutputSink{{
+					Name:         "archive",
+					File:         &v1beta1.FileSink{Path: "/var/log/gpu"},
+					Backpressure: v1beta1.BackpressureBlock,
+				}},
+				Buffering: &v1beta1.EventBuffering{QueueSize: 128, Policy: v1beta1.QueuePolicyDropNewest},
+			}))
+		})
+	})
+
+	Context("of ClusterCudaEBPFPolicy", func() {
+		describeRoundTrip(
+			func() conversion.Convertible { return &ClusterCudaEBPFPolicy{} },
+			func() conversion.Hub { return &v1beta1.ClusterCudaEBPFPolicy{} },
+		)
+	})
+
+	Context("of ProbeTargetBinding", func() {
+		describeRoundTrip(
+			func() conversion.Convertible { return &ProbeTargetBinding{} },
+			func() conversion.Hub { return &v1beta1.ProbeTargetBinding{} },
+		)
+
+		It("should keep the matchExpressions of a node selector in an annotation", func() {
+			hub := &v1beta1.ProbeTargetBinding{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-binding", Namespace: "default"},
+				Spec: v1beta1.ProbeTargetBindingSpec{
+					PolicyRef: "test-policy",
+					NodeSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"nvidia.com/gpu.present": "true"},
+						MatchExpressions: []metav1.LabelSelectorRequirement{{
+							Key:      "nvidia.com/gpu.product",
+							Operator: metav1.LabelSelectorOpIn,
+							Values:   []string{"NVIDIA-A100-SXM4-80GB"},
+						}},
+					},
+				},
+			}
+
+			binding := &ProbeTargetBinding{}
+			Expect(binding.ConvertFrom(hub)).To(Succeed())
+			Expect(binding.Spec.NodeSelector).To(Equal(map[string]string{"nvidia.com/gpu.present": "true"}))
+			Expect(binding.Annotations).To(HaveKeyWithValue(NodeSelectorExpressionsAnnotation,
+				`[{"key":"nvidia.com/gpu.product","operator":"In","values":["NVIDIA-A100-SXM4-80GB"]}]`))
+
+			restored := &v1beta1.ProbeTargetBinding{}
+			Expect(binding.ConvertTo(restored)).To(Succeed())
+			Expect(restored.Annotations).NotTo(HaveKey(NodeSelectorExpressionsAnnotation))
+			Expect(restored.Spec.NodeSelector).To(Equal(hub.Spec.NodeSelector))
+		})
+
+		It("should reject an invalid node selector expressions annotation", func() {
+			binding := &ProbeTargetBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test-binding",
+					Namespace:   "default",
+					Annotations: map[string]string{NodeSelectorExpressionsAnnotation: "zone=a"},
+				},
+				Spec: ProbeTargetBindingSpec{PolicyRef: "test-policy"},
+			}
+
+			err := binding.ConvertTo(&v1beta1.ProbeTargetBinding{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(NodeSelectorExpressionsAnnotation))
+		})
+	})
+})
@@ -0,0 +1,195 @@
+/*
+Copyright 2025 WoodProgrammer.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"github.com/WoodProgrammer/gpu-bpf-operator/api/v1beta1"
+)
+
+// ConvertTo converts this CudaEBPFPolicy to the Hub version (v1beta1).
+func (src *CudaEBPFPolicy) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1beta1.CudaEBPFPolicy)
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+	convertSpecToHub(src.Spec.DeepCopy(), &dst.Spec)
+	convertStatusToHub(src.Status.DeepCopy(), &dst.Status)
+	return nil
+}
+
+// ConvertFrom converts from the Hub version (v1beta1) to this version.
+func (dst *CudaEBPFPolicy) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1beta1.CudaEBPFPolicy)
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+	convertSpecFromHub(src.Spec.DeepCopy(), &dst.Spec)
+	convertStatusFromHub(src.Status.DeepCopy(), &dst.Status)
+	return nil
+}
+
+// convertSpecToHub converts a spec to v1beta1. The output settings move
+// into spec.output and the string fields become typed enums. dst takes
+// ownership of in, which must not be shared with the source object.
+func convertSpecToHub(in *CudaEBPFPolicySpec, dst *v1beta1.CudaEBPFPolicySpec) {
+	*dst = v1beta1.CudaEBPFPolicySpec{
+		LibPath: in.LibPath,
+		Functions: convertSlice(in.Functions, func(fn Function) v1beta1.Function {
+			return v1beta1.Function{
+				Name: fn.Name,
+				Kind: v1beta1.ProbeKind(fn.Kind),
+				Args: convertSlice(fn.Args, func(arg Arg) v1beta1.Arg {
+					return v1beta1.Arg(arg)
+				}),
+				Returns: v1beta1.ReturnClass(fn.Returns),
+				Stack:   (*v1beta1.StackCapture)(fn.Stack),
+				Trace:   fn.Trace,
+			}
+		}),
+		Probes: convertSlice(in.Probes, func(probe string) v1beta1.DriverProbe {
+			return v1beta1.DriverProbe(probe)
+		}),
+		Mode:         v1beta1.TracingMode(in.Mode),
+		ProcessRegex: in.ProcessRegex,
+		PodSelector:  in.PodSelector,
+		Output: v1beta1.OutputSpec{
+			Format: v1beta1.OutputFormat(in.OutputFormat),
+			OTLP:   (*v1beta1.OTLPExport)(in.OTLP),
+			Sinks: convertSlice(in.Sinks, func(sink OutputSink) v1beta1.OutputSink {
+				return v1beta1.OutputSink{
+					Name:         sink.Name,
+					File:         (*v1beta1.FileSink)(sink.File),
+					HTTP:         (*v1beta1.HTTPSink)(sink.HTTP),
+					Kafka:        (*v1beta1.KafkaSink)(sink.Kafka),
+					Batching:     (*v1beta1.SinkBatching)(sink.Batching),
+					Retry:        (*v1beta1.SinkRetry)(sink.Retry),
+					Backpressure: v1beta1.Backpressure(sink.Backpressure),
+					QueueSize:    sink.QueueSize,
+				}
+			}),
+		},
+		Image:           in.Image,
+		ImageSignature:  in.ImageSignature,
+		ImagePullPolicy: in.ImagePullPolicy,
+		Schedule:        (*v1beta1.TracingSchedule)(in.Schedule),
+		PodTemplate:     (*v1beta1.AgentPodTemplate)(in.PodTemplate),
+		Security:        (*v1beta1.AgentSecurity)(in.Security),
+	}
+	if b := in.Buffering; b != nil {
+		dst.Output.Buffering = &v1beta1.EventBuffering{
+			QueueSize: b.QueueSize,
+			Policy:    v1beta1.QueuePolicy(b.Policy),
+			Spill:     (*v1beta1.BufferSpill)(b.Spill),
+		}
+	}
+}
+
+// convertSpecFromHub is the inverse of convertSpecToHub.
+func convertSpecFromHub(in *v1beta1.CudaEBPFPolicySpec, dst *CudaEBPFPolicySpec) {
+	*dst = CudaEBPFPolicySpec{
+		LibPath: in.LibPath,
+		Functions: convertSlice(in.Functions, func(fn v1beta1.Function) Function {
+			return Function{
+				Name: fn.Name,
+				Kind: string(fn.Kind),
+				Args: convertSlice(fn.Args, func(arg v1beta1.Arg) Arg {
+					return Arg(arg)
+				}),
+				Returns: string(fn.Returns),
+				Stack:   (*StackCapture)(fn.Stack),
+				Trace:   fn.Trace,
+			}
+		}),
+		Probes: convertSlice(in.Probes, func(probe v1beta1.DriverProbe) string {
+			return string(probe)
+		}),
+		Mode:         string(in.Mode),
+		ProcessRegex: in.ProcessRegex,
+		PodSelector:  in.PodSelector,
+		OutputFormat: string(in.Output.Format),
+		OTLP:         (*OTLPExport)(in.Output.OTLP),
+		Sinks: convertSlice(in.Output.Sinks, func(sink v1beta1.OutputSink) OutputSink {
+			return OutputSink{
+				Name:         sink.Name,
+				File:         (*FileSink)(sink.File),
+				HTTP:         (*HTTPSink)(sink.HTTP),
+				Kafka:        (*KafkaSink)(sink.Kafka),
+				Batching:     (*SinkBatching)(sink.Batching),
+				Retry:        (*SinkRetry)(sink.Retry),
+				Backpressure: string(sink.Backpressure),
+				QueueSize:    sink.QueueSize,
+			}
+		}),
+		Image:           in.Image,
+		ImageSignature:  in.ImageSignature,
+		ImagePullPolicy: in.ImagePullPolicy,
+		Schedule:        (*TracingSchedule)(in.Schedule),
+		PodTemplate:     (*AgentPodTemplate)(in.PodTemplate),
+		Security:        (*AgentSecurity)(in.Security),
+	}
+	if b := in.Output.Buffering; b != nil {
+		dst.Buffering = &EventBuffering{
+			QueueSize: b.QueueSize,
+			Policy:    string(b.Policy),
+			Spill:     (*BufferSpill)(b.Spill),
+		}
+	}
+}
+
+// convertStatusToHub converts a status to v1beta1, taking ownership of in.
+func convertStatusToHub(in *CudaEBPFPolicyStatus, dst *v1beta1.CudaEBPFPolicyStatus) {
+	*dst = v1beta1.CudaEBPFPolicyStatus{
+		ObservedHash:      in.ObservedHash,
+		Phase:             v1beta1.PolicyPhase(in.Phase),
+		Conditions:        in.Conditions,
+		NextSessionTime:   in.NextSessionTime,
+		LastSession:       (*v1beta1.SessionRecord)(in.LastSession),
+		LastFailure:       (*v1beta1.AgentFailure)(in.LastFailure),
+		ObservedProbes:    in.ObservedProbes,
+		ScriptHash:        in.ScriptHash,
+		ScriptConfigMap:   in.ScriptConfigMap,
+		Preview:           (*v1beta1.ScriptPreview)(in.Preview),
+		RolloutInProgress: in.RolloutInProgress,
+	}
+}
+
+// convertStatusFromHub is the inverse of convertStatusToHub.
+func convertStatusFromHub(in *v1beta1.CudaEBPFPolicyStatus, dst *CudaEBPFPolicyStatus) {
+	*dst = CudaEBPFPolicyStatus{
+		ObservedHash:      in.ObservedHash,
+		Phase:             string(in.Phase),
+		Conditions:        in.Conditions,
+		NextSessionTime:   in.NextSessionTime,
+		LastSession:       (*SessionRecord)(in.LastSession),
+		LastFailure:       (*AgentFailure)(in.LastFailure),
+		ObservedProbes:    in.ObservedProbes,
+		ScriptHash:        in.ScriptHash,
+		ScriptConfigMap:   in.ScriptConfigMap,
+		Preview:           (*ScriptPreview)(in.Preview),
+		RolloutInProgress: in.RolloutInProgress,
+	}
+}
+
+// convertSlice converts every element of in, keeping nil slices nil.
+func convertSlice[S, D any](in []S, convert func(S) D) []D {
+	if in == nil {
+		return nil
+	}
+	out := make([]D, len(in))
+	for i := range in {
+		out[i] = convert(in[i])
+	}
+	return out
+}
@@ -17,32 +17,288 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
+// ChangedByAnnotation holds the user who last changed the spec of a policy.
+// It is set by the policy webhooks and read into the audit trail of the
+// controller.
+const ChangedByAnnotation = "gpu.obs.gpu/changed-by"
+
+// AcknowledgeChangeAnnotation acknowledges a disruptive spec change, such as
+// a new mode or libPath or a change while agents are rolling out. Only the
+// update setting it to a new value, e.g. a change ticket, is acknowledged.
+const AcknowledgeChangeAnnotation = "gpu.obs.gpu/acknowledge-change"
+
+// DryRunAnnotation set to "true" keeps a policy from deploying agents. The
+// controller renders its bpftrace program into status.preview instead.
+const DryRunAnnotation = "gpu.obs.gpu/dry-run"
+
+// ConditionReady is true while the agents of a policy run its latest spec.
+const ConditionReady = "Ready"
+
 // CudaEBPFPolicySpec defines the desired state of CudaEBPFPolicy.
 type CudaEBPFPolicySpec struct {
-	LibPath      string     `json:"libPath"`
+	// LibPath is the library uprobe and uretprobe functions attach to.
+	// Policies tracing kernel functions only may leave it empty.
+	LibPath      string     `json:"libPath,omitempty"`
 	Functions    []Function `json:"functions"`
 	Probes       []string   `json:"probes"`
 	Mode         string     `json:"mode"` // "pidwatch" | "systemwide"
 	ProcessRegex string     `json:"processRegex,omitempty"`
-	OutputFormat string     `json:"output,omitempty"` // "ndjson" | "prometheus"
-	Image        string     `json:"image"`
+	// PodSelector limits tracing to the pods of the policy's namespace with
+	// these labels. Policies that are not systemwide only ever see processes
+	// of pods in their own namespace.
+	PodSelector  *metav1.LabelSelector `json:"podSelector,omitempty"`
+	OutputFormat string                `json:"output,omitempty"` // "ndjson" | "prometheus"
+	// Image is the agent image. It defaults to the image configured on the
+	// operator.
+	Image string `json:"image,omitempty"`
+	// ImageSignature is a base64 signature of the image digest, required
+	// when the operator verifies agent images against signing keys.
+	ImageSignature string `json:"imageSignature,omitempty"`
+	// ImagePullPolicy of the agent container, defaulted by the operator.
+	ImagePullPolicy corev1.PullPolicy `json:"imagePullPolicy,omitempty"`
+	// Schedule limits tracing to time-boxed sessions. Without it the policy
+	// traces for as long as it exists.
+	Schedule *TracingSchedule `json:"schedule,omitempty"`
+	// OTLP exports events, spans and metrics to an OpenTelemetry collector.
+	OTLP *OTLPExport `json:"otlp,omitempty"`
+	// Sinks ship events to external systems. Every event is delivered to
+	// all sinks independently of each other.
+	Sinks []OutputSink `json:"sinks,omitempty"`
+	// Buffering bounds the queue between bpftrace output and event
+	// processing, so that slow sinks cannot stall bpftrace.
+	Buffering *EventBuffering `json:"buffering,omitempty"`
+	// PodTemplate is merged onto the pod template of the agent DaemonSet.
+	PodTemplate *AgentPodTemplate `json:"podTemplate,omitempty"`
+	// Security tunes the security profile of the agent container. The
+	// capabilities and host paths are derived from the probes of the policy.
+	Security *AgentSecurity `json:"security,omitempty"`
+}
+
+// AgentSecurity configures how the agent container is locked down.
+type AgentSecurity struct {
+	// LegacyKernel grants SYS_ADMIN and SYS_RESOURCE and mounts the kernel
+	// headers for nodes older than Linux 5.8, which lack CAP_BPF and
+	// CAP_PERFMON and may lack BTF.
+	LegacyKernel bool `json:"legacyKernel,omitempty"`
+	// SeccompProfile is applied to the agent container.
+	SeccompProfile *corev1.SeccompProfile `json:"seccompProfile,omitempty"`
+	// AppArmorProfile is applied to the agent container.
+	AppArmorProfile *corev1.AppArmorProfile `json:"appArmorProfile,omitempty"`
+	// ReadOnlyRootFilesystem defaults to true, the agent writes to an
+	// emptyDir mounted at /tmp.
+	ReadOnlyRootFilesystem *bool `json:"readOnlyRootFilesystem,omitempty"`
+}
+
+// AgentPodTemplate overrides parts of the generated agent pods. Env vars,
+// tolerations, volumes, mounts and image pull secrets are added, and
+// everything else replaces the default. The env vars the controller
+// generates for the agent cannot be overridden.
+type AgentPodTemplate struct {
+	// Labels and Annotations are added to the agent pods. The "app" and
+	// "gpu.obs.gpu/policy" or "gpu.obs.gpu/cluster-policy" labels select the
+	// pods of the DaemonSet and cannot be overridden.
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	Resources          *corev1.ResourceRequirements  `json:"resources,omitempty"`
+	Tolerations        []corev1.Toleration           `json:"tolerations,omitempty"`
+	Affinity           *corev1.Affinity              `json:"affinity,omitempty"`
+	NodeSelector       map[string]string             `json:"nodeSelector,omitempty"`
+	PriorityClassName  string                        `json:"priorityClassName,omitempty"`
+	ServiceAccountName string                        `json:"serviceAccountName,omitempty"`
+	ImagePullSecrets   []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+
+	Env          []corev1.EnvVar      `json:"env,omitempty"`
+	Volumes      []corev1.Volume      `json:"volumes,omitempty"`
+	VolumeMounts []corev1.VolumeMount `json:"volumeMounts,omitempty"`
+}
+
+// EventBuffering configures the agent's event queue.
+type EventBuffering struct {
+	// QueueSize is how many output lines may wait to be processed.
+	QueueSize int `json:"queueSize,omitempty"`
+	// Policy decides what happens when the queue is full: "dropOldest" and
+	// "dropNewest" discard lines and count them, "block" stalls bpftrace.
+	Policy string `json:"policy,omitempty"` // "dropOldest" | "dropNewest" | "block"
+	// Spill overflows lines to a file on the node before dropping them.
+	Spill *BufferSpill `json:"spill,omitempty"`
+}
+
+// BufferSpill is an on-disk overflow area for the event queue.
+type BufferSpill struct {
+	// Path is a host directory, mounted into the agent as a hostPath volume.
+	Path string `json:"path"`
+	// MaxSizeMB bounds the size of the spill file.
+	MaxSizeMB int `json:"maxSizeMB,omitempty"`
+}
+
+// OutputSink is a destination for the events of the agents. Exactly one of
+// File, HTTP and Kafka must be set.
+type OutputSink struct {
+	// Name identifies the sink in metrics and volume names.
+	Name  string     `json:"name"`
+	File  *FileSink  `json:"file,omitempty"`
+	HTTP  *HTTPSink  `json:"http,omitempty"`
+	Kafka *KafkaSink `json:"kafka,omitempty"`
+
+	Batching *SinkBatching `json:"batching,omitempty"`
+	Retry    *SinkRetry    `json:"retry,omitempty"`
+	// Backpressure decides what happens when the sink's queue is full:
+	// "drop" discards new events, "block" slows down event processing.
+	Backpressure string `json:"backpressure,omitempty"` // "drop" | "block"
+	// QueueSize is how many events may wait for delivery.
+	QueueSize int `json:"queueSize,omitempty"`
+}
+
+// FileSink writes NDJSON files to a directory on the node.
+type FileSink struct {
+	// Path is a host directory, mounted into the agent as a hostPath volume.
+	Path string `json:"path"`
+	// MaxSizeMB is the size at which the current file is rotated.
+	MaxSizeMB int `json:"maxSizeMB,omitempty"`
+	// MaxFiles is how many rotated files are kept.
+	MaxFiles int `json:"maxFiles,omitempty"`
+}
+
+// HTTPSink POSTs batches of events as NDJSON.
+type HTTPSink struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// KafkaSink produces events to a Kafka topic, one record per event.
+type KafkaSink struct {
+	Brokers []string `json:"brokers"`
+	Topic   string   `json:"topic"`
+}
+
+// SinkBatching bounds how many events are delivered at once.
+type SinkBatching struct {
+	MaxEvents int              `json:"maxEvents,omitempty"`
+	MaxWait   *metav1.Duration `json:"maxWait,omitempty"`
+}
+
+// SinkRetry configures redelivery of failed batches.
+type SinkRetry struct {
+	MaxAttempts int              `json:"maxAttempts,omitempty"`
+	Backoff     *metav1.Duration `json:"backoff,omitempty"`
+}
+
+// OTLPExport configures the OTLP/HTTP export of the agents.
+type OTLPExport struct {
+	// Endpoint is the base URL of the collector's OTLP/HTTP receiver, e.g.
+	// http://otel-collector.observability:4318
+	Endpoint string `json:"endpoint"`
+	// MetricInterval is how often metrics are exported. Defaults to 60s.
+	MetricInterval *metav1.Duration `json:"metricInterval,omitempty"`
+}
+
+// TracingSchedule bounds when the agents of a policy run.
+type TracingSchedule struct {
+	// StartTime delays the first session until the given time.
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// Duration is how long each session runs. Without a cron expression the
+	// policy runs a single session.
+	Duration *metav1.Duration `json:"duration,omitempty"`
+	// Cron starts a session at every match of a 5-field cron expression (UTC).
+	Cron string `json:"cron,omitempty"`
 }
 
 // CudaEBPFPolicyStatus defines the observed state of CudaEBPFPolicy.
 type CudaEBPFPolicyStatus struct {
 	ObservedHash string `json:"observedHash,omitempty"`
+	Phase        string `json:"phase,omitempty"` // "Active" | "Scheduled" | "Completed" | "Terminating" | "DryRun"
+	// Conditions describe the state of the policy. Ready is true while the
+	// agents run the latest spec.
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// NextSessionTime is when the next scheduled session starts.
+	NextSessionTime *metav1.Time `json:"nextSessionTime,omitempty"`
+	// LastSession records the most recent tracing session of a scheduled policy.
+	LastSession *SessionRecord `json:"lastSession,omitempty"`
+	// LastFailure is the most recent unexpected bpftrace exit reported by
+	// any of the agents.
+	LastFailure *AgentFailure `json:"lastFailure,omitempty"`
+	// ObservedProbes are the probes of the last reconciled spec, as
+	// "<kind>:<function>" or raw probe definitions. Changes to them are
+	// audited.
+	ObservedProbes []string `json:"observedProbes,omitempty"`
+	// ScriptHash is the content hash of the bpftrace program the agents run.
+	ScriptHash string `json:"scriptHash,omitempty"`
+	// ScriptConfigMap is the ConfigMap holding the bpftrace program, in the
+	// namespace of the agents.
+	ScriptConfigMap string `json:"scriptConfigMap,omitempty"`
+	// Preview is the bpftrace program of a dry-run policy.
+	Preview *ScriptPreview `json:"preview,omitempty"`
+	// RolloutInProgress is set while the agents are rolling out the latest
+	// spec. Spec changes are rejected until it clears.
+	RolloutInProgress bool `json:"rolloutInProgress,omitempty"`
+}
+
+// ScriptPreview is the bpftrace program a policy renders to, produced by
+// the same pipeline as the program the agents run.
+type ScriptPreview struct {
+	// Script is the rendered bpftrace program.
+	Script string `json:"script"`
+	// Hash is the content hash of the program.
+	Hash string `json:"hash"`
+	// Warnings are problems found while rendering the program.
+	Warnings []string `json:"warnings,omitempty"`
+	// GeneratedTime is when the program last changed.
+	GeneratedTime metav1.Time `json:"generatedTime"`
+}
+
+// AgentFailure describes an unexpected bpftrace exit on a node.
+type AgentFailure struct {
+	Node     string      `json:"node"`
+	Time     metav1.Time `json:"time"`
+	Reason   string      `json:"reason"`
+	ExitCode int32       `json:"exitCode,omitempty"`
+	// Restarts is how often the agent restarted bpftrace before this failure.
+	Restarts int32 `json:"restarts,omitempty"`
+	// Bundle names the diagnostic bundle the agent serves on localhost:9091/debug/diagnostics.
+	Bundle string `json:"bundle,omitempty"`
+}
+
+// SessionRecord describes a single tracing session.
+type SessionRecord struct {
+	StartTime metav1.Time  `json:"startTime"`
+	EndTime   *metav1.Time `json:"endTime,omitempty"`
+	Result    string       `json:"result"` // "Running" | "Completed"
+	// NodesTraced is the number of agents that were ready when the session ended.
+	NodesTraced int32 `json:"nodesTraced,omitempty"`
 }
 
 type Function struct {
 	Name string `json:"name"`
 	Kind string `json:"kind"`
-	Args []Arg  `json:"args,omitempty"`
+	// Args are logged in a CALL event when the function is entered.
+	// uretprobe and kretprobe functions get an extra entry probe for them.
+	Args []Arg `json:"args,omitempty"`
+	// Returns selects how the return value of a uretprobe/kretprobe is
+	// classified into error events and per-code counters.
+	Returns string `json:"returns,omitempty"` // "errno" | "cudaError" | "pointer"
+	// Stack captures kernel and/or user stacks each time the function is hit.
+	Stack *StackCapture `json:"stack,omitempty"`
+	// Trace pairs every call with its return and exports it as an OTLP span.
+	Trace bool `json:"trace,omitempty"`
+}
+
+// StackCapture selects the stacks recorded on each hit of a traced function.
+// Stacks are symbolized by bpftrace and aggregated by the agent.
+type StackCapture struct {
+	Kernel bool `json:"kernel,omitempty"`
+	User   bool `json:"user,omitempty"`
+	// TopN is the number of hottest stacks the agent reports per function.
+	TopN int `json:"topN,omitempty"`
 }
 type Arg struct {
 	Index int    `json:"index"`
@@ -51,6 +307,7 @@ type Arg struct {
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:storageversion
 
 // CudaEBPFPolicy is the Schema for the cudaebpfpolicies API.
 type CudaEBPFPolicy struct {
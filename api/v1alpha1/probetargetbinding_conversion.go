@@ -0,0 +1,88 @@
+/*
+Copyright 2025 WoodProgrammer.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"encoding/json"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"github.com/WoodProgrammer/gpu-bpf-operator/api/v1beta1"
+)
+
+// NodeSelectorExpressionsAnnotation keeps the matchExpressions of a v1beta1
+// node selector, which v1alpha1 cannot express, across conversions.
+const NodeSelectorExpressionsAnnotation = "gpu.obs.gpu/node-selector-expressions"
+
+// ConvertTo converts this ProbeTargetBinding to the Hub version (v1beta1).
+func (src *ProbeTargetBinding) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1beta1.ProbeTargetBinding)
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+	in := src.Spec.DeepCopy()
+	dst.Spec = v1beta1.ProbeTargetBindingSpec{
+		PolicyRef:      in.PolicyRef,
+		CanaryPercent:  in.CanaryPercent,
+		MaxUnavailable: in.MaxUnavailable,
+	}
+	if in.NodeSelector != nil {
+		dst.Spec.NodeSelector = &metav1.LabelSelector{MatchLabels: in.NodeSelector}
+	}
+	if expressions, ok := dst.Annotations[NodeSelectorExpressionsAnnotation]; ok {
+		if dst.Spec.NodeSelector == nil {
+			dst.Spec.NodeSelector = &metav1.LabelSelector{}
+		}
+		if err := json.Unmarshal([]byte(expressions), &dst.Spec.NodeSelector.MatchExpressions); err != nil {
+			return fmt.Errorf("invalid %s annotation: %w", NodeSelectorExpressionsAnnotation, err)
+		}
+		delete(dst.Annotations, NodeSelectorExpressionsAnnotation)
+	}
+	dst.Status = v1beta1.ProbeTargetBindingStatus(src.Status)
+	return nil
+}
+
+// ConvertFrom converts from the Hub version (v1beta1) to this version.
+func (dst *ProbeTargetBinding) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1beta1.ProbeTargetBinding)
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+	in := src.Spec.DeepCopy()
+	dst.Spec = ProbeTargetBindingSpec{
+		PolicyRef:      in.PolicyRef,
+		CanaryPercent:  in.CanaryPercent,
+		MaxUnavailable: in.MaxUnavailable,
+	}
+	delete(dst.Annotations, NodeSelectorExpressionsAnnotation)
+	if selector := in.NodeSelector; selector != nil {
+		dst.Spec.NodeSelector = selector.MatchLabels
+		if dst.Spec.NodeSelector == nil {
+			dst.Spec.NodeSelector = map[string]string{}
+		}
+		if len(selector.MatchExpressions) > 0 {
+			expressions, err := json.Marshal(selector.MatchExpressions)
+			if err != nil {
+				return err
+			}
+			if dst.Annotations == nil {
+				dst.Annotations = map[string]string{}
+			}
+			dst.Annotations[NodeSelectorExpressionsAnnotation] = string(expressions)
+		}
+	}
+	dst.Status = ProbeTargetBindingStatus(src.Status)
+	return nil
+}
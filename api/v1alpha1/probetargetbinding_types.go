@@ -25,10 +25,17 @@ import (
 
 // ProbeTargetBindingSpec defines the desired state of ProbeTargetBinding.
 type ProbeTargetBindingSpec struct {
-	PolicyRef      string            `json:"policyRef"`
-	NodeSelector   map[string]string `json:"nodeSelector,omitempty"`
-	CanaryPercent  int               `json:"canaryPercent,omitempty"`
-	MaxUnavailable int               `json:"maxUnavailable,omitempty"`
+	// PolicyRef names the CudaEBPFPolicy in the binding's namespace.
+	PolicyRef string `json:"policyRef"`
+	// NodeSelector selects the nodes the policy is rolled out to. Bindings
+	// of the same policy must not select overlapping nodes.
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// CanaryPercent is the share of the selected nodes, 0 to 100, that run
+	// the policy first. Defaults to 100.
+	CanaryPercent int `json:"canaryPercent,omitempty"`
+	// MaxUnavailable is the number of nodes whose agents are replaced at a
+	// time. Defaults to 1.
+	MaxUnavailable int `json:"maxUnavailable,omitempty"`
 }
 type ProbeTargetBindingStatus struct {
 	AppliedHash string `json:"appliedHash,omitempty"`
@@ -36,6 +43,7 @@ type ProbeTargetBindingStatus struct {
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:storageversion
 
 // ProbeTargetBinding is the Schema for the probetargetbindings API.
 type ProbeTargetBinding struct {
@@ -0,0 +1,105 @@
+/*
+Copyright 2025 WoodProgrammer.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TraceCaptureSpec defines the desired state of TraceCapture.
+type TraceCaptureSpec struct {
+	// PolicyRef is the name of the CudaEBPFPolicy whose agents are captured.
+	PolicyRef string `json:"policyRef"`
+	// NodeSelector limits the capture to agents on matching nodes.
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// PodSelector limits the captured events to processes of matching pods
+	// in the namespace of the capture.
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+	// Duration is how long events are collected for.
+	Duration metav1.Duration `json:"duration"`
+	// Storage is where the capture archive is written.
+	Storage CaptureStorage `json:"storage"`
+}
+
+// CaptureStorage selects where a capture archive is stored. Exactly one of
+// the fields must be set.
+type CaptureStorage struct {
+	PersistentVolumeClaim *PVCCaptureStorage `json:"persistentVolumeClaim,omitempty"`
+	S3                    *S3CaptureStorage  `json:"s3,omitempty"`
+}
+
+// PVCCaptureStorage writes the archive to a PersistentVolumeClaim.
+type PVCCaptureStorage struct {
+	ClaimName string `json:"claimName"`
+	// Path is the directory within the volume the archive is written to.
+	Path string `json:"path,omitempty"`
+}
+
+// S3CaptureStorage uploads the archive to an S3 compatible object store.
+type S3CaptureStorage struct {
+	// Endpoint is the host[:port] of the object store, e.g. minio.storage:9000.
+	Endpoint string `json:"endpoint"`
+	Bucket   string `json:"bucket"`
+	Prefix   string `json:"prefix,omitempty"`
+	Region   string `json:"region,omitempty"`
+	// Insecure uses plain HTTP instead of HTTPS.
+	Insecure bool `json:"insecure,omitempty"`
+	// CredentialsSecret holds the accessKeyID and secretAccessKey keys.
+	CredentialsSecret corev1.LocalObjectReference `json:"credentialsSecret"`
+}
+
+// TraceCaptureStatus defines the observed state of TraceCapture.
+type TraceCaptureStatus struct {
+	Phase   string `json:"phase,omitempty"` // "Pending" | "Running" | "Succeeded" | "Failed"
+	Message string `json:"message,omitempty"`
+	// Location is where the capture archive is stored, e.g.
+	// pvc://traces/captures/my-capture.tar.gz or s3://traces/my-capture.tar.gz
+	Location       string       `json:"location,omitempty"`
+	StartTime      *metav1.Time `json:"startTime,omitempty"`
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// NodesCaptured is the number of agents events are collected from.
+	NodesCaptured int32 `json:"nodesCaptured,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Policy",type=string,JSONPath=`.spec.policyRef`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Location",type=string,JSONPath=`.status.location`
+
+// TraceCapture is the Schema for the tracecaptures API.
+type TraceCapture struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TraceCaptureSpec   `json:"spec,omitempty"`
+	Status TraceCaptureStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// TraceCaptureList contains a list of TraceCapture.
+type TraceCaptureList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []TraceCapture `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&TraceCapture{}, &TraceCaptureList{})
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2025 WoodProgrammer.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="Mode",type=string,JSONPath=`.spec.mode`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+
+// ClusterCudaEBPFPolicy is the Schema for the clustercudaebpfpolicies API.
+// It traces like a CudaEBPFPolicy, but is not owned by any namespace: its
+// agents run in the operator's agent namespace and see every process on the
+// node. spec.podSelector is not supported.
+type ClusterCudaEBPFPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   CudaEBPFPolicySpec   `json:"spec,omitempty"`
+	Status CudaEBPFPolicyStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ClusterCudaEBPFPolicyList contains a list of ClusterCudaEBPFPolicy.
+type ClusterCudaEBPFPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterCudaEBPFPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterCudaEBPFPolicy{}, &ClusterCudaEBPFPolicyList{})
+}
@@ -19,13 +19,17 @@ package main
 import (
 	"crypto/tls"
 	"flag"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
@@ -38,7 +42,10 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	gpuv1alpha1 "github.com/WoodProgrammer/gpu-bpf-operator/api/v1alpha1"
+	gpuv1beta1 "github.com/WoodProgrammer/gpu-bpf-operator/api/v1beta1"
+	"github.com/WoodProgrammer/gpu-bpf-operator/internal/config"
 	"github.com/WoodProgrammer/gpu-bpf-operator/internal/controller"
+	"github.com/WoodProgrammer/gpu-bpf-operator/internal/imagepolicy"
 	webhookv1alpha1 "github.com/WoodProgrammer/gpu-bpf-operator/internal/webhook/v1alpha1"
 	// +kubebuilder:scaffold:imports
 )
@@ -52,6 +59,7 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(gpuv1alpha1.AddToScheme(scheme))
+	utilruntime.Must(gpuv1beta1.AddToScheme(scheme))
 	// +kubebuilder:scaffold:scheme
 }
 
@@ -64,6 +72,10 @@ func main() {
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var agentDefaultsPath, agentImage, agentImagePullPolicy, allowedImageRegistries string
+	var allowedImageRepositories, imageSigningKeys string
+	var requireImageDigest bool
+	var agentNamespace string
 	var tlsOpts []func(*tls.Config)
 	flag.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metrics endpoint binds to. "+
 		"Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
@@ -82,6 +94,21 @@ func main() {
 	flag.StringVar(&metricsCertKey, "metrics-cert-key", "tls.key", "The name of the metrics server key file.")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.StringVar(&agentDefaultsPath, "agent-defaults", "",
+		"A YAML file with the default agent image, pull policy, resources and tolerations and the allowed image registries.")
+	flag.StringVar(&agentImage, "agent-image", "", "The agent image of policies that do not set one.")
+	flag.StringVar(&agentImagePullPolicy, "agent-image-pull-policy", "",
+		"The pull policy of agent images for policies that do not set one.")
+	flag.StringVar(&allowedImageRegistries, "allowed-image-registries", "",
+		"Comma-separated registries agent images may be pulled from. Any registry is allowed if empty.")
+	flag.StringVar(&allowedImageRepositories, "allowed-image-repositories", "",
+		"Comma-separated registries or repositories agent images may come from. Any repository is allowed if empty.")
+	flag.BoolVar(&requireImageDigest, "require-image-digest", false,
+		"If set, agent images must be pinned by digest instead of a tag.")
+	flag.StringVar(&imageSigningKeys, "image-signing-keys", "",
+		"Comma-separated PEM files with the ECDSA or Ed25519 public keys agent images must be signed with.")
+	flag.StringVar(&agentNamespace, "agent-namespace", os.Getenv("POD_NAMESPACE"),
+		"The namespace the agents of cluster policies run in. Defaults to the operator's namespace.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -90,6 +117,42 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	// Flags take precedence over the defaults file
+	agentDefaults := &config.AgentDefaults{}
+	if agentDefaultsPath != "" {
+		var err error
+		if agentDefaults, err = config.LoadAgentDefaults(agentDefaultsPath); err != nil {
+			setupLog.Error(err, "unable to load agent defaults")
+			os.Exit(1)
+		}
+	}
+	if agentImage != "" {
+		agentDefaults.Image = agentImage
+	}
+	if agentImagePullPolicy != "" {
+		agentDefaults.ImagePullPolicy = corev1.PullPolicy(agentImagePullPolicy)
+	}
+	if allowedImageRegistries != "" {
+		agentDefaults.AllowedRegistries = strings.Split(allowedImageRegistries, ",")
+	}
+
+	imagePolicy := &imagepolicy.Policy{RequireDigest: requireImageDigest}
+	if allowedImageRepositories != "" {
+		imagePolicy.AllowedRepositories = strings.Split(allowedImageRepositories, ",")
+	}
+	if imageSigningKeys != "" {
+		keys, err := imagepolicy.LoadPublicKeys(strings.Split(imageSigningKeys, ","))
+		if err != nil {
+			setupLog.Error(err, "unable to load image signing keys")
+			os.Exit(1)
+		}
+		if len(keys) == 0 {
+			setupLog.Error(nil, "no public keys found", "files", imageSigningKeys)
+			os.Exit(1)
+		}
+		imagePolicy.PublicKeys = keys
+	}
+
 	// if the enable-http2 flag is false (the default), http/2 should be disabled
 	// due to its vulnerabilities. More specifically, disabling http/2 will
 	// prevent from being vulnerable to the HTTP/2 Stream Cancellation and
@@ -203,26 +266,62 @@ func main() {
 		os.Exit(1)
 	}
 
+	agentStatus := &controller.HTTPAgentStatusReader{Client: &http.Client{Timeout: 5 * time.Second}}
+	operatorNamespace := os.Getenv("POD_NAMESPACE")
 	if err := (&controller.CudaEBPFPolicyReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:            mgr.GetClient(),
+		Scheme:            mgr.GetScheme(),
+		AgentStatus:       agentStatus,
+		Recorder:          mgr.GetEventRecorderFor("cudaebpfpolicy-controller"),
+		OperatorNamespace: operatorNamespace,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "CudaEBPFPolicy")
 		os.Exit(1)
 	}
+	if agentNamespace == "" {
+		setupLog.Error(nil, "the agent namespace is required, set --agent-namespace or POD_NAMESPACE")
+		os.Exit(1)
+	}
+	if err := (&controller.ClusterCudaEBPFPolicyReconciler{
+		Client:            mgr.GetClient(),
+		Scheme:            mgr.GetScheme(),
+		AgentNamespace:    agentNamespace,
+		OperatorNamespace: operatorNamespace,
+		AgentStatus:       agentStatus,
+		Recorder:          mgr.GetEventRecorderFor("clustercudaebpfpolicy-controller"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ClusterCudaEBPFPolicy")
+		os.Exit(1)
+	}
 	if err := (&controller.ProbeTargetBindingReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("probetargetbinding-controller"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ProbeTargetBinding")
+		os.Exit(1)
+	}
+	if err := (&controller.TraceCaptureReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "ProbeTargetBinding")
+		setupLog.Error(err, "unable to create controller", "controller", "TraceCapture")
 		os.Exit(1)
 	}
 	// nolint:goconst
 	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
-		if err := webhookv1alpha1.SetupCudaEBPFPolicyWebhookWithManager(mgr); err != nil {
+		if err := webhookv1alpha1.SetupCudaEBPFPolicyWebhookWithManager(mgr, agentDefaults, imagePolicy); err != nil {
 			setupLog.Error(err, "unable to create webhook", "webhook", "CudaEBPFPolicy")
 			os.Exit(1)
 		}
+		if err := webhookv1alpha1.SetupClusterCudaEBPFPolicyWebhookWithManager(mgr, agentDefaults, imagePolicy); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "ClusterCudaEBPFPolicy")
+			os.Exit(1)
+		}
+		if err := webhookv1alpha1.SetupProbeTargetBindingWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "ProbeTargetBinding")
+			os.Exit(1)
+		}
 	}
 	// +kubebuilder:scaffold:builder
 
@@ -0,0 +1,79 @@
+/*
+Copyright 2025 WoodProgrammer.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// AgentDefaults are operator-wide settings for the agents of all policies.
+// Policies inherit them for every field they leave empty.
+type AgentDefaults struct {
+	// Image is the agent image of policies without spec.image.
+	Image string `json:"image,omitempty"`
+	// ImagePullPolicy is used for policies without spec.imagePullPolicy.
+	ImagePullPolicy corev1.PullPolicy `json:"imagePullPolicy,omitempty"`
+	// Resources and Tolerations are set on agent pods whose policy does
+	// not override them in spec.podTemplate.
+	Resources   *corev1.ResourceRequirements `json:"resources,omitempty"`
+	Tolerations []corev1.Toleration          `json:"tolerations,omitempty"`
+	// AllowedRegistries restricts agent images to these registries. Any
+	// registry is allowed when it is empty.
+	AllowedRegistries []string `json:"allowedRegistries,omitempty"`
+}
+
+// LoadAgentDefaults reads agent defaults from a YAML or JSON file.
+func LoadAgentDefaults(path string) (*AgentDefaults, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defaults := &AgentDefaults{}
+	if err := yaml.UnmarshalStrict(data, defaults); err != nil {
+		return nil, fmt.Errorf("parsing agent defaults %s: %w", path, err)
+	}
+	return defaults, nil
+}
+
+// RegistryAllowed reports whether the registry of an image is allowed.
+func (d *AgentDefaults) RegistryAllowed(image string) bool {
+	if d == nil || len(d.AllowedRegistries) == 0 {
+		return true
+	}
+	registry := ImageRegistry(image)
+	for _, allowed := range d.AllowedRegistries {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), registry) {
+			return true
+		}
+	}
+	return false
+}
+
+// ImageRegistry returns the registry host of an image reference, following
+// the Docker convention that images without a registry come from docker.io.
+func ImageRegistry(image string) string {
+	first, _, found := strings.Cut(image, "/")
+	if !found || (!strings.ContainsAny(first, ".:") && first != "localhost") {
+		return "docker.io"
+	}
+	return strings.ToLower(first)
+}
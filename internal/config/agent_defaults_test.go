@@ -0,0 +1,79 @@
+/*
+Copyright 2025 WoodProgrammer.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var _ = Describe("Agent defaults", func() {
+	It("Should load defaults from a YAML file", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "agent.yaml")
+		Expect(os.WriteFile(path, []byte(`
+image: registry.example.com/gpu-bpf/agent:v1.2.0
+imagePullPolicy: IfNotPresent
+resources:
+  limits:
+    memory: 512Mi
+tolerations:
+- key: nvidia.com/gpu
+  operator: Exists
+  effect: NoSchedule
+allowedRegistries:
+- registry.example.com
+`), 0o644)).To(Succeed())
+
+		defaults, err := LoadAgentDefaults(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(defaults.Image).To(Equal("registry.example.com/gpu-bpf/agent:v1.2.0"))
+		Expect(defaults.ImagePullPolicy).To(Equal(corev1.PullIfNotPresent))
+		Expect(defaults.Resources.Limits.Memory().String()).To(Equal("512Mi"))
+		Expect(defaults.Tolerations).To(HaveLen(1))
+		Expect(defaults.AllowedRegistries).To(ConsistOf("registry.example.com"))
+	})
+
+	It("Should reject unknown fields", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "agent.yaml")
+		Expect(os.WriteFile(path, []byte("imag: agent:latest\n"), 0o644)).To(Succeed())
+
+		_, err := LoadAgentDefaults(path)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should find the registry of image references", func() {
+		Expect(ImageRegistry("busybox")).To(Equal("docker.io"))
+		Expect(ImageRegistry("library/busybox:1.36")).To(Equal("docker.io"))
+		Expect(ImageRegistry("ghcr.io/woodprogrammer/agent:v1")).To(Equal("ghcr.io"))
+		Expect(ImageRegistry("localhost:5000/agent")).To(Equal("localhost:5000"))
+		Expect(ImageRegistry("localhost/agent")).To(Equal("localhost"))
+	})
+
+	It("Should only allow images from the allowed registries", func() {
+		defaults := &AgentDefaults{AllowedRegistries: []string{"ghcr.io", "docker.io"}}
+		Expect(defaults.RegistryAllowed("ghcr.io/woodprogrammer/agent:v1")).To(BeTrue())
+		Expect(defaults.RegistryAllowed("busybox")).To(BeTrue())
+		Expect(defaults.RegistryAllowed("quay.io/woodprogrammer/agent:v1")).To(BeFalse())
+
+		var unset *AgentDefaults
+		Expect(unset.RegistryAllowed("quay.io/woodprogrammer/agent:v1")).To(BeTrue())
+	})
+})
@@ -0,0 +1,121 @@
+/*
+Copyright 2025 WoodProgrammer.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	gpuv1alpha1 "github.com/WoodProgrammer/gpu-bpf-operator/api/v1alpha1"
+)
+
+// agentStatusInterval is how often the agents of an active policy are asked
+// for bpftrace failures
+const agentStatusInterval = time.Minute
+
+// AgentStatusReader reads the state an agent pod reports on its HTTP server.
+type AgentStatusReader interface {
+	// LastFailure returns the last unexpected bpftrace exit of the agent, or
+	// nil if bpftrace has not failed since the agent started.
+	LastFailure(ctx context.Context, pod *corev1.Pod) (*gpuv1alpha1.AgentFailure, error)
+}
+
+// HTTPAgentStatusReader reads the /debug/failure endpoint of the agents.
+type HTTPAgentStatusReader struct {
+	Client *http.Client
+}
+
+func (h *HTTPAgentStatusReader) LastFailure(ctx context.Context, pod *corev1.Pod) (*gpuv1alpha1.AgentFailure, error) {
+	url := fmt.Sprintf("http://%s/debug/failure", net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(agentPort)))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+		return nil, nil
+	case http.StatusOK:
+	default:
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	failure := &gpuv1alpha1.AgentFailure{}
+	if err := json.NewDecoder(resp.Body).Decode(failure); err != nil {
+		return nil, fmt.Errorf("decoding failure of agent %s: %w", pod.Name, err)
+	}
+	return failure, nil
+}
+
+// runningAgents returns the running agent pods of a policy's DaemonSet.
+func runningAgents(ctx context.Context, c client.Client, policy *gpuv1alpha1.CudaEBPFPolicy) ([]corev1.Pod, error) {
+	pods, err := agentPods(ctx, c, policy)
+	if err != nil {
+		return nil, err
+	}
+	var agents []corev1.Pod
+	for _, pod := range pods {
+		if pod.Status.Phase != corev1.PodRunning || pod.Status.PodIP == "" {
+			continue
+		}
+		agents = append(agents, pod)
+	}
+	return agents, nil
+}
+
+// latestAgentFailure asks every running agent of the policy for its last
+// bpftrace failure and returns the most recent one. Agents that cannot be
+// reached are skipped.
+func (r *CudaEBPFPolicyReconciler) latestAgentFailure(ctx context.Context, policy *gpuv1alpha1.CudaEBPFPolicy) (*gpuv1alpha1.AgentFailure, error) {
+	log := logf.FromContext(ctx)
+
+	agents, err := runningAgents(ctx, r.Client, policy)
+	if err != nil {
+		return nil, err
+	}
+	var latest *gpuv1alpha1.AgentFailure
+	for i := range agents {
+		failure, err := r.AgentStatus.LastFailure(ctx, &agents[i])
+		if err != nil {
+			log.Info("Failed to read agent status", "pod", agents[i].Name, "error", err.Error())
+			continue
+		}
+		if failure == nil {
+			continue
+		}
+		if failure.Node == "" {
+			failure.Node = agents[i].Spec.NodeName
+		}
+		if latest == nil || failure.Time.After(latest.Time.Time) {
+			latest = failure
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2025 WoodProgrammer.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	gpuv1alpha1 "github.com/WoodProgrammer/gpu-bpf-operator/api/v1alpha1"
+)
+
+// policyProbes lists the probes of a spec for the audit trail: functions as
+// "<kind>:<name>" followed by the raw probe definitions.
+func policyProbes(spec *gpuv1alpha1.CudaEBPFPolicySpec) []string {
+	var probes []string
+	for _, fn := range spec.Functions {
+		probes = append(probes, fn.Kind+":"+fn.Name)
+	}
+	slices.Sort(probes)
+	return append(probes, spec.Probes...)
+}
+
+// changeAuthor returns who last changed the spec of obj and when. The user
+// recorded by the webhook is preferred over the field manager of the latest
+// spec update, which names a client rather than a person.
+func changeAuthor(obj client.Object) (string, time.Time) {
+	var manager string
+	var changedAt time.Time
+	for _, entry := range obj.GetManagedFields() {
+		if entry.Subresource != "" || entry.FieldsV1 == nil || entry.Time == nil {
+			continue
+		}
+		if !bytes.Contains(entry.FieldsV1.Raw, []byte(`"f:spec"`)) {
+			continue
+		}
+		if entry.Time.After(changedAt) {
+			manager, changedAt = entry.Manager, entry.Time.Time
+		}
+	}
+	if user := obj.GetAnnotations()[gpuv1alpha1.ChangedByAnnotation]; user != "" {
+		return user, changedAt
+	}
+	return manager, changedAt
+}
+
+// auditProbeChange writes a structured audit record of a change to the
+// probes of a policy and emits a matching event on owner. action is one of
+// "add", "update" or "delete".
+func (r *CudaEBPFPolicyReconciler) auditProbeChange(ctx context.Context, owner client.Object, action string, previous, current []string) {
+	var added, removed []string
+	for _, probe := range current {
+		if !slices.Contains(previous, probe) {
+			added = append(added, probe)
+		}
+	}
+	for _, probe := range previous {
+		if !slices.Contains(current, probe) {
+			removed = append(removed, probe)
+		}
+	}
+
+	user, changedAt := changeAuthor(owner)
+	if action == "delete" {
+		// Deletions are not recorded in managed fields
+		user, changedAt = "", owner.GetDeletionTimestamp().Time
+	}
+	if changedAt.IsZero() {
+		changedAt = time.Now()
+	}
+	logf.FromContext(ctx).WithName("audit").Info("Policy probes changed",
+		"action", action,
+		"policy", client.ObjectKeyFromObject(owner).String(),
+		"user", user,
+		"time", changedAt.UTC().Format(time.RFC3339),
+		"addedProbes", added,
+		"removedProbes", removed,
+	)
+
+	by := ""
+	if user != "" {
+		by = " by " + user
+	}
+	switch action {
+	case "add":
+		r.recordEvent(owner, corev1.EventTypeNormal, "Created", "Policy created%s with probes %s", by, strings.Join(current, ", "))
+	case "update":
+		r.recordEvent(owner, corev1.EventTypeNormal, "Updated", "Policy updated%s%s", by, probeChangeSummary(added, removed))
+	case "delete":
+		r.recordEvent(owner, corev1.EventTypeNormal, "Deleted", "Policy deleted, removing probes %s", strings.Join(previous, ", "))
+	}
+}
+
+// probeChangeSummary describes added and removed probes for an event message.
+func probeChangeSummary(added, removed []string) string {
+	var parts []string
+	if len(added) > 0 {
+		parts = append(parts, "added "+strings.Join(added, ", "))
+	}
+	if len(removed) > 0 {
+		parts = append(parts, "removed "+strings.Join(removed, ", "))
+	}
+	if len(parts) == 0 {
+		return ", probes unchanged"
+	}
+	return fmt.Sprintf(": %s", strings.Join(parts, "; "))
+}
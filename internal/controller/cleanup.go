@@ -0,0 +1,142 @@
+/*
+Copyright 2025 WoodProgrammer.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	gpuv1alpha1 "github.com/WoodProgrammer/gpu-bpf-operator/api/v1alpha1"
+)
+
+const (
+	// cleanupTimeout bounds how long a deleted policy waits for its agents
+	// to detach their probes before the finalizer is removed regardless.
+	cleanupTimeout = 2 * time.Minute
+	// cleanupPollInterval is how often the agents of a deleted policy are
+	// checked for having exited. The Pod watch of namespaced policies skips
+	// agent pods, and cluster policies do not watch pods, so exiting agents
+	// never trigger a reconcile themselves.
+	cleanupPollInterval = 5 * time.Second
+	// agentTerminationGracePeriod gives bpftrace time to detach its probes
+	// and run its END block when an agent is stopped.
+	agentTerminationGracePeriod int64 = 30
+)
+
+// finalizeAgents tears down the agent resources of a deleted policy: the
+// DaemonSet and the script and pod UID ConfigMaps. The agents detach their
+// probes when they are stopped; it returns a non-zero result while agent pods
+// are still exiting and cleanupTimeout has not passed since the owner was
+// deleted. Past the timeout the policy is released with a warning event even
+// if the resources could not be removed, rather than blocking its deletion
+// forever; the garbage collector removes whatever it still owns.
+//
+// There are no pinned BPF objects to remove: bpftrace does not pin its
+// programs or maps, so they go away with the bpftrace process, and
+// /sys/fs/bpf is only mounted read-only, for legacy kernels.
+func (r *CudaEBPFPolicyReconciler) finalizeAgents(ctx context.Context, policy *gpuv1alpha1.CudaEBPFPolicy, owner client.Object, now time.Time) (ctrl.Result, error) {
+	log := logf.FromContext(ctx)
+
+	deletedAt := owner.GetDeletionTimestamp()
+	timedOut := deletedAt != nil && now.Sub(deletedAt.Time) >= cleanupTimeout
+
+	pods, err := r.removeAgents(ctx, policy, owner)
+	if err != nil {
+		if !timedOut {
+			return ctrl.Result{}, err
+		}
+		log.Error(err, "Giving up removing the agents after the cleanup timeout")
+		r.recordEvent(owner, corev1.EventTypeWarning, "CleanupFailed",
+			"Could not remove the agents within %s, probes may still be attached: %v", cleanupTimeout, err)
+		return ctrl.Result{}, nil
+	}
+	if len(pods) == 0 {
+		r.recordEvent(owner, corev1.EventTypeNormal, "CleanedUp", "Removed the agents of the policy")
+		return ctrl.Result{}, nil
+	}
+
+	if timedOut {
+		log.Info("Timed out waiting for agents to exit", "remaining", len(pods))
+		r.recordEvent(owner, corev1.EventTypeWarning, "CleanupTimedOut",
+			"%d agent pod(s) did not exit within %s, probes may still be attached on their nodes", len(pods), cleanupTimeout)
+		return ctrl.Result{}, nil
+	}
+	log.Info("Waiting for agents to detach their probes", "remaining", len(pods))
+	r.recordEvent(owner, corev1.EventTypeNormal, "WaitingForAgents", "Waiting for %d agent pod(s) to detach their probes", len(pods))
+	return ctrl.Result{RequeueAfter: cleanupPollInterval}, nil
+}
+
+// removeAgents deletes the agent resources of a policy and returns the agent
+// pods that are still exiting.
+func (r *CudaEBPFPolicyReconciler) removeAgents(ctx context.Context, policy *gpuv1alpha1.CudaEBPFPolicy, owner client.Object) ([]corev1.Pod, error) {
+	log := logf.FromContext(ctx)
+
+	if _, err := r.stopDaemonSet(ctx, policy, owner); err != nil {
+		return nil, err
+	}
+	if err := r.deleteScripts(ctx, policy, owner, ""); err != nil {
+		return nil, err
+	}
+	if owner.GetNamespace() != "" {
+		cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: tenantPodsConfigMapName(policy), Namespace: policy.Namespace}}
+		if err := r.Delete(ctx, cm); err != nil && !errors.IsNotFound(err) {
+			log.Error(err, "Failed to delete pod UID ConfigMap", "ConfigMap.Namespace", cm.Namespace, "ConfigMap.Name", cm.Name)
+			return nil, err
+		}
+	}
+
+	pods, err := agentPods(ctx, r.Client, policy)
+	if err != nil {
+		log.Error(err, "Failed to list agent pods")
+		return nil, err
+	}
+	return pods, nil
+}
+
+// agentPods returns all pods of a policy's agent DaemonSet, including those
+// that are shutting down.
+func agentPods(ctx context.Context, c client.Client, policy *gpuv1alpha1.CudaEBPFPolicy) ([]corev1.Pod, error) {
+	pods := &corev1.PodList{}
+	if err := c.List(ctx, pods, client.InNamespace(policy.Namespace), client.MatchingLabels{"app": "gpu-operator"}); err != nil {
+		return nil, err
+	}
+	var agents []corev1.Pod
+	for _, pod := range pods.Items {
+		owner := metav1.GetControllerOf(&pod)
+		if owner == nil || owner.Kind != "DaemonSet" || owner.Name != policy.Name {
+			continue
+		}
+		agents = append(agents, pod)
+	}
+	return agents, nil
+}
+
+// recordEvent emits an event on obj. Events are dropped when no recorder is set.
+func (r *CudaEBPFPolicyReconciler) recordEvent(obj runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	if r.Recorder == nil {
+		return
+	}
+	r.Recorder.Eventf(obj, eventtype, reason, messageFmt, args...)
+}
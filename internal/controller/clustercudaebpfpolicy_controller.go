@@ -0,0 +1,191 @@
+/*
+Copyright 2025 WoodProgrammer.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	gpuv1alpha1 "github.com/WoodProgrammer/gpu-bpf-operator/api/v1alpha1"
+	"github.com/WoodProgrammer/gpu-bpf-operator/internal/schedule"
+)
+
+// ClusterCudaEBPFPolicyReconciler reconciles a ClusterCudaEBPFPolicy object
+type ClusterCudaEBPFPolicyReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// AgentNamespace is where the agents of cluster policies run.
+	AgentNamespace string
+	// OperatorNamespace is where the operator runs, see CudaEBPFPolicyReconciler.
+	OperatorNamespace string
+	// AgentStatus reads bpftrace failures from the agents into the policy
+	// status. Failures are not reported when it is nil.
+	AgentStatus AgentStatusReader
+	// Recorder emits events on policies. No events are emitted when it is nil.
+	Recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=gpu.obs.gpu,resources=clustercudaebpfpolicies,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=gpu.obs.gpu,resources=clustercudaebpfpolicies/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=gpu.obs.gpu,resources=clustercudaebpfpolicies/finalizers,verbs=update
+
+// Reconcile runs the agents of a cluster policy in the agent namespace,
+// following the same tracing sessions as a CudaEBPFPolicy.
+func (r *ClusterCudaEBPFPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := logf.FromContext(ctx)
+
+	policy := &gpuv1alpha1.ClusterCudaEBPFPolicy{}
+	if err := r.Get(ctx, req.NamespacedName, policy); err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("ClusterCudaEBPFPolicy resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get ClusterCudaEBPFPolicy")
+		return ctrl.Result{}, err
+	}
+	agentPolicy := r.agentPolicy(policy)
+	agents := r.agentReconciler()
+
+	if !policy.DeletionTimestamp.IsZero() {
+		if controllerutil.ContainsFinalizer(policy, finalizerName) {
+			if policy.Status.Phase != "Terminating" {
+				agents.auditProbeChange(ctx, policy, "delete", policy.Status.ObservedProbes, nil)
+			}
+			// The agents live in another namespace, remove them before letting go
+			result, err := agents.finalizeAgents(ctx, agentPolicy, policy, time.Now())
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			if !result.IsZero() {
+				if policy.Status.Phase != "Terminating" {
+					policy.Status.Phase = "Terminating"
+					if err := r.Status().Update(ctx, policy); err != nil {
+						log.Error(err, "Failed to update policy status")
+						return ctrl.Result{}, err
+					}
+				}
+				return result, nil
+			}
+			controllerutil.RemoveFinalizer(policy, finalizerName)
+			// The policy is gone once the finalizer was removed by an earlier reconcile
+			if err := r.Update(ctx, policy); err != nil {
+				return ctrl.Result{}, client.IgnoreNotFound(err)
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+	if !controllerutil.ContainsFinalizer(policy, finalizerName) {
+		controllerutil.AddFinalizer(policy, finalizerName)
+		if err := r.Update(ctx, policy); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	currentHash, err := agents.calculateHash(agentPolicy)
+	if err != nil {
+		log.Error(err, "Failed to calculate hash")
+		return ctrl.Result{}, err
+	}
+	specChanged := policy.Status.ObservedHash != "" && policy.Status.ObservedHash != currentHash
+
+	now := time.Now()
+	window, err := schedule.Evaluate(policy.Spec.Schedule, policy.CreationTimestamp.Time, now)
+	if err != nil {
+		// The webhook rejects invalid schedules, retrying won't fix the spec
+		log.Error(err, "Invalid tracing schedule")
+		return ctrl.Result{}, nil
+	}
+
+	status := policy.Status.DeepCopy()
+	status.ObservedHash = currentHash
+	if policy.Status.ObservedHash == "" || specChanged {
+		action := "add"
+		if specChanged {
+			action = "update"
+		}
+		probes := policyProbes(&policy.Spec)
+		agents.auditProbeChange(ctx, policy, action, status.ObservedProbes, probes)
+		status.ObservedProbes = probes
+	}
+	result, err := agents.reconcileSession(ctx, agentPolicy, policy, window, now, status, specChanged)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	setReadyCondition(status, policy.Generation, now)
+
+	if !equality.Semantic.DeepEqual(&policy.Status, status) {
+		policy.Status = *status
+		if err := r.Status().Update(ctx, policy); err != nil {
+			log.Error(err, "Failed to update policy status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	log.Info("Successfully reconciled ClusterCudaEBPFPolicy", "phase", status.Phase, "policy", req.Name)
+	return result, nil
+}
+
+// agentPolicy is the namespaced view of a cluster policy its agents are
+// built from. The "cluster-" prefix keeps the agent DaemonSet apart from
+// those of most namespaced policies in the agent namespace. The agents are
+// selected by the clusterPolicyLabel, and a namespaced policy named
+// "cluster-<name>" cannot take over the DaemonSet as it is not its owner.
+func (r *ClusterCudaEBPFPolicyReconciler) agentPolicy(policy *gpuv1alpha1.ClusterCudaEBPFPolicy) *gpuv1alpha1.CudaEBPFPolicy {
+	return &gpuv1alpha1.CudaEBPFPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "cluster-" + policy.Name,
+			Namespace:         r.AgentNamespace,
+			CreationTimestamp: policy.CreationTimestamp,
+		},
+		Spec: *policy.Spec.DeepCopy(),
+	}
+}
+
+// agentReconciler manages agent resources the way namespaced policies do.
+func (r *ClusterCudaEBPFPolicyReconciler) agentReconciler() *CudaEBPFPolicyReconciler {
+	return &CudaEBPFPolicyReconciler{
+		Client:            r.Client,
+		Scheme:            r.Scheme,
+		AgentStatus:       r.AgentStatus,
+		Recorder:          r.Recorder,
+		OperatorNamespace: r.OperatorNamespace,
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ClusterCudaEBPFPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&gpuv1alpha1.ClusterCudaEBPFPolicy{}).
+		Owns(&appsv1.DaemonSet{}).
+		Owns(&corev1.ConfigMap{}).
+		Owns(&networkingv1.NetworkPolicy{}).
+		Named("clustercudaebpfpolicy").
+		Complete(r)
+}
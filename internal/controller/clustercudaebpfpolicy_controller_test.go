@@ -0,0 +1,196 @@
+/*
+Copyright 2025 WoodProgrammer.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	gpuv1alpha1 "github.com/WoodProgrammer/gpu-bpf-operator/api/v1alpha1"
+)
+
+var _ = Describe("ClusterCudaEBPFPolicy Controller", func() {
+	Context("When reconciling a resource", func() {
+		const resourceName = "fleet-trace"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{Name: resourceName}
+		agentsName := types.NamespacedName{Name: "cluster-" + resourceName, Namespace: "default"}
+
+		BeforeEach(func() {
+			By("creating the custom resource for the Kind ClusterCudaEBPFPolicy")
+			resource := &gpuv1alpha1.ClusterCudaEBPFPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceName},
+				Spec: gpuv1alpha1.CudaEBPFPolicySpec{
+					LibPath:   "/usr/lib/libcudart.so",
+					Image:     "test-image:latest",
+					Mode:      "pidwatch",
+					Functions: []gpuv1alpha1.Function{{Name: "cudaMalloc", Kind: "uprobe"}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			resource := &gpuv1alpha1.ClusterCudaEBPFPolicy{}
+			err := k8sClient.Get(ctx, typeNamespacedName, resource)
+			if errors.IsNotFound(err) {
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Cleanup the specific resource instance ClusterCudaEBPFPolicy")
+			resource.Finalizers = nil
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+		})
+
+		It("should run the agents in the agent namespace", func() {
+			controllerReconciler := &ClusterCudaEBPFPolicyReconciler{
+				Client:         k8sClient,
+				Scheme:         k8sClient.Scheme(),
+				AgentNamespace: "default",
+			}
+
+			By("Reconciling the created resource")
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			By("owning the agent DaemonSet from the cluster policy")
+			ds := &appsv1.DaemonSet{}
+			Expect(k8sClient.Get(ctx, agentsName, ds)).To(Succeed())
+			Expect(ds.OwnerReferences).To(ConsistOf(HaveField("Kind", "ClusterCudaEBPFPolicy")))
+
+			By("selecting the agents by the cluster policy label")
+			Expect(ds.Spec.Selector.MatchLabels).To(Equal(map[string]string{"app": "gpu-operator", "gpu.obs.gpu/cluster-policy": resourceName}))
+
+			By("not confining the agents to the pods of a namespace")
+			Expect(ds.Spec.Template.Spec.Containers[0].Env).NotTo(ContainElement(HaveField("Name", "POD_UIDS_FILE")))
+			Expect(ds.Spec.Template.Spec.Volumes).NotTo(ContainElement(HaveField("Name", "tenant-pods")))
+
+			policy := &gpuv1alpha1.ClusterCudaEBPFPolicy{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, policy)).To(Succeed())
+			Expect(policy.Status.ObservedHash).NotTo(BeEmpty())
+		})
+
+		It("should only let the operator, collectors and Prometheus reach the agents", func() {
+			controllerReconciler := &ClusterCudaEBPFPolicyReconciler{
+				Client:            k8sClient,
+				Scheme:            k8sClient.Scheme(),
+				AgentNamespace:    "default",
+				OperatorNamespace: "gpu-bpf-operator-system",
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			np := &networkingv1.NetworkPolicy{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: agentsName.Name + "-agents", Namespace: "default"}, np)).To(Succeed())
+			Expect(np.OwnerReferences).To(ConsistOf(HaveField("Kind", "ClusterCudaEBPFPolicy")))
+			Expect(np.Spec.PodSelector.MatchLabels).To(Equal(map[string]string{"app": "gpu-operator", "gpu.obs.gpu/cluster-policy": resourceName}))
+			Expect(np.Spec.PolicyTypes).To(ConsistOf(networkingv1.PolicyTypeIngress))
+			Expect(np.Spec.Ingress).To(HaveLen(1))
+			rule := np.Spec.Ingress[0]
+			Expect(rule.Ports).To(ConsistOf(HaveField("Port.IntVal", int32(9090))))
+
+			By("admitting the operator from its own namespace only")
+			Expect(rule.From).To(ContainElement(And(
+				HaveField("PodSelector.MatchLabels", map[string]string{"control-plane": "controller-manager"}),
+				HaveField("NamespaceSelector.MatchLabels", map[string]string{corev1.LabelMetadataName: "gpu-bpf-operator-system"}),
+			)))
+			By("admitting the trace capture collectors of the agent namespace")
+			Expect(rule.From).To(ContainElement(And(
+				HaveField("PodSelector.MatchLabels", map[string]string{"app": "gpu-operator-capture"}),
+				HaveField("NamespaceSelector", BeNil()),
+			)))
+			By("admitting Prometheus from namespaces that may scrape metrics")
+			Expect(rule.From).To(ContainElement(And(
+				HaveField("PodSelector", BeNil()),
+				HaveField("NamespaceSelector.MatchLabels", map[string]string{"metrics": "enabled"}),
+			)))
+		})
+
+		It("should remove the agents when the policy is deleted", func() {
+			controllerReconciler := &ClusterCudaEBPFPolicyReconciler{
+				Client:         k8sClient,
+				Scheme:         k8sClient.Scheme(),
+				AgentNamespace: "default",
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			By("deleting the cluster policy")
+			policy := &gpuv1alpha1.ClusterCudaEBPFPolicy{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, policy)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, policy)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, typeNamespacedName, policy))).To(BeTrue())
+			ds := &appsv1.DaemonSet{}
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, agentsName, ds))).To(BeTrue())
+		})
+
+		It("should not take over the agents of a namespaced policy of the same name", func() {
+			By("creating the agents of a namespaced policy named after the cluster agents")
+			labels := map[string]string{"app": "gpu-operator", "gpu.obs.gpu/policy": agentsName.Name}
+			other := &appsv1.DaemonSet{
+				ObjectMeta: metav1.ObjectMeta{Name: agentsName.Name, Namespace: agentsName.Namespace},
+				Spec: appsv1.DaemonSetSpec{
+					Selector: &metav1.LabelSelector{MatchLabels: labels},
+					Template: corev1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{Labels: labels},
+						Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "agent", Image: "test-image:latest"}}},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, other)).To(Succeed())
+			DeferCleanup(func() {
+				Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, other))).To(Succeed())
+			})
+
+			controllerReconciler := &ClusterCudaEBPFPolicyReconciler{
+				Client:         k8sClient,
+				Scheme:         k8sClient.Scheme(),
+				AgentNamespace: "default",
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).To(MatchError(ContainSubstring("belongs to another owner")))
+
+			By("deleting the cluster policy")
+			policy := &gpuv1alpha1.ClusterCudaEBPFPolicy{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, policy)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, policy)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			ds := &appsv1.DaemonSet{}
+			Expect(k8sClient.Get(ctx, agentsName, ds)).To(Succeed())
+			Expect(ds.Spec.Selector.MatchLabels).To(Equal(labels))
+		})
+	})
+})
@@ -22,30 +22,54 @@ import (
 	b64 "encoding/base64"
 	"encoding/json"
 	"fmt"
+	"maps"
+	"strconv"
+	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
 
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 
 	gpuv1alpha1 "github.com/WoodProgrammer/gpu-bpf-operator/api/v1alpha1"
+	"github.com/WoodProgrammer/gpu-bpf-operator/internal/schedule"
+	"github.com/WoodProgrammer/gpu-bpf-operator/internal/script"
 )
 
 const (
 	finalizerName = "gpu.obs.gpu/finalizer"
+	// policyLabel and clusterPolicyLabel select the agent pods of a
+	// namespaced and a cluster policy, next to the "app" label all agents share
+	policyLabel        = "gpu.obs.gpu/policy"
+	clusterPolicyLabel = "gpu.obs.gpu/cluster-policy"
 )
 
 // CudaEBPFPolicyReconciler reconciles a CudaEBPFPolicy object
 type CudaEBPFPolicyReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+	// AgentStatus reads bpftrace failures from the agents into the policy
+	// status. Failures are not reported when it is nil.
+	AgentStatus AgentStatusReader
+	// Recorder emits events on policies. No events are emitted when it is nil.
+	Recorder record.EventRecorder
+	// OperatorNamespace is where the operator runs. The NetworkPolicies in
+	// front of the agents admit the operator from any namespace when it is
+	// empty.
+	OperatorNamespace string
 }
 
 // PolicyConfig represents the configuration from CONFIG.md
@@ -73,6 +97,10 @@ type ReconfigRequest struct {
 // +kubebuilder:rbac:groups=gpu.obs.gpu,resources=cudaebpfpolicies,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=gpu.obs.gpu,resources=cudaebpfpolicies/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=gpu.obs.gpu,resources=cudaebpfpolicies/finalizers,verbs=update
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -99,19 +127,28 @@ func (r *CudaEBPFPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 	if !policy.DeletionTimestamp.IsZero() {
 		action = "delete"
 		if controllerutil.ContainsFinalizer(policy, finalizerName) {
-			// Daemonset logic
-			// Remove finalizer
-			found := &appsv1.DaemonSet{}
-			err = r.Get(ctx, types.NamespacedName{Name: policy.Name, Namespace: policy.Namespace}, found)
-			if err != nil && errors.IsNotFound(err) {
-				log.Error(err, "Failed to create new Daemonset", "Daemonset.Namespace", found.Namespace, "Daemonset.Name", found.Name)
-				r.Delete(ctx, found)
+			if policy.Status.Phase != "Terminating" {
+				r.auditProbeChange(ctx, policy, action, policy.Status.ObservedProbes, nil)
+			}
+			result, err := r.finalizeAgents(ctx, policy, policy, time.Now())
+			if err != nil {
 				return ctrl.Result{}, err
 			}
+			if !result.IsZero() {
+				if policy.Status.Phase != "Terminating" {
+					policy.Status.Phase = "Terminating"
+					if err := r.Status().Update(ctx, policy); err != nil {
+						log.Error(err, "Failed to update policy status")
+						return ctrl.Result{}, err
+					}
+				}
+				return result, nil
+			}
 
 			controllerutil.RemoveFinalizer(policy, finalizerName)
+			// The policy is gone once the finalizer was removed by an earlier reconcile
 			if err := r.Update(ctx, policy); err != nil {
-				return ctrl.Result{}, err
+				return ctrl.Result{}, client.IgnoreNotFound(err)
 			}
 		}
 		return ctrl.Result{}, nil
@@ -136,64 +173,250 @@ func (r *CudaEBPFPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 	} else if policy.Status.ObservedHash != currentHash {
 		action = "update"
 		log.Info("Update detected on policy definitions")
+	}
 
-		updatedHash, err := r.calculateHash(policy)
-		policy.Status.ObservedHash = updatedHash
-		log.Info("Hash values adjusted")
-		if err != nil {
-			log.Error(err, "Failed to calculate hash")
+	// Work out whether a tracing session should be running right now
+	now := time.Now()
+	window, err := schedule.Evaluate(policy.Spec.Schedule, policy.CreationTimestamp.Time, now)
+	if err != nil {
+		// The webhook rejects invalid schedules, retrying won't fix the spec
+		log.Error(err, "Invalid tracing schedule")
+		return ctrl.Result{}, nil
+	}
+
+	status := policy.Status.DeepCopy()
+	status.ObservedHash = currentHash
+	if action != "" {
+		probes := policyProbes(&policy.Spec)
+		r.auditProbeChange(ctx, policy, action, status.ObservedProbes, probes)
+		status.ObservedProbes = probes
+	}
+	result, err := r.reconcileSession(ctx, policy, policy, window, now, status, action == "update")
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	setReadyCondition(status, policy.Generation, now)
+
+	if !equality.Semantic.DeepEqual(&policy.Status, status) {
+		policy.Status = *status
+		if err := r.Status().Update(ctx, policy); err != nil {
+			log.Error(err, "Failed to update policy status")
 			return ctrl.Result{}, err
 		}
+	}
+
+	log.Info("Successfully reconciled CudaEBPFPolicy", "action", action, "phase", status.Phase, "policy", req.NamespacedName)
+	return result, nil
+}
+
+// reconcileSession starts or stops the agents of a policy depending on the
+// tracing window and records the outcome in status. owner is the object the
+// agent resources belong to, which differs from policy for cluster policies.
+func (r *CudaEBPFPolicyReconciler) reconcileSession(ctx context.Context, policy *gpuv1alpha1.CudaEBPFPolicy, owner client.Object,
+	window schedule.Window, now time.Time, status *gpuv1alpha1.CudaEBPFPolicyStatus, specChanged bool) (ctrl.Result, error) {
+	result := ctrl.Result{}
+
+	if owner.GetAnnotations()[gpuv1alpha1.DryRunAnnotation] == "true" {
+		return result, r.reconcilePreview(ctx, policy, owner, now, status)
+	}
+	status.Preview = nil
 
-		ds, err := r.createDaemonsetProbeAgent(policy)
+	if window.Active {
+		if tracesNamespaceOnly(policy) && owner.GetNamespace() != "" {
+			if err := r.reconcileTenantPods(ctx, policy); err != nil {
+				return result, err
+			}
+		}
+		if err := r.reconcileNetworkPolicy(ctx, policy, owner); err != nil {
+			return result, err
+		}
+		scriptHash, err := r.reconcileScript(ctx, policy, owner)
 		if err != nil {
-			log.Error(err, "error while creating daemonset object")
+			return result, err
 		}
-		log.Info("Update a new Daemonset", "Daemonset.Namespace", ds.Namespace, "Daemonset.Name", ds.Name)
-		err = r.Update(ctx, ds)
+		scriptChanged := status.ScriptHash != "" && status.ScriptHash != scriptHash
+		status.ScriptHash = scriptHash
+		status.ScriptConfigMap = scriptConfigMapName(policy, scriptHash)
+		rollingOut, err := r.reconcileDaemonSet(ctx, policy, owner, specChanged || scriptChanged)
 		if err != nil {
-			log.Error(err, "Failed to update new Daemonset", "Daemonset.Namespace", ds.Namespace, "Daemonset.Name", ds.Name)
-			return ctrl.Result{}, err
+			return result, err
+		}
+		status.RolloutInProgress = rollingOut
+		// The previous programs are removed once every agent runs the latest
+		if !rollingOut {
+			if err := r.deleteScripts(ctx, policy, owner, status.ScriptConfigMap); err != nil {
+				return result, err
+			}
+		}
+		status.Phase = "Active"
+		status.NextSessionTime = nil
+		if policy.Spec.Schedule != nil {
+			if status.LastSession == nil || !status.LastSession.StartTime.Time.Equal(window.Start) {
+				status.LastSession = &gpuv1alpha1.SessionRecord{
+					StartTime: metav1.NewTime(window.Start),
+					Result:    "Running",
+				}
+			}
+		}
+		if !window.End.IsZero() {
+			result.RequeueAfter = window.End.Sub(now)
+		}
+		if r.AgentStatus != nil {
+			failure, err := r.latestAgentFailure(ctx, policy)
+			if err != nil {
+				return result, err
+			}
+			if failure != nil && (status.LastFailure == nil || failure.Time.After(status.LastFailure.Time.Time)) {
+				r.recordEvent(owner, corev1.EventTypeWarning, "BpftraceFailed", "bpftrace failed on node %s: %s", failure.Node, failure.Reason)
+				status.LastFailure = failure
+			}
+			if result.RequeueAfter == 0 || result.RequeueAfter > agentStatusInterval {
+				result.RequeueAfter = agentStatusInterval
+			}
 		}
-		// Daemonset created successfully - return and requeue
-		return ctrl.Result{Requeue: true}, nil
-
 	} else {
-		// No changes detected
-		log.Info("No changes detected in policy spec")
-		return ctrl.Result{}, nil
+		status.RolloutInProgress = false
+		nodesTraced, err := r.stopDaemonSet(ctx, policy, owner)
+		if err != nil {
+			return result, err
+		}
+		if status.LastSession != nil && status.LastSession.Result == "Running" {
+			endTime := metav1.NewTime(now)
+			status.LastSession.EndTime = &endTime
+			status.LastSession.Result = "Completed"
+			status.LastSession.NodesTraced = nodesTraced
+			r.recordEvent(owner, corev1.EventTypeNormal, "SessionCompleted", "Tracing session ended on %d node(s)", nodesTraced)
+		}
+		if window.Done {
+			status.Phase = "Completed"
+			status.NextSessionTime = nil
+		} else {
+			nextSession := metav1.NewTime(window.Start)
+			status.Phase = "Scheduled"
+			status.NextSessionTime = &nextSession
+			result.RequeueAfter = window.Start.Sub(now)
+		}
+	}
+	return result, nil
+}
+
+// setReadyCondition records in the Ready condition whether the agents run
+// the latest spec, or why they don't.
+func setReadyCondition(status *gpuv1alpha1.CudaEBPFPolicyStatus, generation int64, now time.Time) {
+	condition := metav1.Condition{
+		Type:               gpuv1alpha1.ConditionReady,
+		Status:             metav1.ConditionFalse,
+		Reason:             status.Phase,
+		ObservedGeneration: generation,
+		LastTransitionTime: metav1.NewTime(now),
+	}
+	switch {
+	case status.Phase == "Active" && status.RolloutInProgress:
+		condition.Reason = "RollingOut"
+		condition.Message = "Agents are rolling out the latest spec"
+	case status.Phase == "Active":
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "AgentsReady"
+		condition.Message = "Agents run the latest spec"
+	case status.Phase == "Scheduled":
+		condition.Message = "Waiting for the next tracing session"
+	case status.Phase == "Completed":
+		condition.Message = "All tracing sessions have ended"
+	case status.Phase == "DryRun":
+		condition.Message = "Dry-run policies do not deploy agents"
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+}
+
+// reconcileDaemonSet creates the agent DaemonSet of a policy, or rolls the
+// desired pod template out to it when the policy spec changed. It reports
+// whether the agents are still rolling out.
+func (r *CudaEBPFPolicyReconciler) reconcileDaemonSet(ctx context.Context, policy *gpuv1alpha1.CudaEBPFPolicy, owner client.Object, specChanged bool) (bool, error) {
+	log := logf.FromContext(ctx)
+
+	ds, err := r.buildAgentDaemonSet(policy, owner)
+	if err != nil {
+		log.Error(err, "error while creating daemonset object")
+		return false, err
 	}
-	// Check if the deployment already exists, if not create a new one
+
 	found := &appsv1.DaemonSet{}
 	err = r.Get(ctx, types.NamespacedName{Name: policy.Name, Namespace: policy.Namespace}, found)
 	if err != nil && errors.IsNotFound(err) {
-		// Define a new deployment
-		ds, err := r.createDaemonsetProbeAgent(policy)
-		if err != nil {
-			log.Error(err, "error while creating daemonset object")
-		}
 		log.Info("Creating a new Daemonset", "Daemonset.Namespace", ds.Namespace, "Daemonset.Name", ds.Name)
-		err = r.Create(ctx, ds)
-		if err != nil {
+		if err := r.Create(ctx, ds); err != nil {
 			log.Error(err, "Failed to create new Daemonset", "Daemonset.Namespace", ds.Namespace, "Daemonset.Name", ds.Name)
-			return ctrl.Result{}, err
+			r.recordEvent(owner, corev1.EventTypeWarning, "RolloutFailed", "Failed to create agent DaemonSet %s/%s: %v", ds.Namespace, ds.Name, err)
+			return false, err
 		}
-		// Deployment created successfully - return and requeue
-		return ctrl.Result{Requeue: true}, nil
+		r.recordEvent(owner, corev1.EventTypeNormal, "AgentsCreated", "Created agent DaemonSet %s/%s", ds.Namespace, ds.Name)
+		return true, nil
 	} else if err != nil {
 		log.Error(err, "Failed to get Daemonset")
-		return ctrl.Result{}, err
+		return false, err
+	}
+	if !metav1.IsControlledBy(found, owner) {
+		err := fmt.Errorf("agent DaemonSet %s/%s belongs to another owner", found.Namespace, found.Name)
+		log.Error(err, "Refusing to take over the Daemonset")
+		r.recordEvent(owner, corev1.EventTypeWarning, "RolloutFailed", "Agent DaemonSet %s/%s belongs to another owner", found.Namespace, found.Name)
+		return false, err
 	}
 
-	// Update status with new hash
-	policy.Status.ObservedHash = currentHash
-	if err := r.Status().Update(ctx, policy); err != nil {
-		log.Error(err, "Failed to update policy status")
-		return ctrl.Result{}, err
+	if !specChanged {
+		return rollingOut(found), nil
 	}
+	log.Info("Update a new Daemonset", "Daemonset.Namespace", found.Namespace, "Daemonset.Name", found.Name)
+	found.Spec.Template = ds.Spec.Template
+	if err := r.Update(ctx, found); err != nil {
+		log.Error(err, "Failed to update new Daemonset", "Daemonset.Namespace", found.Namespace, "Daemonset.Name", found.Name)
+		r.recordEvent(owner, corev1.EventTypeWarning, "RolloutFailed", "Failed to update agent DaemonSet %s/%s: %v", found.Namespace, found.Name, err)
+		return false, err
+	}
+	r.recordEvent(owner, corev1.EventTypeNormal, "AgentsUpdated", "Rolling out the updated agents of DaemonSet %s/%s", found.Namespace, found.Name)
+	return true, nil
+}
+
+// agentLabels select the agent pods of a policy. The agents of a cluster
+// policy use a label key of their own, so that they never match the agents
+// of a namespaced policy in the agent namespace.
+func agentLabels(policy *gpuv1alpha1.CudaEBPFPolicy, owner client.Object) map[string]string {
+	if _, ok := owner.(*gpuv1alpha1.ClusterCudaEBPFPolicy); ok {
+		return map[string]string{"app": "gpu-operator", clusterPolicyLabel: owner.GetName()}
+	}
+	return map[string]string{"app": "gpu-operator", policyLabel: policy.Name}
+}
 
-	log.Info("Successfully reconciled CudaEBPFPolicy", "action", action, "policy", req.NamespacedName)
-	return ctrl.Result{}, nil
+// rollingOut reports whether a DaemonSet has agents left to update or start.
+func rollingOut(ds *appsv1.DaemonSet) bool {
+	return ds.Status.ObservedGeneration < ds.Generation ||
+		ds.Status.UpdatedNumberScheduled < ds.Status.DesiredNumberScheduled ||
+		ds.Status.NumberAvailable < ds.Status.DesiredNumberScheduled
+}
+
+// stopDaemonSet removes the agents of a policy between tracing sessions. It
+// returns the number of agents that were ready when they were stopped. A
+// DaemonSet of the same name controlled by another owner is left alone.
+func (r *CudaEBPFPolicyReconciler) stopDaemonSet(ctx context.Context, policy *gpuv1alpha1.CudaEBPFPolicy, owner client.Object) (int32, error) {
+	log := logf.FromContext(ctx)
+
+	found := &appsv1.DaemonSet{}
+	err := r.Get(ctx, types.NamespacedName{Name: policy.Name, Namespace: policy.Namespace}, found)
+	if errors.IsNotFound(err) {
+		return 0, nil
+	} else if err != nil {
+		log.Error(err, "Failed to get Daemonset")
+		return 0, err
+	}
+	if !metav1.IsControlledBy(found, owner) {
+		return 0, nil
+	}
+
+	log.Info("Stopping tracing session", "Daemonset.Namespace", found.Namespace, "Daemonset.Name", found.Name)
+	if err := r.Delete(ctx, found); err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to delete Daemonset", "Daemonset.Namespace", found.Namespace, "Daemonset.Name", found.Name)
+		return 0, err
+	}
+	return found.Status.NumberReady, nil
 }
 
 // calculateHash computes a hash of the policy spec to detect changes
@@ -207,104 +430,125 @@ func (r *CudaEBPFPolicyReconciler) calculateHash(policy *gpuv1alpha1.CudaEBPFPol
 }
 
 func (r *CudaEBPFPolicyReconciler) createDaemonsetProbeAgent(policy *gpuv1alpha1.CudaEBPFPolicy) (*appsv1.DaemonSet, error) {
-	labels := map[string]string{
-		"app": "gpu-operator",
+	return r.buildAgentDaemonSet(policy, policy)
+}
+
+// buildAgentDaemonSet builds the agent DaemonSet of a policy, controlled by owner.
+func (r *CudaEBPFPolicyReconciler) buildAgentDaemonSet(policy *gpuv1alpha1.CudaEBPFPolicy, owner client.Object) (*appsv1.DaemonSet, error) {
+	labels := agentLabels(policy, owner)
+	program, err := renderScript(policy)
+	if err != nil {
+		return nil, err
 	}
-	probeCallsDetails, err := r.EncodeProbeCalls(policy)
+	functionCallsDetails, err := r.EncodeFunctionCalls(policy)
 	if err != nil {
 		return nil, err
 	}
 
-	// Define security capabilities required for eBPF
+	// Grant only the capabilities and host paths the probes of the policy need
 	capabilities := &corev1.Capabilities{
-		Add: []corev1.Capability{
-			"SYS_ADMIN",    // Required for loading eBPF programs
-			"SYS_RESOURCE", // For setting memory limits
-			"SYS_PTRACE",   // For attaching to processes
-			"NET_ADMIN",    // For network-related eBPF programs
-			"BPF",          // Specific eBPF capability (Linux 5.8+)
-			"PERFMON",      // For performance monitoring (Linux 5.8+)
-		},
+		Drop: []corev1.Capability{"ALL"},
+		Add:  agentCapabilities(policy),
 	}
+	volumes, volumeMounts := agentVolumes(policy)
 
-	// Define volume mounts for eBPF operations
-	volumeMounts := []corev1.VolumeMount{
-		{
-			Name:      "lib-modules",
-			MountPath: "/lib/modules",
-		},
-		{
-			Name:      "usr-src",
-			MountPath: "/usr/src",
-		},
-		{
-			Name:      "sys-kernel-debug",
-			MountPath: "/sys/kernel/debug",
-		},
+	env := []corev1.EnvVar{{
+		Name:  "LIB_PATH",
+		Value: policy.Spec.LibPath,
+	},
 		{
-			Name:      "sys-fs-bpf",
-			MountPath: "/sys/fs/bpf",
-			ReadOnly:  true,
+			Name:  "FUNCTION_CALLS",
+			Value: functionCallsDetails,
 		},
 		{
-			Name:      "proc",
-			MountPath: "/proc",
-			ReadOnly:  true,
+			Name:  "POLICY_NAME",
+			Value: policy.Name,
 		},
+		fieldRefEnvVar("NODE_NAME", "spec.nodeName"),
+		fieldRefEnvVar("POD_NAME", "metadata.name"),
+		fieldRefEnvVar("POD_NAMESPACE", "metadata.namespace"),
+	}
+	// The agents run the program rendered into the script ConfigMap
+	scriptVol, scriptMnt, scriptEnv := scriptMount(policy, script.Hash(program))
+	volumes = append(volumes, scriptVol)
+	volumeMounts = append(volumeMounts, scriptMnt)
+	env = append(env, scriptEnv)
+
+	// Namespace-scoped policies only report processes of their own pods
+	if tracesNamespaceOnly(policy) && owner.GetNamespace() != "" {
+		volume, mount, podUIDs := tenantPodsMount(policy)
+		volumes = append(volumes, volume)
+		volumeMounts = append(volumeMounts, mount)
+		env = append(env, podUIDs)
+	}
+	if otlp := policy.Spec.OTLP; otlp != nil {
+		env = append(env, corev1.EnvVar{Name: "OTEL_EXPORTER_OTLP_ENDPOINT", Value: otlp.Endpoint})
+		if otlp.MetricInterval != nil {
+			env = append(env, corev1.EnvVar{
+				Name:  "OTEL_METRIC_EXPORT_INTERVAL",
+				Value: strconv.FormatInt(otlp.MetricInterval.Milliseconds(), 10),
+			})
+		}
 	}
 
-	// Define volumes from host paths
-	hostPathDirectory := corev1.HostPathDirectory
-	//hostPathFile := corev1.HostPathFile
-	volumes := []corev1.Volume{
-		{
-			Name: "sys-fs-bpf",
-			VolumeSource: corev1.VolumeSource{
-				HostPath: &corev1.HostPathVolumeSource{
-					Path: "/sys/fs/bpf",
-					Type: &hostPathDirectory,
-				},
-			},
-		},
-		{
-			Name: "sys-kernel-debug",
-			VolumeSource: corev1.VolumeSource{
-				HostPath: &corev1.HostPathVolumeSource{
-					Path: "/sys/kernel/debug",
-					Type: &hostPathDirectory,
-				},
-			},
-		},
-		{
-			Name: "proc",
-			VolumeSource: corev1.VolumeSource{
-				HostPath: &corev1.HostPathVolumeSource{
-					Path: "/proc",
-					Type: &hostPathDirectory,
-				},
-			},
-		},
-		{
-			Name: "lib-modules",
-			VolumeSource: corev1.VolumeSource{
-				HostPath: &corev1.HostPathVolumeSource{
-					Path: "/lib/modules",
-					Type: &hostPathDirectory,
+	// File sinks write to a directory on the node
+	hostPathDirectoryOrCreate := corev1.HostPathDirectoryOrCreate
+	if len(policy.Spec.Sinks) > 0 {
+		sinkDetails, err := r.EncodeOutputSinks(policy)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, corev1.EnvVar{Name: "OUTPUT_SINKS", Value: sinkDetails})
+		for _, sink := range policy.Spec.Sinks {
+			if sink.File == nil {
+				continue
+			}
+			volumes = append(volumes, corev1.Volume{
+				Name: "sink-" + sink.Name,
+				VolumeSource: corev1.VolumeSource{
+					HostPath: &corev1.HostPathVolumeSource{
+						Path: sink.File.Path,
+						Type: &hostPathDirectoryOrCreate,
+					},
 				},
-			},
-		},
-		{
-			Name: "usr-src",
-			VolumeSource: corev1.VolumeSource{
-				HostPath: &corev1.HostPathVolumeSource{
-					Path: "/usr/src",
-					Type: &hostPathDirectory,
+			})
+			volumeMounts = append(volumeMounts, corev1.VolumeMount{
+				Name:      "sink-" + sink.Name,
+				MountPath: sink.File.Path,
+			})
+		}
+	}
+
+	if buffering := policy.Spec.Buffering; buffering != nil {
+		if buffering.QueueSize > 0 {
+			env = append(env, corev1.EnvVar{Name: "EVENT_QUEUE_SIZE", Value: strconv.Itoa(buffering.QueueSize)})
+		}
+		if buffering.Policy != "" {
+			env = append(env, corev1.EnvVar{Name: "EVENT_DROP_POLICY", Value: buffering.Policy})
+		}
+		if spill := buffering.Spill; spill != nil {
+			env = append(env, corev1.EnvVar{Name: "EVENT_SPILL_DIR", Value: spill.Path})
+			if spill.MaxSizeMB > 0 {
+				env = append(env, corev1.EnvVar{Name: "EVENT_SPILL_MAX_MB", Value: strconv.Itoa(spill.MaxSizeMB)})
+			}
+			volumes = append(volumes, corev1.Volume{
+				Name: "event-spill",
+				VolumeSource: corev1.VolumeSource{
+					HostPath: &corev1.HostPathVolumeSource{
+						Path: spill.Path,
+						Type: &hostPathDirectoryOrCreate,
+					},
 				},
-			},
-		},
+			})
+			volumeMounts = append(volumeMounts, corev1.VolumeMount{
+				Name:      "event-spill",
+				MountPath: spill.Path,
+			})
+		}
 	}
 
 	hostPID := true
+	terminationGracePeriod := agentTerminationGracePeriod
 
 	ds := &appsv1.DaemonSet{
 		ObjectMeta: metav1.ObjectMeta{
@@ -317,54 +561,260 @@ func (r *CudaEBPFPolicyReconciler) createDaemonsetProbeAgent(policy *gpuv1alpha1
 			},
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: labels,
+					// The pod template overrides add labels that must not
+					// end up in the immutable selector
+					Labels: maps.Clone(labels),
 				},
 				Spec: corev1.PodSpec{
-					HostPID: hostPID,
-					Volumes: volumes,
+					HostPID:                       hostPID,
+					TerminationGracePeriodSeconds: &terminationGracePeriod,
+					Volumes:                       volumes,
 					Containers: []corev1.Container{{
-						Image: policy.Spec.Image,
-						Name:  "bpf-tracer-agent",
+						Image:           policy.Spec.Image,
+						ImagePullPolicy: policy.Spec.ImagePullPolicy,
+						Name:            "bpf-tracer-agent",
 						Ports: []corev1.ContainerPort{{
 							ContainerPort: 9090,
 							Name:          "bpfpolicyagent",
 						}},
-						Env: []corev1.EnvVar{{
-							Name:  "LIB_PATH",
-							Value: policy.Spec.LibPath,
+						Env: env,
+						// The agent restarts bpftrace itself and only reports
+						// unhealthy once it gave up
+						LivenessProbe: &corev1.Probe{
+							ProbeHandler:     agentHTTPProbe("/healthz"),
+							PeriodSeconds:    10,
+							FailureThreshold: 3,
 						},
-							{
-								Name:  "PROBE_CALLS",
-								Value: probeCallsDetails,
-							}},
-						SecurityContext: &corev1.SecurityContext{
-							Capabilities: capabilities,
+						// Ready once bpftrace attached its probes
+						ReadinessProbe: &corev1.Probe{
+							ProbeHandler:  agentHTTPProbe("/readyz"),
+							PeriodSeconds: 5,
 						},
-						VolumeMounts: volumeMounts,
+						SecurityContext: agentSecurityContext(policy, capabilities),
+						VolumeMounts:    volumeMounts,
 					}},
 				},
 			},
 		},
 	}
-	ctrl.SetControllerReference(policy, ds, r.Scheme)
+	applyPodTemplate(&ds.Spec.Template, policy.Spec.PodTemplate)
+	if err := ctrl.SetControllerReference(owner, ds, r.Scheme); err != nil {
+		return nil, err
+	}
 	return ds, nil
 }
 
-func (r *CudaEBPFPolicyReconciler) EncodeProbeCalls(policy *gpuv1alpha1.CudaEBPFPolicy) (string, error) {
-	jsonBytes, err := json.Marshal(policy.Spec.Probes)
+// agentCapabilities computes the capabilities bpftrace needs for the probes
+// of a policy. Loading programs and attaching to kernel and user probes needs
+// BPF and PERFMON on Linux 5.8+, older kernels only know SYS_ADMIN. Uprobes
+// and user stacks reach into processes of other containers, which needs
+// SYS_PTRACE.
+func agentCapabilities(policy *gpuv1alpha1.CudaEBPFPolicy) []corev1.Capability {
+	var caps []corev1.Capability
+	if policy.Spec.Security != nil && policy.Spec.Security.LegacyKernel {
+		// SYS_RESOURCE raises the memlock limit for BPF maps before memcg accounting
+		caps = []corev1.Capability{"SYS_ADMIN", "SYS_RESOURCE"}
+	} else {
+		caps = []corev1.Capability{"BPF", "PERFMON"}
+	}
+	for _, fn := range policy.Spec.Functions {
+		if fn.Kind == "uprobe" || fn.Kind == "uretprobe" || (fn.Stack != nil && fn.Stack.User) {
+			caps = append(caps, "SYS_PTRACE")
+			break
+		}
+	}
+	return caps
+}
+
+// agentVolumes returns the volumes of the agent pod: an emptyDir for the
+// rendered script and diagnostics, tracefs when kernel probes are attached
+// and the kernel headers and bpffs on legacy kernels.
+func agentVolumes(policy *gpuv1alpha1.CudaEBPFPolicy) ([]corev1.Volume, []corev1.VolumeMount) {
+	volumes := []corev1.Volume{{
+		Name:         "tmp",
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	}}
+	volumeMounts := []corev1.VolumeMount{{
+		Name:      "tmp",
+		MountPath: "/tmp",
+	}}
+
+	hostPathDirectory := corev1.HostPathDirectory
+	addHostPath := func(name, path string, readOnly bool) {
+		volumes = append(volumes, corev1.Volume{
+			Name: name,
+			VolumeSource: corev1.VolumeSource{
+				HostPath: &corev1.HostPathVolumeSource{
+					Path: path,
+					Type: &hostPathDirectory,
+				},
+			},
+		})
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{
+			Name:      name,
+			MountPath: path,
+			ReadOnly:  readOnly,
+		})
+	}
+
+	kernelProbes := len(policy.Spec.Probes) > 0
+	for _, fn := range policy.Spec.Functions {
+		if fn.Kind == "kprobe" || fn.Kind == "kretprobe" {
+			kernelProbes = true
+		}
+	}
+	if kernelProbes {
+		addHostPath("sys-kernel-debug", "/sys/kernel/debug", false)
+	}
+	if policy.Spec.Security != nil && policy.Spec.Security.LegacyKernel {
+		addHostPath("lib-modules", "/lib/modules", true)
+		addHostPath("usr-src", "/usr/src", true)
+		addHostPath("sys-fs-bpf", "/sys/fs/bpf", true)
+	}
+	return volumes, volumeMounts
+}
+
+// agentSecurityContext locks the agent container down to the computed
+// capabilities with a read-only root filesystem unless the policy opts out.
+func agentSecurityContext(policy *gpuv1alpha1.CudaEBPFPolicy, capabilities *corev1.Capabilities) *corev1.SecurityContext {
+	readOnly := true
+	allowPrivilegeEscalation := false
+	sc := &corev1.SecurityContext{
+		Capabilities:             capabilities,
+		ReadOnlyRootFilesystem:   &readOnly,
+		AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+	}
+	if security := policy.Spec.Security; security != nil {
+		if security.ReadOnlyRootFilesystem != nil {
+			readOnly = *security.ReadOnlyRootFilesystem
+		}
+		sc.SeccompProfile = security.SeccompProfile.DeepCopy()
+		sc.AppArmorProfile = security.AppArmorProfile.DeepCopy()
+	}
+	return sc
+}
+
+// applyPodTemplate merges the policy's pod template overrides onto the
+// generated agent pod template.
+func applyPodTemplate(template *corev1.PodTemplateSpec, overrides *gpuv1alpha1.AgentPodTemplate) {
+	if overrides == nil {
+		return
+	}
+	pod := &template.Spec
+	container := &pod.Containers[0]
+
+	// The "app" label is the DaemonSet selector and is never overridden
+	for key, value := range overrides.Labels {
+		if _, reserved := template.Labels[key]; !reserved {
+			template.Labels[key] = value
+		}
+	}
+	if len(overrides.Annotations) > 0 {
+		if template.Annotations == nil {
+			template.Annotations = map[string]string{}
+		}
+		for key, value := range overrides.Annotations {
+			template.Annotations[key] = value
+		}
+	}
+
+	if overrides.Resources != nil {
+		container.Resources = *overrides.Resources.DeepCopy()
+	}
+	for _, toleration := range overrides.Tolerations {
+		pod.Tolerations = append(pod.Tolerations, *toleration.DeepCopy())
+	}
+	if overrides.Affinity != nil {
+		pod.Affinity = overrides.Affinity.DeepCopy()
+	}
+	if len(overrides.NodeSelector) > 0 {
+		pod.NodeSelector = map[string]string{}
+		for key, value := range overrides.NodeSelector {
+			pod.NodeSelector[key] = value
+		}
+	}
+	if overrides.PriorityClassName != "" {
+		pod.PriorityClassName = overrides.PriorityClassName
+	}
+	if overrides.ServiceAccountName != "" {
+		pod.ServiceAccountName = overrides.ServiceAccountName
+	}
+	pod.ImagePullSecrets = append(pod.ImagePullSecrets, overrides.ImagePullSecrets...)
+
+	// Env vars are merged by name. The generated env is applied after the
+	// overrides so that the agent configuration is never replaced.
+	env := make([]corev1.EnvVar, 0, len(overrides.Env)+len(container.Env))
+	for _, override := range overrides.Env {
+		env = append(env, *override.DeepCopy())
+	}
+	for _, generated := range container.Env {
+		replaced := false
+		for i := range env {
+			if env[i].Name == generated.Name {
+				env[i] = generated
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			env = append(env, generated)
+		}
+	}
+	container.Env = env
+	for _, volume := range overrides.Volumes {
+		pod.Volumes = append(pod.Volumes, *volume.DeepCopy())
+	}
+	for _, mount := range overrides.VolumeMounts {
+		container.VolumeMounts = append(container.VolumeMounts, *mount.DeepCopy())
+	}
+}
+
+// EncodeFunctionCalls encodes spec.functions for the agent's FUNCTION_CALLS variable.
+func (r *CudaEBPFPolicyReconciler) EncodeFunctionCalls(policy *gpuv1alpha1.CudaEBPFPolicy) (string, error) {
+	jsonBytes, err := json.Marshal(policy.Spec.Functions)
+	if err != nil {
+		return "", err
+	}
+	return b64.StdEncoding.EncodeToString(jsonBytes), nil
+}
+
+// EncodeOutputSinks encodes spec.sinks for the agent's OUTPUT_SINKS variable.
+func (r *CudaEBPFPolicyReconciler) EncodeOutputSinks(policy *gpuv1alpha1.CudaEBPFPolicy) (string, error) {
+	jsonBytes, err := json.Marshal(policy.Spec.Sinks)
 	if err != nil {
 		return "", err
 	}
-	fmt.Println("The jsonBytes is  ", jsonBytes)
-	sEnc := b64.StdEncoding.EncodeToString([]byte(jsonBytes))
-	fmt.Println("The sEnc is  ", sEnc)
-	return sEnc, nil
+	return b64.StdEncoding.EncodeToString(jsonBytes), nil
+}
+
+// agentHTTPProbe probes an endpoint of the agent HTTP server.
+func agentHTTPProbe(path string) corev1.ProbeHandler {
+	return corev1.ProbeHandler{
+		HTTPGet: &corev1.HTTPGetAction{
+			Path: path,
+			Port: intstr.FromString("bpfpolicyagent"),
+		},
+	}
+}
+
+// fieldRefEnvVar exposes a field of the agent pod through the downward API.
+func fieldRefEnvVar(name, fieldPath string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{
+			FieldRef: &corev1.ObjectFieldSelector{FieldPath: fieldPath},
+		},
+	}
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *CudaEBPFPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&gpuv1alpha1.CudaEBPFPolicy{}).
+		Owns(&appsv1.DaemonSet{}).
+		Owns(&corev1.ConfigMap{}).
+		Owns(&networkingv1.NetworkPolicy{}).
+		Watches(&corev1.Pod{}, handler.EnqueueRequestsFromMapFunc(r.policiesForPod)).
 		Named("cudaebpfpolicy").
 		Complete(r)
 }
@@ -18,11 +18,19 @@ package controller
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -81,4 +89,975 @@ var _ = Describe("CudaEBPFPolicy Controller", func() {
 			// Example: If you expect a certain status condition after reconciliation, verify it here.
 		})
 	})
+
+	Context("When reconciling a scheduled policy", func() {
+		const resourceName = "scheduled-resource"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default",
+		}
+
+		BeforeEach(func() {
+			By("creating a policy whose first session starts in an hour")
+			startTime := metav1.NewTime(time.Now().Add(time.Hour))
+			resource := &gpuv1alpha1.CudaEBPFPolicy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      resourceName,
+					Namespace: "default",
+				},
+				Spec: gpuv1alpha1.CudaEBPFPolicySpec{
+					LibPath:   "/usr/lib/libcudart.so",
+					Image:     "test-image:latest",
+					Functions: []gpuv1alpha1.Function{{Name: "cudaMalloc", Kind: "uprobe"}},
+					Schedule: &gpuv1alpha1.TracingSchedule{
+						StartTime: &startTime,
+						Duration:  &metav1.Duration{Duration: 10 * time.Minute},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			resource := &gpuv1alpha1.CudaEBPFPolicy{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+
+			By("Cleanup the specific resource instance CudaEBPFPolicy")
+			resource.Finalizers = nil
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, resource))).To(Succeed())
+		})
+
+		It("should wait for the session to start", func() {
+			controllerReconciler := &CudaEBPFPolicyReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			result, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(BeNumerically(">", 59*time.Minute))
+
+			By("checking that no agents are running")
+			ds := &appsv1.DaemonSet{}
+			err = k8sClient.Get(ctx, typeNamespacedName, ds)
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+
+			By("checking the status reports the next session")
+			policy := &gpuv1alpha1.CudaEBPFPolicy{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, policy)).To(Succeed())
+			Expect(policy.Status.Phase).To(Equal("Scheduled"))
+			Expect(policy.Status.NextSessionTime).NotTo(BeNil())
+		})
+	})
+
+	Context("When agents report bpftrace failures", func() {
+		const resourceName = "failing-resource"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default",
+		}
+
+		BeforeEach(func() {
+			By("creating a policy and one of its running agents")
+			resource := &gpuv1alpha1.CudaEBPFPolicy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      resourceName,
+					Namespace: "default",
+				},
+				Spec: gpuv1alpha1.CudaEBPFPolicySpec{
+					LibPath:   "/usr/lib/libcudart.so",
+					Image:     "test-image:latest",
+					Functions: []gpuv1alpha1.Function{{Name: "cudaMalloc", Kind: "uprobe"}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+
+			isController := true
+			agent := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "failing-agent",
+					Namespace: "default",
+					Labels:    map[string]string{"app": "gpu-operator"},
+					OwnerReferences: []metav1.OwnerReference{{
+						APIVersion: "apps/v1",
+						Kind:       "DaemonSet",
+						Name:       resourceName,
+						UID:        "00000000-0000-0000-0000-000000000002",
+						Controller: &isController,
+					}},
+				},
+				Spec: corev1.PodSpec{
+					NodeName:   "node-b",
+					Containers: []corev1.Container{{Name: "bpf-tracer-agent", Image: "test-image:latest"}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, agent)).To(Succeed())
+			agent.Status.Phase = corev1.PodRunning
+			agent.Status.PodIP = "10.0.0.8"
+			Expect(k8sClient.Status().Update(ctx, agent)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			resource := &gpuv1alpha1.CudaEBPFPolicy{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+
+			By("Cleanup the policy and its agents")
+			resource.Finalizers = nil
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, resource))).To(Succeed())
+			ds := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: resourceName, Namespace: "default"}}
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, ds))).To(Succeed())
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "failing-agent", Namespace: "default"}}
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, pod))).To(Succeed())
+		})
+
+		It("should record the last failure in the policy status", func() {
+			failedAt := metav1.NewTime(time.Now().Add(-time.Minute).Truncate(time.Second))
+			controllerReconciler := &CudaEBPFPolicyReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+				AgentStatus: fakeAgentStatus{"failing-agent": {
+					Time:     failedAt,
+					Reason:   "ERROR: Could not resolve symbol: /usr/lib/libcudart.so:cudaMalloc",
+					ExitCode: 1,
+					Restarts: 2,
+				}},
+			}
+
+			result, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(agentStatusInterval))
+
+			policy := &gpuv1alpha1.CudaEBPFPolicy{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, policy)).To(Succeed())
+			Expect(policy.Status.LastFailure).NotTo(BeNil())
+			Expect(policy.Status.LastFailure.Node).To(Equal("node-b"))
+			Expect(policy.Status.LastFailure.Reason).To(ContainSubstring("Could not resolve symbol"))
+			Expect(policy.Status.LastFailure.Time.Equal(&failedAt)).To(BeTrue())
+		})
+	})
+
+	Context("When deleting a policy", func() {
+		const resourceName = "deleted-resource"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default",
+		}
+
+		BeforeEach(func() {
+			By("creating a policy with an agent pod that is still running")
+			resource := &gpuv1alpha1.CudaEBPFPolicy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      resourceName,
+					Namespace: "default",
+				},
+				Spec: gpuv1alpha1.CudaEBPFPolicySpec{
+					LibPath:   "/usr/lib/libcudart.so",
+					Image:     "test-image:latest",
+					Mode:      "pidwatch",
+					Functions: []gpuv1alpha1.Function{{Name: "cudaMalloc", Kind: "uprobe"}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+
+			isController := true
+			agent := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "deleted-agent",
+					Namespace: "default",
+					Labels:    map[string]string{"app": "gpu-operator"},
+					OwnerReferences: []metav1.OwnerReference{{
+						APIVersion: "apps/v1",
+						Kind:       "DaemonSet",
+						Name:       resourceName,
+						UID:        "00000000-0000-0000-0000-000000000004",
+						Controller: &isController,
+					}},
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "bpf-tracer-agent", Image: "test-image:latest"}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, agent)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			resource := &gpuv1alpha1.CudaEBPFPolicy{}
+			if err := k8sClient.Get(ctx, typeNamespacedName, resource); err == nil {
+				resource.Finalizers = nil
+				Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+				Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, resource))).To(Succeed())
+			}
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "deleted-agent", Namespace: "default"}}
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, pod))).To(Succeed())
+		})
+
+		It("should remove the agents before releasing the policy", func() {
+			controllerReconciler := &CudaEBPFPolicyReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &appsv1.DaemonSet{})).To(Succeed())
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: resourceName + "-pods", Namespace: "default"}, &corev1.ConfigMap{})).To(Succeed())
+
+			By("deleting the policy while its agent is still detaching")
+			recorder := record.NewFakeRecorder(10)
+			controllerReconciler.Recorder = recorder
+			policy := &gpuv1alpha1.CudaEBPFPolicy{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, policy)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, policy)).To(Succeed())
+			result, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(cleanupPollInterval))
+			Expect(recorder.Events).To(Receive(Equal("Normal Deleted Policy deleted, removing probes uprobe:cudaMalloc")))
+			Expect(recorder.Events).To(Receive(ContainSubstring("WaitingForAgents")))
+
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, typeNamespacedName, &appsv1.DaemonSet{}))).To(BeTrue())
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, types.NamespacedName{Name: resourceName + "-pods", Namespace: "default"}, &corev1.ConfigMap{}))).To(BeTrue())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, policy)).To(Succeed())
+			Expect(policy.Status.Phase).To(Equal("Terminating"))
+			Expect(policy.Finalizers).To(ContainElement(finalizerName))
+
+			By("removing the finalizer once the agent has exited")
+			agent := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "deleted-agent", Namespace: "default"}}
+			Expect(k8sClient.Delete(ctx, agent)).To(Succeed())
+			result, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.IsZero()).To(BeTrue())
+			Expect(recorder.Events).To(Receive(ContainSubstring("CleanedUp")))
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, typeNamespacedName, policy))).To(BeTrue())
+		})
+
+		It("should give up waiting for the agents after the cleanup timeout", func() {
+			controllerReconciler := &CudaEBPFPolicyReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			recorder := record.NewFakeRecorder(10)
+			controllerReconciler.Recorder = recorder
+
+			policy := &gpuv1alpha1.CudaEBPFPolicy{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, policy)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, policy)).To(Succeed())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, policy)).To(Succeed())
+
+			result, err := controllerReconciler.finalizeAgents(ctx, policy, policy, time.Now().Add(cleanupTimeout))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.IsZero()).To(BeTrue())
+			Expect(recorder.Events).To(Receive(ContainSubstring("CleanupTimedOut")))
+		})
+
+		It("should release the policy after the cleanup timeout when the agents cannot be removed", func() {
+			controllerReconciler := &CudaEBPFPolicyReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			recorder := record.NewFakeRecorder(10)
+			controllerReconciler.Client = failingDeletes{k8sClient}
+			controllerReconciler.Recorder = recorder
+
+			policy := &gpuv1alpha1.CudaEBPFPolicy{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, policy)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, policy)).To(Succeed())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, policy)).To(Succeed())
+
+			By("retrying while the cleanup timeout has not passed")
+			_, err = controllerReconciler.finalizeAgents(ctx, policy, policy, time.Now())
+			Expect(err).To(HaveOccurred())
+
+			By("giving up with a warning once it passed")
+			result, err := controllerReconciler.finalizeAgents(ctx, policy, policy, time.Now().Add(cleanupTimeout))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.IsZero()).To(BeTrue())
+			Expect(recorder.Events).To(Receive(And(HavePrefix("Warning CleanupFailed"), ContainSubstring("deletes are forbidden"))))
+		})
+	})
+
+	Context("When auditing policy changes", func() {
+		const resourceName = "audited-resource"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default",
+		}
+
+		drain := func(recorder *record.FakeRecorder) []string {
+			var events []string
+			for len(recorder.Events) > 0 {
+				events = append(events, <-recorder.Events)
+			}
+			return events
+		}
+
+		BeforeEach(func() {
+			resource := &gpuv1alpha1.CudaEBPFPolicy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        resourceName,
+					Namespace:   "default",
+					Annotations: map[string]string{gpuv1alpha1.ChangedByAnnotation: "alice"},
+				},
+				Spec: gpuv1alpha1.CudaEBPFPolicySpec{
+					LibPath:   "/usr/lib/libcudart.so",
+					Image:     "test-image:latest",
+					Mode:      "systemwide",
+					Functions: []gpuv1alpha1.Function{{Name: "cudaMalloc", Kind: "uprobe"}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			resource := &gpuv1alpha1.CudaEBPFPolicy{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Finalizers = nil
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, resource))).To(Succeed())
+			ds := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: resourceName, Namespace: "default"}}
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, ds))).To(Succeed())
+		})
+
+		It("should tell who changed which probes", func() {
+			recorder := record.NewFakeRecorder(20)
+			controllerReconciler := &CudaEBPFPolicyReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: recorder,
+			}
+
+			By("reconciling the new policy")
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(drain(recorder)).To(ContainElements(
+				"Normal Created Policy created by alice with probes uprobe:cudaMalloc",
+				ContainSubstring("AgentsCreated"),
+			))
+			policy := &gpuv1alpha1.CudaEBPFPolicy{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, policy)).To(Succeed())
+			Expect(policy.Status.ObservedProbes).To(Equal([]string{"uprobe:cudaMalloc"}))
+
+			By("changing the probes of the policy")
+			policy.Annotations[gpuv1alpha1.ChangedByAnnotation] = "bob"
+			policy.Spec.Functions = []gpuv1alpha1.Function{{Name: "cudaFree", Kind: "uprobe"}}
+			Expect(k8sClient.Update(ctx, policy)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(drain(recorder)).To(ContainElements(
+				"Normal Updated Policy updated by bob: added uprobe:cudaFree; removed uprobe:cudaMalloc",
+				ContainSubstring("AgentsUpdated"),
+			))
+		})
+
+		It("should fall back to the field manager of the spec", func() {
+			changedAt := metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+			policy := &gpuv1alpha1.CudaEBPFPolicy{ObjectMeta: metav1.ObjectMeta{
+				ManagedFields: []metav1.ManagedFieldsEntry{
+					{Manager: "kubectl-edit", Time: &changedAt, FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:mode":{}}}`)}},
+					{Manager: "manager", Subresource: "status", Time: &metav1.Time{Time: time.Now()},
+						FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:status":{"f:phase":{}}}`)}},
+				},
+			}}
+			user, at := changeAuthor(policy)
+			Expect(user).To(Equal("kubectl-edit"))
+			Expect(at).To(BeTemporally("==", changedAt.Time))
+		})
+	})
+
+	Context("When shipping the bpftrace script", func() {
+		const resourceName = "scripted-resource"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default",
+		}
+
+		BeforeEach(func() {
+			resource := &gpuv1alpha1.CudaEBPFPolicy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      resourceName,
+					Namespace: "default",
+				},
+				Spec: gpuv1alpha1.CudaEBPFPolicySpec{
+					LibPath:   "/usr/lib/libcudart.so",
+					Image:     "test-image:latest",
+					Mode:      "systemwide",
+					Functions: []gpuv1alpha1.Function{{Name: "cudaMalloc", Kind: "uprobe"}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			resource := &gpuv1alpha1.CudaEBPFPolicy{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Finalizers = nil
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, resource))).To(Succeed())
+			ds := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: resourceName, Namespace: "default"}}
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, ds))).To(Succeed())
+			cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: resource.Status.ScriptConfigMap, Namespace: "default"}}
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, cm))).To(Succeed())
+		})
+
+		It("should store the rendered program in a content-addressed ConfigMap", func() {
+			controllerReconciler := &CudaEBPFPolicyReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			By("referencing the program in the policy status")
+			policy := &gpuv1alpha1.CudaEBPFPolicy{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, policy)).To(Succeed())
+			Expect(policy.Status.ScriptHash).To(HaveLen(10))
+			Expect(policy.Status.ScriptConfigMap).To(Equal(resourceName + "-script-" + policy.Status.ScriptHash))
+			firstScript := policy.Status.ScriptConfigMap
+
+			cm := &corev1.ConfigMap{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: firstScript, Namespace: "default"}, cm)).To(Succeed())
+			Expect(cm.Data["nvidia_events.bt"]).To(ContainSubstring("uprobe:/usr/lib/libcudart.so:cudaMalloc"))
+			Expect(*cm.Immutable).To(BeTrue())
+
+			By("mounting the program into the agents")
+			ds := &appsv1.DaemonSet{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, ds)).To(Succeed())
+			Expect(ds.Spec.Template.Spec.Containers[0].Env).To(ContainElement(
+				corev1.EnvVar{Name: "SCRIPT_PATH", Value: "/etc/gpu-bpf/script/nvidia_events.bt"}))
+			Expect(ds.Spec.Template.Spec.Containers[0].Env).NotTo(ContainElement(HaveField("Name", "PROBE_CALLS")))
+			Expect(ds.Spec.Template.Spec.Volumes).To(ContainElement(HaveField("ConfigMap.Name", firstScript)))
+
+			By("replacing the ConfigMap when the program changes")
+			policy.Spec.Functions = append(policy.Spec.Functions, gpuv1alpha1.Function{Name: "cudaFree", Kind: "uprobe"})
+			Expect(k8sClient.Update(ctx, policy)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, policy)).To(Succeed())
+			Expect(policy.Status.ScriptConfigMap).NotTo(Equal(firstScript))
+			Expect(k8sClient.Get(ctx, typeNamespacedName, ds)).To(Succeed())
+			Expect(ds.Spec.Template.Spec.Volumes).To(ContainElement(HaveField("ConfigMap.Name", policy.Status.ScriptConfigMap)))
+
+			By("keeping the previous ConfigMap while agents still mount it")
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: firstScript, Namespace: "default"}, cm)).To(Succeed())
+			ds.Status = appsv1.DaemonSetStatus{
+				ObservedGeneration:     ds.Generation,
+				DesiredNumberScheduled: 2,
+				UpdatedNumberScheduled: 1,
+				NumberAvailable:        2,
+			}
+			Expect(k8sClient.Status().Update(ctx, ds)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: firstScript, Namespace: "default"}, cm)).To(Succeed())
+
+			By("removing the previous ConfigMap once the rollout finished")
+			Expect(k8sClient.Get(ctx, typeNamespacedName, ds)).To(Succeed())
+			ds.Status.UpdatedNumberScheduled = 2
+			Expect(k8sClient.Status().Update(ctx, ds)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, types.NamespacedName{Name: firstScript, Namespace: "default"}, cm))).To(BeTrue())
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: policy.Status.ScriptConfigMap, Namespace: "default"}, cm)).To(Succeed())
+		})
+
+		It("should report agents that are still rolling out", func() {
+			controllerReconciler := &CudaEBPFPolicyReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			policy := &gpuv1alpha1.CudaEBPFPolicy{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, policy)).To(Succeed())
+			Expect(policy.Status.RolloutInProgress).To(BeTrue())
+			ready := meta.FindStatusCondition(policy.Status.Conditions, gpuv1alpha1.ConditionReady)
+			Expect(ready).NotTo(BeNil())
+			Expect(ready.Status).To(Equal(metav1.ConditionFalse))
+			Expect(ready.Reason).To(Equal("RollingOut"))
+
+			By("clearing the flag once every agent runs the latest template")
+			ds := &appsv1.DaemonSet{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, ds)).To(Succeed())
+			ds.Status = appsv1.DaemonSetStatus{
+				ObservedGeneration:     ds.Generation,
+				DesiredNumberScheduled: 2,
+				UpdatedNumberScheduled: 2,
+				NumberAvailable:        2,
+			}
+			Expect(k8sClient.Status().Update(ctx, ds)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, policy)).To(Succeed())
+			Expect(policy.Status.RolloutInProgress).To(BeFalse())
+			Expect(meta.IsStatusConditionTrue(policy.Status.Conditions, gpuv1alpha1.ConditionReady)).To(BeTrue())
+		})
+	})
+
+	Context("When previewing the bpftrace script", func() {
+		const resourceName = "preview-resource"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default",
+		}
+
+		BeforeEach(func() {
+			resource := &gpuv1alpha1.CudaEBPFPolicy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        resourceName,
+					Namespace:   "default",
+					Annotations: map[string]string{gpuv1alpha1.DryRunAnnotation: "true"},
+				},
+				Spec: gpuv1alpha1.CudaEBPFPolicySpec{
+					LibPath:   "/usr/lib/libcudart.so",
+					Image:     "test-image:latest",
+					Mode:      "systemwide",
+					Functions: []gpuv1alpha1.Function{{Name: "cudaMalloc", Kind: "uprobe"}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			resource := &gpuv1alpha1.CudaEBPFPolicy{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Finalizers = nil
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, resource))).To(Succeed())
+			ds := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: resourceName, Namespace: "default"}}
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, ds))).To(Succeed())
+			cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: resource.Status.ScriptConfigMap, Namespace: "default"}}
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, cm))).To(Succeed())
+		})
+
+		It("should render the program into status without deploying agents", func() {
+			controllerReconciler := &CudaEBPFPolicyReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			policy := &gpuv1alpha1.CudaEBPFPolicy{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, policy)).To(Succeed())
+			Expect(policy.Status.Phase).To(Equal("DryRun"))
+			Expect(policy.Status.Preview).NotTo(BeNil())
+			Expect(policy.Status.Preview.Script).To(ContainSubstring("uprobe:/usr/lib/libcudart.so:cudaMalloc"))
+			Expect(policy.Status.Preview.Hash).To(HaveLen(10))
+			Expect(policy.Status.ScriptConfigMap).To(BeEmpty())
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, typeNamespacedName, &appsv1.DaemonSet{}))).To(BeTrue())
+
+			By("keeping the preview while the program is unchanged")
+			generated := policy.Status.Preview.GeneratedTime
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, policy)).To(Succeed())
+			Expect(policy.Status.Preview.GeneratedTime.Equal(&generated)).To(BeTrue())
+
+			By("reporting warnings found while rendering")
+			policy.Spec.Probes = []string{"nvidia_close"}
+			Expect(k8sClient.Update(ctx, policy)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, policy)).To(Succeed())
+			Expect(policy.Status.Preview.Warnings).To(ConsistOf(ContainSubstring("nvidia_close")))
+
+			By("deploying the agents once the annotation is removed")
+			delete(policy.Annotations, gpuv1alpha1.DryRunAnnotation)
+			Expect(k8sClient.Update(ctx, policy)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, policy)).To(Succeed())
+			Expect(policy.Status.Phase).To(Equal("Active"))
+			Expect(policy.Status.Preview).To(BeNil())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &appsv1.DaemonSet{})).To(Succeed())
+		})
+	})
+
+	Context("When building the agent DaemonSet", func() {
+		It("should configure the OTLP export of the agents", func() {
+			policy := &gpuv1alpha1.CudaEBPFPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "otlp-policy", Namespace: "default"},
+				Spec: gpuv1alpha1.CudaEBPFPolicySpec{
+					LibPath:   "/usr/lib/libcudart.so",
+					Image:     "test-image:latest",
+					Functions: []gpuv1alpha1.Function{{Name: "cudaLaunchKernel", Kind: "uprobe", Trace: true}},
+					OTLP: &gpuv1alpha1.OTLPExport{
+						Endpoint:       "http://otel-collector:4318",
+						MetricInterval: &metav1.Duration{Duration: 30 * time.Second},
+					},
+				},
+			}
+			controllerReconciler := &CudaEBPFPolicyReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			ds, err := controllerReconciler.createDaemonsetProbeAgent(policy)
+			Expect(err).NotTo(HaveOccurred())
+			env := ds.Spec.Template.Spec.Containers[0].Env
+			Expect(env).To(ContainElements(
+				corev1.EnvVar{Name: "POLICY_NAME", Value: "otlp-policy"},
+				corev1.EnvVar{Name: "OTEL_EXPORTER_OTLP_ENDPOINT", Value: "http://otel-collector:4318"},
+				corev1.EnvVar{Name: "OTEL_METRIC_EXPORT_INTERVAL", Value: "30000"},
+			))
+		})
+
+		It("should merge the pod template overrides onto the agents", func() {
+			policy := &gpuv1alpha1.CudaEBPFPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "template-policy", Namespace: "default"},
+				Spec: gpuv1alpha1.CudaEBPFPolicySpec{
+					LibPath:         "/usr/lib/libcudart.so",
+					Image:           "test-image:latest",
+					ImagePullPolicy: corev1.PullIfNotPresent,
+					PodTemplate: &gpuv1alpha1.AgentPodTemplate{
+						Labels: map[string]string{"app": "other", "team": "ml-infra"},
+						Resources: &corev1.ResourceRequirements{
+							Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("512Mi")},
+						},
+						Tolerations: []corev1.Toleration{{
+							Key:      "nvidia.com/gpu",
+							Operator: corev1.TolerationOpExists,
+							Effect:   corev1.TaintEffectNoSchedule,
+						}},
+						PriorityClassName:  "system-node-critical",
+						ServiceAccountName: "gpu-bpf-agent",
+						ImagePullSecrets:   []corev1.LocalObjectReference{{Name: "registry"}},
+						Env: []corev1.EnvVar{
+							{Name: "LIB_PATH", Value: "/opt/cuda/lib64/libcudart.so"},
+							{Name: "GOMAXPROCS", Value: "2"},
+						},
+						Volumes: []corev1.Volume{{
+							Name:         "cuda",
+							VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/opt/cuda"}},
+						}},
+						VolumeMounts: []corev1.VolumeMount{{Name: "cuda", MountPath: "/opt/cuda", ReadOnly: true}},
+					},
+				},
+			}
+			controllerReconciler := &CudaEBPFPolicyReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			ds, err := controllerReconciler.createDaemonsetProbeAgent(policy)
+			Expect(err).NotTo(HaveOccurred())
+			template := ds.Spec.Template
+			container := template.Spec.Containers[0]
+
+			By("keeping the selector labels")
+			Expect(template.Labels).To(Equal(map[string]string{"app": "gpu-operator", "gpu.obs.gpu/policy": "template-policy", "team": "ml-infra"}))
+			Expect(ds.Spec.Selector.MatchLabels).To(Equal(map[string]string{"app": "gpu-operator", "gpu.obs.gpu/policy": "template-policy"}))
+
+			By("scheduling onto tainted GPU nodes")
+			Expect(template.Spec.Tolerations).To(HaveLen(1))
+			Expect(template.Spec.PriorityClassName).To(Equal("system-node-critical"))
+			Expect(template.Spec.ServiceAccountName).To(Equal("gpu-bpf-agent"))
+			Expect(template.Spec.ImagePullSecrets).To(ConsistOf(corev1.LocalObjectReference{Name: "registry"}))
+			Expect(container.Resources.Limits.Memory().String()).To(Equal("512Mi"))
+			Expect(container.ImagePullPolicy).To(Equal(corev1.PullIfNotPresent))
+
+			By("merging env vars by name, keeping the generated values")
+			Expect(container.Env).To(ContainElements(
+				corev1.EnvVar{Name: "LIB_PATH", Value: "/usr/lib/libcudart.so"},
+				corev1.EnvVar{Name: "GOMAXPROCS", Value: "2"},
+			))
+			Expect(container.Env).NotTo(ContainElement(corev1.EnvVar{Name: "LIB_PATH", Value: "/opt/cuda/lib64/libcudart.so"}))
+			Expect(container.Env).To(ContainElement(HaveField("Name", "SCRIPT_PATH")))
+
+			By("rendering the program against spec.libPath")
+			policy.Spec.Functions = []gpuv1alpha1.Function{{Name: "cudaMalloc", Kind: "uprobe"}}
+			program, err := renderScript(policy)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(program).To(ContainSubstring("uprobe:/usr/lib/libcudart.so:cudaMalloc"))
+
+			By("adding the extra volumes")
+			Expect(container.VolumeMounts).To(ContainElement(corev1.VolumeMount{Name: "cuda", MountPath: "/opt/cuda", ReadOnly: true}))
+			Expect(template.Spec.Volumes).To(ContainElement(HaveField("Name", "cuda")))
+			Expect(template.Spec.Volumes).To(ContainElement(HaveField("Name", "tmp")))
+		})
+
+		It("should grant only the capabilities the probes need", func() {
+			controllerReconciler := &CudaEBPFPolicyReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			By("building the agents of a kernel probe policy")
+			policy := &gpuv1alpha1.CudaEBPFPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "kernel-policy", Namespace: "default"},
+				Spec: gpuv1alpha1.CudaEBPFPolicySpec{
+					LibPath:   "/usr/lib/libcudart.so",
+					Image:     "test-image:latest",
+					Mode:      "systemwide",
+					Probes:    []string{"nvidia_open"},
+					Functions: []gpuv1alpha1.Function{{Name: "nvidia_mmap", Kind: "kprobe"}},
+				},
+			}
+			ds, err := controllerReconciler.createDaemonsetProbeAgent(policy)
+			Expect(err).NotTo(HaveOccurred())
+			container := ds.Spec.Template.Spec.Containers[0]
+			Expect(container.SecurityContext.Capabilities.Drop).To(ConsistOf(corev1.Capability("ALL")))
+			Expect(container.SecurityContext.Capabilities.Add).To(ConsistOf(corev1.Capability("BPF"), corev1.Capability("PERFMON")))
+			Expect(*container.SecurityContext.ReadOnlyRootFilesystem).To(BeTrue())
+			Expect(*container.SecurityContext.AllowPrivilegeEscalation).To(BeFalse())
+			Expect(ds.Spec.Template.Spec.Volumes).To(ConsistOf(HaveField("Name", "tmp"), HaveField("Name", "sys-kernel-debug"), HaveField("Name", "agent-script")))
+
+			By("building the agents of a uprobe policy on legacy kernels")
+			policy.Spec.Probes = nil
+			policy.Spec.Functions = []gpuv1alpha1.Function{{Name: "cudaMalloc", Kind: "uprobe"}}
+			policy.Spec.Security = &gpuv1alpha1.AgentSecurity{
+				LegacyKernel:   true,
+				SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+			}
+			ds, err = controllerReconciler.createDaemonsetProbeAgent(policy)
+			Expect(err).NotTo(HaveOccurred())
+			container = ds.Spec.Template.Spec.Containers[0]
+			Expect(container.SecurityContext.Capabilities.Add).To(ConsistOf(
+				corev1.Capability("SYS_ADMIN"), corev1.Capability("SYS_RESOURCE"), corev1.Capability("SYS_PTRACE"),
+			))
+			Expect(container.SecurityContext.Capabilities.Add).NotTo(ContainElement(corev1.Capability("NET_ADMIN")))
+			Expect(container.SecurityContext.SeccompProfile.Type).To(Equal(corev1.SeccompProfileTypeRuntimeDefault))
+			Expect(ds.Spec.Template.Spec.Volumes).To(ContainElement(HaveField("Name", "lib-modules")))
+			Expect(ds.Spec.Template.Spec.Volumes).NotTo(ContainElement(HaveField("Name", "sys-kernel-debug")))
+		})
+
+		It("should probe the health and readiness endpoints of the agents", func() {
+			policy := &gpuv1alpha1.CudaEBPFPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "probed-policy", Namespace: "default"},
+				Spec: gpuv1alpha1.CudaEBPFPolicySpec{
+					LibPath: "/usr/lib/libcudart.so",
+					Image:   "test-image:latest",
+				},
+			}
+			controllerReconciler := &CudaEBPFPolicyReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			ds, err := controllerReconciler.createDaemonsetProbeAgent(policy)
+			Expect(err).NotTo(HaveOccurred())
+			container := ds.Spec.Template.Spec.Containers[0]
+			Expect(container.LivenessProbe).NotTo(BeNil())
+			Expect(container.LivenessProbe.HTTPGet.Path).To(Equal("/healthz"))
+			Expect(container.LivenessProbe.HTTPGet.Port.StrVal).To(Equal(container.Ports[0].Name))
+			Expect(container.ReadinessProbe).NotTo(BeNil())
+			Expect(container.ReadinessProbe.HTTPGet.Path).To(Equal("/readyz"))
+		})
+
+		It("should pass the output sinks to the agents", func() {
+			policy := &gpuv1alpha1.CudaEBPFPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "sink-policy", Namespace: "default"},
+				Spec: gpuv1alpha1.CudaEBPFPolicySpec{
+					LibPath: "/usr/lib/libcudart.so",
+					Image:   "test-image:latest",
+					Sinks: []gpuv1alpha1.OutputSink{
+						{Name: "local", File: &gpuv1alpha1.FileSink{Path: "/var/log/gpu-bpf"}},
+						{Name: "bus", Kafka: &gpuv1alpha1.KafkaSink{Brokers: []string{"kafka:9092"}, Topic: "gpu-events"}},
+					},
+				},
+			}
+			controllerReconciler := &CudaEBPFPolicyReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			ds, err := controllerReconciler.createDaemonsetProbeAgent(policy)
+			Expect(err).NotTo(HaveOccurred())
+			encoded, err := controllerReconciler.EncodeOutputSinks(policy)
+			Expect(err).NotTo(HaveOccurred())
+			container := ds.Spec.Template.Spec.Containers[0]
+			Expect(container.Env).To(ContainElement(corev1.EnvVar{Name: "OUTPUT_SINKS", Value: encoded}))
+
+			By("mounting the directory of the file sink from the node")
+			Expect(container.VolumeMounts).To(ContainElement(corev1.VolumeMount{Name: "sink-local", MountPath: "/var/log/gpu-bpf"}))
+			var sinkVolume *corev1.Volume
+			for i := range ds.Spec.Template.Spec.Volumes {
+				if ds.Spec.Template.Spec.Volumes[i].Name == "sink-local" {
+					sinkVolume = &ds.Spec.Template.Spec.Volumes[i]
+				}
+			}
+			Expect(sinkVolume).NotTo(BeNil())
+			Expect(sinkVolume.HostPath.Path).To(Equal("/var/log/gpu-bpf"))
+			Expect(*sinkVolume.HostPath.Type).To(Equal(corev1.HostPathDirectoryOrCreate))
+		})
+
+		It("should configure the event queue of the agents", func() {
+			policy := &gpuv1alpha1.CudaEBPFPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "buffered-policy", Namespace: "default"},
+				Spec: gpuv1alpha1.CudaEBPFPolicySpec{
+					LibPath: "/usr/lib/libcudart.so",
+					Image:   "test-image:latest",
+					Buffering: &gpuv1alpha1.EventBuffering{
+						QueueSize: 50000,
+						Policy:    "dropNewest",
+						Spill:     &gpuv1alpha1.BufferSpill{Path: "/var/spool/gpu-bpf", MaxSizeMB: 512},
+					},
+				},
+			}
+			controllerReconciler := &CudaEBPFPolicyReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			ds, err := controllerReconciler.createDaemonsetProbeAgent(policy)
+			Expect(err).NotTo(HaveOccurred())
+			container := ds.Spec.Template.Spec.Containers[0]
+			Expect(container.Env).To(ContainElements(
+				corev1.EnvVar{Name: "EVENT_QUEUE_SIZE", Value: "50000"},
+				corev1.EnvVar{Name: "EVENT_DROP_POLICY", Value: "dropNewest"},
+				corev1.EnvVar{Name: "EVENT_SPILL_DIR", Value: "/var/spool/gpu-bpf"},
+				corev1.EnvVar{Name: "EVENT_SPILL_MAX_MB", Value: "512"},
+			))
+			Expect(container.VolumeMounts).To(ContainElement(corev1.VolumeMount{Name: "event-spill", MountPath: "/var/spool/gpu-bpf"}))
+		})
+	})
+
+	Context("When scoping policies to their namespace", func() {
+		ctx := context.Background()
+		isController := true
+
+		newPod := func(name, namespace string, labels map[string]string) *corev1.Pod {
+			return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				UID:       types.UID(name + "-uid"),
+				Labels:    labels,
+			}}
+		}
+
+		BeforeEach(func() {
+			agent := newPod("tenant-agent", "default", map[string]string{"app": "gpu-operator"})
+			agent.OwnerReferences = []metav1.OwnerReference{{
+				APIVersion: "apps/v1",
+				Kind:       "DaemonSet",
+				Name:       "tenant-policy",
+				UID:        "00000000-0000-0000-0000-000000000003",
+				Controller: &isController,
+			}}
+			for _, pod := range []*corev1.Pod{
+				newPod("trainer", "default", map[string]string{"app": "trainer"}),
+				newPod("notebook", "default", map[string]string{"app": "notebook"}),
+				newPod("other-team", "research", map[string]string{"app": "trainer"}),
+				agent,
+			} {
+				Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+			}
+		})
+
+		AfterEach(func() {
+			for _, key := range []types.NamespacedName{
+				{Name: "trainer", Namespace: "default"},
+				{Name: "notebook", Namespace: "default"},
+				{Name: "other-team", Namespace: "research"},
+				{Name: "tenant-agent", Namespace: "default"},
+			} {
+				pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace}}
+				Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, pod))).To(Succeed())
+			}
+			cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "tenant-policy-pods", Namespace: "default"}}
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, cm))).To(Succeed())
+		})
+
+		It("should only hand the selected pods of the namespace to the agents", func() {
+			policy := &gpuv1alpha1.CudaEBPFPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "tenant-policy", Namespace: "default", UID: "00000000-0000-0000-0000-000000000003"},
+				Spec: gpuv1alpha1.CudaEBPFPolicySpec{
+					LibPath:     "/usr/lib/libcudart.so",
+					Image:       "test-image:latest",
+					Mode:        "pidwatch",
+					PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "trainer"}},
+				},
+			}
+			controllerReconciler := &CudaEBPFPolicyReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			By("writing the pod UIDs to a ConfigMap")
+			Expect(controllerReconciler.reconcileTenantPods(ctx, policy)).To(Succeed())
+			cm := &corev1.ConfigMap{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "tenant-policy-pods", Namespace: "default"}, cm)).To(Succeed())
+			Expect(cm.Data["pod-uids"]).To(Equal("trainer-uid"))
+
+			By("following pods of the namespace")
+			policy.Spec.PodSelector = nil
+			Expect(controllerReconciler.reconcileTenantPods(ctx, policy)).To(Succeed())
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "tenant-policy-pods", Namespace: "default"}, cm)).To(Succeed())
+			Expect(cm.Data["pod-uids"]).To(Equal("notebook-uid\ntrainer-uid"))
+
+			By("mounting the ConfigMap into the agents")
+			ds, err := controllerReconciler.createDaemonsetProbeAgent(policy)
+			Expect(err).NotTo(HaveOccurred())
+			container := ds.Spec.Template.Spec.Containers[0]
+			Expect(container.Env).To(ContainElement(corev1.EnvVar{Name: "POD_UIDS_FILE", Value: "/etc/gpu-bpf/pods/pod-uids"}))
+			Expect(ds.Spec.Template.Spec.Volumes).To(ContainElement(HaveField("ConfigMap.Name", "tenant-policy-pods")))
+		})
+
+		It("should let systemwide policies see every process", func() {
+			policy := &gpuv1alpha1.CudaEBPFPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "fleet-policy", Namespace: "default"},
+				Spec: gpuv1alpha1.CudaEBPFPolicySpec{
+					LibPath: "/usr/lib/libcudart.so",
+					Image:   "test-image:latest",
+					Mode:    "systemwide",
+				},
+			}
+			controllerReconciler := &CudaEBPFPolicyReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			ds, err := controllerReconciler.createDaemonsetProbeAgent(policy)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ds.Spec.Template.Spec.Containers[0].Env).NotTo(ContainElement(HaveField("Name", "POD_UIDS_FILE")))
+			Expect(ds.Spec.Template.Spec.Volumes).NotTo(ContainElement(HaveField("Name", "tenant-pods")))
+		})
+	})
 })
+
+// fakeAgentStatus reports canned failures by agent pod name.
+type fakeAgentStatus map[string]*gpuv1alpha1.AgentFailure
+
+func (f fakeAgentStatus) LastFailure(_ context.Context, pod *corev1.Pod) (*gpuv1alpha1.AgentFailure, error) {
+	failure, ok := f[pod.Name]
+	if !ok {
+		return nil, nil
+	}
+	return failure.DeepCopy(), nil
+}
+
+// failingDeletes is a client that cannot delete anything.
+type failingDeletes struct {
+	client.Client
+}
+
+func (c failingDeletes) Delete(context.Context, client.Object, ...client.DeleteOption) error {
+	return fmt.Errorf("deletes are forbidden")
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2025 WoodProgrammer.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	gpuv1alpha1 "github.com/WoodProgrammer/gpu-bpf-operator/api/v1alpha1"
+)
+
+func agentNetworkPolicyName(policy *gpuv1alpha1.CudaEBPFPolicy) string {
+	return policy.Name + "-agents"
+}
+
+// agentNetworkPolicySpec limits who may reach the agents of a policy. The
+// agent port serves the events of every process the policy traces, which
+// for cluster policies covers whole nodes. Only the operator, the trace
+// capture collectors of the agent namespace and Prometheus in namespaces
+// labelled metrics: enabled get through.
+func (r *CudaEBPFPolicyReconciler) agentNetworkPolicySpec(policy *gpuv1alpha1.CudaEBPFPolicy, owner client.Object) networkingv1.NetworkPolicySpec {
+	protocol := corev1.ProtocolTCP
+	port := intstr.FromInt32(agentPort)
+	operatorNamespaces := &metav1.LabelSelector{}
+	if r.OperatorNamespace != "" {
+		operatorNamespaces.MatchLabels = map[string]string{corev1.LabelMetadataName: r.OperatorNamespace}
+	}
+	return networkingv1.NetworkPolicySpec{
+		PodSelector: metav1.LabelSelector{MatchLabels: agentLabels(policy, owner)},
+		PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+		Ingress: []networkingv1.NetworkPolicyIngressRule{{
+			From: []networkingv1.NetworkPolicyPeer{
+				{
+					NamespaceSelector: operatorNamespaces,
+					PodSelector:       &metav1.LabelSelector{MatchLabels: map[string]string{"control-plane": "controller-manager"}},
+				},
+				{
+					PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "gpu-operator-capture"}},
+				},
+				{
+					NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"metrics": "enabled"}},
+				},
+			},
+			Ports: []networkingv1.NetworkPolicyPort{{Protocol: &protocol, Port: &port}},
+		}},
+	}
+}
+
+// reconcileNetworkPolicy keeps the NetworkPolicy in front of the agents of
+// a policy up to date.
+func (r *CudaEBPFPolicyReconciler) reconcileNetworkPolicy(ctx context.Context, policy *gpuv1alpha1.CudaEBPFPolicy, owner client.Object) error {
+	log := logf.FromContext(ctx)
+	spec := r.agentNetworkPolicySpec(policy, owner)
+
+	found := &networkingv1.NetworkPolicy{}
+	err := r.Get(ctx, types.NamespacedName{Name: agentNetworkPolicyName(policy), Namespace: policy.Namespace}, found)
+	if errors.IsNotFound(err) {
+		np := &networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: agentNetworkPolicyName(policy), Namespace: policy.Namespace},
+			Spec:       spec,
+		}
+		if err := ctrl.SetControllerReference(owner, np, r.Scheme); err != nil {
+			return err
+		}
+		log.Info("Creating agent NetworkPolicy", "NetworkPolicy.Namespace", np.Namespace, "NetworkPolicy.Name", np.Name)
+		return r.Create(ctx, np)
+	} else if err != nil {
+		log.Error(err, "Failed to get agent NetworkPolicy")
+		return err
+	}
+
+	if equality.Semantic.DeepEqual(found.Spec, spec) {
+		return nil
+	}
+	found.Spec = spec
+	return r.Update(ctx, found)
+}
@@ -19,7 +19,11 @@ package controller
 import (
 	"context"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
@@ -31,11 +35,14 @@ import (
 type ProbeTargetBindingReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+	// Recorder emits events on bindings. No events are emitted when it is nil.
+	Recorder record.EventRecorder
 }
 
 // +kubebuilder:rbac:groups=gpu.obs.gpu,resources=probetargetbindings,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=gpu.obs.gpu,resources=probetargetbindings/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=gpu.obs.gpu,resources=probetargetbindings/finalizers,verbs=update
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -47,9 +54,30 @@ type ProbeTargetBindingReconciler struct {
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.21.0/pkg/reconcile
 func (r *ProbeTargetBindingReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	_ = logf.FromContext(ctx)
+	log := logf.FromContext(ctx)
 
-	// TODO(user): your logic here
+	binding := &gpuv1alpha1.ProbeTargetBinding{}
+	if err := r.Get(ctx, req.NamespacedName, binding); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if binding.Spec.PolicyRef == "" {
+		return ctrl.Result{}, nil
+	}
+
+	// Surface dangling references on the binding
+	policy := &gpuv1alpha1.CudaEBPFPolicy{}
+	err := r.Get(ctx, types.NamespacedName{Name: binding.Spec.PolicyRef, Namespace: binding.Namespace}, policy)
+	if errors.IsNotFound(err) {
+		log.Info("Referenced CudaEBPFPolicy not found", "policy", binding.Spec.PolicyRef)
+		if r.Recorder != nil {
+			r.Recorder.Eventf(binding, corev1.EventTypeWarning, "PolicyNotFound",
+				"CudaEBPFPolicy %s/%s does not exist", binding.Namespace, binding.Spec.PolicyRef)
+		}
+		return ctrl.Result{}, nil
+	} else if err != nil {
+		log.Error(err, "Failed to get CudaEBPFPolicy")
+		return ctrl.Result{}, err
+	}
 
 	return ctrl.Result{}, nil
 }
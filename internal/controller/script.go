@@ -0,0 +1,163 @@
+/*
+Copyright 2025 WoodProgrammer.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	gpuv1alpha1 "github.com/WoodProgrammer/gpu-bpf-operator/api/v1alpha1"
+	"github.com/WoodProgrammer/gpu-bpf-operator/internal/script"
+)
+
+const (
+	// scriptVolume holds the bpftrace program rendered for a policy. Its
+	// ConfigMap is named after the hash of the program, so that agents roll
+	// whenever the program changes.
+	scriptVolume    = "agent-script"
+	scriptMountPath = "/etc/gpu-bpf/script"
+)
+
+// renderScript renders the bpftrace program of a policy.
+func renderScript(policy *gpuv1alpha1.CudaEBPFPolicy) (string, error) {
+	return script.Render(&policy.Spec, policy.Spec.LibPath)
+}
+
+func scriptConfigMapName(policy *gpuv1alpha1.CudaEBPFPolicy, hash string) string {
+	return policy.Name + "-script-" + hash
+}
+
+// scriptMount mounts the script ConfigMap with the given hash into the agents.
+func scriptMount(policy *gpuv1alpha1.CudaEBPFPolicy, hash string) (corev1.Volume, corev1.VolumeMount, corev1.EnvVar) {
+	volume := corev1.Volume{
+		Name: scriptVolume,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: scriptConfigMapName(policy, hash)},
+			},
+		},
+	}
+	mount := corev1.VolumeMount{Name: scriptVolume, MountPath: scriptMountPath, ReadOnly: true}
+	env := corev1.EnvVar{Name: "SCRIPT_PATH", Value: scriptMountPath + "/" + script.FileName}
+	return volume, mount, env
+}
+
+// reconcileScript stores the rendered program of a policy in an immutable
+// ConfigMap owned by owner and returns the hash of the program. The
+// ConfigMaps of previous programs are left to reconcileSession, agents that
+// were not updated yet still mount them.
+func (r *CudaEBPFPolicyReconciler) reconcileScript(ctx context.Context, policy *gpuv1alpha1.CudaEBPFPolicy, owner client.Object) (string, error) {
+	log := logf.FromContext(ctx)
+
+	program, err := renderScript(policy)
+	if err != nil {
+		log.Error(err, "Failed to render bpftrace script")
+		return "", err
+	}
+	hash := script.Hash(program)
+	name := scriptConfigMapName(policy, hash)
+
+	err = r.Get(ctx, types.NamespacedName{Name: name, Namespace: policy.Namespace}, &corev1.ConfigMap{})
+	if errors.IsNotFound(err) {
+		immutable := true
+		cm := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: policy.Namespace},
+			Data:       map[string]string{script.FileName: program},
+			Immutable:  &immutable,
+		}
+		if err := ctrl.SetControllerReference(owner, cm, r.Scheme); err != nil {
+			return "", err
+		}
+		log.Info("Creating script ConfigMap", "ConfigMap.Namespace", cm.Namespace, "ConfigMap.Name", cm.Name)
+		if err := r.Create(ctx, cm); err != nil {
+			log.Error(err, "Failed to create script ConfigMap")
+			return "", err
+		}
+	} else if err != nil {
+		log.Error(err, "Failed to get script ConfigMap")
+		return "", err
+	}
+	return hash, nil
+}
+
+// deleteScripts removes the script ConfigMaps of a policy except for keep.
+func (r *CudaEBPFPolicyReconciler) deleteScripts(ctx context.Context, policy *gpuv1alpha1.CudaEBPFPolicy, owner client.Object, keep string) error {
+	scripts := &corev1.ConfigMapList{}
+	if err := r.List(ctx, scripts, client.InNamespace(policy.Namespace)); err != nil {
+		logf.FromContext(ctx).Error(err, "Failed to list script ConfigMaps")
+		return err
+	}
+	for i := range scripts.Items {
+		name := scripts.Items[i].Name
+		if name == keep || !strings.HasPrefix(name, policy.Name+"-script-") || !metav1.IsControlledBy(&scripts.Items[i], owner) {
+			continue
+		}
+		if err := r.Delete(ctx, &scripts.Items[i]); err != nil && !errors.IsNotFound(err) {
+			logf.FromContext(ctx).Error(err, "Failed to delete script ConfigMap", "ConfigMap.Name", scripts.Items[i].Name)
+			return err
+		}
+	}
+	return nil
+}
+
+// reconcilePreview renders the program of a dry-run policy into
+// status.preview and keeps its agents stopped. The preview only changes,
+// and with it GeneratedTime, when the program or its warnings do.
+func (r *CudaEBPFPolicyReconciler) reconcilePreview(ctx context.Context, policy *gpuv1alpha1.CudaEBPFPolicy, owner client.Object,
+	now time.Time, status *gpuv1alpha1.CudaEBPFPolicyStatus) error {
+	if _, err := r.stopDaemonSet(ctx, policy, owner); err != nil {
+		return err
+	}
+	if err := r.deleteScripts(ctx, policy, owner, ""); err != nil {
+		return err
+	}
+
+	program, err := renderScript(policy)
+	if err != nil {
+		logf.FromContext(ctx).Error(err, "Failed to render bpftrace script")
+		return err
+	}
+	warnings := script.Warnings(&policy.Spec)
+	for _, problem := range script.Lint(program) {
+		warnings = append(warnings, problem.Error())
+	}
+	preview := &gpuv1alpha1.ScriptPreview{
+		Script:        program,
+		Hash:          script.Hash(program),
+		Warnings:      warnings,
+		GeneratedTime: metav1.NewTime(now),
+	}
+	if previous := status.Preview; previous == nil || previous.Hash != preview.Hash || !slices.Equal(previous.Warnings, preview.Warnings) {
+		status.Preview = preview
+	}
+	status.Phase = "DryRun"
+	status.NextSessionTime = nil
+	status.ScriptHash = ""
+	status.ScriptConfigMap = ""
+	status.RolloutInProgress = false
+	return nil
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2025 WoodProgrammer.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	gpuv1alpha1 "github.com/WoodProgrammer/gpu-bpf-operator/api/v1alpha1"
+)
+
+const (
+	// tenantPodsVolume holds the UIDs of the pods a namespace-scoped policy
+	// may trace, one per line. The agent drops events of all other processes.
+	tenantPodsVolume    = "tenant-pods"
+	tenantPodsMountPath = "/etc/gpu-bpf/pods"
+	tenantPodsKey       = "pod-uids"
+)
+
+// tracesNamespaceOnly reports whether the agents of a policy only report
+// processes of pods in the policy's namespace. Only systemwide policies,
+// which the webhook admits for users with host tracing permission, see
+// every process on the node.
+func tracesNamespaceOnly(policy *gpuv1alpha1.CudaEBPFPolicy) bool {
+	return policy.Spec.Mode != "systemwide"
+}
+
+func tenantPodsConfigMapName(policy *gpuv1alpha1.CudaEBPFPolicy) string {
+	return policy.Name + "-pods"
+}
+
+// tenantPodsMount mounts the pod UIDs of a policy into its agents.
+func tenantPodsMount(policy *gpuv1alpha1.CudaEBPFPolicy) (corev1.Volume, corev1.VolumeMount, corev1.EnvVar) {
+	volume := corev1.Volume{
+		Name: tenantPodsVolume,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: tenantPodsConfigMapName(policy)},
+			},
+		},
+	}
+	mount := corev1.VolumeMount{Name: tenantPodsVolume, MountPath: tenantPodsMountPath, ReadOnly: true}
+	env := corev1.EnvVar{Name: "POD_UIDS_FILE", Value: tenantPodsMountPath + "/" + tenantPodsKey}
+	return volume, mount, env
+}
+
+// tenantPodUIDs lists the UIDs of the pods a namespace-scoped policy may
+// trace: the pods of its namespace matching its pod selector, except for
+// its own agents.
+func tenantPodUIDs(ctx context.Context, c client.Client, policy *gpuv1alpha1.CudaEBPFPolicy) ([]string, error) {
+	selector := labels.Everything()
+	if policy.Spec.PodSelector != nil {
+		var err error
+		if selector, err = metav1.LabelSelectorAsSelector(policy.Spec.PodSelector); err != nil {
+			return nil, err
+		}
+	}
+	pods := &corev1.PodList{}
+	if err := c.List(ctx, pods, client.InNamespace(policy.Namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+	var uids []string
+	for _, pod := range pods.Items {
+		if owner := metav1.GetControllerOf(&pod); owner != nil && owner.Kind == "DaemonSet" && owner.Name == policy.Name {
+			continue
+		}
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		uids = append(uids, string(pod.UID))
+	}
+	slices.Sort(uids)
+	return uids, nil
+}
+
+// reconcileTenantPods keeps the ConfigMap with the pod UIDs of a
+// namespace-scoped policy up to date.
+func (r *CudaEBPFPolicyReconciler) reconcileTenantPods(ctx context.Context, policy *gpuv1alpha1.CudaEBPFPolicy) error {
+	log := logf.FromContext(ctx)
+
+	uids, err := tenantPodUIDs(ctx, r.Client, policy)
+	if err != nil {
+		log.Error(err, "Failed to list the pods of the policy namespace")
+		return err
+	}
+	data := map[string]string{tenantPodsKey: strings.Join(uids, "\n")}
+
+	found := &corev1.ConfigMap{}
+	err = r.Get(ctx, types.NamespacedName{Name: tenantPodsConfigMapName(policy), Namespace: policy.Namespace}, found)
+	if errors.IsNotFound(err) {
+		cm := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: tenantPodsConfigMapName(policy), Namespace: policy.Namespace},
+			Data:       data,
+		}
+		if err := ctrl.SetControllerReference(policy, cm, r.Scheme); err != nil {
+			return err
+		}
+		log.Info("Creating pod UID ConfigMap", "ConfigMap.Namespace", cm.Namespace, "ConfigMap.Name", cm.Name)
+		return r.Create(ctx, cm)
+	} else if err != nil {
+		log.Error(err, "Failed to get pod UID ConfigMap")
+		return err
+	}
+
+	if found.Data[tenantPodsKey] == data[tenantPodsKey] {
+		return nil
+	}
+	found.Data = data
+	return r.Update(ctx, found)
+}
+
+// policiesForPod requests a reconcile of the namespace-scoped policies that
+// may trace a pod whenever pods of their namespace come and go.
+func (r *CudaEBPFPolicyReconciler) policiesForPod(ctx context.Context, obj client.Object) []reconcile.Request {
+	// Agent pods never belong to the traced workloads
+	if obj.GetLabels()["app"] == "gpu-operator" {
+		return nil
+	}
+	policies := &gpuv1alpha1.CudaEBPFPolicyList{}
+	if err := r.List(ctx, policies, client.InNamespace(obj.GetNamespace())); err != nil {
+		logf.FromContext(ctx).Error(err, "Failed to list policies for pod", "pod", obj.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range policies.Items {
+		if tracesNamespaceOnly(&policies.Items[i]) {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&policies.Items[i])})
+		}
+	}
+	return requests
+}
@@ -0,0 +1,358 @@
+/*
+Copyright 2025 WoodProgrammer.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	gpuv1alpha1 "github.com/WoodProgrammer/gpu-bpf-operator/api/v1alpha1"
+)
+
+const (
+	// captureRetryInterval is how often a pending capture looks for agents again
+	captureRetryInterval = 30 * time.Second
+	// captureGracePeriod is how long a collector may take beyond the capture
+	// duration to reach the agents and store the archive
+	captureGracePeriod = 5 * time.Minute
+	captureMountPath   = "/capture"
+	agentPort          = 9090
+)
+
+// TraceCaptureReconciler reconciles a TraceCapture object
+type TraceCaptureReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=gpu.obs.gpu,resources=tracecaptures,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=gpu.obs.gpu,resources=tracecaptures/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=gpu.obs.gpu,resources=tracecaptures/finalizers,verbs=update
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+
+// Reconcile starts a collector Job for a TraceCapture and tracks it until the
+// capture archive has been stored.
+func (r *TraceCaptureReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := logf.FromContext(ctx)
+
+	capture := &gpuv1alpha1.TraceCapture{}
+	if err := r.Get(ctx, req.NamespacedName, capture); err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("TraceCapture resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get TraceCapture")
+		return ctrl.Result{}, err
+	}
+
+	if capture.Status.Phase == "Succeeded" || capture.Status.Phase == "Failed" {
+		return ctrl.Result{}, nil
+	}
+
+	job := &batchv1.Job{}
+	err := r.Get(ctx, types.NamespacedName{Name: collectorJobName(capture), Namespace: capture.Namespace}, job)
+	if err != nil && errors.IsNotFound(err) {
+		return r.startCapture(ctx, capture)
+	} else if err != nil {
+		log.Error(err, "Failed to get collector Job")
+		return ctrl.Result{}, err
+	}
+
+	status := capture.Status.DeepCopy()
+	for _, cond := range job.Status.Conditions {
+		if cond.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch cond.Type {
+		case batchv1.JobComplete:
+			status.Phase = "Succeeded"
+			status.Message = ""
+			status.CompletionTime = cond.LastTransitionTime.DeepCopy()
+		case batchv1.JobFailed:
+			status.Phase = "Failed"
+			status.Message = fmt.Sprintf("collector failed: %s", cond.Message)
+			status.CompletionTime = cond.LastTransitionTime.DeepCopy()
+		}
+	}
+	return ctrl.Result{}, r.updateCaptureStatus(ctx, capture, status)
+}
+
+// startCapture resolves the agents and pods of a capture and creates its
+// collector Job.
+func (r *TraceCaptureReconciler) startCapture(ctx context.Context, capture *gpuv1alpha1.TraceCapture) (ctrl.Result, error) {
+	log := logf.FromContext(ctx)
+	status := capture.Status.DeepCopy()
+
+	if capture.Spec.Duration.Duration <= 0 {
+		status.Phase = "Failed"
+		status.Message = "duration must be positive"
+		return ctrl.Result{}, r.updateCaptureStatus(ctx, capture, status)
+	}
+
+	policy := &gpuv1alpha1.CudaEBPFPolicy{}
+	err := r.Get(ctx, types.NamespacedName{Name: capture.Spec.PolicyRef, Namespace: capture.Namespace}, policy)
+	if err != nil && errors.IsNotFound(err) {
+		status.Phase = "Pending"
+		status.Message = fmt.Sprintf("CudaEBPFPolicy %s not found", capture.Spec.PolicyRef)
+		return ctrl.Result{RequeueAfter: captureRetryInterval}, r.updateCaptureStatus(ctx, capture, status)
+	} else if err != nil {
+		log.Error(err, "Failed to get CudaEBPFPolicy")
+		return ctrl.Result{}, err
+	}
+
+	targets, err := r.captureTargets(ctx, capture, policy)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if len(targets) == 0 {
+		status.Phase = "Pending"
+		status.Message = "no running agents match the capture"
+		return ctrl.Result{RequeueAfter: captureRetryInterval}, r.updateCaptureStatus(ctx, capture, status)
+	}
+
+	var podUIDs []string
+	if capture.Spec.PodSelector != nil {
+		if podUIDs, err = r.capturedPodUIDs(ctx, capture); err != nil {
+			return ctrl.Result{}, err
+		}
+		if len(podUIDs) == 0 {
+			status.Phase = "Pending"
+			status.Message = "no pods match podSelector"
+			return ctrl.Result{RequeueAfter: captureRetryInterval}, r.updateCaptureStatus(ctx, capture, status)
+		}
+	}
+
+	job, location, err := r.collectorJob(capture, policy, targets, podUIDs)
+	if err != nil {
+		status.Phase = "Failed"
+		status.Message = err.Error()
+		return ctrl.Result{}, r.updateCaptureStatus(ctx, capture, status)
+	}
+	log.Info("Creating collector Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name, "nodes", len(targets))
+	if err := r.Create(ctx, job); err != nil {
+		log.Error(err, "Failed to create collector Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+		return ctrl.Result{}, err
+	}
+
+	now := metav1.Now()
+	status.Phase = "Running"
+	status.Message = ""
+	status.StartTime = &now
+	status.Location = location
+	status.NodesCaptured = int32(len(targets))
+	return ctrl.Result{}, r.updateCaptureStatus(ctx, capture, status)
+}
+
+// captureTargets returns "node=ip:port" for every running agent of the
+// policy on a node matching the capture's nodeSelector.
+func (r *TraceCaptureReconciler) captureTargets(ctx context.Context, capture *gpuv1alpha1.TraceCapture, policy *gpuv1alpha1.CudaEBPFPolicy) ([]string, error) {
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(policy.Namespace), client.MatchingLabels{"app": "gpu-operator"}); err != nil {
+		return nil, err
+	}
+	nodeSelector := labels.SelectorFromSet(capture.Spec.NodeSelector)
+
+	var targets []string
+	for _, pod := range pods.Items {
+		owner := metav1.GetControllerOf(&pod)
+		if owner == nil || owner.Kind != "DaemonSet" || owner.Name != policy.Name {
+			continue
+		}
+		if pod.Status.Phase != corev1.PodRunning || pod.Status.PodIP == "" || pod.Spec.NodeName == "" {
+			continue
+		}
+		if len(capture.Spec.NodeSelector) > 0 {
+			node := &corev1.Node{}
+			if err := r.Get(ctx, types.NamespacedName{Name: pod.Spec.NodeName}, node); err != nil {
+				if errors.IsNotFound(err) {
+					continue
+				}
+				return nil, err
+			}
+			if !nodeSelector.Matches(labels.Set(node.Labels)) {
+				continue
+			}
+		}
+		targets = append(targets, fmt.Sprintf("%s=%s:%d", pod.Spec.NodeName, pod.Status.PodIP, agentPort))
+	}
+	sort.Strings(targets)
+	return targets, nil
+}
+
+// capturedPodUIDs returns the UIDs of the pods matching the capture's podSelector.
+func (r *TraceCaptureReconciler) capturedPodUIDs(ctx context.Context, capture *gpuv1alpha1.TraceCapture) ([]string, error) {
+	selector, err := metav1.LabelSelectorAsSelector(capture.Spec.PodSelector)
+	if err != nil {
+		return nil, err
+	}
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(capture.Namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+	uids := make([]string, 0, len(pods.Items))
+	for _, pod := range pods.Items {
+		uids = append(uids, string(pod.UID))
+	}
+	sort.Strings(uids)
+	return uids, nil
+}
+
+// collectorJob builds the Job running "agent collect" for a capture and
+// returns it with the location the archive will be stored at.
+func (r *TraceCaptureReconciler) collectorJob(capture *gpuv1alpha1.TraceCapture, policy *gpuv1alpha1.CudaEBPFPolicy, targets, podUIDs []string) (*batchv1.Job, string, error) {
+	seconds := int64(math.Ceil(capture.Spec.Duration.Seconds()))
+	archiveName := capture.Name + ".tar.gz"
+
+	env := []corev1.EnvVar{
+		{Name: "CAPTURE_NAME", Value: capture.Name},
+		{Name: "CAPTURE_SECONDS", Value: strconv.FormatInt(seconds, 10)},
+		{Name: "CAPTURE_TARGETS", Value: strings.Join(targets, ",")},
+	}
+	if len(podUIDs) > 0 {
+		env = append(env, corev1.EnvVar{Name: "CAPTURE_PODS", Value: strings.Join(podUIDs, ",")})
+	}
+
+	var location string
+	var volumes []corev1.Volume
+	var volumeMounts []corev1.VolumeMount
+	storage := capture.Spec.Storage
+	switch {
+	case storage.PersistentVolumeClaim != nil && storage.S3 != nil:
+		return nil, "", fmt.Errorf("storage must set only one of persistentVolumeClaim or s3")
+	case storage.PersistentVolumeClaim != nil:
+		pvc := storage.PersistentVolumeClaim
+		location = "pvc://" + path.Join(pvc.ClaimName, pvc.Path, archiveName)
+		env = append(env, corev1.EnvVar{Name: "CAPTURE_OUTPUT", Value: path.Join(captureMountPath, pvc.Path, archiveName)})
+		volumes = append(volumes, corev1.Volume{
+			Name: "capture",
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: pvc.ClaimName},
+			},
+		})
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{Name: "capture", MountPath: captureMountPath})
+	case storage.S3 != nil:
+		s3 := storage.S3
+		key := path.Join(s3.Prefix, archiveName)
+		location = "s3://" + path.Join(s3.Bucket, key)
+		env = append(env,
+			corev1.EnvVar{Name: "S3_ENDPOINT", Value: s3.Endpoint},
+			corev1.EnvVar{Name: "S3_BUCKET", Value: s3.Bucket},
+			corev1.EnvVar{Name: "S3_KEY", Value: key},
+			corev1.EnvVar{Name: "S3_REGION", Value: s3.Region},
+			corev1.EnvVar{Name: "S3_INSECURE", Value: strconv.FormatBool(s3.Insecure)},
+			secretEnvVar("AWS_ACCESS_KEY_ID", s3.CredentialsSecret, "accessKeyID"),
+			secretEnvVar("AWS_SECRET_ACCESS_KEY", s3.CredentialsSecret, "secretAccessKey"),
+		)
+	default:
+		return nil, "", fmt.Errorf("storage must set persistentVolumeClaim or s3")
+	}
+
+	backoffLimit := int32(0)
+	activeDeadline := seconds + int64(captureGracePeriod.Seconds())
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      collectorJobName(capture),
+			Namespace: capture.Namespace,
+			Labels: map[string]string{
+				"app":                       "gpu-operator-capture",
+				"gpu.obs.gpu/trace-capture": capture.Name,
+			},
+		},
+		Spec: batchv1.JobSpec{
+			// A retry would capture a different window, fail instead
+			BackoffLimit:          &backoffLimit,
+			ActiveDeadlineSeconds: &activeDeadline,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						"app":                       "gpu-operator-capture",
+						"gpu.obs.gpu/trace-capture": capture.Name,
+					},
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Volumes:       volumes,
+					Containers: []corev1.Container{{
+						Name:         "collector",
+						Image:        policy.Spec.Image,
+						Args:         []string{"collect"},
+						Env:          env,
+						VolumeMounts: volumeMounts,
+					}},
+				},
+			},
+		},
+	}
+	if err := ctrl.SetControllerReference(capture, job, r.Scheme); err != nil {
+		return nil, "", err
+	}
+	return job, location, nil
+}
+
+func (r *TraceCaptureReconciler) updateCaptureStatus(ctx context.Context, capture *gpuv1alpha1.TraceCapture, status *gpuv1alpha1.TraceCaptureStatus) error {
+	if equality.Semantic.DeepEqual(&capture.Status, status) {
+		return nil
+	}
+	capture.Status = *status
+	if err := r.Status().Update(ctx, capture); err != nil {
+		logf.FromContext(ctx).Error(err, "Failed to update TraceCapture status")
+		return err
+	}
+	return nil
+}
+
+func collectorJobName(capture *gpuv1alpha1.TraceCapture) string {
+	return capture.Name + "-collector"
+}
+
+func secretEnvVar(name string, secret corev1.LocalObjectReference, key string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: secret, Key: key},
+		},
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *TraceCaptureReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&gpuv1alpha1.TraceCapture{}).
+		Owns(&batchv1.Job{}).
+		Named("tracecapture").
+		Complete(r)
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2025 WoodProgrammer.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	gpuv1alpha1 "github.com/WoodProgrammer/gpu-bpf-operator/api/v1alpha1"
+)
+
+var _ = Describe("TraceCapture Controller", func() {
+	Context("When reconciling a resource", func() {
+		const (
+			captureName = "test-capture"
+			policyName  = "capture-policy"
+		)
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      captureName,
+			Namespace: "default",
+		}
+
+		BeforeEach(func() {
+			By("creating the custom resource for the Kind TraceCapture")
+			capture := &gpuv1alpha1.TraceCapture{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      captureName,
+					Namespace: "default",
+				},
+				Spec: gpuv1alpha1.TraceCaptureSpec{
+					PolicyRef: policyName,
+					Duration:  metav1.Duration{Duration: 10 * time.Minute},
+					Storage: gpuv1alpha1.CaptureStorage{
+						PersistentVolumeClaim: &gpuv1alpha1.PVCCaptureStorage{ClaimName: "traces", Path: "captures"},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, capture)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			By("Cleanup the TraceCapture and the objects it references")
+			capture := &gpuv1alpha1.TraceCapture{ObjectMeta: metav1.ObjectMeta{Name: captureName, Namespace: "default"}}
+			Expect(k8sClient.Delete(ctx, capture)).To(Succeed())
+			job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: captureName + "-collector", Namespace: "default"}}
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, job))).To(Succeed())
+			policy := &gpuv1alpha1.CudaEBPFPolicy{ObjectMeta: metav1.ObjectMeta{Name: policyName, Namespace: "default"}}
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, policy))).To(Succeed())
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "agent-node-a", Namespace: "default"}}
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, pod))).To(Succeed())
+		})
+
+		It("should wait while the policy does not exist", func() {
+			controllerReconciler := &TraceCaptureReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			result, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(captureRetryInterval))
+
+			capture := &gpuv1alpha1.TraceCapture{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, capture)).To(Succeed())
+			Expect(capture.Status.Phase).To(Equal("Pending"))
+			Expect(capture.Status.Message).To(ContainSubstring(policyName))
+		})
+
+		It("should start a collector for the running agents of the policy", func() {
+			By("creating the policy and one of its agents")
+			policy := &gpuv1alpha1.CudaEBPFPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: policyName, Namespace: "default"},
+				Spec: gpuv1alpha1.CudaEBPFPolicySpec{
+					LibPath:   "/usr/lib/libcudart.so",
+					Image:     "agent:latest",
+					Functions: []gpuv1alpha1.Function{{Name: "cudaMalloc", Kind: "uprobe"}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, policy)).To(Succeed())
+
+			isController := true
+			agent := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "agent-node-a",
+					Namespace: "default",
+					Labels:    map[string]string{"app": "gpu-operator"},
+					OwnerReferences: []metav1.OwnerReference{{
+						APIVersion: "apps/v1",
+						Kind:       "DaemonSet",
+						Name:       policyName,
+						UID:        "00000000-0000-0000-0000-000000000001",
+						Controller: &isController,
+					}},
+				},
+				Spec: corev1.PodSpec{
+					NodeName:   "node-a",
+					Containers: []corev1.Container{{Name: "bpf-tracer-agent", Image: "agent:latest"}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, agent)).To(Succeed())
+			agent.Status.Phase = corev1.PodRunning
+			agent.Status.PodIP = "10.0.0.7"
+			Expect(k8sClient.Status().Update(ctx, agent)).To(Succeed())
+
+			By("reconciling the capture")
+			controllerReconciler := &TraceCaptureReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			By("checking the collector Job")
+			job := &batchv1.Job{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: captureName + "-collector", Namespace: "default"}, job)).To(Succeed())
+			container := job.Spec.Template.Spec.Containers[0]
+			Expect(container.Image).To(Equal("agent:latest"))
+			Expect(container.Args).To(Equal([]string{"collect"}))
+			Expect(container.Env).To(ContainElements(
+				corev1.EnvVar{Name: "CAPTURE_SECONDS", Value: "600"},
+				corev1.EnvVar{Name: "CAPTURE_TARGETS", Value: "node-a=10.0.0.7:9090"},
+				corev1.EnvVar{Name: "CAPTURE_OUTPUT", Value: "/capture/captures/test-capture.tar.gz"},
+			))
+
+			By("checking the capture status")
+			capture := &gpuv1alpha1.TraceCapture{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, capture)).To(Succeed())
+			Expect(capture.Status.Phase).To(Equal("Running"))
+			Expect(capture.Status.Location).To(Equal("pvc://traces/captures/test-capture.tar.gz"))
+			Expect(capture.Status.NodesCaptured).To(BeEquivalentTo(1))
+		})
+	})
+})
@@ -0,0 +1,188 @@
+/*
+Copyright 2025 WoodProgrammer.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package imagepolicy decides which images the privileged agent pods may run.
+package imagepolicy
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/WoodProgrammer/gpu-bpf-operator/internal/config"
+)
+
+var digestPattern = regexp.MustCompile(`^[a-z0-9]+(?:[.+_-][a-z0-9]+)*:[a-zA-Z0-9=_-]{32,}$`)
+
+// Policy restricts the images agents may run. The zero value allows any image.
+type Policy struct {
+	// AllowedRepositories lists registries ("ghcr.io") or repositories
+	// ("ghcr.io/woodprogrammer/gpu-bpf-agent"). An entry allows the
+	// repository itself and every repository below it.
+	AllowedRepositories []string
+	// RequireDigest rejects images that are not pinned by digest.
+	RequireDigest bool
+	// PublicKeys verify image signatures. When set, every image must be
+	// pinned by digest and carry a signature made by one of the keys.
+	PublicKeys []crypto.PublicKey
+}
+
+// Reference is a parsed image reference.
+type Reference struct {
+	// Repository includes the registry, e.g. docker.io/library/busybox.
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseReference splits an image into repository, tag and digest.
+func ParseReference(image string) (Reference, error) {
+	var ref Reference
+	name := image
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, ref.Digest = name[:i], name[i+1:]
+		if !digestPattern.MatchString(ref.Digest) {
+			return Reference{}, fmt.Errorf("invalid digest %q", ref.Digest)
+		}
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, ref.Tag = name[:i], name[i+1:]
+		if ref.Tag == "" {
+			return Reference{}, fmt.Errorf("invalid image reference %q", image)
+		}
+	}
+	if name == "" {
+		return Reference{}, fmt.Errorf("invalid image reference %q", image)
+	}
+
+	registry := config.ImageRegistry(name)
+	path := name
+	if first, rest, found := strings.Cut(name, "/"); found && strings.EqualFold(first, registry) {
+		path = rest
+	} else if !strings.Contains(name, "/") {
+		path = "library/" + name
+	}
+	ref.Repository = registry + "/" + path
+	return ref, nil
+}
+
+// Check enforces the allowlist and the digest requirement.
+func (p *Policy) Check(image string) error {
+	ref, err := ParseReference(image)
+	if err != nil {
+		return err
+	}
+	if len(p.AllowedRepositories) > 0 && !p.repositoryAllowed(ref.Repository) {
+		return fmt.Errorf("repository %s is not allowed, allowed repositories are %s",
+			ref.Repository, strings.Join(p.AllowedRepositories, ", "))
+	}
+	if (p.RequireDigest || len(p.PublicKeys) > 0) && ref.Digest == "" {
+		return errors.New("image must be pinned by digest (image@sha256:...)")
+	}
+	return nil
+}
+
+func (p *Policy) repositoryAllowed(repository string) bool {
+	for _, allowed := range p.AllowedRepositories {
+		allowed = strings.ToLower(strings.TrimSuffix(allowed, "/"))
+		if repository == allowed || strings.HasPrefix(repository, allowed+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// SignatureRequired reports whether images must be signed.
+func (p *Policy) SignatureRequired() bool {
+	return len(p.PublicKeys) > 0
+}
+
+// VerifySignature checks a base64 signature of the image digest against the
+// configured keys. ECDSA signatures are ASN.1 encoded over the SHA-256 of
+// the digest string, as produced by
+//
+//	printf %s sha256:... | openssl dgst -sha256 -sign key.pem | base64 -w0
+//
+// and Ed25519 signatures sign the digest string itself.
+func (p *Policy) VerifySignature(image, signature string) error {
+	ref, err := ParseReference(image)
+	if err != nil {
+		return err
+	}
+	if ref.Digest == "" {
+		return errors.New("only images pinned by digest can be verified")
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("signature is not valid base64: %w", err)
+	}
+	payload := []byte(ref.Digest)
+	hash := sha256.Sum256(payload)
+	for _, key := range p.PublicKeys {
+		switch key := key.(type) {
+		case *ecdsa.PublicKey:
+			if ecdsa.VerifyASN1(key, hash[:], sig) {
+				return nil
+			}
+		case ed25519.PublicKey:
+			if ed25519.Verify(key, payload, sig) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("signature of %s does not match any trusted key", ref.Digest)
+}
+
+// LoadPublicKeys reads PEM encoded ECDSA and Ed25519 public keys.
+func LoadPublicKeys(paths []string) ([]crypto.PublicKey, error) {
+	var keys []crypto.PublicKey
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				break
+			}
+			if block.Type != "PUBLIC KEY" {
+				continue
+			}
+			key, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("parsing public key in %s: %w", path, err)
+			}
+			switch key.(type) {
+			case *ecdsa.PublicKey, ed25519.PublicKey:
+				keys = append(keys, key)
+			default:
+				return nil, fmt.Errorf("unsupported public key type %T in %s", key, path)
+			}
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2025 WoodProgrammer.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package imagepolicy
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+var _ = Describe("Image policy", func() {
+	Context("Parsing image references", func() {
+		It("Should normalise Docker Hub images", func() {
+			ref, err := ParseReference("busybox:1.36")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ref).To(Equal(Reference{Repository: "docker.io/library/busybox", Tag: "1.36"}))
+		})
+
+		It("Should split registry, tag and digest", func() {
+			ref, err := ParseReference("localhost:5000/gpu/agent:v1@" + digest)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ref).To(Equal(Reference{Repository: "localhost:5000/gpu/agent", Tag: "v1", Digest: digest}))
+		})
+
+		It("Should reject malformed references", func() {
+			_, err := ParseReference("ghcr.io/agent@sha256:abc")
+			Expect(err).To(HaveOccurred())
+			_, err = ParseReference("ghcr.io/agent:")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Checking images", func() {
+		It("Should allow any image by default", func() {
+			Expect((&Policy{}).Check("busybox")).To(Succeed())
+		})
+
+		It("Should only allow the listed registries and repositories", func() {
+			policy := &Policy{AllowedRepositories: []string{"ghcr.io/woodprogrammer", "registry.example.com"}}
+			Expect(policy.Check("ghcr.io/woodprogrammer/gpu-bpf-agent:v1")).To(Succeed())
+			Expect(policy.Check("registry.example.com/gpu/agent:v1")).To(Succeed())
+			Expect(policy.Check("ghcr.io/woodprogrammer-fork/gpu-bpf-agent:v1")).To(MatchError(ContainSubstring("is not allowed")))
+			Expect(policy.Check("busybox")).To(MatchError(ContainSubstring("docker.io/library/busybox")))
+		})
+
+		It("Should require digests when configured", func() {
+			policy := &Policy{RequireDigest: true}
+			Expect(policy.Check("ghcr.io/woodprogrammer/gpu-bpf-agent:v1")).To(MatchError(ContainSubstring("pinned by digest")))
+			Expect(policy.Check("ghcr.io/woodprogrammer/gpu-bpf-agent@" + digest)).To(Succeed())
+		})
+	})
+
+	Context("Verifying signatures", func() {
+		var (
+			ecKey  *ecdsa.PrivateKey
+			edKey  ed25519.PrivateKey
+			policy *Policy
+			image  = "ghcr.io/woodprogrammer/gpu-bpf-agent@" + digest
+		)
+
+		BeforeEach(func() {
+			var err error
+			ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			Expect(err).NotTo(HaveOccurred())
+			_, edKey, err = ed25519.GenerateKey(rand.Reader)
+			Expect(err).NotTo(HaveOccurred())
+
+			dir := GinkgoT().TempDir()
+			var paths []string
+			for name, key := range map[string]crypto.PublicKey{"ecdsa.pem": &ecKey.PublicKey, "ed25519.pem": edKey.Public()} {
+				der, err := x509.MarshalPKIXPublicKey(key)
+				Expect(err).NotTo(HaveOccurred())
+				path := filepath.Join(dir, name)
+				Expect(os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o644)).To(Succeed())
+				paths = append(paths, path)
+			}
+			keys, err := LoadPublicKeys(paths)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(keys).To(HaveLen(2))
+			policy = &Policy{PublicKeys: keys}
+		})
+
+		It("Should accept ECDSA signatures of the digest", func() {
+			hash := sha256.Sum256([]byte(digest))
+			sig, err := ecdsa.SignASN1(rand.Reader, ecKey, hash[:])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(policy.VerifySignature(image, base64.StdEncoding.EncodeToString(sig))).To(Succeed())
+		})
+
+		It("Should accept Ed25519 signatures of the digest", func() {
+			sig := ed25519.Sign(edKey, []byte(digest))
+			Expect(policy.VerifySignature(image, base64.StdEncoding.EncodeToString(sig))).To(Succeed())
+		})
+
+		It("Should reject signatures of other digests", func() {
+			other := "sha256:" + strings.Repeat("f", 64)
+			sig := ed25519.Sign(edKey, []byte(other))
+			Expect(policy.VerifySignature(image, base64.StdEncoding.EncodeToString(sig))).To(MatchError(ContainSubstring("does not match")))
+		})
+
+		It("Should reject signatures made with untrusted keys", func() {
+			_, untrusted, err := ed25519.GenerateKey(rand.Reader)
+			Expect(err).NotTo(HaveOccurred())
+			sig := ed25519.Sign(untrusted, []byte(digest))
+			Expect(policy.VerifySignature(image, base64.StdEncoding.EncodeToString(sig))).To(HaveOccurred())
+		})
+
+		It("Should require a digest when signatures are verified", func() {
+			Expect(policy.SignatureRequired()).To(BeTrue())
+			Expect(policy.Check("ghcr.io/woodprogrammer/gpu-bpf-agent:v1")).To(MatchError(ContainSubstring("pinned by digest")))
+		})
+	})
+})
@@ -0,0 +1,95 @@
+/*
+Copyright 2025 WoodProgrammer.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"errors"
+	"time"
+
+	gpuv1alpha1 "github.com/WoodProgrammer/gpu-bpf-operator/api/v1alpha1"
+)
+
+// Window is the tracing session a point in time falls into.
+type Window struct {
+	// Active reports whether a session is running at the evaluated time.
+	Active bool
+	// Done reports that the schedule will not start any further sessions.
+	Done bool
+	// Start and End bound the running session, or the next one when the
+	// schedule is inactive. End is zero for sessions without a duration.
+	Start time.Time
+	End   time.Time
+}
+
+// Evaluate places now within the sessions of a schedule. base is used as the
+// start of the schedule when it has no explicit start time, which is usually
+// the creation time of the policy.
+func Evaluate(s *gpuv1alpha1.TracingSchedule, base, now time.Time) (Window, error) {
+	if s == nil {
+		return Window{Active: true, Start: base}, nil
+	}
+
+	start := base
+	if s.StartTime != nil {
+		start = s.StartTime.Time
+	}
+	var duration time.Duration
+	if s.Duration != nil {
+		duration = s.Duration.Duration
+	}
+
+	if s.Cron == "" {
+		w := Window{Start: start}
+		if duration > 0 {
+			w.End = start.Add(duration)
+		}
+		switch {
+		case now.Before(start):
+		case w.End.IsZero() || now.Before(w.End):
+			w.Active = true
+		default:
+			w.Done = true
+		}
+		return w, nil
+	}
+
+	if duration <= 0 {
+		return Window{}, errors.New("a cron schedule requires a duration")
+	}
+	cron, err := ParseCron(s.Cron)
+	if err != nil {
+		return Window{}, err
+	}
+
+	// The running session, if any, is the first one starting after now-duration
+	from := now.Add(-duration)
+	if earliest := start.Add(-time.Minute); from.Before(earliest) {
+		from = earliest
+	}
+	next := cron.Next(from)
+	for !next.IsZero() && next.Before(start) {
+		next = cron.Next(next)
+	}
+	if next.IsZero() {
+		return Window{Done: true}, nil
+	}
+	return Window{
+		Active: !next.After(now),
+		Start:  next,
+		End:    next.Add(duration),
+	}, nil
+}
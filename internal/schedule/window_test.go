@@ -0,0 +1,115 @@
+/*
+Copyright 2025 WoodProgrammer.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	gpuv1alpha1 "github.com/WoodProgrammer/gpu-bpf-operator/api/v1alpha1"
+)
+
+var _ = Describe("Tracing schedules", func() {
+	base := time.Date(2025, 3, 10, 9, 30, 0, 0, time.UTC) // a Monday
+
+	Context("When parsing cron expressions", func() {
+		It("Should find the next matching minute", func() {
+			cron, err := ParseCron("*/15 * * * *")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cron.Next(base)).To(Equal(base.Add(15 * time.Minute)))
+		})
+
+		It("Should honour day of week restrictions", func() {
+			cron, err := ParseCron("0 2 * * 6")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cron.Next(base)).To(Equal(time.Date(2025, 3, 15, 2, 0, 0, 0, time.UTC)))
+		})
+
+		It("Should accept macros", func() {
+			cron, err := ParseCron("@daily")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cron.Next(base)).To(Equal(time.Date(2025, 3, 11, 0, 0, 0, 0, time.UTC)))
+		})
+
+		It("Should reject malformed expressions", func() {
+			_, err := ParseCron("61 * * * *")
+			Expect(err).To(HaveOccurred())
+			_, err = ParseCron("* * *")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("When evaluating session windows", func() {
+		It("Should always be active without a schedule", func() {
+			w, err := Evaluate(nil, base, base.Add(time.Hour))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(w.Active).To(BeTrue())
+		})
+
+		It("Should run a single time-boxed session", func() {
+			s := &gpuv1alpha1.TracingSchedule{Duration: &metav1.Duration{Duration: 10 * time.Minute}}
+
+			w, err := Evaluate(s, base, base.Add(5*time.Minute))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(w.Active).To(BeTrue())
+			Expect(w.End).To(Equal(base.Add(10 * time.Minute)))
+
+			w, err = Evaluate(s, base, base.Add(10*time.Minute))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(w.Active).To(BeFalse())
+			Expect(w.Done).To(BeTrue())
+		})
+
+		It("Should wait for the start time", func() {
+			start := metav1.NewTime(base.Add(time.Hour))
+			s := &gpuv1alpha1.TracingSchedule{StartTime: &start}
+
+			w, err := Evaluate(s, base, base)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(w.Active).To(BeFalse())
+			Expect(w.Done).To(BeFalse())
+			Expect(w.Start).To(Equal(start.Time))
+		})
+
+		It("Should run recurring sessions from a cron expression", func() {
+			s := &gpuv1alpha1.TracingSchedule{
+				Cron:     "0 * * * *",
+				Duration: &metav1.Duration{Duration: 10 * time.Minute},
+			}
+
+			By("being between two sessions")
+			w, err := Evaluate(s, base, base)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(w.Active).To(BeFalse())
+			Expect(w.Start).To(Equal(time.Date(2025, 3, 10, 10, 0, 0, 0, time.UTC)))
+
+			By("being inside a session")
+			w, err = Evaluate(s, base, time.Date(2025, 3, 10, 10, 4, 0, 0, time.UTC))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(w.Active).To(BeTrue())
+			Expect(w.End).To(Equal(time.Date(2025, 3, 10, 10, 10, 0, 0, time.UTC)))
+		})
+
+		It("Should require a duration for cron schedules", func() {
+			_, err := Evaluate(&gpuv1alpha1.TracingSchedule{Cron: "@hourly"}, base, base)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
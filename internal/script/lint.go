@@ -0,0 +1,501 @@
+/*
+Copyright 2025 WoodProgrammer.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package script
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxArgs is the number of function arguments bpftrace reads from registers
+// on x86-64, arg0 to arg5.
+const maxArgs = 6
+
+var (
+	symbolPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+	argPattern    = regexp.MustCompile(`^arg[0-9]+$`)
+	verbPattern   = regexp.MustCompile(`^%[-+ #0]*[0-9]*(\.[0-9]+)?(hh|h|ll|l|z)?[diouxXcsp]`)
+)
+
+// Problem is a defect Lint found in a rendered program.
+type Problem struct {
+	// Line is the 1-based line of the program the problem is on.
+	Line int
+	// Probe is the attach point of the probe the problem is in, empty when
+	// the problem is outside of any probe.
+	Probe string
+	// Message describes the problem.
+	Message string
+}
+
+func (p Problem) Error() string {
+	if p.Probe == "" {
+		return fmt.Sprintf("line %d: %s", p.Line, p.Message)
+	}
+	return fmt.Sprintf("line %d in %s: %s", p.Line, p.Probe, p.Message)
+}
+
+// functions are the bpftrace functions the template may call.
+var functions = map[string]bool{
+	"printf": true, "print": true, "clear": true, "delete": true, "zero": true, "exit": true,
+	"count": true, "sum": true, "hist": true, "lhist": true, "min": true, "max": true, "avg": true, "stats": true,
+	"str": true, "buf": true, "join": true, "ksym": true, "usym": true, "kaddr": true, "uaddr": true,
+	"ntop": true, "time": true, "strftime": true, "sizeof": true,
+}
+
+// builtins are the bpftrace builtins readable in any probe. argN and retval
+// are checked against the probe type separately.
+var builtins = map[string]bool{
+	"pid": true, "tid": true, "uid": true, "gid": true, "cpu": true, "comm": true,
+	"nsecs": true, "elapsed": true, "rand": true, "cgroup": true, "username": true,
+	"kstack": true, "ustack": true, "func": true, "probe": true, "curtask": true,
+}
+
+var keywords = map[string]bool{
+	"if": true, "else": true, "while": true, "unroll": true, "return": true,
+}
+
+var castTypes = map[string]bool{
+	"int8": true, "int16": true, "int32": true, "int64": true,
+	"uint8": true, "uint16": true, "uint32": true, "uint64": true,
+}
+
+// probeClass tells which builtins a probe provides.
+type probeClass int
+
+const (
+	// otherProbe is BEGIN, END or an interval, which have neither
+	// arguments nor a return value
+	otherProbe probeClass = iota
+	entryProbe
+	returnProbe
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokVar
+	tokMap
+	tokNumber
+	tokString
+	tokPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	line int
+}
+
+// mapUse records how many keys the first subscripted use of a map had.
+type mapUse struct {
+	keys int
+	line int
+}
+
+type linter struct {
+	src      string
+	pos      int
+	line     int
+	probe    string
+	maps     map[string]mapUse
+	problems []Problem
+}
+
+// Lint checks a rendered program against the subset of bpftrace the
+// template produces: probe syntax, map usage, printf formats and the
+// builtins each probe type provides. It does not replace bpftrace, but
+// catches the programs a spec can break before they reach the nodes.
+func Lint(program string) []Problem {
+	l := &linter{src: program, line: 1, maps: map[string]mapUse{}}
+	l.skipSpace()
+	if strings.HasPrefix(l.src[l.pos:], "#!") {
+		for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+			l.pos++
+		}
+	}
+	for l.skipSpace(); l.pos < len(l.src); l.skipSpace() {
+		if !l.lintProbe() {
+			break
+		}
+	}
+	return l.problems
+}
+
+func (l *linter) errorf(line int, format string, args ...any) {
+	l.problems = append(l.problems, Problem{Line: line, Probe: l.probe, Message: fmt.Sprintf(format, args...)})
+}
+
+// skipSpace skips whitespace and comments.
+func (l *linter) skipSpace() {
+	for l.pos < len(l.src) {
+		switch {
+		case l.src[l.pos] == '\n':
+			l.line++
+			l.pos++
+		case l.src[l.pos] == ' ' || l.src[l.pos] == '\t' || l.src[l.pos] == '\r':
+			l.pos++
+		case strings.HasPrefix(l.src[l.pos:], "//"):
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		case strings.HasPrefix(l.src[l.pos:], "/*"):
+			end := strings.Index(l.src[l.pos+2:], "*/")
+			if end < 0 {
+				l.errorf(l.line, "unterminated comment")
+				l.pos = len(l.src)
+				return
+			}
+			l.line += strings.Count(l.src[l.pos:l.pos+2+end], "\n")
+			l.pos += end + 4
+		default:
+			return
+		}
+	}
+}
+
+// lintProbe lints one probe: its attach point, an optional predicate and
+// its body. It returns false when the program can't be followed any further.
+func (l *linter) lintProbe() bool {
+	l.probe = ""
+	line := l.line
+	// The attach point runs up to the end of the line or the body. The
+	// template renders a single attach point per probe, so a list of them
+	// can only come from a name that smuggled in a comma.
+	start := l.pos
+	for l.pos < len(l.src) && l.src[l.pos] != '\n' && l.src[l.pos] != '{' {
+		l.pos++
+	}
+	point := strings.TrimSpace(l.src[start:l.pos])
+	if point == "" {
+		l.errorf(l.line, "expected an attach point")
+		return false
+	}
+	l.probe = point
+	if strings.Contains(point, ",") {
+		l.errorf(line, "%s lists several attach points, only one per probe is supported", point)
+		return false
+	}
+	class := l.lintAttachPoint(point, line)
+
+	var toks []token
+	tok := l.next()
+	if tok.kind == tokPunct && tok.text == "/" {
+		for tok = l.next(); tok.kind != tokEOF && (tok.kind != tokPunct || tok.text != "/"); tok = l.next() {
+			toks = append(toks, tok)
+		}
+		if len(toks) == 0 {
+			l.errorf(line, "empty predicate")
+		}
+		tok = l.next()
+	}
+	if tok.kind != tokPunct || tok.text != "{" {
+		l.errorf(tok.line, "expected { after %s", l.probe)
+		return false
+	}
+
+	var open []token
+	for {
+		tok = l.next()
+		if tok.kind == tokEOF {
+			l.errorf(line, "unterminated probe body")
+			return false
+		}
+		if tok.kind == tokPunct {
+			switch tok.text {
+			case "(", "[", "{":
+				open = append(open, tok)
+			case ")", "]", "}":
+				if len(open) == 0 && tok.text == "}" {
+					l.lintBody(toks, class)
+					return true
+				}
+				if len(open) == 0 || closing(open[len(open)-1].text) != tok.text {
+					l.errorf(tok.line, "unexpected %s", tok.text)
+					return false
+				}
+				open = open[:len(open)-1]
+			}
+		}
+		toks = append(toks, tok)
+	}
+}
+
+func closing(open string) string {
+	switch open {
+	case "(":
+		return ")"
+	case "[":
+		return "]"
+	default:
+		return "}"
+	}
+}
+
+// lintAttachPoint checks the syntax of an attach point and returns the
+// builtins the probe provides.
+func (l *linter) lintAttachPoint(point string, line int) probeClass {
+	parts := strings.Split(point, ":")
+	switch parts[0] {
+	case "BEGIN", "END":
+		if len(parts) != 1 {
+			l.errorf(line, "%s takes no arguments", parts[0])
+		}
+		return otherProbe
+	case "kprobe", "kretprobe":
+		if len(parts) != 2 || !symbolPattern.MatchString(parts[1]) {
+			l.errorf(line, "%s must be %s:<function>, with a function made of letters, digits, _ and .", point, parts[0])
+		}
+	case "uprobe", "uretprobe":
+		if len(parts) != 3 || !strings.HasPrefix(parts[1], "/") || !symbolPattern.MatchString(parts[2]) {
+			l.errorf(line, "%s must be %s:<absolute library path>:<function>, with a function made of letters, digits, _ and .", point, parts[0])
+		}
+	case "interval":
+		if len(parts) != 3 || !slices.Contains([]string{"s", "ms", "us", "hz"}, parts[1]) {
+			l.errorf(line, "%s must be interval:<s|ms|us|hz>:<count>", point)
+		} else if n, err := strconv.Atoi(parts[2]); err != nil || n <= 0 {
+			l.errorf(line, "%s must have a positive count", point)
+		}
+		return otherProbe
+	default:
+		l.errorf(line, "unsupported probe type %q", parts[0])
+		return otherProbe
+	}
+	if strings.HasSuffix(parts[0], "retprobe") {
+		return returnProbe
+	}
+	return entryProbe
+}
+
+// lintBody checks the identifiers, variables, maps and printf calls of the
+// predicate and statements of a probe.
+func (l *linter) lintBody(toks []token, class probeClass) {
+	assigned := map[string]bool{}
+	for i, tok := range toks {
+		next := tokenAt(toks, i+1)
+		switch tok.kind {
+		case tokIdent:
+			switch {
+			case keywords[tok.text]:
+			case next.kind == tokPunct && next.text == "(":
+				if !functions[tok.text] {
+					l.errorf(tok.line, "unknown function %s", tok.text)
+				} else if tok.text == "printf" {
+					l.lintPrintf(toks[i+1:])
+				}
+			case builtins[tok.text]:
+			case castTypes[tok.text] && tokenAt(toks, i-1).text == "(" && next.text == ")":
+			case tok.text == "retval":
+				if class != returnProbe {
+					l.errorf(tok.line, "retval is only available in kretprobe and uretprobe probes")
+				}
+			case argPattern.MatchString(tok.text):
+				if class != entryProbe {
+					l.errorf(tok.line, "%s is only available in kprobe and uprobe probes", tok.text)
+				} else if n, _ := strconv.Atoi(tok.text[3:]); n >= maxArgs {
+					l.errorf(tok.line, "%s is out of range, only arg0 to arg%d are available", tok.text, maxArgs-1)
+				}
+			default:
+				l.errorf(tok.line, "unknown identifier %s", tok.text)
+			}
+		case tokVar:
+			if next.kind == tokPunct && next.text == "=" {
+				assigned[tok.text] = true
+			} else if !assigned[tok.text] {
+				l.errorf(tok.line, "variable %s is used before it is assigned", tok.text)
+			}
+		case tokMap:
+			keys := -1
+			if next.kind == tokPunct && next.text == "[" {
+				keys = len(arguments(toks[i+1:]))
+			} else if next.kind == tokPunct && next.text == "=" {
+				keys = 0
+			}
+			if keys < 0 {
+				continue
+			}
+			if use, ok := l.maps[tok.text]; !ok {
+				l.maps[tok.text] = mapUse{keys: keys, line: tok.line}
+			} else if use.keys != keys {
+				l.errorf(tok.line, "map %s is used with %d keys here but with %d keys on line %d", tok.text, keys, use.keys, use.line)
+			}
+		}
+	}
+}
+
+// lintPrintf checks that the format of a printf call matches its
+// arguments. toks starts at the opening parenthesis of the call.
+func (l *linter) lintPrintf(toks []token) {
+	args := arguments(toks)
+	if len(args) == 0 || len(args[0]) != 1 || args[0][0].kind != tokString {
+		l.errorf(toks[0].line, "printf needs a string literal format")
+		return
+	}
+	format := args[0][0]
+	verbs := 0
+	for i := 0; i < len(format.text); i++ {
+		if format.text[i] != '%' {
+			continue
+		}
+		if strings.HasPrefix(format.text[i:], "%%") {
+			i++
+			continue
+		}
+		verb := verbPattern.FindString(format.text[i:])
+		if verb == "" {
+			l.errorf(format.line, "invalid printf verb at %q", format.text[i:min(i+4, len(format.text))])
+			return
+		}
+		verbs++
+		i += len(verb) - 1
+	}
+	if verbs != len(args)-1 {
+		l.errorf(format.line, "printf format has %d verbs but %d arguments", verbs, len(args)-1)
+	}
+}
+
+// arguments splits the tokens between an opening parenthesis or bracket at
+// toks[0] and its match into comma separated arguments.
+func arguments(toks []token) [][]token {
+	var args [][]token
+	var arg []token
+	depth := 0
+	for _, tok := range toks[1:] {
+		if tok.kind == tokPunct {
+			switch tok.text {
+			case "(", "[", "{":
+				depth++
+			case ")", "]", "}":
+				if depth == 0 {
+					if len(arg) > 0 || len(args) > 0 {
+						args = append(args, arg)
+					}
+					return args
+				}
+				depth--
+			case ",":
+				if depth == 0 {
+					args = append(args, arg)
+					arg = nil
+					continue
+				}
+			}
+		}
+		arg = append(arg, tok)
+	}
+	return args
+}
+
+func tokenAt(toks []token, i int) token {
+	if i < 0 || i >= len(toks) {
+		return token{kind: tokEOF}
+	}
+	return toks[i]
+}
+
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||", "<<", ">>", "++", "--", "+=", "-=", "->"}
+
+// next returns the next token of a probe.
+func (l *linter) next() token {
+	l.skipSpace()
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, line: l.line}
+	}
+	start, c := l.pos, l.src[l.pos]
+	tok := token{line: l.line}
+	switch {
+	case isIdentByte(c) && !isDigit(c):
+		tok.kind = tokIdent
+		l.pos = identEnd(l.src, l.pos)
+	case c == '$' || c == '@':
+		tok.kind = tokVar
+		if c == '@' {
+			tok.kind = tokMap
+		}
+		l.pos = identEnd(l.src, l.pos+1)
+		if c == '$' && l.pos == start+1 {
+			l.errorf(tok.line, "$ must be followed by a variable name")
+		}
+	case isDigit(c):
+		tok.kind = tokNumber
+		l.pos = identEnd(l.src, l.pos)
+	case c == '"':
+		tok.kind = tokString
+		tok.text = l.lexString()
+		return tok
+	default:
+		tok.kind = tokPunct
+		l.pos++
+		if !strings.ContainsRune("+-*/%&|^!~<>=?:;,.(){}[]", rune(c)) {
+			l.errorf(tok.line, "unexpected character %q", c)
+		}
+		for _, op := range operators {
+			if strings.HasPrefix(l.src[start:], op) {
+				l.pos = start + len(op)
+				break
+			}
+		}
+	}
+	tok.text = l.src[start:l.pos]
+	return tok
+}
+
+// lexString reads a string literal and returns its contents, with escape
+// sequences left in place.
+func (l *linter) lexString() string {
+	line := l.line
+	l.pos++
+	start := l.pos
+	for l.pos < len(l.src) {
+		switch l.src[l.pos] {
+		case '"':
+			l.pos++
+			return l.src[start : l.pos-1]
+		case '\n':
+			l.errorf(line, "unterminated string")
+			return l.src[start:l.pos]
+		case '\\':
+			if l.pos+1 < len(l.src) && !strings.ContainsRune(`nt"\r0123456789x`, rune(l.src[l.pos+1])) {
+				l.errorf(line, "invalid escape sequence \\%c", l.src[l.pos+1])
+			}
+			l.pos++
+		}
+		l.pos++
+	}
+	l.errorf(line, "unterminated string")
+	return l.src[start:]
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func identEnd(src string, pos int) int {
+	for pos < len(src) && isIdentByte(src[pos]) {
+		pos++
+	}
+	return pos
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2025 WoodProgrammer.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package script
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	gpuv1alpha1 "github.com/WoodProgrammer/gpu-bpf-operator/api/v1alpha1"
+)
+
+var _ = Describe("Lint", func() {
+	It("should accept every program the template renders for a valid spec", func() {
+		spec := &gpuv1alpha1.CudaEBPFPolicySpec{
+			Probes: DriverProbes,
+			Functions: []gpuv1alpha1.Function{
+				{Name: "cudaMalloc", Kind: "uprobe", Args: []gpuv1alpha1.Arg{{Index: 1, Name: "size"}}, Trace: true,
+					Stack: &gpuv1alpha1.StackCapture{Kernel: true, User: true}},
+				{Name: "cudaFree", Kind: "uretprobe", Returns: "cudaError", Stack: &gpuv1alpha1.StackCapture{User: true}},
+				{Name: "nvidia_ioctl", Kind: "kretprobe", Returns: "errno", Trace: true},
+				{Name: "nvidia_mmap", Kind: "kprobe", Stack: &gpuv1alpha1.StackCapture{Kernel: true}},
+				{Name: "cudaHostAlloc", Kind: "uretprobe", Returns: "pointer"},
+			},
+		}
+		program, err := Render(spec, "/usr/lib/libcudart.so")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(Lint(program)).To(BeEmpty())
+
+		program, err = Render(&gpuv1alpha1.CudaEBPFPolicySpec{}, "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(Lint(program)).To(BeEmpty())
+	})
+
+	It("should reject malformed attach points", func() {
+		problems := Lint("uprobe:libcudart.so:cudaMalloc\n{\n}\nkprobe:nvidia open\n{\n}\ntracepoint:sched:sched_switch\n{\n}\n")
+		Expect(problems).To(HaveLen(3))
+		Expect(problems[0].Probe).To(Equal("uprobe:libcudart.so:cudaMalloc"))
+		Expect(problems[1].Line).To(Equal(4))
+		Expect(problems[2].Message).To(ContainSubstring(`unsupported probe type "tracepoint"`))
+	})
+
+	It("should reject probes with several attach points", func() {
+		problems := Lint("uprobe:/lib/libcudart.so:cudaMalloc,kprobe:do_sys_openat2\n{\n    printf(\"%s\\n\", str(arg1));\n}\n")
+		Expect(problems).To(HaveLen(1))
+		Expect(problems[0].Message).To(ContainSubstring("lists several attach points"))
+
+		problems = Lint("kprobe:nvidia_open,\nkprobe:nvidia_mmap\n{\n}\n")
+		Expect(problems).To(HaveLen(1))
+		Expect(problems[0].Probe).To(Equal("kprobe:nvidia_open,"))
+	})
+
+	It("should only allow builtins the probe type provides", func() {
+		problems := Lint("kprobe:f\n{\n    printf(\"%d\\n\", retval);\n}\n" +
+			"kretprobe:f\n{\n    @args[arg0] = count();\n}\n" +
+			"uprobe:/lib/libc.so:f\n{\n    @big[arg6] = count();\n}\n")
+		Expect(problems).To(HaveLen(3))
+		Expect(problems[0].Message).To(ContainSubstring("retval is only available"))
+		Expect(problems[1].Message).To(ContainSubstring("arg0 is only available"))
+		Expect(problems[2].Message).To(ContainSubstring("arg6 is out of range"))
+	})
+
+	It("should check maps, variables and printf calls", func() {
+		problems := Lint("BEGIN\n{\n    @calls[comm] = count();\n    @calls[comm, pid] = count();\n" +
+			"    printf(\"%s %d\\n\", comm);\n    printf(\"%y\\n\", pid);\n    print($missing);\n    nope();\n}\n")
+		Expect(problems).To(HaveLen(5))
+		Expect(problems[0].Message).To(Equal("map @calls is used with 2 keys here but with 1 keys on line 3"))
+		Expect(problems[1].Message).To(ContainSubstring("2 verbs but 1 arguments"))
+		Expect(problems[2].Message).To(ContainSubstring("invalid printf verb"))
+		Expect(problems[3].Message).To(ContainSubstring("$missing is used before it is assigned"))
+		Expect(problems[4].Message).To(Equal("unknown function nope"))
+	})
+
+	It("should report unbalanced programs", func() {
+		Expect(Lint("BEGIN\n{\n    printf(\"open\\n\";\n}\n")).To(ContainElement(HaveField("Message", "unexpected }")))
+		Expect(Lint("BEGIN\n{\n    printf(\"open);\n}\n")).To(ContainElement(HaveField("Message", "unterminated string")))
+		Expect(Lint("END\n{\n")).To(ContainElement(HaveField("Message", "unterminated probe body")))
+	})
+})
@@ -0,0 +1,197 @@
+/*
+Copyright 2025 WoodProgrammer.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package script renders the bpftrace program run by the agents of a policy.
+package script
+
+import (
+	"bytes"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+	"text/template"
+
+	gpuv1alpha1 "github.com/WoodProgrammer/gpu-bpf-operator/api/v1alpha1"
+)
+
+// FileName is the name of the rendered program in the script ConfigMap.
+const FileName = "nvidia_events.bt"
+
+// DriverProbes are the NVIDIA driver probes spec.probes may select. The
+// template has a block for each of them.
+var DriverProbes = []string{
+	"nvidia_open",
+	"nvidia_unlocked_ioctl",
+	"nvidia_mmap",
+	"nvidia_isr",
+	"nvidia_isr_kthread_bh",
+}
+
+//go:embed templates/nvidia_events.bt.tmpl
+var programTemplate string
+
+var program = template.Must(template.New("nvidia_events.bt.tmpl").Funcs(funcMap).Parse(programTemplate))
+
+// templateData is what the program template is executed with.
+type templateData struct {
+	ProbeLib  []string
+	LibPath   string
+	Functions []gpuv1alpha1.Function
+}
+
+var funcMap = template.FuncMap{
+	"contains": func(needle string, haystack []string) bool {
+		for _, item := range haystack {
+			if strings.EqualFold(item, needle) {
+				return true
+			}
+		}
+		return false
+	},
+	"probeTarget":   probeTarget,
+	"isReturnProbe": isReturnProbe,
+	"spanTarget":    spanTarget,
+	"tracesSpans": func(functions []gpuv1alpha1.Function) bool {
+		for _, fn := range functions {
+			if fn.Trace {
+				return true
+			}
+		}
+		return false
+	},
+	// stackMaps lists the stack maps populated by the traced functions
+	"stackMaps": func(functions []gpuv1alpha1.Function) []string {
+		used := map[string]bool{}
+		for _, fn := range functions {
+			if fn.Stack != nil {
+				used[stackMapName(fn.Stack)] = true
+			}
+		}
+		var names []string
+		for _, name := range []string{"@kstacks", "@ustacks", "@kustacks"} {
+			if used[name] {
+				names = append(names, name)
+			}
+		}
+		return names
+	},
+	"classifiesReturns": func(functions []gpuv1alpha1.Function) bool {
+		for _, fn := range functions {
+			if fn.Returns != "" {
+				return true
+			}
+		}
+		return false
+	},
+}
+
+// isReturnProbe reports whether a spec.functions entry probes the return of
+// its function.
+func isReturnProbe(fn gpuv1alpha1.Function) bool {
+	return fn.Kind == "uretprobe" || fn.Kind == "kretprobe"
+}
+
+// probeTarget renders the attach point of a spec.functions entry.
+func probeTarget(libPath string, fn gpuv1alpha1.Function) string {
+	switch fn.Kind {
+	case "uprobe", "uretprobe":
+		return fmt.Sprintf("%s:%s:%s", fn.Kind, libPath, fn.Name)
+	default:
+		return fmt.Sprintf("%s:%s", fn.Kind, fn.Name)
+	}
+}
+
+// spanTarget renders the entry or return attach point of a traced function,
+// whichever probe kind the function itself uses.
+func spanTarget(libPath string, fn gpuv1alpha1.Function, ret bool) string {
+	user := fn.Kind == "uprobe" || fn.Kind == "uretprobe"
+	switch {
+	case user && ret:
+		return fmt.Sprintf("uretprobe:%s:%s", libPath, fn.Name)
+	case user:
+		return fmt.Sprintf("uprobe:%s:%s", libPath, fn.Name)
+	case ret:
+		return fmt.Sprintf("kretprobe:%s", fn.Name)
+	default:
+		return fmt.Sprintf("kprobe:%s", fn.Name)
+	}
+}
+
+// AttachPoints returns the attach points of the probes rendered for a
+// spec.functions entry.
+func AttachPoints(libPath string, fn gpuv1alpha1.Function) []string {
+	points := []string{probeTarget(libPath, fn)}
+	if isReturnProbe(fn) && len(fn.Args) > 0 {
+		points = append(points, spanTarget(libPath, fn, false))
+	}
+	if fn.Trace {
+		points = append(points, spanTarget(libPath, fn, false), spanTarget(libPath, fn, true))
+	}
+	return points
+}
+
+// stackMapName returns the bpftrace map a function's stacks are aggregated in.
+// The agent parses the maps by these names.
+func stackMapName(stack *gpuv1alpha1.StackCapture) string {
+	switch {
+	case stack.Kernel && stack.User:
+		return "@kustacks"
+	case stack.Kernel:
+		return "@kstacks"
+	default:
+		return "@ustacks"
+	}
+}
+
+// Render renders the bpftrace program for the probes and functions of a
+// policy, attaching user probes to libPath.
+func Render(spec *gpuv1alpha1.CudaEBPFPolicySpec, libPath string) (string, error) {
+	data := templateData{
+		ProbeLib:  spec.Probes,
+		LibPath:   libPath,
+		Functions: spec.Functions,
+	}
+	var buf bytes.Buffer
+	if err := program.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("rendering bpftrace script: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// Warnings reports parts of a spec that render to a program doing less than
+// the spec suggests.
+func Warnings(spec *gpuv1alpha1.CudaEBPFPolicySpec) []string {
+	var warnings []string
+	for _, probe := range spec.Probes {
+		if !slices.Contains(DriverProbes, probe) {
+			warnings = append(warnings, fmt.Sprintf("probe %q is not a known driver probe and renders to nothing", probe))
+		}
+	}
+	if len(spec.Probes) == 0 && len(spec.Functions) == 0 {
+		warnings = append(warnings, "the policy has no probes or functions, the program only prints its banner")
+	}
+	return warnings
+}
+
+// Hash returns a short content hash of a rendered program, suitable for
+// naming the ConfigMap it is stored in.
+func Hash(script string) string {
+	sum := sha256.Sum256([]byte(script))
+	return hex.EncodeToString(sum[:])[:10]
+}
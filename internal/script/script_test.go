@@ -0,0 +1,99 @@
+/*
+Copyright 2025 WoodProgrammer.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package script
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	gpuv1alpha1 "github.com/WoodProgrammer/gpu-bpf-operator/api/v1alpha1"
+)
+
+var _ = Describe("Render", func() {
+	It("should attach user probes to the library", func() {
+		spec := &gpuv1alpha1.CudaEBPFPolicySpec{
+			Functions: []gpuv1alpha1.Function{
+				{Name: "cudaMalloc", Kind: "uprobe", Args: []gpuv1alpha1.Arg{{Index: 1, Name: "size"}}},
+				{Name: "nvidia_ioctl", Kind: "kprobe", Stack: &gpuv1alpha1.StackCapture{Kernel: true}},
+			},
+		}
+		program, err := Render(spec, "/usr/lib/libcudart.so")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(program).To(ContainSubstring("#!/usr/bin/env bpftrace"))
+		Expect(program).To(ContainSubstring("uprobe:/usr/lib/libcudart.so:cudaMalloc\n{"))
+		Expect(program).To(ContainSubstring(" size=0x%lx"))
+		Expect(program).To(ContainSubstring("kprobe:nvidia_ioctl\n{"))
+		Expect(program).To(ContainSubstring(`@kstacks["nvidia_ioctl", comm, pid, kstack] = count();`))
+	})
+
+	It("should classify 32-bit return values as signed", func() {
+		spec := &gpuv1alpha1.CudaEBPFPolicySpec{
+			Functions: []gpuv1alpha1.Function{
+				{Name: "cuInit", Kind: "uretprobe", Returns: "cudaError"},
+				{Name: "nvidia_open", Kind: "kretprobe", Returns: "errno"},
+			},
+		}
+		program, err := Render(spec, "/usr/lib/libcuda.so")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(program).To(ContainSubstring("class=errno"))
+		Expect(program).To(ContainSubstring("class=cudaError"))
+		Expect(program).NotTo(ContainSubstring("$code = (int64)retval;"))
+		Expect(program).To(ContainSubstring("$code = (int64)(int32)retval;"))
+		Expect(Lint(program)).To(BeEmpty())
+	})
+
+	It("should log the args of return probes on entry", func() {
+		fn := gpuv1alpha1.Function{Name: "cudaMalloc", Kind: "uretprobe", Returns: "cudaError",
+			Args: []gpuv1alpha1.Arg{{Index: 1, Name: "size"}}}
+		program, err := Render(&gpuv1alpha1.CudaEBPFPolicySpec{Functions: []gpuv1alpha1.Function{fn}}, "/usr/lib/libcudart.so")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(program).To(ContainSubstring("uprobe:/usr/lib/libcudart.so:cudaMalloc\n{\n    printf("))
+		Expect(program).To(ContainSubstring(`"CALL", comm, pid, "-", "cudaMalloc", arg1);`))
+		Expect(program).To(ContainSubstring("class=cudaError"))
+		Expect(Lint(program)).To(BeEmpty())
+		Expect(AttachPoints("/usr/lib/libcudart.so", fn)).To(Equal([]string{
+			"uretprobe:/usr/lib/libcudart.so:cudaMalloc", "uprobe:/usr/lib/libcudart.so:cudaMalloc",
+		}))
+	})
+
+	It("should only include the selected driver probes", func() {
+		program, err := Render(&gpuv1alpha1.CudaEBPFPolicySpec{Probes: []string{"nvidia_open"}}, "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(program).To(ContainSubstring("kprobe:nvidia_open"))
+		Expect(program).NotTo(ContainSubstring("kprobe:nvidia_mmap"))
+	})
+})
+
+var _ = Describe("Warnings", func() {
+	It("should flag probes that render to nothing", func() {
+		spec := &gpuv1alpha1.CudaEBPFPolicySpec{Probes: []string{"nvidia_open", "nvidia_close"}}
+		Expect(Warnings(spec)).To(ConsistOf(ContainSubstring(`"nvidia_close"`)))
+	})
+
+	It("should flag policies that trace nothing", func() {
+		Expect(Warnings(&gpuv1alpha1.CudaEBPFPolicySpec{})).To(HaveLen(1))
+		Expect(Warnings(&gpuv1alpha1.CudaEBPFPolicySpec{Probes: []string{"nvidia_mmap"}})).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Hash", func() {
+	It("should address programs by their content", func() {
+		Expect(Hash("BEGIN {}")).To(HaveLen(10))
+		Expect(Hash("BEGIN {}")).To(Equal(Hash("BEGIN {}")))
+		Expect(Hash("BEGIN {}")).NotTo(Equal(Hash("END {}")))
+	})
+})
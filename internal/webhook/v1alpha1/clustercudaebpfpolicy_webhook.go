@@ -0,0 +1,154 @@
+/*
+Copyright 2025 WoodProgrammer.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	gpuv1alpha1 "github.com/WoodProgrammer/gpu-bpf-operator/api/v1alpha1"
+	"github.com/WoodProgrammer/gpu-bpf-operator/internal/config"
+	"github.com/WoodProgrammer/gpu-bpf-operator/internal/imagepolicy"
+)
+
+// nolint:unused
+// log is for logging in this package.
+var clustercudaebpfpolicylog = logf.Log.WithName("clustercudaebpfpolicy-resource")
+
+// SetupClusterCudaEBPFPolicyWebhookWithManager registers the webhook for ClusterCudaEBPFPolicy in the manager.
+// Cluster policies are defaulted and validated like namespaced ones, except
+// that they are not confined to a namespace.
+func SetupClusterCudaEBPFPolicyWebhookWithManager(mgr ctrl.Manager, defaults *config.AgentDefaults, images *imagepolicy.Policy) error {
+	return ctrl.NewWebhookManagedBy(mgr).For(&gpuv1alpha1.ClusterCudaEBPFPolicy{}).
+		WithValidator(&ClusterCudaEBPFPolicyCustomValidator{Defaults: defaults, ImagePolicy: images}).
+		WithDefaulter(&ClusterCudaEBPFPolicyCustomDefaulter{Defaults: defaults}).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-gpu-obs-gpu-v1alpha1-clustercudaebpfpolicy,mutating=true,failurePolicy=fail,sideEffects=None,groups=gpu.obs.gpu,resources=clustercudaebpfpolicies,verbs=create;update,versions=v1alpha1,name=mclustercudaebpfpolicy-v1alpha1.kb.io,admissionReviewVersions=v1
+
+// ClusterCudaEBPFPolicyCustomDefaulter struct is responsible for setting default values on the custom resource of the
+// Kind ClusterCudaEBPFPolicy when those are created or updated.
+//
+// NOTE: The +kubebuilder:object:generate=false marker prevents controller-gen from generating DeepCopy methods,
+// as it is used only for temporary operations and does not need to be deeply copied.
+type ClusterCudaEBPFPolicyCustomDefaulter struct {
+	// Defaults are the operator-wide agent settings filled into policies.
+	Defaults *config.AgentDefaults
+}
+
+var _ webhook.CustomDefaulter = &ClusterCudaEBPFPolicyCustomDefaulter{}
+
+// Default implements webhook.CustomDefaulter so a webhook will be registered for the Kind ClusterCudaEBPFPolicy.
+func (d *ClusterCudaEBPFPolicyCustomDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	policy, ok := obj.(*gpuv1alpha1.ClusterCudaEBPFPolicy)
+	if !ok {
+		return fmt.Errorf("expected a ClusterCudaEBPFPolicy object but got %T", obj)
+	}
+	clustercudaebpfpolicylog.Info("Defaulting for ClusterCudaEBPFPolicy", "name", policy.GetName())
+
+	(&CudaEBPFPolicyCustomDefaulter{Defaults: d.Defaults}).defaultSpec(&policy.Spec)
+	recordChangedBy(ctx, policy, &policy.Spec)
+
+	return nil
+}
+
+// +kubebuilder:webhook:path=/validate-gpu-obs-gpu-v1alpha1-clustercudaebpfpolicy,mutating=false,failurePolicy=fail,sideEffects=None,groups=gpu.obs.gpu,resources=clustercudaebpfpolicies,verbs=create;update,versions=v1alpha1,name=vclustercudaebpfpolicy-v1alpha1.kb.io,admissionReviewVersions=v1
+
+// ClusterCudaEBPFPolicyCustomValidator struct is responsible for validating the ClusterCudaEBPFPolicy resource
+// when it is created, updated, or deleted. Creating a cluster policy is
+// itself the grant to trace the host, so no tenancy checks apply.
+//
+// NOTE: The +kubebuilder:object:generate=false marker prevents controller-gen from generating DeepCopy methods,
+// as this struct is used only for temporary operations and does not need to be deeply copied.
+type ClusterCudaEBPFPolicyCustomValidator struct {
+	// Defaults hold the registries agent images may be pulled from.
+	Defaults *config.AgentDefaults
+	// ImagePolicy restricts the repositories of agent images and verifies
+	// their signatures.
+	ImagePolicy *imagepolicy.Policy
+}
+
+var _ webhook.CustomValidator = &ClusterCudaEBPFPolicyCustomValidator{}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type ClusterCudaEBPFPolicy.
+func (v *ClusterCudaEBPFPolicyCustomValidator) ValidateCreate(_ context.Context, obj runtime.Object) (admission.Warnings, error) {
+	policy, ok := obj.(*gpuv1alpha1.ClusterCudaEBPFPolicy)
+	if !ok {
+		return nil, fmt.Errorf("expected a ClusterCudaEBPFPolicy object but got %T", obj)
+	}
+	clustercudaebpfpolicylog.Info("Validation for ClusterCudaEBPFPolicy upon creation", "name", policy.GetName())
+
+	return specWarnings(&policy.Spec), v.validateClusterCudaEBPFPolicy(policy, nil)
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type ClusterCudaEBPFPolicy.
+func (v *ClusterCudaEBPFPolicyCustomValidator) ValidateUpdate(_ context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	policy, ok := newObj.(*gpuv1alpha1.ClusterCudaEBPFPolicy)
+	if !ok {
+		return nil, fmt.Errorf("expected a ClusterCudaEBPFPolicy object for the newObj but got %T", newObj)
+	}
+	oldPolicy, ok := oldObj.(*gpuv1alpha1.ClusterCudaEBPFPolicy)
+	if !ok {
+		return nil, fmt.Errorf("expected a ClusterCudaEBPFPolicy object for the oldObj but got %T", oldObj)
+	}
+	clustercudaebpfpolicylog.Info("Validation for ClusterCudaEBPFPolicy upon update", "name", policy.GetName())
+
+	return specWarnings(&policy.Spec), v.validateClusterCudaEBPFPolicy(policy, oldPolicy)
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type ClusterCudaEBPFPolicy.
+func (v *ClusterCudaEBPFPolicyCustomValidator) ValidateDelete(_ context.Context, obj runtime.Object) (admission.Warnings, error) {
+	policy, ok := obj.(*gpuv1alpha1.ClusterCudaEBPFPolicy)
+	if !ok {
+		return nil, fmt.Errorf("expected a ClusterCudaEBPFPolicy object but got %T", obj)
+	}
+	clustercudaebpfpolicylog.Info("Validation for ClusterCudaEBPFPolicy upon deletion", "name", policy.GetName())
+
+	// No validation needed on delete
+	return nil, nil
+}
+
+// validateClusterCudaEBPFPolicy validates the ClusterCudaEBPFPolicy spec. oldPolicy is nil on creation.
+func (v *ClusterCudaEBPFPolicyCustomValidator) validateClusterCudaEBPFPolicy(policy, oldPolicy *gpuv1alpha1.ClusterCudaEBPFPolicy) error {
+	specValidator := &CudaEBPFPolicyCustomValidator{Defaults: v.Defaults, ImagePolicy: v.ImagePolicy}
+	allErrs := specValidator.validateSpec(&policy.Spec)
+
+	// Pod selectors are relative to the policy's namespace
+	if policy.Spec.PodSelector != nil {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec").Child("podSelector"),
+			"cluster policies are not namespaced and cannot select pods"))
+	}
+
+	// Validate changes to the live policy
+	if oldPolicy != nil {
+		allErrs = append(allErrs, validateUpdate(policy, oldPolicy, &policy.Spec, &oldPolicy.Spec, &oldPolicy.Status)...)
+	}
+
+	if len(allErrs) == 0 {
+		return nil
+	}
+
+	return allErrs.ToAggregate()
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2025 WoodProgrammer.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	gpuv1alpha1 "github.com/WoodProgrammer/gpu-bpf-operator/api/v1alpha1"
+	"github.com/WoodProgrammer/gpu-bpf-operator/internal/config"
+)
+
+var _ = Describe("ClusterCudaEBPFPolicy Webhook", func() {
+	var (
+		obj       *gpuv1alpha1.ClusterCudaEBPFPolicy
+		validator ClusterCudaEBPFPolicyCustomValidator
+		defaulter ClusterCudaEBPFPolicyCustomDefaulter
+		ctx       context.Context
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		obj = &gpuv1alpha1.ClusterCudaEBPFPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "fleet-policy"},
+			Spec: gpuv1alpha1.CudaEBPFPolicySpec{
+				LibPath:      "/usr/lib/libcudart.so",
+				Image:        "test-image:latest",
+				Mode:         "systemwide",
+				OutputFormat: "ndjson",
+				Functions:    []gpuv1alpha1.Function{{Name: "cudaMalloc", Kind: "uprobe"}},
+			},
+		}
+		validator = ClusterCudaEBPFPolicyCustomValidator{}
+		defaulter = ClusterCudaEBPFPolicyCustomDefaulter{}
+	})
+
+	Context("When creating ClusterCudaEBPFPolicy under Defaulting Webhook", func() {
+		It("Should apply the same defaults as namespaced policies", func() {
+			obj.Spec.Mode = ""
+			obj.Spec.OutputFormat = ""
+			obj.Spec.Image = ""
+			defaulter.Defaults = &config.AgentDefaults{Image: "registry.example.com/gpu-bpf-agent:v1"}
+
+			Expect(defaulter.Default(ctx, obj)).To(Succeed())
+			Expect(obj.Spec.Mode).To(Equal("pidwatch"))
+			Expect(obj.Spec.OutputFormat).To(Equal("ndjson"))
+			Expect(obj.Spec.Image).To(Equal("registry.example.com/gpu-bpf-agent:v1"))
+		})
+	})
+
+	Context("When creating or updating ClusterCudaEBPFPolicy under Validating Webhook", func() {
+		It("Should admit host tracing without a tenancy check", func() {
+			obj.Spec.Functions = []gpuv1alpha1.Function{{Name: "nvidia_ioctl", Kind: "kprobe"}}
+
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Should validate the spec like namespaced policies", func() {
+			obj.Spec.Mode = "invalid"
+
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.mode"))
+		})
+
+		It("Should deny pod selectors", func() {
+			obj.Spec.PodSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "trainer"}}
+
+			_, err := validator.ValidateUpdate(ctx, obj.DeepCopy(), obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.podSelector"))
+		})
+
+		It("Should enforce the allowed registries", func() {
+			validator.Defaults = &config.AgentDefaults{AllowedRegistries: []string{"registry.example.com"}}
+
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.image"))
+		})
+	})
+})
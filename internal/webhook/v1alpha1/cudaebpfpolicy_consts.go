@@ -1,9 +1,20 @@
 package v1alpha1
 
-var ALLOWED_GPU_EVENTS = []string{
-	"nvidia_open",
-	"nvidia_unlocked_ioctl",
-	"nvidia_mmap",
-	"nvidia_isr",
-	"nvidia_isr_kthread_bh",
-}
+import (
+	"regexp"
+
+	"github.com/WoodProgrammer/gpu-bpf-operator/internal/script"
+)
+
+var ALLOWED_GPU_EVENTS = script.DriverProbes
+
+// kernelSymbolPattern matches kernel function names, including the
+// .isra.0 style suffixes of compiler generated clones
+var kernelSymbolPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z0-9_]+)*$`)
+
+// userSpacePattern matches the CUDA runtime, driver and NVML API naming
+var userSpacePattern = regexp.MustCompile(`^(cuda|cu|nvml)[A-Z]`)
+
+// identifierPattern matches library symbols and argument names, which are
+// rendered into attach points and printf formats as they are
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
@@ -18,10 +18,21 @@ package v1alpha1
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"net/url"
+	"path"
+	"slices"
 	"strings"
+	"time"
 
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 	ctrl "sigs.k8s.io/controller-runtime"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
@@ -29,6 +40,10 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
 	gpuv1alpha1 "github.com/WoodProgrammer/gpu-bpf-operator/api/v1alpha1"
+	"github.com/WoodProgrammer/gpu-bpf-operator/internal/config"
+	"github.com/WoodProgrammer/gpu-bpf-operator/internal/imagepolicy"
+	"github.com/WoodProgrammer/gpu-bpf-operator/internal/schedule"
+	"github.com/WoodProgrammer/gpu-bpf-operator/internal/script"
 )
 
 // nolint:unused
@@ -36,10 +51,16 @@ import (
 var cudaebpfpolicylog = logf.Log.WithName("cudaebpfpolicy-resource")
 
 // SetupCudaEBPFPolicyWebhookWithManager registers the webhook for CudaEBPFPolicy in the manager.
-func SetupCudaEBPFPolicyWebhookWithManager(mgr ctrl.Manager) error {
+// Policies are defaulted from and validated against the operator's agent
+// defaults and image policy, both of which may be nil.
+func SetupCudaEBPFPolicyWebhookWithManager(mgr ctrl.Manager, defaults *config.AgentDefaults, images *imagepolicy.Policy) error {
 	return ctrl.NewWebhookManagedBy(mgr).For(&gpuv1alpha1.CudaEBPFPolicy{}).
-		WithValidator(&CudaEBPFPolicyCustomValidator{}).
-		WithDefaulter(&CudaEBPFPolicyCustomDefaulter{}).
+		WithValidator(&CudaEBPFPolicyCustomValidator{
+			Defaults:    defaults,
+			ImagePolicy: images,
+			Authorizer:  &SubjectAccessReviewAuthorizer{Client: mgr.GetClient()},
+		}).
+		WithDefaulter(&CudaEBPFPolicyCustomDefaulter{Defaults: defaults}).
 		Complete()
 }
 
@@ -53,13 +74,14 @@ func SetupCudaEBPFPolicyWebhookWithManager(mgr ctrl.Manager) error {
 // NOTE: The +kubebuilder:object:generate=false marker prevents controller-gen from generating DeepCopy methods,
 // as it is used only for temporary operations and does not need to be deeply copied.
 type CudaEBPFPolicyCustomDefaulter struct {
-	// TODO(user): Add more fields as needed for defaulting
+	// Defaults are the operator-wide agent settings filled into policies.
+	Defaults *config.AgentDefaults
 }
 
 var _ webhook.CustomDefaulter = &CudaEBPFPolicyCustomDefaulter{}
 
 // Default implements webhook.CustomDefaulter so a webhook will be registered for the Kind CudaEBPFPolicy.
-func (d *CudaEBPFPolicyCustomDefaulter) Default(_ context.Context, obj runtime.Object) error {
+func (d *CudaEBPFPolicyCustomDefaulter) Default(ctx context.Context, obj runtime.Object) error {
 	cudaebpfpolicy, ok := obj.(*gpuv1alpha1.CudaEBPFPolicy)
 
 	if !ok {
@@ -67,12 +89,100 @@ func (d *CudaEBPFPolicyCustomDefaulter) Default(_ context.Context, obj runtime.O
 	}
 	cudaebpfpolicylog.Info("Defaulting for CudaEBPFPolicy", "name", cudaebpfpolicy.GetName())
 
+	d.defaultSpec(&cudaebpfpolicy.Spec)
+	recordChangedBy(ctx, cudaebpfpolicy, &cudaebpfpolicy.Spec)
+
+	return nil
+}
+
+// defaultSpec defaults the spec shared by namespaced and cluster policies
+func (d *CudaEBPFPolicyCustomDefaulter) defaultSpec(spec *gpuv1alpha1.CudaEBPFPolicySpec) {
 	// Set default mode to pidwatch if not specified
-	if cudaebpfpolicy.Spec.Mode == "" {
-		cudaebpfpolicy.Spec.Mode = "pidwatch"
+	if spec.Mode == "" {
+		spec.Mode = "pidwatch"
 	}
 
-	return nil
+	// Set default output format to ndjson if not specified
+	if spec.OutputFormat == "" {
+		spec.OutputFormat = "ndjson"
+	}
+
+	// Sinks drop events rather than stall the agent unless asked to block
+	for i := range spec.Sinks {
+		if spec.Sinks[i].Backpressure == "" {
+			spec.Sinks[i].Backpressure = "drop"
+		}
+	}
+
+	d.applyAgentDefaults(spec)
+}
+
+// recordChangedBy sets the ChangedByAnnotation of a policy to the requesting
+// user when its spec is created or changed. Other updates keep the previous
+// value, so that the annotation cannot be forged.
+func recordChangedBy(ctx context.Context, obj metav1.Object, spec *gpuv1alpha1.CudaEBPFPolicySpec) {
+	req, err := admission.RequestFromContext(ctx)
+	if err != nil {
+		return
+	}
+	user := req.UserInfo.Username
+	switch req.Operation {
+	case admissionv1.Create:
+	case admissionv1.Update:
+		old := &struct {
+			metav1.ObjectMeta `json:"metadata,omitempty"`
+			Spec              gpuv1alpha1.CudaEBPFPolicySpec `json:"spec,omitempty"`
+		}{}
+		if err := json.Unmarshal(req.OldObject.Raw, old); err != nil {
+			return
+		}
+		if equality.Semantic.DeepEqual(&old.Spec, spec) {
+			user = old.Annotations[gpuv1alpha1.ChangedByAnnotation]
+		}
+	default:
+		return
+	}
+
+	annotations := obj.GetAnnotations()
+	if user == "" {
+		delete(annotations, gpuv1alpha1.ChangedByAnnotation)
+		obj.SetAnnotations(annotations)
+		return
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[gpuv1alpha1.ChangedByAnnotation] = user
+	obj.SetAnnotations(annotations)
+}
+
+// applyAgentDefaults fills in the operator-wide agent settings the policy leaves empty.
+func (d *CudaEBPFPolicyCustomDefaulter) applyAgentDefaults(spec *gpuv1alpha1.CudaEBPFPolicySpec) {
+	defaults := d.Defaults
+	if defaults == nil {
+		return
+	}
+	if spec.Image == "" {
+		spec.Image = defaults.Image
+	}
+	if spec.ImagePullPolicy == "" {
+		spec.ImagePullPolicy = defaults.ImagePullPolicy
+	}
+
+	if defaults.Resources == nil && len(defaults.Tolerations) == 0 {
+		return
+	}
+	if spec.PodTemplate == nil {
+		spec.PodTemplate = &gpuv1alpha1.AgentPodTemplate{}
+	}
+	if spec.PodTemplate.Resources == nil && defaults.Resources != nil {
+		spec.PodTemplate.Resources = defaults.Resources.DeepCopy()
+	}
+	if len(spec.PodTemplate.Tolerations) == 0 {
+		for _, toleration := range defaults.Tolerations {
+			spec.PodTemplate.Tolerations = append(spec.PodTemplate.Tolerations, *toleration.DeepCopy())
+		}
+	}
 }
 
 // TODO(user): change verbs to "verbs=create;update;delete" if you want to enable deletion validation.
@@ -86,31 +196,43 @@ func (d *CudaEBPFPolicyCustomDefaulter) Default(_ context.Context, obj runtime.O
 // NOTE: The +kubebuilder:object:generate=false marker prevents controller-gen from generating DeepCopy methods,
 // as this struct is used only for temporary operations and does not need to be deeply copied.
 type CudaEBPFPolicyCustomValidator struct {
-	// TODO(user): Add more fields as needed for validation
+	// Defaults hold the registries agent images may be pulled from.
+	Defaults *config.AgentDefaults
+	// ImagePolicy restricts the repositories of agent images and verifies
+	// their signatures. The agents run privileged, so a policy must not be
+	// able to run arbitrary images on GPU nodes.
+	ImagePolicy *imagepolicy.Policy
+	// Authorizer grants host access to namespaced policies. Without it,
+	// policies may only trace the pods of their own namespace.
+	Authorizer HostTracingAuthorizer
 }
 
 var _ webhook.CustomValidator = &CudaEBPFPolicyCustomValidator{}
 
 // ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type CudaEBPFPolicy.
-func (v *CudaEBPFPolicyCustomValidator) ValidateCreate(_ context.Context, obj runtime.Object) (admission.Warnings, error) {
+func (v *CudaEBPFPolicyCustomValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
 	cudaebpfpolicy, ok := obj.(*gpuv1alpha1.CudaEBPFPolicy)
 	if !ok {
 		return nil, fmt.Errorf("expected a CudaEBPFPolicy object but got %T", obj)
 	}
 	cudaebpfpolicylog.Info("Validation for CudaEBPFPolicy upon creation", "name", cudaebpfpolicy.GetName())
 
-	return nil, v.validateCudaEBPFPolicy(cudaebpfpolicy)
+	return specWarnings(&cudaebpfpolicy.Spec), v.validateCudaEBPFPolicy(ctx, cudaebpfpolicy, nil)
 }
 
 // ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type CudaEBPFPolicy.
-func (v *CudaEBPFPolicyCustomValidator) ValidateUpdate(_ context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+func (v *CudaEBPFPolicyCustomValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
 	cudaebpfpolicy, ok := newObj.(*gpuv1alpha1.CudaEBPFPolicy)
 	if !ok {
 		return nil, fmt.Errorf("expected a CudaEBPFPolicy object for the newObj but got %T", newObj)
 	}
+	oldPolicy, ok := oldObj.(*gpuv1alpha1.CudaEBPFPolicy)
+	if !ok {
+		return nil, fmt.Errorf("expected a CudaEBPFPolicy object for the oldObj but got %T", oldObj)
+	}
 	cudaebpfpolicylog.Info("Validation for CudaEBPFPolicy upon update", "name", cudaebpfpolicy.GetName())
 
-	return nil, v.validateCudaEBPFPolicy(cudaebpfpolicy)
+	return specWarnings(&cudaebpfpolicy.Spec), v.validateCudaEBPFPolicy(ctx, cudaebpfpolicy, oldPolicy)
 }
 
 // ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type CudaEBPFPolicy.
@@ -125,45 +247,181 @@ func (v *CudaEBPFPolicyCustomValidator) ValidateDelete(ctx context.Context, obj
 	return nil, nil
 }
 
-// validateCudaEBPFPolicy validates the CudaEBPFPolicy spec
-func (v *CudaEBPFPolicyCustomValidator) validateCudaEBPFPolicy(policy *gpuv1alpha1.CudaEBPFPolicy) error {
+// validateCudaEBPFPolicy validates the CudaEBPFPolicy spec. oldPolicy is nil on creation.
+func (v *CudaEBPFPolicyCustomValidator) validateCudaEBPFPolicy(ctx context.Context, policy, oldPolicy *gpuv1alpha1.CudaEBPFPolicy) error {
+	allErrs := v.validateSpec(&policy.Spec)
+
+	// Validate the policy stays within its namespace unless granted host access
+	allErrs = append(allErrs, v.validateTenancy(ctx, policy, oldPolicy)...)
+
+	// Validate changes to the live policy
+	if oldPolicy != nil {
+		allErrs = append(allErrs, validateUpdate(policy, oldPolicy, &policy.Spec, &oldPolicy.Spec, &oldPolicy.Status)...)
+	}
+
+	if len(allErrs) == 0 {
+		return nil
+	}
+
+	return allErrs.ToAggregate()
+}
+
+// validateSpec validates the spec shared by namespaced and cluster policies
+func (v *CudaEBPFPolicyCustomValidator) validateSpec(spec *gpuv1alpha1.CudaEBPFPolicySpec) field.ErrorList {
 	var allErrs field.ErrorList
 
 	// Validate functions field
-	if err := v.validateFunctions(policy.Spec.Functions, field.NewPath("spec").Child("functions")); err != nil {
+	if err := v.validateFunctions(spec.Functions, field.NewPath("spec").Child("functions")); err != nil {
 		allErrs = append(allErrs, err...)
 	}
 
 	// Validate mode field
-	if err := v.validateMode(policy.Spec.Mode, field.NewPath("spec").Child("mode")); err != nil {
+	if err := v.validateMode(spec.Mode, field.NewPath("spec").Child("mode")); err != nil {
 		allErrs = append(allErrs, err)
 	}
 
 	// Validate outputFormat field
-	if err := v.validateOutputFormat(policy.Spec.OutputFormat, field.NewPath("spec").Child("output")); err != nil {
+	if err := v.validateOutputFormat(spec.OutputFormat, field.NewPath("spec").Child("output")); err != nil {
 		allErrs = append(allErrs, err)
 	}
 
-	// Validate libPath is not empty
-	if policy.Spec.LibPath == "" {
-		allErrs = append(allErrs, field.Required(field.NewPath("spec").Child("libPath"), "libPath must be specified"))
+	// Validate schedule if present
+	if spec.Schedule != nil {
+		allErrs = append(allErrs, v.validateSchedule(spec.Schedule, field.NewPath("spec").Child("schedule"))...)
+	}
+
+	// Validate OTLP export if present
+	if spec.OTLP != nil {
+		allErrs = append(allErrs, v.validateOTLP(spec.OTLP, field.NewPath("spec").Child("otlp"))...)
+	}
+
+	// Validate output sinks
+	if len(spec.Sinks) > 0 {
+		allErrs = append(allErrs, v.validateSinks(spec.Sinks, field.NewPath("spec").Child("sinks"))...)
+	}
+
+	// Validate event buffering if present
+	if spec.Buffering != nil {
+		allErrs = append(allErrs, v.validateBuffering(spec.Buffering, field.NewPath("spec").Child("buffering"))...)
+	}
+
+	// Validate pod template overrides if present
+	if spec.PodTemplate != nil {
+		allErrs = append(allErrs, v.validatePodTemplate(spec.PodTemplate, field.NewPath("spec").Child("podTemplate"))...)
+	}
+
+	// Validate security profiles if present
+	if spec.Security != nil {
+		allErrs = append(allErrs, v.validateSecurity(spec.Security, field.NewPath("spec").Child("security"))...)
+	}
+
+	// Validate pod selector if present
+	if spec.PodSelector != nil {
+		allErrs = append(allErrs, metav1validation.ValidateLabelSelector(spec.PodSelector,
+			metav1validation.LabelSelectorValidationOptions{}, field.NewPath("spec").Child("podSelector"))...)
+	}
+
+	// Validate uprobes have a library to attach to
+	if spec.LibPath != "" && !path.IsAbs(spec.LibPath) {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("libPath"), spec.LibPath, "libPath must be an absolute path"))
+	} else if spec.LibPath == "" && slices.ContainsFunc(spec.Functions, isUserProbe) {
+		allErrs = append(allErrs, field.Required(field.NewPath("spec").Child("libPath"), "libPath must be specified for uprobe and uretprobe functions"))
 	}
 
-	// Validate image is not empty
-	if policy.Spec.Image == "" {
+	// Validate image is not empty and comes from an allowed registry
+	if spec.Image == "" {
 		allErrs = append(allErrs, field.Required(field.NewPath("spec").Child("image"), "image must be specified"))
+	} else if !v.Defaults.RegistryAllowed(spec.Image) {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec").Child("image"),
+			fmt.Sprintf("registry %s is not allowed, allowed registries are %s",
+				config.ImageRegistry(spec.Image), strings.Join(v.Defaults.AllowedRegistries, ", "))))
+	} else if v.ImagePolicy != nil {
+		allErrs = append(allErrs, v.validateImagePolicy(spec, field.NewPath("spec"))...)
 	}
 
-	for _, fn := range policy.Spec.Probes {
-		if !contains(ALLOWED_GPU_EVENTS, fn) {
-			allErrs = append(allErrs, field.Required(field.NewPath("spec").Child("functions"), fmt.Sprintf("Invalid nvidia event defined %s", fn)))
-		}
+	// Validate image pull policy if present
+	switch spec.ImagePullPolicy {
+	case "", corev1.PullAlways, corev1.PullIfNotPresent, corev1.PullNever:
+	default:
+		allErrs = append(allErrs, field.NotSupported(field.NewPath("spec").Child("imagePullPolicy"), spec.ImagePullPolicy,
+			[]corev1.PullPolicy{corev1.PullAlways, corev1.PullIfNotPresent, corev1.PullNever}))
 	}
+
+	// Validate driver probes and their overlap with functions
+	allErrs = append(allErrs, v.validateProbes(spec, field.NewPath("spec"))...)
+
+	// Lint the rendered program once the fields it is rendered from are valid
 	if len(allErrs) == 0 {
-		return nil
+		allErrs = append(allErrs, v.validateProgram(spec, field.NewPath("spec"))...)
 	}
+	return allErrs
+}
 
-	return allErrs.ToAggregate()
+// validateProgram renders the bpftrace program the way the controller does
+// and reports the problems the linter finds against the spec entries that
+// produced them
+func (v *CudaEBPFPolicyCustomValidator) validateProgram(spec *gpuv1alpha1.CudaEBPFPolicySpec, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	program, err := script.Render(spec, spec.LibPath)
+	if err != nil {
+		return append(allErrs, field.InternalError(fldPath, err))
+	}
+
+	for _, problem := range script.Lint(program) {
+		path, value := programSource(spec, spec.LibPath, problem.Probe, fldPath)
+		allErrs = append(allErrs, field.Invalid(path, value, problem.Error()))
+	}
+	return allErrs
+}
+
+// programSource returns the spec entry the probe with the given attach point
+// was rendered from, or fldPath itself for the probes every program has
+func programSource(spec *gpuv1alpha1.CudaEBPFPolicySpec, libPath, probe string, fldPath *field.Path) (*field.Path, string) {
+	for i, fn := range spec.Functions {
+		if slices.Contains(script.AttachPoints(libPath, fn), probe) {
+			return fldPath.Child("functions").Index(i), fn.Name
+		}
+	}
+	for i, name := range spec.Probes {
+		if probe == "kprobe:"+name || probe == "kretprobe:"+name {
+			return fldPath.Child("probes").Index(i), name
+		}
+	}
+	return fldPath, probe
+}
+
+// validateProbes validates the driver probes of a policy. A kernel function
+// in spec.functions must not attach to a driver probe spec.probes already
+// traces.
+func (v *CudaEBPFPolicyCustomValidator) validateProbes(spec *gpuv1alpha1.CudaEBPFPolicySpec, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	probeIndex := make(map[string]int)
+	for i, probe := range spec.Probes {
+		probePath := fldPath.Child("probes").Index(i)
+		if !contains(ALLOWED_GPU_EVENTS, probe) {
+			allErrs = append(allErrs, field.NotSupported(probePath, probe, ALLOWED_GPU_EVENTS))
+			continue
+		}
+		if _, ok := probeIndex[probe]; ok {
+			allErrs = append(allErrs, field.Duplicate(probePath, probe))
+			continue
+		}
+		probeIndex[probe] = i
+	}
+
+	for i, fn := range spec.Functions {
+		if isUserProbe(fn) {
+			continue
+		}
+		if j, ok := probeIndex[fn.Name]; ok {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("functions").Index(i).Child("name"), fn.Name,
+				fmt.Sprintf("%s is already traced by spec.probes[%d], remove it from spec.functions or spec.probes", fn.Name, j)))
+		}
+	}
+
+	return allErrs
 }
 
 // validateFunctions validates the functions field
@@ -199,17 +457,80 @@ func (v *CudaEBPFPolicyCustomValidator) validateFunctions(functions []gpuv1alpha
 			allErrs = append(allErrs, field.NotSupported(funcPath.Child("kind"), fn.Kind, validKinds))
 		}
 
-		// Validate arguments if present
+		// Validate kernel functions name a kernel symbol
+		if (fn.Kind == "kprobe" || fn.Kind == "kretprobe") && fn.Name != "" && !kernelSymbolPattern.MatchString(fn.Name) {
+			allErrs = append(allErrs, field.Invalid(funcPath.Child("name"), fn.Name,
+				"kprobe and kretprobe functions must be kernel symbols, made of letters, digits and _ with optional .suffixes"))
+		}
+
+		// Validate library functions name a plain symbol
+		if isUserProbe(fn) && fn.Name != "" && !identifierPattern.MatchString(fn.Name) {
+			allErrs = append(allErrs, field.Invalid(funcPath.Child("name"), fn.Name,
+				"uprobe and uretprobe functions must be library symbols, made of letters, digits and _"))
+		}
+
+		// Return probes log their args from an extra probe on entry
 		if len(fn.Args) > 0 {
 			if err := v.validateArgs(fn.Args, funcPath.Child("args")); err != nil {
 				allErrs = append(allErrs, err...)
 			}
 		}
+
+		// Validate return value classification if present
+		if err := v.validateReturns(fn, funcPath.Child("returns")); err != nil {
+			allErrs = append(allErrs, err)
+		}
+
+		// Validate stack capture if present
+		if fn.Stack != nil {
+			allErrs = append(allErrs, v.validateStack(fn.Stack, funcPath.Child("stack"))...)
+		}
 	}
 
 	return allErrs
 }
 
+// isUserProbe reports whether a function attaches to spec.libPath
+func isUserProbe(fn gpuv1alpha1.Function) bool {
+	return fn.Kind == "uprobe" || fn.Kind == "uretprobe"
+}
+
+// specWarnings returns the admission warnings for parts of a spec that are
+// valid but unlikely to do what was meant
+func specWarnings(spec *gpuv1alpha1.CudaEBPFPolicySpec) admission.Warnings {
+	var warnings admission.Warnings
+
+	for i, fn := range spec.Functions {
+		if !isUserProbe(fn) && userSpacePattern.MatchString(fn.Name) {
+			warnings = append(warnings, fmt.Sprintf("spec.functions[%d]: %s looks like a CUDA library function, "+
+				"but %s functions attach to kernel symbols; use uprobe or uretprobe for library functions", i, fn.Name, fn.Kind))
+		}
+	}
+
+	if spec.LibPath != "" && !slices.ContainsFunc(spec.Functions, isUserProbe) {
+		warnings = append(warnings, "spec.libPath is unused, no uprobe or uretprobe function attaches to it")
+	}
+
+	return warnings
+}
+
+// validateReturns validates the return value classification of a function
+func (v *CudaEBPFPolicyCustomValidator) validateReturns(fn gpuv1alpha1.Function, fldPath *field.Path) *field.Error {
+	if fn.Returns == "" {
+		return nil
+	}
+
+	if fn.Kind != "uretprobe" && fn.Kind != "kretprobe" {
+		return field.Invalid(fldPath, fn.Returns, "returns is only supported for uretprobe and kretprobe functions")
+	}
+
+	validReturns := []string{"errno", "cudaError", "pointer"}
+	if !slices.Contains(validReturns, fn.Returns) {
+		return field.NotSupported(fldPath, fn.Returns, validReturns)
+	}
+	return nil
+}
+
 // validateArgs validates function arguments
 func (v *CudaEBPFPolicyCustomValidator) validateArgs(args []gpuv1alpha1.Arg, fldPath *field.Path) field.ErrorList {
 	var allErrs field.ErrorList
@@ -223,6 +544,9 @@ func (v *CudaEBPFPolicyCustomValidator) validateArgs(args []gpuv1alpha1.Arg, fld
 		// Validate argument name is not empty
 		if arg.Name == "" {
 			allErrs = append(allErrs, field.Required(argPath.Child("name"), "argument name must be specified"))
+		} else if !identifierPattern.MatchString(arg.Name) {
+			allErrs = append(allErrs, field.Invalid(argPath.Child("name"), arg.Name,
+				"argument name must be made of letters, digits and _, starting with a letter or _"))
 		}
 
 		// Validate argument index is non-negative
@@ -240,6 +564,283 @@ func (v *CudaEBPFPolicyCustomValidator) validateArgs(args []gpuv1alpha1.Arg, fld
 	return allErrs
 }
 
+// validateStack validates the stack capture options of a function
+func (v *CudaEBPFPolicyCustomValidator) validateStack(stack *gpuv1alpha1.StackCapture, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if !stack.Kernel && !stack.User {
+		allErrs = append(allErrs, field.Required(fldPath, "at least one of kernel or user stacks must be enabled"))
+	}
+
+	if stack.TopN < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("topN"), stack.TopN, "topN must be non-negative"))
+	}
+
+	return allErrs
+}
+
+// validateSchedule validates the tracing schedule: a positive duration, and
+// a parsable cron expression that comes with a duration
+func (v *CudaEBPFPolicyCustomValidator) validateSchedule(s *gpuv1alpha1.TracingSchedule, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if s.Duration != nil && s.Duration.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("duration"), s.Duration.Duration.String(), "duration must be positive"))
+	}
+
+	if s.Cron != "" {
+		if s.Duration == nil {
+			allErrs = append(allErrs, field.Required(fldPath.Child("duration"), "duration must be specified for cron schedules"))
+		}
+		if _, err := schedule.ParseCron(s.Cron); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("cron"), s.Cron, err.Error()))
+		}
+	}
+	return allErrs
+}
+
+// validateOTLP validates the OTLP export settings
+func (v *CudaEBPFPolicyCustomValidator) validateOTLP(otlp *gpuv1alpha1.OTLPExport, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if otlp.Endpoint == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("endpoint"), "endpoint must be specified"))
+	} else if u, err := url.Parse(otlp.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("endpoint"), otlp.Endpoint, "endpoint must be an http or https URL"))
+	}
+
+	if otlp.MetricInterval != nil && otlp.MetricInterval.Duration < time.Second {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("metricInterval"), otlp.MetricInterval.Duration.String(), "metricInterval must be at least 1s"))
+	}
+	return allErrs
+}
+
+// validateSinks validates the output sinks
+func (v *CudaEBPFPolicyCustomValidator) validateSinks(sinks []gpuv1alpha1.OutputSink, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	names := map[string]bool{}
+
+	for i, sink := range sinks {
+		sinkPath := fldPath.Index(i)
+
+		if sink.Name == "" {
+			allErrs = append(allErrs, field.Required(sinkPath.Child("name"), "sink name must be specified"))
+		} else if errs := validation.IsDNS1123Label(sink.Name); len(errs) > 0 {
+			allErrs = append(allErrs, field.Invalid(sinkPath.Child("name"), sink.Name, strings.Join(errs, "; ")))
+		} else if names[sink.Name] {
+			allErrs = append(allErrs, field.Duplicate(sinkPath.Child("name"), sink.Name))
+		}
+		names[sink.Name] = true
+
+		configured := 0
+		if sink.File != nil {
+			configured++
+			if !path.IsAbs(sink.File.Path) {
+				allErrs = append(allErrs, field.Invalid(sinkPath.Child("file", "path"), sink.File.Path, "path must be absolute"))
+			}
+			if sink.File.MaxSizeMB < 0 {
+				allErrs = append(allErrs, field.Invalid(sinkPath.Child("file", "maxSizeMB"), sink.File.MaxSizeMB, "maxSizeMB must not be negative"))
+			}
+			if sink.File.MaxFiles < 0 {
+				allErrs = append(allErrs, field.Invalid(sinkPath.Child("file", "maxFiles"), sink.File.MaxFiles, "maxFiles must not be negative"))
+			}
+		}
+		if sink.HTTP != nil {
+			configured++
+			if u, err := url.Parse(sink.HTTP.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				allErrs = append(allErrs, field.Invalid(sinkPath.Child("http", "url"), sink.HTTP.URL, "url must be an http or https URL"))
+			}
+		}
+		if sink.Kafka != nil {
+			configured++
+			if len(sink.Kafka.Brokers) == 0 {
+				allErrs = append(allErrs, field.Required(sinkPath.Child("kafka", "brokers"), "at least one broker must be specified"))
+			}
+			if sink.Kafka.Topic == "" {
+				allErrs = append(allErrs, field.Required(sinkPath.Child("kafka", "topic"), "topic must be specified"))
+			}
+		}
+		if configured != 1 {
+			allErrs = append(allErrs, field.Invalid(sinkPath, sink.Name, "exactly one of file, http or kafka must be specified"))
+		}
+
+		if sink.Backpressure != "" && sink.Backpressure != "drop" && sink.Backpressure != "block" {
+			allErrs = append(allErrs, field.NotSupported(sinkPath.Child("backpressure"), sink.Backpressure, []string{"drop", "block"}))
+		}
+		if sink.QueueSize < 0 {
+			allErrs = append(allErrs, field.Invalid(sinkPath.Child("queueSize"), sink.QueueSize, "queueSize must not be negative"))
+		}
+		if b := sink.Batching; b != nil {
+			if b.MaxEvents < 0 {
+				allErrs = append(allErrs, field.Invalid(sinkPath.Child("batching", "maxEvents"), b.MaxEvents, "maxEvents must not be negative"))
+			}
+			if b.MaxWait != nil && b.MaxWait.Duration <= 0 {
+				allErrs = append(allErrs, field.Invalid(sinkPath.Child("batching", "maxWait"), b.MaxWait.Duration.String(), "maxWait must be positive"))
+			}
+		}
+		if r := sink.Retry; r != nil {
+			if r.MaxAttempts < 0 {
+				allErrs = append(allErrs, field.Invalid(sinkPath.Child("retry", "maxAttempts"), r.MaxAttempts, "maxAttempts must not be negative"))
+			}
+			if r.Backoff != nil && r.Backoff.Duration <= 0 {
+				allErrs = append(allErrs, field.Invalid(sinkPath.Child("retry", "backoff"), r.Backoff.Duration.String(), "backoff must be positive"))
+			}
+		}
+	}
+	return allErrs
+}
+
+// validateBuffering validates the event queue settings
+func (v *CudaEBPFPolicyCustomValidator) validateBuffering(b *gpuv1alpha1.EventBuffering, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if b.QueueSize < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("queueSize"), b.QueueSize, "queueSize must not be negative"))
+	}
+	validPolicies := []string{"dropOldest", "dropNewest", "block"}
+	if b.Policy != "" && !slices.Contains(validPolicies, b.Policy) {
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("policy"), b.Policy, validPolicies))
+	}
+	if b.Spill != nil {
+		if !path.IsAbs(b.Spill.Path) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("spill", "path"), b.Spill.Path, "path must be absolute"))
+		}
+		if b.Spill.MaxSizeMB < 0 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("spill", "maxSizeMB"), b.Spill.MaxSizeMB, "maxSizeMB must not be negative"))
+		}
+	}
+	return allErrs
+}
+
+// agentVolumes are the volumes the controller generates for the agent pods.
+// Volumes named "sink-<name>" are generated for file sinks.
+var agentVolumes = []string{"tmp", "lib-modules", "usr-src", "sys-kernel-debug", "sys-fs-bpf", "event-spill", "tenant-pods", "agent-script"}
+
+// isAgentVolume reports whether a volume name is reserved for the agent
+func isAgentVolume(name string) bool {
+	return slices.Contains(agentVolumes, name) || strings.HasPrefix(name, "sink-")
+}
+
+// agentLabels are the labels that select the agent pods of a policy.
+var agentLabels = []string{"app", "gpu.obs.gpu/policy", "gpu.obs.gpu/cluster-policy"}
+
+// agentEnv are the env vars the controller generates for the agent pods.
+// Every env var prefixed with one of agentEnvPrefixes is reserved as well.
+var (
+	agentEnv         = []string{"SCRIPT_PATH", "POD_UIDS_FILE", "FUNCTION_CALLS", "OUTPUT_SINKS", "LIB_PATH", "POLICY_NAME", "NODE_NAME", "POD_NAME", "POD_NAMESPACE"}
+	agentEnvPrefixes = []string{"EVENT_", "OTEL_"}
+)
+
+// isAgentEnv reports whether an env var name is reserved for the agent
+func isAgentEnv(name string) bool {
+	if slices.Contains(agentEnv, name) {
+		return true
+	}
+	return slices.ContainsFunc(agentEnvPrefixes, func(prefix string) bool { return strings.HasPrefix(name, prefix) })
+}
+
+// validatePodTemplate validates the agent pod template overrides
+func (v *CudaEBPFPolicyCustomValidator) validatePodTemplate(tpl *gpuv1alpha1.AgentPodTemplate, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	allErrs = append(allErrs, metav1validation.ValidateLabels(tpl.Labels, fldPath.Child("labels"))...)
+	for _, key := range agentLabels {
+		if _, ok := tpl.Labels[key]; ok {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child("labels").Key(key), "the "+key+" label selects the agent pods and cannot be overridden"))
+		}
+	}
+
+	envNames := map[string]bool{}
+	for i, env := range tpl.Env {
+		namePath := fldPath.Child("env").Index(i).Child("name")
+		switch {
+		case env.Name == "":
+			allErrs = append(allErrs, field.Required(namePath, "env var name must be specified"))
+		case isAgentEnv(env.Name):
+			allErrs = append(allErrs, field.Invalid(namePath, env.Name, "env var name is reserved for the configuration of the agent"))
+		case envNames[env.Name]:
+			allErrs = append(allErrs, field.Duplicate(namePath, env.Name))
+		}
+		envNames[env.Name] = true
+	}
+
+	volumeNames := map[string]bool{}
+	for i, volume := range tpl.Volumes {
+		namePath := fldPath.Child("volumes").Index(i).Child("name")
+		switch {
+		case volume.Name == "":
+			allErrs = append(allErrs, field.Required(namePath, "volume name must be specified"))
+		case isAgentVolume(volume.Name):
+			allErrs = append(allErrs, field.Invalid(namePath, volume.Name, "volume name is reserved for the volumes of the agent"))
+		case volumeNames[volume.Name]:
+			allErrs = append(allErrs, field.Duplicate(namePath, volume.Name))
+		default:
+			if errs := validation.IsDNS1123Label(volume.Name); len(errs) > 0 {
+				allErrs = append(allErrs, field.Invalid(namePath, volume.Name, strings.Join(errs, "; ")))
+			}
+		}
+		volumeNames[volume.Name] = true
+	}
+	for i, mount := range tpl.VolumeMounts {
+		mountPath := fldPath.Child("volumeMounts").Index(i)
+		switch {
+		case isAgentVolume(mount.Name):
+			// Mounting them elsewhere would expose host paths beyond the ones
+			// the controller mounts
+			allErrs = append(allErrs, field.Forbidden(mountPath.Child("name"), "the volumes of the agent cannot be mounted again"))
+		case !volumeNames[mount.Name]:
+			allErrs = append(allErrs, field.NotFound(mountPath.Child("name"), mount.Name))
+		}
+		if !path.IsAbs(mount.MountPath) {
+			allErrs = append(allErrs, field.Invalid(mountPath.Child("mountPath"), mount.MountPath, "mountPath must be absolute"))
+		}
+	}
+	return allErrs
+}
+
+// validateImagePolicy enforces the operator's image policy on the agent image
+func (v *CudaEBPFPolicyCustomValidator) validateImagePolicy(spec *gpuv1alpha1.CudaEBPFPolicySpec, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if err := v.ImagePolicy.Check(spec.Image); err != nil {
+		return append(allErrs, field.Forbidden(fldPath.Child("image"), err.Error()))
+	}
+	if !v.ImagePolicy.SignatureRequired() {
+		return allErrs
+	}
+	if spec.ImageSignature == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("imageSignature"), "agent images must be signed"))
+	} else if err := v.ImagePolicy.VerifySignature(spec.Image, spec.ImageSignature); err != nil {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("imageSignature"), err.Error()))
+	}
+	return allErrs
+}
+
+// validateSecurity validates the seccomp and AppArmor profiles of the agent
+func (v *CudaEBPFPolicyCustomValidator) validateSecurity(security *gpuv1alpha1.AgentSecurity, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if p := security.SeccompProfile; p != nil {
+		validTypes := []string{string(corev1.SeccompProfileTypeRuntimeDefault), string(corev1.SeccompProfileTypeLocalhost), string(corev1.SeccompProfileTypeUnconfined)}
+		if !slices.Contains(validTypes, string(p.Type)) {
+			allErrs = append(allErrs, field.NotSupported(fldPath.Child("seccompProfile", "type"), p.Type, validTypes))
+		}
+		if (p.Type == corev1.SeccompProfileTypeLocalhost) != (p.LocalhostProfile != nil && *p.LocalhostProfile != "") {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("seccompProfile", "localhostProfile"), p.LocalhostProfile, "localhostProfile must be set if and only if type is Localhost"))
+		}
+	}
+	if p := security.AppArmorProfile; p != nil {
+		validTypes := []string{string(corev1.AppArmorProfileTypeRuntimeDefault), string(corev1.AppArmorProfileTypeLocalhost), string(corev1.AppArmorProfileTypeUnconfined)}
+		if !slices.Contains(validTypes, string(p.Type)) {
+			allErrs = append(allErrs, field.NotSupported(fldPath.Child("appArmorProfile", "type"), p.Type, validTypes))
+		}
+		if (p.Type == corev1.AppArmorProfileTypeLocalhost) != (p.LocalhostProfile != nil && *p.LocalhostProfile != "") {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("appArmorProfile", "localhostProfile"), p.LocalhostProfile, "localhostProfile must be set if and only if type is Localhost"))
+		}
+	}
+	return allErrs
+}
+
 // validateMode validates the mode field
 func (v *CudaEBPFPolicyCustomValidator) validateMode(mode string, fldPath *field.Path) *field.Error {
 	validModes := []string{"pidwatch", "systemwide"}
@@ -18,14 +18,30 @@ package v1alpha1
 
 import (
 	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
 	gpuv1alpha1 "github.com/WoodProgrammer/gpu-bpf-operator/api/v1alpha1"
+	"github.com/WoodProgrammer/gpu-bpf-operator/internal/config"
+	"github.com/WoodProgrammer/gpu-bpf-operator/internal/imagepolicy"
 )
 
+const imageDigest = "sha256:9a1b5c0e3f6d8e2a4b7c9d1e3f5a7b9c2d4e6f8a0b1c3d5e7f9a2b4c6d8e0f1a"
+
 var _ = Describe("CudaEBPFPolicy Webhook", func() {
 	var (
 		obj       *gpuv1alpha1.CudaEBPFPolicy
@@ -44,7 +60,10 @@ var _ = Describe("CudaEBPFPolicy Webhook", func() {
 			},
 		}
 		oldObj = &gpuv1alpha1.CudaEBPFPolicy{}
-		validator = CudaEBPFPolicyCustomValidator{}
+		ctx = admission.NewContextWithRequest(ctx, admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			UserInfo: authenticationv1.UserInfo{Username: "alice", Groups: []string{"research"}},
+		}})
+		validator = CudaEBPFPolicyCustomValidator{Authorizer: fakeHostTracing{"alice": true}}
 		Expect(validator).NotTo(BeNil(), "Expected validator to be initialized")
 		defaulter = CudaEBPFPolicyCustomDefaulter{}
 		Expect(defaulter).NotTo(BeNil(), "Expected defaulter to be initialized")
@@ -64,6 +83,22 @@ var _ = Describe("CudaEBPFPolicy Webhook", func() {
 			Expect(obj.Spec.OutputFormat).To(Equal("ndjson"))
 		})
 
+		It("Should default the backpressure of output sinks to drop", func() {
+			By("simulating a sink without a backpressure policy")
+			obj.Spec.Sinks = []gpuv1alpha1.OutputSink{
+				{Name: "local", File: &gpuv1alpha1.FileSink{Path: "/var/log/gpu-bpf"}},
+				{Name: "hook", HTTP: &gpuv1alpha1.HTTPSink{URL: "https://events.example.com"}, Backpressure: "block"},
+			}
+
+			By("calling the Default method to apply defaults")
+			err := defaulter.Default(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+
+			By("checking that only the unset policy is defaulted")
+			Expect(obj.Spec.Sinks[0].Backpressure).To(Equal("drop"))
+			Expect(obj.Spec.Sinks[1].Backpressure).To(Equal("block"))
+		})
+
 		It("Should apply defaults for mode when empty", func() {
 			By("simulating a scenario where mode is empty")
 			obj.Spec.Mode = ""
@@ -90,6 +125,85 @@ var _ = Describe("CudaEBPFPolicy Webhook", func() {
 			Expect(obj.Spec.OutputFormat).To(Equal("prometheus"))
 			Expect(obj.Spec.Mode).To(Equal("systemwide"))
 		})
+
+		It("Should fill in the operator's agent defaults", func() {
+			By("configuring operator-wide agent defaults")
+			defaulter.Defaults = &config.AgentDefaults{
+				Image:           "ghcr.io/woodprogrammer/gpu-bpf-agent:v1",
+				ImagePullPolicy: corev1.PullIfNotPresent,
+				Resources: &corev1.ResourceRequirements{
+					Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("512Mi")},
+				},
+				Tolerations: []corev1.Toleration{{Key: "nvidia.com/gpu", Operator: corev1.TolerationOpExists}},
+			}
+
+			By("calling the Default method")
+			err := defaulter.Default(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+
+			By("checking that the defaults are set")
+			Expect(obj.Spec.Image).To(Equal("ghcr.io/woodprogrammer/gpu-bpf-agent:v1"))
+			Expect(obj.Spec.ImagePullPolicy).To(Equal(corev1.PullIfNotPresent))
+			Expect(obj.Spec.PodTemplate).NotTo(BeNil())
+			Expect(obj.Spec.PodTemplate.Resources.Limits.Memory().String()).To(Equal("512Mi"))
+			Expect(obj.Spec.PodTemplate.Tolerations).To(HaveLen(1))
+		})
+
+		It("Should not override agent settings of the policy", func() {
+			By("configuring operator-wide agent defaults")
+			defaulter.Defaults = &config.AgentDefaults{
+				Image:           "ghcr.io/woodprogrammer/gpu-bpf-agent:v1",
+				ImagePullPolicy: corev1.PullIfNotPresent,
+				Tolerations:     []corev1.Toleration{{Key: "nvidia.com/gpu", Operator: corev1.TolerationOpExists}},
+			}
+			obj.Spec.Image = "test-image:latest"
+			obj.Spec.ImagePullPolicy = corev1.PullAlways
+			obj.Spec.PodTemplate = &gpuv1alpha1.AgentPodTemplate{
+				Tolerations: []corev1.Toleration{{Key: "dedicated", Value: "gpu", Effect: corev1.TaintEffectNoSchedule}},
+			}
+
+			By("calling the Default method")
+			err := defaulter.Default(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+
+			By("checking that the policy keeps its settings")
+			Expect(obj.Spec.Image).To(Equal("test-image:latest"))
+			Expect(obj.Spec.ImagePullPolicy).To(Equal(corev1.PullAlways))
+			Expect(obj.Spec.PodTemplate.Tolerations).To(ConsistOf(HaveField("Key", "dedicated")))
+		})
+
+		It("Should record who changed the spec", func() {
+			obj.Spec.Mode = "pidwatch"
+			obj.Spec.OutputFormat = "ndjson"
+			request := func(operation admissionv1.Operation, old *gpuv1alpha1.CudaEBPFPolicy) context.Context {
+				req := admissionv1.AdmissionRequest{
+					Operation: operation,
+					UserInfo:  authenticationv1.UserInfo{Username: "alice"},
+				}
+				if old != nil {
+					raw, err := json.Marshal(old)
+					Expect(err).NotTo(HaveOccurred())
+					req.OldObject.Raw = raw
+				}
+				return admission.NewContextWithRequest(context.Background(), admission.Request{AdmissionRequest: req})
+			}
+
+			By("stamping the creator")
+			Expect(defaulter.Default(request(admissionv1.Create, nil), obj)).To(Succeed())
+			Expect(obj.Annotations).To(HaveKeyWithValue(gpuv1alpha1.ChangedByAnnotation, "alice"))
+
+			By("keeping the previous author when only metadata changes")
+			old := obj.DeepCopy()
+			old.Annotations[gpuv1alpha1.ChangedByAnnotation] = "bob"
+			obj.Annotations[gpuv1alpha1.ChangedByAnnotation] = "mallory"
+			Expect(defaulter.Default(request(admissionv1.Update, old), obj)).To(Succeed())
+			Expect(obj.Annotations).To(HaveKeyWithValue(gpuv1alpha1.ChangedByAnnotation, "bob"))
+
+			By("stamping the user who changed the spec")
+			obj.Spec.ProcessRegex = "python.*"
+			Expect(defaulter.Default(request(admissionv1.Update, old), obj)).To(Succeed())
+			Expect(obj.Annotations).To(HaveKeyWithValue(gpuv1alpha1.ChangedByAnnotation, "alice"))
+		})
 	})
 
 	Context("When creating or updating CudaEBPFPolicy under Validating Webhook", func() {
@@ -295,6 +409,40 @@ var _ = Describe("CudaEBPFPolicy Webhook", func() {
 			Expect(err.Error()).To(ContainSubstring("libPath must be specified"))
 		})
 
+		It("Should admit kernel functions without libPath", func() {
+			By("simulating a creation scenario with kernel functions only")
+			obj.Spec.Functions = []gpuv1alpha1.Function{
+				{Name: "nvidia_ioctl", Kind: "kprobe"},
+			}
+			obj.Spec.LibPath = ""
+			obj.Spec.Image = "test-image:latest"
+			obj.Spec.Mode = "pidwatch"
+
+			By("validating the creation")
+			warnings, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(BeEmpty())
+
+			By("warning about a libPath nothing attaches to")
+			obj.Spec.LibPath = "/usr/lib/libcudart.so"
+			warnings, err = validator.ValidateCreate(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(ConsistOf(ContainSubstring("spec.libPath is unused")))
+		})
+
+		It("Should deny relative libPaths", func() {
+			By("simulating an invalid creation scenario")
+			obj.Spec.Functions = []gpuv1alpha1.Function{{Name: "cudaMalloc", Kind: "uprobe"}}
+			obj.Spec.LibPath = "libcudart.so"
+			obj.Spec.Image = "test-image:latest"
+			obj.Spec.Mode = "pidwatch"
+
+			By("validating the creation")
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("libPath must be an absolute path"))
+		})
+
 		It("Should deny creation if image is empty", func() {
 			By("simulating an invalid creation scenario")
 			obj.Spec.Functions = []gpuv1alpha1.Function{
@@ -313,6 +461,207 @@ var _ = Describe("CudaEBPFPolicy Webhook", func() {
 			Expect(err.Error()).To(ContainSubstring("image must be specified"))
 		})
 
+		It("Should deny images from registries that are not allowed", func() {
+			By("configuring an allowlist of registries")
+			validator.Defaults = &config.AgentDefaults{AllowedRegistries: []string{"ghcr.io"}}
+			obj.Spec.Functions = []gpuv1alpha1.Function{{Name: "cudaStreamCreate", Kind: "uprobe"}}
+			obj.Spec.LibPath = "/usr/lib/libcuda.so"
+			obj.Spec.Mode = "pidwatch"
+			obj.Spec.Image = "quay.io/woodprogrammer/gpu-bpf-agent:v1"
+
+			By("validating the creation")
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("registry quay.io is not allowed"))
+
+			By("using an image from the allowed registry")
+			obj.Spec.Image = "ghcr.io/woodprogrammer/gpu-bpf-agent:v1"
+			_, err = validator.ValidateCreate(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Should deny agent images outside the image policy", func() {
+			By("configuring an image policy that requires digests")
+			validator.ImagePolicy = &imagepolicy.Policy{
+				AllowedRepositories: []string{"ghcr.io/woodprogrammer"},
+				RequireDigest:       true,
+			}
+			obj.Spec.Functions = []gpuv1alpha1.Function{{Name: "cudaStreamCreate", Kind: "uprobe"}}
+			obj.Spec.LibPath = "/usr/lib/libcuda.so"
+			obj.Spec.Mode = "pidwatch"
+
+			By("using an image of another repository")
+			obj.Spec.Image = "docker.io/attacker/agent@" + imageDigest
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("repository docker.io/attacker/agent is not allowed"))
+
+			By("using a tag instead of a digest")
+			obj.Spec.Image = "ghcr.io/woodprogrammer/gpu-bpf-agent:v1"
+			_, err = validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("pinned by digest"))
+
+			By("using a pinned image of an allowed repository")
+			obj.Spec.Image = "ghcr.io/woodprogrammer/gpu-bpf-agent@" + imageDigest
+			_, err = validator.ValidateCreate(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Should require a valid signature of the agent image", func() {
+			By("configuring a signing key")
+			public, private, err := ed25519.GenerateKey(rand.Reader)
+			Expect(err).NotTo(HaveOccurred())
+			validator.ImagePolicy = &imagepolicy.Policy{PublicKeys: []crypto.PublicKey{public}}
+			obj.Spec.Functions = []gpuv1alpha1.Function{{Name: "cudaStreamCreate", Kind: "uprobe"}}
+			obj.Spec.LibPath = "/usr/lib/libcuda.so"
+			obj.Spec.Mode = "pidwatch"
+			obj.Spec.Image = "ghcr.io/woodprogrammer/gpu-bpf-agent@" + imageDigest
+
+			By("omitting the signature")
+			_, err = validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("agent images must be signed"))
+
+			By("signing another digest")
+			obj.Spec.ImageSignature = base64.StdEncoding.EncodeToString(ed25519.Sign(private, []byte("sha256:"+strings.Repeat("0", 64))))
+			_, err = validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.imageSignature"))
+
+			By("signing the digest of the image")
+			obj.Spec.ImageSignature = base64.StdEncoding.EncodeToString(ed25519.Sign(private, []byte(imageDigest)))
+			_, err = validator.ValidateCreate(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Should deny host access to users without the trace-host permission", func() {
+			By("simulating a user that was not granted host tracing")
+			validator.Authorizer = fakeHostTracing{}
+			obj.Spec.Functions = []gpuv1alpha1.Function{
+				{Name: "cudaMalloc", Kind: "uprobe"},
+				{Name: "nvidia_ioctl", Kind: "kprobe"},
+			}
+			obj.Spec.LibPath = "/usr/lib/libcudart.so"
+			obj.Spec.Image = "test-image:latest"
+			obj.Spec.Mode = "systemwide"
+			obj.Spec.PodTemplate = &gpuv1alpha1.AgentPodTemplate{
+				Volumes: []corev1.Volume{{
+					Name:         "host",
+					VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/"}},
+				}},
+			}
+
+			By("validating the creation")
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.mode"))
+			Expect(err.Error()).To(ContainSubstring("spec.functions[1].kind"))
+			Expect(err.Error()).To(ContainSubstring("spec.podTemplate.volumes[0].hostPath"))
+			Expect(err.Error()).To(ContainSubstring(`requires the "trace-host" permission`))
+		})
+
+		It("Should admit namespace-scoped tracing without the trace-host permission", func() {
+			By("simulating a user that was not granted host tracing")
+			validator.Authorizer = fakeHostTracing{}
+			obj.Spec.Functions = []gpuv1alpha1.Function{{Name: "cudaMalloc", Kind: "uprobe"}}
+			obj.Spec.LibPath = "/usr/lib/libcudart.so"
+			obj.Spec.Image = "test-image:latest"
+			obj.Spec.Mode = "pidwatch"
+			obj.Spec.PodSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "trainer"}}
+
+			By("validating the creation")
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Should treat driver probes as host access", func() {
+			By("simulating a pidwatch policy with driver kprobes from a user without host tracing")
+			validator.Authorizer = fakeHostTracing{}
+			obj.Spec.Probes = []string{"nvidia_open"}
+			obj.Spec.Image = "test-image:latest"
+			obj.Spec.Mode = "pidwatch"
+
+			By("validating the creation")
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.probes[0]"))
+			Expect(err.Error()).To(ContainSubstring(`requires the "trace-host" permission`))
+		})
+
+		It("Should treat legacy kernels, file sinks and spilled events as host access", func() {
+			By("simulating a pidwatch policy writing to the host from a user without host tracing")
+			validator.Authorizer = fakeHostTracing{}
+			obj.Spec.Functions = []gpuv1alpha1.Function{{Name: "cudaMalloc", Kind: "uprobe"}}
+			obj.Spec.LibPath = "/usr/lib/libcudart.so"
+			obj.Spec.Image = "test-image:latest"
+			obj.Spec.Mode = "pidwatch"
+			obj.Spec.Security = &gpuv1alpha1.AgentSecurity{LegacyKernel: true}
+			obj.Spec.Sinks = []gpuv1alpha1.OutputSink{
+				{Name: "hook", HTTP: &gpuv1alpha1.HTTPSink{URL: "https://events.example.com/ingest"}},
+				{Name: "local", File: &gpuv1alpha1.FileSink{Path: "/etc"}},
+			}
+			obj.Spec.Buffering = &gpuv1alpha1.EventBuffering{Spill: &gpuv1alpha1.BufferSpill{Path: "/root"}}
+
+			By("validating the creation")
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.security.legacyKernel"))
+			Expect(err.Error()).NotTo(ContainSubstring("spec.sinks[0]"))
+			Expect(err.Error()).To(ContainSubstring("spec.sinks[1].file.path"))
+			Expect(err.Error()).To(ContainSubstring("spec.buffering.spill.path"))
+			Expect(err.Error()).To(ContainSubstring(`requires the "trace-host" permission`))
+		})
+
+		It("Should not check the permission again when the spec is unchanged", func() {
+			By("simulating the controller adding its finalizer to a systemwide policy")
+			validator.Authorizer = fakeHostTracing{}
+			obj.Spec.Functions = []gpuv1alpha1.Function{{Name: "cudaMalloc", Kind: "uprobe"}}
+			obj.Spec.LibPath = "/usr/lib/libcudart.so"
+			obj.Spec.Image = "test-image:latest"
+			obj.Spec.Mode = "systemwide"
+			oldObj = obj.DeepCopy()
+			obj.Finalizers = []string{"gpu.obs.gpu/finalizer"}
+
+			By("validating the update")
+			_, err := validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).NotTo(HaveOccurred())
+
+			By("changing the spec")
+			obj.Spec.ProcessRegex = "python.*"
+			_, err = validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("Should deny invalid pod selectors", func() {
+			obj.Spec.Functions = []gpuv1alpha1.Function{{Name: "cudaMalloc", Kind: "uprobe"}}
+			obj.Spec.LibPath = "/usr/lib/libcudart.so"
+			obj.Spec.Image = "test-image:latest"
+			obj.Spec.Mode = "pidwatch"
+			obj.Spec.PodSelector = &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "app", Operator: metav1.LabelSelectorOpIn},
+			}}
+
+			By("validating the creation")
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.podSelector"))
+		})
+
+		It("Should deny unknown image pull policies", func() {
+			By("simulating an invalid pull policy")
+			obj.Spec.Functions = []gpuv1alpha1.Function{{Name: "cudaStreamCreate", Kind: "uprobe"}}
+			obj.Spec.LibPath = "/usr/lib/libcuda.so"
+			obj.Spec.Mode = "pidwatch"
+			obj.Spec.Image = "test-image:latest"
+			obj.Spec.ImagePullPolicy = "Sometimes"
+
+			By("validating the creation")
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.imagePullPolicy"))
+		})
+
 		It("Should admit creation with valid spec", func() {
 			By("simulating a valid creation scenario")
 			obj.Spec.Functions = []gpuv1alpha1.Function{
@@ -359,6 +708,455 @@ var _ = Describe("CudaEBPFPolicy Webhook", func() {
 			Expect(err).NotTo(HaveOccurred())
 		})
 
+		It("Should admit arguments and return value classification on one return probe", func() {
+			By("simulating a return probe that also logs its arguments")
+			obj.Spec.Functions = []gpuv1alpha1.Function{
+				{Name: "cudaMalloc", Kind: "uretprobe", Returns: "cudaError", Args: []gpuv1alpha1.Arg{{Index: 1, Name: "size"}}},
+			}
+			obj.Spec.LibPath = "/usr/lib/libcudart.so"
+			obj.Spec.Image = "test-image:latest"
+			obj.Spec.Mode = "pidwatch"
+
+			By("validating the creation")
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+
+			By("linting the args of the return probe")
+			obj.Spec.Functions[0].Args[0].Index = 6
+			_, err = validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("arg6 is out of range"))
+		})
+
+		It("Should require kernel symbols for kernel functions", func() {
+			By("simulating a kprobe on something that can't be a kernel symbol")
+			obj.Spec.Functions = []gpuv1alpha1.Function{
+				{Name: "_ZN4cuda6launchEv@plt", Kind: "kprobe"},
+			}
+			obj.Spec.Image = "test-image:latest"
+			obj.Spec.Mode = "pidwatch"
+
+			By("validating the creation")
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("must be kernel symbols"))
+
+			By("warning about kprobes on CUDA API names")
+			obj.Spec.Functions = []gpuv1alpha1.Function{
+				{Name: "cudaMalloc", Kind: "kprobe"},
+				{Name: "nvidia_ioctl", Kind: "kprobe"},
+			}
+			warnings, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(ConsistOf(ContainSubstring("spec.functions[0]: cudaMalloc looks like a CUDA library function")))
+		})
+
+		It("Should require plain identifiers for library functions and argument names", func() {
+			By("simulating a uprobe name that smuggles in a second attach point")
+			obj.Spec.Functions = []gpuv1alpha1.Function{
+				{Name: "cudaMalloc,kprobe:do_sys_openat2", Kind: "uprobe"},
+			}
+			obj.Spec.LibPath = "/usr/lib/libcudart.so"
+			obj.Spec.Image = "test-image:latest"
+			obj.Spec.Mode = "pidwatch"
+
+			By("validating the creation")
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.functions[0].name: Invalid value"))
+			Expect(err.Error()).To(ContainSubstring("must be library symbols"))
+
+			By("simulating an argument name that ends up in a printf format")
+			obj.Spec.Functions = []gpuv1alpha1.Function{
+				{Name: "cudaMalloc", Kind: "uprobe", Args: []gpuv1alpha1.Arg{{Index: 1, Name: "size=%s"}}},
+			}
+			_, err = validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.functions[0].args[0].name: Invalid value"))
+
+			By("accepting mangled C++ symbols and plain argument names")
+			obj.Spec.Functions = []gpuv1alpha1.Function{
+				{Name: "_ZN4cuda6launchEv", Kind: "uprobe", Args: []gpuv1alpha1.Arg{{Index: 1, Name: "dev_ptr"}}},
+			}
+			_, err = validator.ValidateCreate(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Should deny driver probes traced by both probes and functions", func() {
+			By("simulating a kernel function that is also a selected driver probe")
+			obj.Spec.Probes = []string{"nvidia_mmap", "nvidia_open", "nvidia_open"}
+			obj.Spec.Functions = []gpuv1alpha1.Function{
+				{Name: "cudaMalloc", Kind: "uprobe"},
+				{Name: "nvidia_open", Kind: "kretprobe"},
+			}
+			obj.Spec.LibPath = "/usr/lib/libcudart.so"
+			obj.Spec.Image = "test-image:latest"
+			obj.Spec.Mode = "pidwatch"
+
+			By("validating the creation")
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.probes[2]: Duplicate value"))
+			Expect(err.Error()).To(ContainSubstring("spec.functions[1].name: Invalid value: \"nvidia_open\": nvidia_open is already traced by spec.probes[1]"))
+		})
+
+		It("Should deny unknown driver probes", func() {
+			By("simulating an invalid creation scenario")
+			obj.Spec.Probes = []string{"nvidia_open", "anan"}
+			obj.Spec.Functions = []gpuv1alpha1.Function{{Name: "cudaMalloc", Kind: "uprobe"}}
+			obj.Spec.LibPath = "/usr/lib/libcudart.so"
+			obj.Spec.Image = "test-image:latest"
+			obj.Spec.Mode = "pidwatch"
+
+			By("validating the creation")
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.probes[1]: Unsupported value: \"anan\""))
+		})
+
+		It("Should admit return value classification on return probes", func() {
+			By("simulating a valid creation scenario with classified returns")
+			obj.Spec.Functions = []gpuv1alpha1.Function{
+				{Name: "cudaMalloc", Kind: "uretprobe", Returns: "cudaError"},
+				{Name: "nvidia_open", Kind: "kretprobe", Returns: "errno"},
+				{Name: "cudaHostAlloc", Kind: "uretprobe", Returns: "pointer"},
+			}
+			obj.Spec.LibPath = "/usr/lib/libcudart.so"
+			obj.Spec.Image = "test-image:latest"
+			obj.Spec.Mode = "pidwatch"
+
+			By("validating the creation")
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Should deny return value classification on entry probes", func() {
+			By("simulating an invalid creation scenario")
+			obj.Spec.Functions = []gpuv1alpha1.Function{
+				{Name: "cudaMalloc", Kind: "uprobe", Returns: "cudaError"},
+			}
+			obj.Spec.LibPath = "/usr/lib/libcudart.so"
+			obj.Spec.Image = "test-image:latest"
+			obj.Spec.Mode = "pidwatch"
+
+			By("validating the creation")
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("returns is only supported for uretprobe and kretprobe functions"))
+		})
+
+		It("Should deny unknown return value classifications", func() {
+			By("simulating an invalid creation scenario")
+			obj.Spec.Functions = []gpuv1alpha1.Function{
+				{Name: "cudaMalloc", Kind: "uretprobe", Returns: "hresult"},
+			}
+			obj.Spec.LibPath = "/usr/lib/libcudart.so"
+			obj.Spec.Image = "test-image:latest"
+			obj.Spec.Mode = "pidwatch"
+
+			By("validating the creation")
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("returns"))
+		})
+
+		It("Should admit stack capture on traced functions", func() {
+			By("simulating a valid creation scenario with stack capture")
+			obj.Spec.Functions = []gpuv1alpha1.Function{
+				{Name: "nvidia_unlocked_ioctl", Kind: "kprobe", Stack: &gpuv1alpha1.StackCapture{Kernel: true, User: true, TopN: 5}},
+				{Name: "cudaMalloc", Kind: "uprobe", Stack: &gpuv1alpha1.StackCapture{User: true}},
+			}
+			obj.Spec.LibPath = "/usr/lib/libcudart.so"
+			obj.Spec.Image = "test-image:latest"
+			obj.Spec.Mode = "pidwatch"
+
+			By("validating the creation")
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Should deny stack capture without any stack selected", func() {
+			By("simulating an invalid creation scenario")
+			obj.Spec.Functions = []gpuv1alpha1.Function{
+				{Name: "cudaMalloc", Kind: "uprobe", Stack: &gpuv1alpha1.StackCapture{TopN: 5}},
+			}
+			obj.Spec.LibPath = "/usr/lib/libcudart.so"
+			obj.Spec.Image = "test-image:latest"
+			obj.Spec.Mode = "pidwatch"
+
+			By("validating the creation")
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("at least one of kernel or user stacks must be enabled"))
+		})
+
+		It("Should deny functions that render to an invalid program", func() {
+			By("simulating a function name bpftrace can't attach to")
+			obj.Spec.Functions = []gpuv1alpha1.Function{
+				{Name: "cudaMalloc", Kind: "uprobe"},
+				{Name: "cuda Free", Kind: "uprobe"},
+			}
+			obj.Spec.LibPath = "/usr/lib/libcudart.so"
+			obj.Spec.Image = "test-image:latest"
+			obj.Spec.Mode = "pidwatch"
+
+			By("validating the creation")
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.functions[1].name: Invalid value: \"cuda Free\""))
+		})
+
+		It("Should deny arguments the program can't read", func() {
+			By("simulating an argument beyond the registers and a format verb in its name")
+			obj.Spec.Functions = []gpuv1alpha1.Function{
+				{Name: "cudaMemcpy", Kind: "uprobe", Args: []gpuv1alpha1.Arg{{Index: 6, Name: "stream"}}},
+				{Name: "cudaMalloc", Kind: "uprobe", Args: []gpuv1alpha1.Arg{{Index: 1, Name: "size%"}}},
+			}
+			obj.Spec.LibPath = "/usr/lib/libcudart.so"
+			obj.Spec.Image = "test-image:latest"
+			obj.Spec.Mode = "pidwatch"
+
+			By("validating the creation")
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.functions[1].args[0].name: Invalid value: \"size%\""))
+
+			By("linting the program once the argument names are valid")
+			obj.Spec.Functions[1].Args[0].Name = "size"
+			_, err = validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.functions[0]: Invalid value: \"cudaMemcpy\""))
+			Expect(err.Error()).To(ContainSubstring("arg6 is out of range"))
+			Expect(err.Error()).NotTo(ContainSubstring("spec.functions[1]"))
+		})
+
+		It("Should admit recurring tracing schedules", func() {
+			By("simulating a valid creation scenario with a cron schedule")
+			obj.Spec.Functions = []gpuv1alpha1.Function{{Name: "cudaMalloc", Kind: "uprobe"}}
+			obj.Spec.LibPath = "/usr/lib/libcudart.so"
+			obj.Spec.Image = "test-image:latest"
+			obj.Spec.Mode = "pidwatch"
+			obj.Spec.Schedule = &gpuv1alpha1.TracingSchedule{
+				Cron:     "0 2 * * *",
+				Duration: &metav1.Duration{Duration: 10 * time.Minute},
+			}
+
+			By("validating the creation")
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Should deny cron schedules without a duration", func() {
+			By("simulating an invalid creation scenario")
+			obj.Spec.Functions = []gpuv1alpha1.Function{{Name: "cudaMalloc", Kind: "uprobe"}}
+			obj.Spec.LibPath = "/usr/lib/libcudart.so"
+			obj.Spec.Image = "test-image:latest"
+			obj.Spec.Mode = "pidwatch"
+			obj.Spec.Schedule = &gpuv1alpha1.TracingSchedule{Cron: "0 2 * * *"}
+
+			By("validating the creation")
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("duration must be specified for cron schedules"))
+		})
+
+		It("Should deny malformed cron expressions", func() {
+			By("simulating an invalid creation scenario")
+			obj.Spec.Functions = []gpuv1alpha1.Function{{Name: "cudaMalloc", Kind: "uprobe"}}
+			obj.Spec.LibPath = "/usr/lib/libcudart.so"
+			obj.Spec.Image = "test-image:latest"
+			obj.Spec.Mode = "pidwatch"
+			obj.Spec.Schedule = &gpuv1alpha1.TracingSchedule{
+				Cron:     "0 25 * * *",
+				Duration: &metav1.Duration{Duration: 10 * time.Minute},
+			}
+
+			By("validating the creation")
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.schedule.cron"))
+		})
+
+		It("Should admit OTLP export with traced functions", func() {
+			By("simulating a valid creation scenario with OTLP export")
+			obj.Spec.Functions = []gpuv1alpha1.Function{{Name: "cudaLaunchKernel", Kind: "uprobe", Trace: true}}
+			obj.Spec.LibPath = "/usr/lib/libcudart.so"
+			obj.Spec.Image = "test-image:latest"
+			obj.Spec.Mode = "pidwatch"
+			obj.Spec.OTLP = &gpuv1alpha1.OTLPExport{
+				Endpoint:       "http://otel-collector.observability:4318",
+				MetricInterval: &metav1.Duration{Duration: 30 * time.Second},
+			}
+
+			By("validating the creation")
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Should deny OTLP endpoints that are not URLs", func() {
+			By("simulating an invalid creation scenario")
+			obj.Spec.Functions = []gpuv1alpha1.Function{{Name: "cudaMalloc", Kind: "uprobe"}}
+			obj.Spec.LibPath = "/usr/lib/libcudart.so"
+			obj.Spec.Image = "test-image:latest"
+			obj.Spec.Mode = "pidwatch"
+			obj.Spec.OTLP = &gpuv1alpha1.OTLPExport{Endpoint: "otel-collector:4318"}
+
+			By("validating the creation")
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("endpoint must be an http or https URL"))
+		})
+
+		It("Should admit file, HTTP and Kafka output sinks", func() {
+			By("simulating a valid creation scenario with output sinks")
+			obj.Spec.Functions = []gpuv1alpha1.Function{{Name: "cudaMalloc", Kind: "uprobe"}}
+			obj.Spec.LibPath = "/usr/lib/libcudart.so"
+			obj.Spec.Image = "test-image:latest"
+			obj.Spec.Mode = "pidwatch"
+			obj.Spec.Sinks = []gpuv1alpha1.OutputSink{
+				{Name: "local", File: &gpuv1alpha1.FileSink{Path: "/var/log/gpu-bpf", MaxSizeMB: 50, MaxFiles: 3}},
+				{
+					Name:     "hook",
+					HTTP:     &gpuv1alpha1.HTTPSink{URL: "https://events.example.com/ingest"},
+					Batching: &gpuv1alpha1.SinkBatching{MaxEvents: 100, MaxWait: &metav1.Duration{Duration: 2 * time.Second}},
+					Retry:    &gpuv1alpha1.SinkRetry{MaxAttempts: 5, Backoff: &metav1.Duration{Duration: time.Second}},
+				},
+				{Name: "bus", Kafka: &gpuv1alpha1.KafkaSink{Brokers: []string{"kafka:9092"}, Topic: "gpu-events"}, Backpressure: "block"},
+			}
+
+			By("validating the creation")
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Should deny invalid output sinks", func() {
+			By("simulating an invalid creation scenario")
+			obj.Spec.Functions = []gpuv1alpha1.Function{{Name: "cudaMalloc", Kind: "uprobe"}}
+			obj.Spec.LibPath = "/usr/lib/libcudart.so"
+			obj.Spec.Image = "test-image:latest"
+			obj.Spec.Mode = "pidwatch"
+			obj.Spec.Sinks = []gpuv1alpha1.OutputSink{
+				{Name: "local", File: &gpuv1alpha1.FileSink{Path: "logs"}},
+				{Name: "local", Kafka: &gpuv1alpha1.KafkaSink{Brokers: []string{"kafka:9092"}}},
+				{Name: "both", File: &gpuv1alpha1.FileSink{Path: "/tmp"}, HTTP: &gpuv1alpha1.HTTPSink{URL: "https://events.example.com"}},
+				{Name: "hook", HTTP: &gpuv1alpha1.HTTPSink{URL: "https://events.example.com"}, Backpressure: "spill"},
+			}
+
+			By("validating the creation")
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.sinks[0].file.path"))
+			Expect(err.Error()).To(ContainSubstring("spec.sinks[1].name"))
+			Expect(err.Error()).To(ContainSubstring("spec.sinks[1].kafka.topic"))
+			Expect(err.Error()).To(ContainSubstring("exactly one of file, http or kafka"))
+			Expect(err.Error()).To(ContainSubstring("spec.sinks[3].backpressure"))
+		})
+
+		It("Should deny unknown event queue policies", func() {
+			By("simulating an invalid creation scenario")
+			obj.Spec.Functions = []gpuv1alpha1.Function{{Name: "cudaMalloc", Kind: "uprobe"}}
+			obj.Spec.LibPath = "/usr/lib/libcudart.so"
+			obj.Spec.Image = "test-image:latest"
+			obj.Spec.Mode = "pidwatch"
+			obj.Spec.Buffering = &gpuv1alpha1.EventBuffering{
+				QueueSize: 1000,
+				Policy:    "dropAll",
+				Spill:     &gpuv1alpha1.BufferSpill{Path: "spool"},
+			}
+
+			By("validating the creation")
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.buffering.policy"))
+			Expect(err.Error()).To(ContainSubstring("spec.buffering.spill.path"))
+		})
+
+		It("Should admit pod template overrides for tainted GPU nodes", func() {
+			By("simulating a valid creation scenario with pod template overrides")
+			obj.Spec.Functions = []gpuv1alpha1.Function{{Name: "cudaMalloc", Kind: "uprobe"}}
+			obj.Spec.LibPath = "/usr/lib/libcudart.so"
+			obj.Spec.Image = "test-image:latest"
+			obj.Spec.Mode = "pidwatch"
+			obj.Spec.PodTemplate = &gpuv1alpha1.AgentPodTemplate{
+				Labels: map[string]string{"team": "ml-infra"},
+				Tolerations: []corev1.Toleration{{
+					Key:      "nvidia.com/gpu",
+					Operator: corev1.TolerationOpExists,
+					Effect:   corev1.TaintEffectNoSchedule,
+				}},
+				PriorityClassName: "system-node-critical",
+				Env:               []corev1.EnvVar{{Name: "GOMAXPROCS", Value: "2"}},
+				Volumes: []corev1.Volume{{
+					Name:         "cuda",
+					VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/opt/cuda"}},
+				}},
+				VolumeMounts: []corev1.VolumeMount{{Name: "cuda", MountPath: "/opt/cuda"}},
+			}
+
+			By("validating the creation")
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Should deny pod template overrides that clash with the agent", func() {
+			By("simulating an invalid creation scenario")
+			obj.Spec.Functions = []gpuv1alpha1.Function{{Name: "cudaMalloc", Kind: "uprobe"}}
+			obj.Spec.LibPath = "/usr/lib/libcudart.so"
+			obj.Spec.Image = "test-image:latest"
+			obj.Spec.Mode = "pidwatch"
+			obj.Spec.PodTemplate = &gpuv1alpha1.AgentPodTemplate{
+				Labels: map[string]string{"app": "other", "gpu.obs.gpu/cluster-policy": "other"},
+				Env: []corev1.EnvVar{
+					{Name: "SCRIPT_PATH", Value: "/tmp/other.bt"},
+					{Name: "OTEL_EXPORTER_OTLP_ENDPOINT", Value: "http://collector:4318"},
+					{Name: "EVENT_DROP_POLICY", Value: "block"},
+					{Name: "GOMAXPROCS", Value: "2"},
+				},
+				Volumes: []corev1.Volume{{
+					Name:         "sys-kernel-debug",
+					VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+				}},
+				VolumeMounts: []corev1.VolumeMount{
+					{Name: "missing", MountPath: "data"},
+					{Name: "sink-local", MountPath: "/etc"},
+					{Name: "tmp", MountPath: "/var/lib"},
+				},
+			}
+
+			By("validating the creation")
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.podTemplate.labels[app]"))
+			Expect(err.Error()).To(ContainSubstring("spec.podTemplate.labels[gpu.obs.gpu/cluster-policy]"))
+			Expect(err.Error()).To(ContainSubstring("spec.podTemplate.env[0].name"))
+			Expect(err.Error()).To(ContainSubstring("spec.podTemplate.env[1].name"))
+			Expect(err.Error()).To(ContainSubstring("spec.podTemplate.env[2].name"))
+			Expect(err.Error()).NotTo(ContainSubstring("spec.podTemplate.env[3].name"))
+			Expect(err.Error()).To(ContainSubstring("spec.podTemplate.volumes[0].name"))
+			Expect(err.Error()).To(ContainSubstring("spec.podTemplate.volumeMounts[0].name"))
+			Expect(err.Error()).To(ContainSubstring("spec.podTemplate.volumeMounts[0].mountPath"))
+			Expect(err.Error()).To(ContainSubstring("spec.podTemplate.volumeMounts[1].name: Forbidden"))
+			Expect(err.Error()).To(ContainSubstring("spec.podTemplate.volumeMounts[2].name: Forbidden"))
+		})
+
+		It("Should deny Localhost seccomp profiles without a profile", func() {
+			By("simulating an invalid creation scenario")
+			obj.Spec.Functions = []gpuv1alpha1.Function{{Name: "cudaMalloc", Kind: "uprobe"}}
+			obj.Spec.LibPath = "/usr/lib/libcudart.so"
+			obj.Spec.Image = "test-image:latest"
+			obj.Spec.Mode = "pidwatch"
+			obj.Spec.Security = &gpuv1alpha1.AgentSecurity{
+				SeccompProfile:  &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeLocalhost},
+				AppArmorProfile: &corev1.AppArmorProfile{Type: corev1.AppArmorProfileTypeRuntimeDefault},
+			}
+
+			By("validating the creation")
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.security.seccompProfile.localhostProfile"))
+			Expect(err.Error()).NotTo(ContainSubstring("appArmorProfile"))
+		})
+
 		It("Should validate updates correctly", func() {
 			By("simulating a valid update scenario")
 			oldObj.Spec.Functions = []gpuv1alpha1.Function{
@@ -383,7 +1181,7 @@ var _ = Describe("CudaEBPFPolicy Webhook", func() {
 			}
 			obj.Spec.LibPath = "/usr/lib/libcuda.so"
 			obj.Spec.Image = "test-image:v2"
-			obj.Spec.Mode = "systemwide"
+			obj.Spec.Mode = "pidwatch"
 
 			By("validating the update")
 			_, err := validator.ValidateUpdate(ctx, oldObj, obj)
@@ -413,4 +1211,76 @@ var _ = Describe("CudaEBPFPolicy Webhook", func() {
 		})
 	})
 
+	Context("When updating a live CudaEBPFPolicy under Validating Webhook", func() {
+		BeforeEach(func() {
+			oldObj.Spec.Functions = []gpuv1alpha1.Function{{Name: "cudaMalloc", Kind: "uprobe"}}
+			oldObj.Spec.LibPath = "/usr/lib/libcudart.so"
+			oldObj.Spec.Image = "ghcr.io/woodprogrammer/gpu-bpf-agent:v1"
+			oldObj.Spec.Mode = "pidwatch"
+			oldObj.DeepCopyInto(obj)
+		})
+
+		It("Should require an acknowledgement to change mode or libPath", func() {
+			By("changing mode and libPath without an acknowledgement")
+			obj.Spec.Mode = "systemwide"
+			obj.Spec.LibPath = "/usr/local/cuda/lib64/libcudart.so"
+			_, err := validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.mode: Forbidden: changing mode from pidwatch to systemwide"))
+			Expect(err.Error()).To(ContainSubstring("spec.libPath: Forbidden"))
+
+			By("ignoring an acknowledgement left over from an earlier change")
+			oldObj.Annotations = map[string]string{gpuv1alpha1.AcknowledgeChangeAnnotation: "CHG-1"}
+			obj.Annotations = map[string]string{gpuv1alpha1.AcknowledgeChangeAnnotation: "CHG-1"}
+			_, err = validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).To(HaveOccurred())
+
+			By("admitting the change with a new acknowledgement")
+			obj.Annotations[gpuv1alpha1.AcknowledgeChangeAnnotation] = "CHG-2"
+			_, err = validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Should deny moving the agent image to another repository", func() {
+			By("admitting a new tag of the same repository")
+			obj.Spec.Image = "ghcr.io/woodprogrammer/gpu-bpf-agent:v2"
+			_, err := validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).NotTo(HaveOccurred())
+
+			By("denying another repository even when acknowledged")
+			obj.Spec.Image = "ghcr.io/someone-else/gpu-bpf-agent:v1"
+			obj.Annotations = map[string]string{gpuv1alpha1.AcknowledgeChangeAnnotation: "CHG-3"}
+			_, err = validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("can't move from repository ghcr.io/woodprogrammer/gpu-bpf-agent"))
+		})
+
+		It("Should deny spec changes while agents are rolling out", func() {
+			oldObj.Status.RolloutInProgress = true
+
+			By("admitting updates that leave the spec untouched")
+			obj.Finalizers = []string{"gpu.obs.gpu/finalizer"}
+			_, err := validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).NotTo(HaveOccurred())
+
+			By("denying a spec change")
+			obj.Spec.Functions = append(obj.Spec.Functions, gpuv1alpha1.Function{Name: "cudaFree", Kind: "uprobe"})
+			_, err = validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("still rolling out the previous change"))
+
+			By("admitting the change with an acknowledgement")
+			obj.Annotations = map[string]string{gpuv1alpha1.AcknowledgeChangeAnnotation: "CHG-4"}
+			_, err = validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
 })
+
+// fakeHostTracing grants host tracing to the users set to true.
+type fakeHostTracing map[string]bool
+
+func (f fakeHostTracing) CanTraceHost(_ context.Context, user authenticationv1.UserInfo, _ string) (bool, error) {
+	return f[user.Username], nil
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2025 WoodProgrammer.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	gpuv1alpha1 "github.com/WoodProgrammer/gpu-bpf-operator/api/v1alpha1"
+)
+
+const (
+	// defaultCanaryPercent rolls a binding out to all of its nodes.
+	defaultCanaryPercent = 100
+	// defaultMaxUnavailable replaces the agents of one node at a time.
+	defaultMaxUnavailable = 1
+)
+
+// nolint:unused
+// log is for logging in this package.
+var probetargetbindinglog = logf.Log.WithName("probetargetbinding-resource")
+
+// SetupProbeTargetBindingWebhookWithManager registers the webhook for ProbeTargetBinding in the manager.
+// Bindings are validated against the policies and bindings the manager's
+// client sees.
+func SetupProbeTargetBindingWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).For(&gpuv1alpha1.ProbeTargetBinding{}).
+		WithValidator(&ProbeTargetBindingCustomValidator{Client: mgr.GetClient()}).
+		WithDefaulter(&ProbeTargetBindingCustomDefaulter{}).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-gpu-obs-gpu-v1alpha1-probetargetbinding,mutating=true,failurePolicy=fail,sideEffects=None,groups=gpu.obs.gpu,resources=probetargetbindings,verbs=create;update,versions=v1alpha1,name=mprobetargetbinding-v1alpha1.kb.io,admissionReviewVersions=v1
+
+// ProbeTargetBindingCustomDefaulter struct is responsible for setting default values on the custom resource of the
+// Kind ProbeTargetBinding when those are created or updated.
+//
+// NOTE: The +kubebuilder:object:generate=false marker prevents controller-gen from generating DeepCopy methods,
+// as it is used only for temporary operations and does not need to be deeply copied.
+type ProbeTargetBindingCustomDefaulter struct{}
+
+var _ webhook.CustomDefaulter = &ProbeTargetBindingCustomDefaulter{}
+
+// Default implements webhook.CustomDefaulter so a webhook will be registered for the Kind ProbeTargetBinding.
+func (d *ProbeTargetBindingCustomDefaulter) Default(_ context.Context, obj runtime.Object) error {
+	binding, ok := obj.(*gpuv1alpha1.ProbeTargetBinding)
+	if !ok {
+		return fmt.Errorf("expected a ProbeTargetBinding object but got %T", obj)
+	}
+	probetargetbindinglog.Info("Defaulting for ProbeTargetBinding", "name", binding.GetName())
+
+	// Zero is not a meaningful canary or rollout budget, so it means unset
+	if binding.Spec.CanaryPercent == 0 {
+		binding.Spec.CanaryPercent = defaultCanaryPercent
+	}
+	if binding.Spec.MaxUnavailable == 0 {
+		binding.Spec.MaxUnavailable = defaultMaxUnavailable
+	}
+
+	return nil
+}
+
+// +kubebuilder:webhook:path=/validate-gpu-obs-gpu-v1alpha1-probetargetbinding,mutating=false,failurePolicy=fail,sideEffects=None,groups=gpu.obs.gpu,resources=probetargetbindings,verbs=create;update,versions=v1alpha1,name=vprobetargetbinding-v1alpha1.kb.io,admissionReviewVersions=v1
+
+// ProbeTargetBindingCustomValidator struct is responsible for validating the ProbeTargetBinding resource
+// when it is created, updated, or deleted.
+//
+// NOTE: The +kubebuilder:object:generate=false marker prevents controller-gen from generating DeepCopy methods,
+// as this struct is used only for temporary operations and does not need to be deeply copied.
+type ProbeTargetBindingCustomValidator struct {
+	// Client resolves policy references and finds the other bindings of a
+	// policy. Without it, only the spec itself is validated.
+	Client client.Reader
+}
+
+var _ webhook.CustomValidator = &ProbeTargetBindingCustomValidator{}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type ProbeTargetBinding.
+func (v *ProbeTargetBindingCustomValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	binding, ok := obj.(*gpuv1alpha1.ProbeTargetBinding)
+	if !ok {
+		return nil, fmt.Errorf("expected a ProbeTargetBinding object but got %T", obj)
+	}
+	probetargetbindinglog.Info("Validation for ProbeTargetBinding upon creation", "name", binding.GetName())
+
+	return nil, v.validateProbeTargetBinding(ctx, binding)
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type ProbeTargetBinding.
+func (v *ProbeTargetBindingCustomValidator) ValidateUpdate(ctx context.Context, _, newObj runtime.Object) (admission.Warnings, error) {
+	binding, ok := newObj.(*gpuv1alpha1.ProbeTargetBinding)
+	if !ok {
+		return nil, fmt.Errorf("expected a ProbeTargetBinding object for the newObj but got %T", newObj)
+	}
+	probetargetbindinglog.Info("Validation for ProbeTargetBinding upon update", "name", binding.GetName())
+
+	return nil, v.validateProbeTargetBinding(ctx, binding)
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type ProbeTargetBinding.
+func (v *ProbeTargetBindingCustomValidator) ValidateDelete(_ context.Context, obj runtime.Object) (admission.Warnings, error) {
+	binding, ok := obj.(*gpuv1alpha1.ProbeTargetBinding)
+	if !ok {
+		return nil, fmt.Errorf("expected a ProbeTargetBinding object but got %T", obj)
+	}
+	probetargetbindinglog.Info("Validation for ProbeTargetBinding upon deletion", "name", binding.GetName())
+
+	// No validation needed on delete
+	return nil, nil
+}
+
+// validateProbeTargetBinding validates the ProbeTargetBinding spec
+func (v *ProbeTargetBindingCustomValidator) validateProbeTargetBinding(ctx context.Context, binding *gpuv1alpha1.ProbeTargetBinding) error {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	// Validate canaryPercent is a percentage
+	if binding.Spec.CanaryPercent < 0 || binding.Spec.CanaryPercent > 100 {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("canaryPercent"), binding.Spec.CanaryPercent,
+			"canaryPercent must be between 0 and 100"))
+	}
+
+	// Validate maxUnavailable is non-negative
+	if binding.Spec.MaxUnavailable < 0 {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("maxUnavailable"), binding.Spec.MaxUnavailable,
+			"maxUnavailable must be non-negative"))
+	}
+
+	// Validate nodeSelector holds valid label keys and values
+	allErrs = append(allErrs, metav1validation.ValidateLabels(binding.Spec.NodeSelector, specPath.Child("nodeSelector"))...)
+
+	// Validate policyRef names a policy of the binding's namespace
+	if binding.Spec.PolicyRef == "" {
+		allErrs = append(allErrs, field.Required(specPath.Child("policyRef"), "policyRef must be specified"))
+	} else if v.Client != nil {
+		allErrs = append(allErrs, v.validatePolicyRef(ctx, binding, specPath)...)
+	}
+
+	if len(allErrs) == 0 {
+		return nil
+	}
+
+	return allErrs.ToAggregate()
+}
+
+// validatePolicyRef resolves the policy of a binding and rejects bindings
+// that target nodes another binding of the same policy already targets
+func (v *ProbeTargetBindingCustomValidator) validatePolicyRef(ctx context.Context, binding *gpuv1alpha1.ProbeTargetBinding, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	policy := &gpuv1alpha1.CudaEBPFPolicy{}
+	err := v.Client.Get(ctx, types.NamespacedName{Name: binding.Spec.PolicyRef, Namespace: binding.Namespace}, policy)
+	if errors.IsNotFound(err) {
+		return append(allErrs, field.NotFound(fldPath.Child("policyRef"), binding.Spec.PolicyRef))
+	} else if err != nil {
+		return append(allErrs, field.InternalError(fldPath.Child("policyRef"), fmt.Errorf("getting CudaEBPFPolicy: %w", err)))
+	}
+
+	bindings := &gpuv1alpha1.ProbeTargetBindingList{}
+	if err := v.Client.List(ctx, bindings, client.InNamespace(binding.Namespace)); err != nil {
+		return append(allErrs, field.InternalError(fldPath, fmt.Errorf("listing ProbeTargetBindings: %w", err)))
+	}
+	for _, other := range bindings.Items {
+		if other.Name == binding.Name || other.Spec.PolicyRef != binding.Spec.PolicyRef {
+			continue
+		}
+		if selectorsOverlap(binding.Spec.NodeSelector, other.Spec.NodeSelector) {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child("nodeSelector"),
+				fmt.Sprintf("nodes matching it are already targeted with policy %s by ProbeTargetBinding %s",
+					binding.Spec.PolicyRef, other.Name)))
+		}
+	}
+
+	return allErrs
+}
+
+// selectorsOverlap reports whether a node can match both node selectors,
+// which is the case unless they require different values for a label.
+func selectorsOverlap(a, b map[string]string) bool {
+	for key, value := range a {
+		if other, ok := b[key]; ok && other != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2025 WoodProgrammer.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	gpuv1alpha1 "github.com/WoodProgrammer/gpu-bpf-operator/api/v1alpha1"
+)
+
+var _ = Describe("ProbeTargetBinding Webhook", func() {
+	var (
+		obj       *gpuv1alpha1.ProbeTargetBinding
+		validator ProbeTargetBindingCustomValidator
+		defaulter ProbeTargetBindingCustomDefaulter
+		ctx       context.Context
+		scheme    *runtime.Scheme
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		obj = &gpuv1alpha1.ProbeTargetBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "gpu-nodes", Namespace: "default"},
+			Spec: gpuv1alpha1.ProbeTargetBindingSpec{
+				PolicyRef:      "cuda-trace",
+				NodeSelector:   map[string]string{"nvidia.com/gpu.present": "true"},
+				CanaryPercent:  10,
+				MaxUnavailable: 1,
+			},
+		}
+		scheme = runtime.NewScheme()
+		Expect(gpuv1alpha1.AddToScheme(scheme)).To(Succeed())
+		policy := &gpuv1alpha1.CudaEBPFPolicy{ObjectMeta: metav1.ObjectMeta{Name: "cuda-trace", Namespace: "default"}}
+		validator = ProbeTargetBindingCustomValidator{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(policy).Build()}
+		defaulter = ProbeTargetBindingCustomDefaulter{}
+	})
+
+	Context("When creating ProbeTargetBinding under Defaulting Webhook", func() {
+		It("Should default the canary and the rollout budget", func() {
+			obj.Spec.CanaryPercent = 0
+			obj.Spec.MaxUnavailable = 0
+			Expect(defaulter.Default(ctx, obj)).To(Succeed())
+			Expect(obj.Spec.CanaryPercent).To(Equal(100))
+			Expect(obj.Spec.MaxUnavailable).To(Equal(1))
+		})
+
+		It("Should not override existing values", func() {
+			obj.Spec.MaxUnavailable = 3
+			Expect(defaulter.Default(ctx, obj)).To(Succeed())
+			Expect(obj.Spec.CanaryPercent).To(Equal(10))
+			Expect(obj.Spec.MaxUnavailable).To(Equal(3))
+		})
+	})
+
+	Context("When creating or updating ProbeTargetBinding under Validating Webhook", func() {
+		It("Should admit a binding of an existing policy", func() {
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Should deny references to missing policies", func() {
+			obj.Spec.PolicyRef = "cuda-trcae"
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(`spec.policyRef: Not found: "cuda-trcae"`))
+
+			By("requiring a reference at all")
+			obj.Spec.PolicyRef = ""
+			_, err = validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.policyRef: Required value"))
+		})
+
+		It("Should only resolve policies of the binding's namespace", func() {
+			obj.Namespace = "team-a"
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.policyRef: Not found"))
+		})
+
+		It("Should deny out of range canaries and rollout budgets", func() {
+			obj.Spec.CanaryPercent = 150
+			obj.Spec.MaxUnavailable = -1
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("canaryPercent must be between 0 and 100"))
+			Expect(err.Error()).To(ContainSubstring("maxUnavailable must be non-negative"))
+		})
+
+		It("Should deny invalid node selectors", func() {
+			obj.Spec.NodeSelector = map[string]string{"gpu model": "a100"}
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.nodeSelector"))
+		})
+
+		It("Should deny bindings of a policy to overlapping nodes", func() {
+			other := &gpuv1alpha1.ProbeTargetBinding{
+				ObjectMeta: metav1.ObjectMeta{Name: "a100-nodes", Namespace: "default"},
+				Spec: gpuv1alpha1.ProbeTargetBindingSpec{
+					PolicyRef:    "cuda-trace",
+					NodeSelector: map[string]string{"nvidia.com/gpu.product": "A100"},
+				},
+			}
+			policy := &gpuv1alpha1.CudaEBPFPolicy{ObjectMeta: metav1.ObjectMeta{Name: "cuda-trace", Namespace: "default"}}
+			validator.Client = fake.NewClientBuilder().WithScheme(scheme).WithObjects(policy, other).Build()
+
+			By("denying a selector that can match the same nodes")
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("already targeted with policy cuda-trace by ProbeTargetBinding a100-nodes"))
+
+			By("admitting a selector that excludes them")
+			obj.Spec.NodeSelector = map[string]string{"nvidia.com/gpu.product": "H100"}
+			_, err = validator.ValidateCreate(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+
+			By("not comparing a binding with itself on update")
+			oldObj := other.DeepCopy()
+			other.Spec.MaxUnavailable = 2
+			_, err = validator.ValidateUpdate(ctx, oldObj, other)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+})
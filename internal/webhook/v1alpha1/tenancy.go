@@ -0,0 +1,147 @@
+/*
+Copyright 2025 WoodProgrammer.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	gpuv1alpha1 "github.com/WoodProgrammer/gpu-bpf-operator/api/v1alpha1"
+)
+
+// HostTracingVerb is the verb on cudaebpfpolicies that allows a namespaced
+// policy to trace beyond the pods of its namespace. Cluster admins grant it
+// with the cudaebpfpolicy-host-tracer-role.
+const HostTracingVerb = "trace-host"
+
+// HostTracingAuthorizer decides whether a user may create policies that see
+// processes outside of their namespace.
+type HostTracingAuthorizer interface {
+	CanTraceHost(ctx context.Context, user authenticationv1.UserInfo, namespace string) (bool, error)
+}
+
+// SubjectAccessReviewAuthorizer asks the API server whether the user has the
+// HostTracingVerb on cudaebpfpolicies in the namespace.
+type SubjectAccessReviewAuthorizer struct {
+	Client client.Client
+}
+
+// +kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+
+func (a *SubjectAccessReviewAuthorizer) CanTraceHost(ctx context.Context, user authenticationv1.UserInfo, namespace string) (bool, error) {
+	extra := map[string]authorizationv1.ExtraValue{}
+	for key, value := range user.Extra {
+		extra[key] = authorizationv1.ExtraValue(value)
+	}
+	review := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user.Username,
+			UID:    user.UID,
+			Groups: user.Groups,
+			Extra:  extra,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: namespace,
+				Verb:      HostTracingVerb,
+				Group:     gpuv1alpha1.GroupVersion.Group,
+				Resource:  "cudaebpfpolicies",
+			},
+		},
+	}
+	if err := a.Client.Create(ctx, review); err != nil {
+		return false, err
+	}
+	return review.Status.Allowed, nil
+}
+
+// hostAccess lists the parts of a policy that reach beyond the pods of its
+// namespace: systemwide tracing, driver and kernel function probes, which
+// fire for every process on the node, legacy kernel support, which runs the
+// agent with SYS_ADMIN and mounts kernel paths, and host paths mounted into
+// the agent, including the directories of file sinks and spilled events.
+func hostAccess(spec *gpuv1alpha1.CudaEBPFPolicySpec, fldPath *field.Path) field.ErrorList {
+	var access field.ErrorList
+	if spec.Mode == "systemwide" {
+		access = append(access, field.Forbidden(fldPath.Child("mode"), "systemwide tracing"))
+	}
+	if spec.Security != nil && spec.Security.LegacyKernel {
+		access = append(access, field.Forbidden(fldPath.Child("security", "legacyKernel"), "legacy kernel support, which grants SYS_ADMIN"))
+	}
+	for i, sink := range spec.Sinks {
+		if sink.File != nil {
+			access = append(access, field.Forbidden(fldPath.Child("sinks").Index(i).Child("file", "path"), "file sinks on the host"))
+		}
+	}
+	if spec.Buffering != nil && spec.Buffering.Spill != nil {
+		access = append(access, field.Forbidden(fldPath.Child("buffering", "spill", "path"), "spilling events to the host"))
+	}
+	for i := range spec.Probes {
+		access = append(access, field.Forbidden(fldPath.Child("probes").Index(i), "driver kprobes"))
+	}
+	for i, fn := range spec.Functions {
+		if fn.Kind == "kprobe" || fn.Kind == "kretprobe" {
+			access = append(access, field.Forbidden(fldPath.Child("functions").Index(i).Child("kind"), fn.Kind+" functions"))
+		}
+	}
+	if spec.PodTemplate != nil {
+		for i, volume := range spec.PodTemplate.Volumes {
+			if volume.HostPath != nil {
+				access = append(access, field.Forbidden(fldPath.Child("podTemplate", "volumes").Index(i).Child("hostPath"), "host path volumes"))
+			}
+		}
+	}
+	return access
+}
+
+// validateTenancy only admits policies with host access from users that were
+// granted the HostTracingVerb. Updates that leave the spec untouched, such as
+// the finalizer added by the controller, are not checked again.
+func (v *CudaEBPFPolicyCustomValidator) validateTenancy(ctx context.Context, policy, oldPolicy *gpuv1alpha1.CudaEBPFPolicy) field.ErrorList {
+	access := hostAccess(&policy.Spec, field.NewPath("spec"))
+	if len(access) == 0 {
+		return nil
+	}
+	if oldPolicy != nil && equality.Semantic.DeepEqual(oldPolicy.Spec, policy.Spec) {
+		return nil
+	}
+
+	allowed := false
+	if v.Authorizer != nil {
+		req, err := admission.RequestFromContext(ctx)
+		if err != nil {
+			return field.ErrorList{field.InternalError(field.NewPath("spec"), err)}
+		}
+		allowed, err = v.Authorizer.CanTraceHost(ctx, req.UserInfo, policy.Namespace)
+		if err != nil {
+			return field.ErrorList{field.InternalError(field.NewPath("spec"), fmt.Errorf("checking host tracing permission: %w", err))}
+		}
+	}
+	if allowed {
+		return nil
+	}
+	for _, err := range access {
+		err.Detail = fmt.Sprintf("%s requires the %q permission on cudaebpfpolicies in namespace %s",
+			err.Detail, HostTracingVerb, policy.Namespace)
+	}
+	return access
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2025 WoodProgrammer.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	gpuv1alpha1 "github.com/WoodProgrammer/gpu-bpf-operator/api/v1alpha1"
+	"github.com/WoodProgrammer/gpu-bpf-operator/internal/imagepolicy"
+)
+
+// validateUpdate enforces the rules for changing a live policy on top of the
+// create validation. A new mode or libPath, and any spec change while the
+// agents are still rolling out, must be acknowledged with the
+// AcknowledgeChangeAnnotation. Moving the agent image to another repository
+// is never allowed. Updates that leave the spec untouched are not checked.
+func validateUpdate(obj, oldObj metav1.Object, spec, oldSpec *gpuv1alpha1.CudaEBPFPolicySpec,
+	oldStatus *gpuv1alpha1.CudaEBPFPolicyStatus) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if equality.Semantic.DeepEqual(spec, oldSpec) {
+		return nil
+	}
+	acknowledged := changeAcknowledged(obj, oldObj)
+	specPath := field.NewPath("spec")
+
+	if spec.Mode != oldSpec.Mode && !acknowledged {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("mode"),
+			fmt.Sprintf("changing mode from %s to %s %s", oldSpec.Mode, spec.Mode, acknowledgeHint)))
+	}
+
+	if spec.LibPath != oldSpec.LibPath && !acknowledged {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("libPath"),
+			fmt.Sprintf("changing libPath from %q to %q %s", oldSpec.LibPath, spec.LibPath, acknowledgeHint)))
+	}
+
+	// Malformed images are reported by the create validation
+	if spec.Image != oldSpec.Image {
+		ref, err := imagepolicy.ParseReference(spec.Image)
+		oldRef, oldErr := imagepolicy.ParseReference(oldSpec.Image)
+		if err == nil && oldErr == nil && ref.Repository != oldRef.Repository {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("image"),
+				fmt.Sprintf("the agent image can't move from repository %s to %s, create a new policy instead",
+					oldRef.Repository, ref.Repository)))
+		}
+	}
+
+	if oldStatus.RolloutInProgress && !acknowledged {
+		allErrs = append(allErrs, field.Forbidden(specPath,
+			fmt.Sprintf("the agents are still rolling out the previous change, retry once status.rolloutInProgress "+
+				"is cleared or change the spec with a new value of the %s annotation", gpuv1alpha1.AcknowledgeChangeAnnotation)))
+	}
+
+	return allErrs
+}
+
+var acknowledgeHint = fmt.Sprintf("disrupts the running agents and requires a new value of the %s annotation",
+	gpuv1alpha1.AcknowledgeChangeAnnotation)
+
+// changeAcknowledged reports whether an update sets the
+// AcknowledgeChangeAnnotation to a new value. A value left over from an
+// earlier change acknowledges nothing.
+func changeAcknowledged(obj, oldObj metav1.Object) bool {
+	value := obj.GetAnnotations()[gpuv1alpha1.AcknowledgeChangeAnnotation]
+	return value != "" && value != oldObj.GetAnnotations()[gpuv1alpha1.AcknowledgeChangeAnnotation]
+}
@@ -109,7 +109,13 @@ var _ = BeforeSuite(func() {
 	})
 	Expect(err).NotTo(HaveOccurred())
 
-	err = SetupCudaEBPFPolicyWebhookWithManager(mgr)
+	err = SetupCudaEBPFPolicyWebhookWithManager(mgr, nil, nil)
+	Expect(err).NotTo(HaveOccurred())
+
+	err = SetupClusterCudaEBPFPolicyWebhookWithManager(mgr, nil, nil)
+	Expect(err).NotTo(HaveOccurred())
+
+	err = SetupProbeTargetBindingWebhookWithManager(mgr)
 	Expect(err).NotTo(HaveOccurred())
 
 	// +kubebuilder:scaffold:webhook